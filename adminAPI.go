@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+)
+
+type AdminResponse struct {
+	Action  string `json:"action"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+	Success bool   `json:"success"`
+}
+
+func sendAdminResponse(w http.ResponseWriter, r *http.Request, action string, err error, message string) {
+	response := AdminResponse{Action: action, Message: message, Status: http.StatusOK, Success: true}
+	if err != nil {
+		response.Message = err.Error()
+		response.Status = queryErrorStatus(err)
+		response.Success = false
+		setLogField(r.Context(), "error", err)
+	}
+	if r.Method != http.MethodGet { // reads are not audited
+		recordAudit(r, action, response.Status, response.Message)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(response)
+}
+
+type CacheFileInfo struct {
+	Name     string  `json:"name"`
+	Bytes    int64   `json:"bytes"`
+	RawBytes int64   `json:"raw_bytes"`       // size uncompressed
+	Ratio    float64 `json:"ratio,omitempty"` // raw_bytes / bytes, compressed files only
+}
+
+type CacheListing struct {
+	Files       []CacheFileInfo `json:"files"`
+	Bytes       int64           `json:"bytes"`
+	RawBytes    int64           `json:"raw_bytes"`
+	MemoryGrids int             `json:"memory_grids"`
+	MemoryBytes int64           `json:"memory_bytes"`
+	Status      int             `json:"status"`
+	Success     bool            `json:"success"`
+}
+
+// adminCacheHandler serves GET /admin/cache, the files in tmp/ (objects and
+// the manifest included) with their compression ratios and what the in-memory cache holds.
+func adminCacheHandler(w http.ResponseWriter, r *http.Request) {
+	listing := CacheListing{Files: []CacheFileInfo{}, Status: http.StatusOK, Success: true}
+	err := filepath.WalkDir(tmpDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		name, _ := filepath.Rel(tmpDir, path)
+		file := CacheFileInfo{Name: filepath.ToSlash(name), Bytes: info.Size(), RawBytes: info.Size()}
+		if raw, ok := compressedRawSize(path); ok && file.Bytes > 0 {
+			file.RawBytes = raw
+			file.Ratio = float64(raw) / float64(file.Bytes)
+		}
+		listing.Files = append(listing.Files, file)
+		listing.Bytes += file.Bytes
+		listing.RawBytes += file.RawBytes
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		sendAdminResponse(w, r, "cache", err, "")
+		return
+	}
+	listing.MemoryGrids, listing.MemoryBytes = fileCacheStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// adminPurgeHandler serves POST /admin/cache/purge, dropping every in-memory
+// cache. Cache files in tmp/ are kept.
+func adminPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	files, _ := fileCacheStats()
+	ClearDateRangeCache()
+	typhoonCache.clear()
+	sendAdminResponse(w, r, "purge", nil, fmt.Sprintf("dropped %d cached grids and the typhoon cache", files))
+}
+
+// adminPrefetchHandler serves POST /admin/prefetch?date=&batch=&step=, downloading
+// and loading a batch ahead of the queries that will need it.
+func adminPrefetchHandler(w http.ResponseWriter, r *http.Request) {
+	date := r.URL.Query().Get("date")
+	batch := r.URL.Query().Get("batch")
+	setLogField(r.Context(), "date", date)
+	setLogField(r.Context(), "batch", batch)
+	if err := validateDateBatch(date, batch); err != nil {
+		sendAdminResponse(w, r, "prefetch", err, "")
+		return
+	}
+	step, err := parseStep(r.URL.Query().Get("step"), batch)
+	if err != nil {
+		sendAdminResponse(w, r, "prefetch", err, "")
+		return
+	}
+	filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+	if _, err := getOrLoadParams(r.Context(), filePath, date, batch, step, windParams); err != nil {
+		sendAdminResponse(w, r, "prefetch", fmt.Errorf("failed to load %s: %w", filePath, err), "")
+		return
+	}
+	sendAdminResponse(w, r, "prefetch", nil, fmt.Sprintf("loaded %s-%s step %s", date, batch, stepName(step)))
+}
+
+// adminReloadHandler serves POST /admin/reload, reloading the IBTrACS table.
+func adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := loadTyphonData(); err != nil {
+		sendAdminResponse(w, r, "reload", fmt.Errorf("%w: ibtracs: %w", ErrDatasetUnavailable, err), "")
+		return
+	}
+	sendAdminResponse(w, r, "reload", nil, fmt.Sprintf("loaded %d IBTrACS records", len(currentTyphonData().records)))
+}
+
+// adminExportHandler serves GET /admin/export?date=&batch=&param=&step=, a
+// stored grid as a JSON param file with its values unpacked, for tools that
+// do not read the binary format.
+func adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+	date, batch, param := httpQuery.Get("date"), httpQuery.Get("batch"), httpQuery.Get("param")
+	if err := validateDateBatch(date, batch); err != nil {
+		sendAdminResponse(w, r, "export", err, "")
+		return
+	}
+	if _, ok := paramRegistry[param]; !ok {
+		sendAdminResponse(w, r, "export", fmt.Errorf("%w: unknown param %q", ErrInvalidParams, param), "")
+		return
+	}
+	step, err := parseStep(httpQuery.Get("step"), batch)
+	if err != nil {
+		sendAdminResponse(w, r, "export", err, "")
+		return
+	}
+	grid, err := readStoredParam(date, batch, param, step)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("%w: %s-%s %s step %s is not stored", ErrDataNotPublished, date, batch, param, stepName(step))
+	}
+	if err != nil {
+		sendAdminResponse(w, r, "export", err, "")
+		return
+	}
+	spec := specOf(grid.Grid)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paramFile{Grid: &spec, Values: grid.Values})
+}
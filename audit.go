@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit log: every admin operation (purge, prefetch, reload) is appended to
+// -audit-log as one JSON line, whether it succeeded or not, with who did it
+// and with which parameters. The file is only ever appended to, GET
+// /admin/audit reads it back.
+
+var auditLogPath = "audit.log"
+
+type AuditActor struct {
+	Role   string `json:"role"`
+	Token  string `json:"token,omitempty"` // fingerprint of the bearer token, never the token
+	Remote string `json:"remote"`
+}
+
+type AuditEntry struct {
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Actor   AuditActor        `json:"actor"`
+	Params  map[string]string `json:"params,omitempty"`
+	Status  int               `json:"status"`
+	Message string            `json:"message,omitempty"`
+}
+
+var auditMutex sync.Mutex
+
+// tokenFingerprint identifies a token in the audit log without disclosing
+// it.
+func tokenFingerprint(r *http.Request) string {
+	token := requestToken(r)
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// recordAudit appends an entry for the admin action r performed. Failing to
+// write it is logged, the action has happened already.
+func recordAudit(r *http.Request, action string, status int, message string) {
+	if auditLogPath == "" {
+		return
+	}
+	entry := AuditEntry{
+		Time:    clock.Now().UTC(),
+		Action:  action,
+		Actor:   AuditActor{Role: requestRole(r), Token: tokenFingerprint(r), Remote: r.RemoteAddr},
+		Status:  status,
+		Message: message,
+	}
+	if query := r.URL.Query(); len(query) > 0 {
+		entry.Params = make(map[string]string, len(query))
+		for key := range query {
+			entry.Params[key] = query.Get(key)
+		}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Fail to marshal audit entry: %v", err)
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	file, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Fail to open audit log %s: %v", auditLogPath, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Fail to write audit log %s: %v", auditLogPath, err)
+		return
+	}
+	if err := file.Sync(); err != nil {
+		log.Printf("Fail to sync audit log %s: %v", auditLogPath, err)
+	}
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 10000
+)
+
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	Status  int          `json:"status"`
+	Success bool         `json:"success"`
+}
+
+// adminAuditHandler serves GET /admin/audit?since=&action=&limit=, the latest
+// limit entries matching the filters, oldest first.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+	var since time.Time
+	if sinceStr := httpQuery.Get("since"); sinceStr != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, sinceStr); err != nil {
+			sendAdminResponse(w, r, "audit", fmt.Errorf("%w: since %q", ErrInvalidParams, sinceStr), "")
+			return
+		}
+	}
+	limit := defaultAuditLimit
+	if limitStr := httpQuery.Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 1 || limit > maxAuditLimit {
+			sendAdminResponse(w, r, "audit", fmt.Errorf("%w: limit %q", ErrInvalidParams, limitStr), "")
+			return
+		}
+	}
+	action := httpQuery.Get("action")
+
+	entries, err := readAuditLog(since, action, limit)
+	if err != nil {
+		sendAdminResponse(w, r, "audit", err, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditResponse{Entries: entries, Status: http.StatusOK, Success: true})
+}
+
+func readAuditLog(since time.Time, action string, limit int) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	if auditLogPath == "" {
+		return entries, nil
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	file, err := os.Open(auditLogPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // a line cut short by a crash
+		}
+		if !entry.Time.After(since) || action != "" && entry.Action != action {
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Role based access control. Tokens (API keys) are read from the
+// -auth-tokens file, one "role token" pair per line, and from -api-keys,
+// comma separated "role:token" pairs best given as GRIBER_API_KEYS so they
+// stay out of the process list. Clients send them as "Authorization: Bearer
+// <token>" or "X-API-Key: <token>". Roles are ordered, a role may do
+// everything the roles below it may:
+//
+//	reader    query endpoints
+//	ingester  + prefetching data into the caches
+//	admin     + purging caches and reloading data
+//
+// Without any token access control is off and every request is admin,
+// which is only suitable when the network in front of the server is.
+
+const (
+	roleReader   = "reader"
+	roleIngester = "ingester"
+	roleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{
+	roleReader:   1,
+	roleIngester: 2,
+	roleAdmin:    3,
+}
+
+// authTokens maps token to role, nil when access control is off.
+var authTokens map[string]string
+
+// apiKeys is -api-keys, "role:token" pairs separated by commas.
+var apiKeys = ""
+
+// authTokenStore returns the tokens of the -auth-tokens file at path (none
+// when empty) and of -api-keys, nil when there are none at all.
+func authTokenStore(path string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	if path != "" {
+		var err error
+		if tokens, err = loadAuthTokens(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, pair := range strings.Split(apiKeys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		role, token, ok := strings.Cut(pair, ":")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("-api-keys: want \"role:token\" pairs")
+		}
+		if _, ok := roleRank[role]; !ok {
+			return nil, fmt.Errorf("-api-keys: unknown role %q", role)
+		}
+		tokens[token] = role
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return tokens, nil
+}
+
+func loadAuthTokens(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"role token\"", path, line)
+		}
+		if _, ok := roleRank[fields[0]]; !ok {
+			return nil, fmt.Errorf("%s:%d: unknown role %q", path, line, fields[0])
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return tokens, nil
+}
+
+// requestToken returns the bearer token or API key of the request, "" if it
+// has none.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// requestRole returns the role of the request's token, "" if it has none or
+// an unknown one.
+func requestRole(r *http.Request) string {
+	return tokenRole(requestToken(r))
+}
+
+// tokenRole returns the role of token, "" for none or an unknown one.
+func tokenRole(token string) string {
+	if authTokens == nil {
+		return roleAdmin
+	}
+	if token == "" {
+		return ""
+	}
+	for known, role := range authTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return role
+		}
+	}
+	return ""
+}
+
+// requireRole only lets requests with at least role through to next.
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := requestRole(r)
+		if got == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="griber"`)
+			sendAuthError(w, r, http.StatusUnauthorized)
+			return
+		}
+		setLogField(r.Context(), "role", got)
+		if roleRank[got] < roleRank[role] {
+			sendAuthError(w, r, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func sendAuthError(w http.ResponseWriter, r *http.Request, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   statusText(languageFrom(r.Context()), statusCode),
+		"status":  statusCode,
+		"success": false,
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// POST /batch answers many /api points in one request. Points are grouped by
+// date, batch and step so the fields of each are loaded once however many
+// points read them, with up to batchWorkers loads at a time. Every result has
+// its own status: a cycle that cannot be loaded fails its points only.
+
+const (
+	maxBatchPoints = 10000
+	maxBatchCycles = 64 // distinct date/batch/step loads
+	batchWorkers   = 4
+)
+
+type BatchPoint struct {
+	Lat   *float64 `json:"lat"`
+	Lon   *float64 `json:"lon"`
+	Date  string   `json:"date"`
+	Batch string   `json:"batch"`
+	Step  int      `json:"step"` // forecast step in hours, 0 is the analysis
+}
+
+type BatchRequest struct {
+	Points  []BatchPoint `json:"points"`
+	Param   string       `json:"param"`   // wind or a single parameter such as 2t, for every point
+	Params  []string     `json:"params"`  // any parameters, overrides Param
+	Derived []string     `json:"derived"` // speed and/or dir
+}
+
+type BatchResponse struct {
+	Results []SingleResponse `json:"results"` // in the order of the points
+	Loads   int              `json:"loads"`   // date/batch/step fields loaded
+	Status  int              `json:"status"`
+	Success bool             `json:"success"`
+}
+
+var batchFailResponse = BatchResponse{
+	Results: []SingleResponse{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendBatchJsonError(w http.ResponseWriter, statusCode int) {
+	response := batchFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// batchHandler serves POST /batch with a BatchRequest body.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	var request BatchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4<<20)).Decode(&request); err != nil {
+		sendBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+	if len(request.Points) == 0 || len(request.Points) > maxBatchPoints {
+		sendBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+	param, err := parseQueryParam(request.Param)
+	if err != nil {
+		sendBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+	paramList, err := parseQueryParams(strings.Join(request.Params, ","))
+	if err != nil || (paramList != nil && request.Param != "") {
+		sendBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+	derived, err := parseDerived(strings.Join(request.Derived, ","))
+	if err != nil {
+		sendBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+	names := selectedParams(param, paramList)
+	if len(derived) > 0 {
+		if _, _, err := windComponents(names); err != nil {
+			sendBatchJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	points := make([]SingleAPIParams, len(request.Points))
+	cycles := make(map[string][]int) // indices of the points of each load
+	var order []string
+	for i, point := range request.Points {
+		if point.Lat == nil || point.Lon == nil || !isValidDateFormat(point.Date) || !isValidBatch(point.Batch) {
+			sendBatchJsonError(w, http.StatusBadRequest)
+			return
+		}
+		if _, err := parseStep(strconv.Itoa(point.Step), point.Batch); err != nil {
+			sendBatchJsonError(w, http.StatusBadRequest)
+			return
+		}
+		points[i] = SingleAPIParams{
+			Lat:     *point.Lat,
+			Lon:     *point.Lon,
+			Date:    point.Date,
+			Batch:   point.Batch,
+			Step:    point.Step,
+			Param:   param,
+			Params:  paramList,
+			Derived: derived,
+		}
+		key := point.Date + "|" + point.Batch + "|" + stepName(point.Step)
+		if cycles[key] == nil {
+			order = append(order, key)
+		}
+		cycles[key] = append(cycles[key], i)
+	}
+	if len(cycles) > maxBatchCycles {
+		sendBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "batch_points", len(points))
+	data := BatchQuery(r.Context(), points, names, order, cycles)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// BatchQuery loads the fields of every cycle in order once and answers its
+// points from them.
+func BatchQuery(ctx context.Context, points []SingleAPIParams, names []string, order []string, cycles map[string][]int) BatchResponse {
+	response := BatchResponse{
+		Results: make([]SingleResponse, len(points)),
+		Loads:   len(order),
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	next := make(chan string)
+	var wg sync.WaitGroup
+	for range min(batchWorkers, len(order)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range next {
+				indices := cycles[key]
+				first := points[indices[0]]
+				filePath := filepath.Join(tmpDir, first.Date+"-"+first.Batch+".json")
+				grid, fields, err := loadParamFields(ctx, filePath, first.Date, first.Batch, first.Step, names)
+				if err != nil {
+					appendLogField(ctx, "error", err.Error())
+				}
+				for _, i := range indices {
+					if err != nil {
+						response.Results[i] = singleFailResponse
+						response.Results[i].Status = queryErrorStatus(err)
+						continue
+					}
+					result, pointErr := singlePoint(ctx, points[i], names, grid, fields, filePath)
+					if pointErr != nil {
+						result = singleFailResponse
+						result.Status = queryErrorStatus(pointErr)
+					}
+					response.Results[i] = result
+				}
+			}
+		}()
+	}
+	for _, key := range order {
+		next <- key
+	}
+	close(next)
+	wg.Wait()
+	return response
+}
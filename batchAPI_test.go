@@ -0,0 +1,79 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// zOrderKey interleaves the bits of the quantized latitude and longitude, so
+// points close in space get close keys (Morton order).
+func zOrderKey(lat, lon float64) uint64 {
+	y := uint32(math.Max(0, math.Min(1, (90-lat)/180)) * math.MaxUint32)
+	x := uint32((normalizeLon(lon) + 180) / 360 * math.MaxUint32)
+	return spreadBits(x) | spreadBits(y)<<1
+}
+
+// spreadBits moves bit k of v to bit 2k.
+func spreadBits(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// BenchmarkBatchPointOrder answers 100k scattered points of one global 0.25°
+// cycle the way BatchQuery does, in request order and in Z-order, the sort
+// included. The grids are plain in-memory arrays of 8 MB per parameter, not
+// mmap'd files, so the locality Z-order buys is in the CPU caches only and
+// does not pay for the sort: BatchQuery keeps request order.
+func BenchmarkBatchPointOrder(b *testing.B) {
+	ctx := context.Background()
+	fields := [][]float64{make([]float64, defaultGrid.Size()), make([]float64, defaultGrid.Size())}
+	for i := range fields[0] {
+		fields[0][i], fields[1][i] = float64(i%41)-20, float64(i%23)-11
+	}
+	random := rand.New(rand.NewPCG(1, 2))
+	points := make([]SingleAPIParams, 100000)
+	for i := range points {
+		points[i] = SingleAPIParams{Lat: random.Float64()*180 - 90, Lon: random.Float64()*360 - 180}
+	}
+	results := make([]SingleResponse, len(points))
+	answer := func(i int) {
+		result, err := singlePoint(ctx, points[i], windParams, defaultGrid, fields, "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		results[i] = result
+	}
+
+	b.Run("request", func(b *testing.B) {
+		for b.Loop() {
+			for i := range points {
+				answer(i)
+			}
+		}
+	})
+	b.Run("zorder", func(b *testing.B) {
+		for b.Loop() {
+			type keyed struct {
+				key   uint64
+				index int
+			}
+			order := make([]keyed, len(points))
+			for i, p := range points {
+				order[i] = keyed{zOrderKey(p.Lat, p.Lon), i}
+			}
+			slices.SortFunc(order, func(a, b keyed) int { return cmp.Compare(a.key, b.key) })
+			for _, k := range order {
+				answer(k.index)
+			}
+		}
+	})
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// griber bench replays a query workload and reports throughput and latency
+// percentiles per endpoint.
+//
+//	griber bench -target http://localhost:8080 -date 20250101 -batch 00z
+//	griber bench -workload queries.txt -concurrency 32 -duration 1m
+//	griber bench -library -date 20250101 -batch 00z
+//	griber bench -typhoon -duration 10s
+//
+// A recorded workload has one request path per line ("/api?lat=..."), blank
+// lines and lines starting with # are skipped. -library sends the requests
+// straight to the handlers in this process instead of over the network, so the
+// cache and decode layers are measured without HTTP overhead. -typhoon
+// instead compares the IBTrACS day lookup through the load-time index with a
+// scan of the whole table.
+
+type benchResult struct {
+	endpoint string
+	latency  time.Duration
+	failed   bool
+}
+
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	target := flags.String("target", "http://localhost:8080", "base URL of a running instance")
+	library := flags.Bool("library", false, "call the handlers in-process instead of over HTTP")
+	workload := flags.String("workload", "", "file of recorded request paths, synthetic when empty")
+	date := flags.String("date", "", "date (yyyymmdd) of the synthetic workload")
+	batch := flags.String("batch", "00z", "batch of the synthetic workload")
+	concurrency := flags.Int("concurrency", 8, "number of concurrent clients")
+	duration := flags.Duration("duration", 30*time.Second, "how long to run")
+	seed := flags.Int64("seed", 1, "random seed of the synthetic workload")
+	typhoon := flags.Bool("typhoon", false, "benchmark the IBTrACS lookup instead of the endpoints")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *typhoon {
+		return benchTyphoon(os.Stdout, *duration)
+	}
+	if *concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+
+	var next func(rng *rand.Rand) string
+	if *workload != "" {
+		paths, err := readBenchWorkload(*workload)
+		if err != nil {
+			return err
+		}
+		next = func(rng *rand.Rand) string { return paths[rng.Intn(len(paths))] }
+	} else {
+		if *date == "" {
+			return fmt.Errorf("-date is required for the synthetic workload")
+		}
+		next = func(rng *rand.Rand) string { return syntheticBenchPath(rng, *date, *batch) }
+	}
+
+	var do func(path string) (int, error)
+	if *library {
+		registerHandlers()
+		do = func(path string) (int, error) {
+			recorder := httptest.NewRecorder()
+			http.DefaultServeMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			return recorder.Code, nil
+		}
+	} else {
+		base := strings.TrimSuffix(*target, "/")
+		client := &http.Client{Timeout: 5 * time.Minute}
+		do = func(path string) (int, error) {
+			resp, err := client.Get(base + path)
+			if err != nil {
+				return 0, err
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			return resp.StatusCode, nil
+		}
+	}
+
+	results := make(chan benchResult, *concurrency*4)
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	for c := 0; c < *concurrency; c++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				path := next(rng)
+				start := time.Now()
+				status, err := do(path)
+				results <- benchResult{
+					endpoint: benchEndpoint(path),
+					latency:  time.Since(start),
+					failed:   err != nil || status != http.StatusOK,
+				}
+			}
+		}(rand.New(rand.NewSource(*seed + int64(c))))
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	started := time.Now()
+	latencies := make(map[string][]time.Duration)
+	failures := make(map[string]int)
+	for result := range results {
+		latencies[result.endpoint] = append(latencies[result.endpoint], result.latency)
+		if result.failed {
+			failures[result.endpoint]++
+		}
+	}
+	printBenchReport(os.Stdout, latencies, failures, time.Since(started))
+	return nil
+}
+
+func readBenchWorkload(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open workload: %w", err)
+	}
+	defer file.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("fail to read workload: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("workload %s is empty", path)
+	}
+	return paths, nil
+}
+
+// syntheticBenchPath mixes the query endpoints roughly like production
+// traffic: mostly point queries, some small ranges and short series.
+func syntheticBenchPath(rng *rand.Rand, date, batch string) string {
+	lat := rng.Float64()*180 - 90
+	lon := rng.Float64()*360 - 180
+	switch n := rng.Intn(10); {
+	case n < 7:
+		return fmt.Sprintf("/api?lat=%.2f&lon=%.2f&date=%s&batch=%s", lat, lon, date, batch)
+	case n < 9:
+		return fmt.Sprintf("/range?slat=%.2f&slon=%.2f&elat=%.2f&elon=%.2f&step=0.25&date=%s&batch=%s",
+			lat, lon, lat-5, lon+5, date, batch)
+	default:
+		return fmt.Sprintf("/daterange?lat=%.2f&lon=%.2f&start_date=%s&end_date=%s&batch=%s",
+			lat, lon, date, date, batch)
+	}
+}
+
+func benchEndpoint(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+func printBenchReport(w io.Writer, latencies map[string][]time.Duration, failures map[string]int, elapsed time.Duration) {
+	endpoints := make([]string, 0, len(latencies))
+	for endpoint := range latencies {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	fmt.Fprintf(w, "%-12s %8s %8s %10s %10s %10s %10s %10s\n",
+		"endpoint", "requests", "errors", "rps", "p50", "p90", "p99", "max")
+	for _, endpoint := range endpoints {
+		durations := latencies[endpoint]
+		sort.Slice(durations, func(a, b int) bool { return durations[a] < durations[b] })
+		percentile := func(p float64) time.Duration {
+			return durations[int(p*float64(len(durations)-1))]
+		}
+		fmt.Fprintf(w, "%-12s %8d %8d %10.1f %10s %10s %10s %10s\n",
+			endpoint, len(durations), failures[endpoint],
+			float64(len(durations))/elapsed.Seconds(),
+			percentile(0.50), percentile(0.90), percentile(0.99), durations[len(durations)-1])
+	}
+}
+
+// benchTyphoon times the /typhoon lookup (each storm's record closest to a
+// batch time, and its track) for every day with storms, through the index
+// and by scanning the table as getTyphon did before the index existed.
+func benchTyphoon(w io.Writer, duration time.Duration) error {
+	if err := loadTyphonData(); err != nil {
+		return err
+	}
+	dataset := currentTyphonData()
+	dates := make([]string, 0, len(dataset.byDate))
+	for date := range dataset.byDate {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	if len(dates) == 0 {
+		return fmt.Errorf("%s has no records", ibtracsPath)
+	}
+
+	lookups := map[string]func(date string, target int64) int{
+		"index": func(date string, target int64) int {
+			n := 0
+			for sid := range closestTyphonRecords(dataset.byDate[date], target) {
+				n += len(dataset.tracks[sid])
+			}
+			return n
+		},
+		"scan": func(date string, target int64) int {
+			var day [][]string
+			for _, record := range dataset.records {
+				if strings.HasPrefix(record[colIsoTime], date) {
+					day = append(day, record)
+				}
+			}
+			closest := closestTyphonRecords(day, target)
+			n := 0
+			for _, record := range dataset.records {
+				if _, ok := closest[record[colSID]]; ok {
+					n++
+				}
+			}
+			return n
+		},
+	}
+
+	fmt.Fprintf(w, "%d records, %d days with storms\n", len(dataset.records), len(dates))
+	fmt.Fprintf(w, "%-8s %10s %14s\n", "lookup", "ops", "ns/op")
+	perOp := make(map[string]float64)
+	for _, name := range []string{"scan", "index"} {
+		lookup := lookups[name]
+		ops := 0
+		start := time.Now()
+		for time.Since(start) < duration/2 {
+			date := dates[ops%len(dates)]
+			target, _ := strconv.ParseInt(date+"000000", 10, 64)
+			lookup(date, target)
+			ops++
+		}
+		perOp[name] = float64(time.Since(start).Nanoseconds()) / float64(ops)
+		fmt.Fprintf(w, "%-8s %10d %14.0f\n", name, ops, perOp[name])
+	}
+	fmt.Fprintf(w, "speedup  %.1fx\n", perOp["scan"]/perOp["index"])
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Stored objects are binary: a small header followed by the values as
+// little-endian blocks, which is a fraction of the size of JSON numbers and
+// decodes without parsing text.
+//
+//	"GRBC" | version uint16 | header length uint32 | header (JSON) | values
+//
+// The values are float32s, NaN where missing, or the int16s of -packing
+// int16. Objects written before are JSON param files, decodeParamObject
+// tells them apart by their first bytes.
+
+const (
+	binaryMagic   = "GRBC"
+	binaryVersion = 1
+
+	encodingFloat32 = "float32"
+	encodingInt16   = "int16"
+)
+
+type binaryHeader struct {
+	Grid     *GridSpec `json:"grid"`
+	Count    int       `json:"count"`
+	Encoding string    `json:"encoding"`
+	Packing  *Packing  `json:"packing,omitempty"` // int16 only
+}
+
+// encodeParamObject writes a param file in the binary format.
+func encodeParamObject(file paramFile) ([]byte, error) {
+	header := binaryHeader{Grid: file.Grid, Count: len(file.Values), Encoding: encodingFloat32}
+	if file.Packing != nil {
+		header = binaryHeader{Grid: file.Grid, Count: len(file.Packed) / 2, Encoding: encodingInt16, Packing: file.Packing}
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(binaryMagic)
+	binary.Write(&buffer, binary.LittleEndian, uint16(binaryVersion))
+	binary.Write(&buffer, binary.LittleEndian, uint32(len(headerJSON)))
+	buffer.Write(headerJSON)
+	if file.Packing != nil {
+		buffer.Write(file.Packed)
+		return buffer.Bytes(), nil
+	}
+	block := make([]byte, 4*len(file.Values))
+	for i, v := range file.Values {
+		binary.LittleEndian.PutUint32(block[4*i:], math.Float32bits(float32(v)))
+	}
+	buffer.Write(block)
+	return buffer.Bytes(), nil
+}
+
+// decodeParamObject reads an object in whichever format it was written.
+func decodeParamObject(content []byte) (paramFile, error) {
+	var file paramFile
+	if !bytes.HasPrefix(content, []byte(binaryMagic)) {
+		err := json.Unmarshal(content, &file)
+		return file, err
+	}
+	if len(content) < 10 {
+		return file, fmt.Errorf("binary object of %d bytes", len(content))
+	}
+	if version := binary.LittleEndian.Uint16(content[4:]); version != binaryVersion {
+		return file, fmt.Errorf("unknown binary object version %d", version)
+	}
+	headerLength := int(binary.LittleEndian.Uint32(content[6:]))
+	if 10+headerLength > len(content) {
+		return file, fmt.Errorf("binary object header of %d bytes overruns the object", headerLength)
+	}
+	var header binaryHeader
+	if err := json.Unmarshal(content[10:10+headerLength], &header); err != nil {
+		return file, fmt.Errorf("failed to unmarshal binary object header: %w", err)
+	}
+	payload := content[10+headerLength:]
+
+	file.Grid = header.Grid
+	switch header.Encoding {
+	case encodingFloat32:
+		if len(payload) != 4*header.Count {
+			return file, fmt.Errorf("binary object holds %d bytes for %d float32s", len(payload), header.Count)
+		}
+		file.Values = make(NullFloats, header.Count)
+		for i := range file.Values {
+			file.Values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(payload[4*i:])))
+		}
+	case encodingInt16:
+		if len(payload) != 2*header.Count || header.Packing == nil {
+			return file, fmt.Errorf("binary object holds %d bytes for %d int16s", len(payload), header.Count)
+		}
+		file.Packing, file.Packed = header.Packing, payload
+	default:
+		return file, fmt.Errorf("unknown binary object encoding %q", header.Encoding)
+	}
+	return file, nil
+}
@@ -1,65 +1,134 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-func downloadAndSave(date string, batch string) error {
+// downloadAndSave downloads the given parameters of a batch and stores each
+// as its own object (see contentStore.go), so they can be loaded and evicted
+// independently.
+func downloadAndSave(ctx context.Context, date string, batch string, step int, params []string) error {
 	// date : yyyymmdd ; batch in 06z 18z UTC Time
-	var objectName string
-	var IndexPath string
-	if batch == "00z" || batch == "12z" {
-		objectName = makeRelative(date, batch, ".grib2", "oper")
-		IndexPath = makeAbs(bucketName, date, batch, ".index", "oper")
-		log.Println("Parsing oper")
-	} else if batch == "06z" || batch == "18z" {
-		objectName = makeRelative(date, batch, ".grib2", "scda")
-		IndexPath = makeAbs(bucketName, date, batch, ".index", "scda")
-		log.Println("Parsing scda")
+	if err := checkColdIngest(); err != nil {
+		return err
 	}
+	if err := decodersAvailable(ctx); err != nil {
+		return err
+	}
+	setLogField(ctx, "download", date+"-"+batch+"-"+stepName(step))
 
-	indexUrl := makeUrl("storage.googleapis.com", IndexPath)
-	indexScanner, err := queryIndex(indexUrl) // index resp scanner
+	stream, err := streamForBatch(batch)
+	if err != nil {
+		return err
+	}
+	setLogField(ctx, "stream", stream)
+	objectName := makeRelative(date, batch, step, ".grib2", stream)
+	indexScanner, err := source.Index(ctx, date, batch, stream, step) // index resp scanner
 	if err != nil {
 		return fmt.Errorf("fail to SingleQuery index: %w", err)
 	}
-	gribChunk, err := parseIndexResponse(indexScanner) // [10u, 10v]
+	gribChunk, err := parseIndexResponse(indexScanner, params) // e.g. [10u, 10v]
 	if err != nil {
 		return fmt.Errorf("fail to parse index response: %w", err)
 	}
-	gribJsonMap, err := getGribData(gribChunk, bucketName, objectName) // {"10u":.. "10v":..}
+	decoded, err := getGribData(ctx, gribChunk, bucketName, objectName) // {"10u":.. "10v":..}
 	if err != nil {
 		return fmt.Errorf("fail to get grib data: %w", err)
 	}
 
-	uValues, err := unwarpGribRawJsonValue(gribJsonMap["10u"])
-	if err != nil {
-		return fmt.Errorf("fail to unwrap 10u: %w", err)
+	for _, param := range params {
+		chunk, ok := decoded[param]
+		if !ok {
+			return fmt.Errorf("%w: %s is not in the index of %s", ErrDataNotPublished, param, objectName)
+		}
+		deduplicated, err := storeParam(date, batch, param, step, newParamFile(chunk.Grid, chunk.Values))
+		if err != nil {
+			return err
+		}
+		if deduplicated {
+			addLogCount(ctx, "deduplicated", 1)
+		}
 	}
-	vValues, err := unwarpGribRawJsonValue(gribJsonMap["10v"])
-	if err != nil {
-		return fmt.Errorf("fail to unwrap 10v: %w", err)
+	runIngestHooks(IngestMetadata{Date: date, Batch: batch, Step: step, Grid: decoded[params[0]].Grid, Params: params}, decoded)
+	return nil
+}
+
+// paramFile is the layout of stored objects and of the
+// tmp/<date>-<batch>-<param>.json files written before them.
+type paramFile struct {
+	Grid    *GridSpec  `json:"grid"`
+	Values  NullFloats `json:"values,omitempty"`  // unpacked values
+	Packing *Packing   `json:"packing,omitempty"` // set for packed values
+	Packed  []byte     `json:"packed,omitempty"`
+}
+
+func paramFilePath(date, batch, param string) string {
+	return filepath.Join(tmpDir, date+"-"+batch+"-"+param+".json")
+}
+
+// cacheFile is the layout of the tmp/<date>-<batch>.json files written before
+// parameters were stored separately. They are still read, never written.
+type cacheFile struct {
+	Grid *GridSpec  `json:"grid,omitempty"` // nil in files written before grids were recorded
+	U    NullFloats `json:"10u"`
+	V    NullFloats `json:"10v"`
+}
+
+// grid returns the grid the cached values are on.
+func (c *cacheFile) grid() (Grid, error) {
+	if c.Grid == nil {
+		return defaultGrid, nil
 	}
+	return c.Grid.Grid()
+}
 
-	processedMap := map[string][]float64{
-		"10u": uValues,
-		"10v": vValues,
+// streamForBatch returns the ECMWF stream a batch is published in: the main
+// runs at 00z/12z are "oper", the short cut-off runs at 06z/18z are "scda".
+func streamForBatch(batch string) (string, error) {
+	switch batch {
+	case "00z", "12z":
+		return "oper", nil
+	case "06z", "18z":
+		return "scda", nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidBatch, batch)
 	}
+}
 
-	processedJson, err := json.Marshal(processedMap)
-	if err != nil {
-		return fmt.Errorf("fail to marshal Map to Json: %w", err)
+// indexURL is the public URL of the .index file of a batch's forecast step.
+func indexURL(date, batch, stream string, step int) string {
+	return makeUrl("storage.googleapis.com", makeAbs(bucketName, date, batch, step, ".index", stream))
+}
+
+// Forecast steps (lead times) are published every 3 hours, up to 144h for the
+// main runs and 90h for the short cut-off runs at 06z/18z.
+const stepInterval = 3
+
+func maxStep(batch string) int {
+	if batch == "06z" || batch == "18z" {
+		return 90
 	}
+	return 144
+}
 
-	fileName := fmt.Sprintf("%s-%s.json", date, batch)
-	fileName = filepath.Join("tmp", fileName)
-	err = writeFile(fileName, []byte(processedJson))
-	if err != nil {
-		return fmt.Errorf("fail to write file: %w", err)
+// parseStep parses a step= argument such as "24" or "24h", empty is the
+// analysis (0h).
+func parseStep(s, batch string) (int, error) {
+	if s == "" {
+		return 0, nil
 	}
+	step, err := strconv.Atoi(strings.TrimSuffix(s, "h"))
+	if err != nil || step < 0 || step%stepInterval != 0 || step > maxStep(batch) {
+		return 0, fmt.Errorf("%w: step %q, want 0-%dh in %dh increments", ErrInvalidParams, s, maxStep(batch), stepInterval)
+	}
+	return step, nil
+}
 
-	return nil
+// stepName is how a step appears in object names and cache keys, e.g. "24h".
+func stepName(step int) string {
+	return strconv.Itoa(step) + "h"
 }
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Wind farm capacity factors from the archive: the 10 m wind at each turbine
+// site is extrapolated to hub height with the power law v(h) = v10 (h/10)^alpha
+// and run through the site's power curve. The portfolio is the output of all
+// sites with data over their combined capacity.
+
+const (
+	maxCapacitySites = 200
+	maxCapacityDays  = 366
+)
+
+// PowerCurve is a turbine's output in kW at hub height wind speeds in m/s,
+// linear in between. Outside the curve (below cut-in, above cut-out) the
+// turbine produces nothing.
+type PowerCurve struct {
+	Speed []float64 `json:"speed"`
+	Power []float64 `json:"power"`
+}
+
+func (c PowerCurve) at(speed float64) float64 {
+	if speed < c.Speed[0] || speed > c.Speed[len(c.Speed)-1] {
+		return 0
+	}
+	i, w := polarAxis(c.Speed, speed)
+	return (1-w)*c.Power[i] + w*c.Power[i+1]
+}
+
+type TurbineSite struct {
+	ID         string     `json:"id"`
+	Lat        float64    `json:"lat"`
+	Lon        float64    `json:"lon"`
+	HubHeight  float64    `json:"hub_height"` // m
+	Capacity   float64    `json:"capacity"`   // kW, the curve's maximum when 0
+	PowerCurve PowerCurve `json:"power_curve"`
+}
+
+type CapacityFactorRequest struct {
+	Start   string        `json:"start"`   // yyyymmdd
+	End     string        `json:"end"`     // yyyymmdd
+	Batches []string      `json:"batches"` // all when empty
+	Alpha   *float64      `json:"alpha"`   // wind shear exponent, 1/7 when unset
+	Sites   []TurbineSite `json:"sites"`
+}
+
+type CapacitySummary struct {
+	Mean    NullFloat `json:"mean"`
+	Std     NullFloat `json:"std"`
+	Min     NullFloat `json:"min"`
+	P10     NullFloat `json:"p10"`
+	P50     NullFloat `json:"p50"`
+	P90     NullFloat `json:"p90"`
+	Max     NullFloat `json:"max"`
+	Samples int       `json:"samples"`
+	Energy  NullFloat `json:"energy"` // MWh expected over the period at the mean capacity factor
+}
+
+type SiteCapacityFactor struct {
+	ID        string          `json:"id"`
+	Capacity  float64         `json:"capacity"`
+	HubSpeed  NullFloats      `json:"hub_speed"`  // m/s
+	CF        NullFloats      `json:"cf"`         // 0-1
+	MeanSpeed NullFloat       `json:"mean_speed"` // m/s at hub height
+	Summary   CapacitySummary `json:"summary"`
+	Missing   int             `json:"missing"` // date/batch pairs without data
+}
+
+type PortfolioCapacityFactor struct {
+	Capacity float64         `json:"capacity"`
+	CF       NullFloats      `json:"cf"`
+	Summary  CapacitySummary `json:"summary"`
+}
+
+type CapacityFactorResponse struct {
+	Times     []time.Time             `json:"times"`
+	Sites     []SiteCapacityFactor    `json:"sites"`
+	Portfolio PortfolioCapacityFactor `json:"portfolio"`
+	Status    int                     `json:"status"`
+	Success   bool                    `json:"success"`
+}
+
+var capacityFactorFailResponse = CapacityFactorResponse{
+	Times:   []time.Time{},
+	Sites:   []SiteCapacityFactor{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendCapacityFactorJsonError(w http.ResponseWriter, statusCode int) {
+	response := capacityFactorFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// capacityFactorHandler serves POST /energy/capacity-factor with a
+// CapacityFactorRequest body.
+func capacityFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var request CapacityFactorRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4<<20)).Decode(&request); err != nil {
+		sendCapacityFactorJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", request.Start+"-"+request.End)
+	data, err2 := CapacityFactorQuery(r.Context(), request)
+	if err2 != nil {
+		sendCapacityFactorJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func CapacityFactorQuery(ctx context.Context, request CapacityFactorRequest) (CapacityFactorResponse, error) {
+	if len(request.Sites) == 0 || len(request.Sites) > maxCapacitySites {
+		return capacityFactorFailResponse, fmt.Errorf("%w: between 1 and %d sites", ErrInvalidParams, maxCapacitySites)
+	}
+	alpha := defaultAlpha
+	if request.Alpha != nil {
+		alpha = *request.Alpha
+	}
+	if alpha < 0 || alpha > 1 {
+		return capacityFactorFailResponse, fmt.Errorf("%w: alpha must be between 0 and 1", ErrInvalidParams)
+	}
+	points := make([][2]float64, len(request.Sites))
+	for i := range request.Sites {
+		site := &request.Sites[i]
+		if err := site.validate(); err != nil {
+			return capacityFactorFailResponse, err
+		}
+		if site.Capacity == 0 {
+			site.Capacity = slices.Max(site.PowerCurve.Power)
+		}
+		points[i] = [2]float64{site.Lat, site.Lon}
+	}
+	batches := allBatches
+	if len(request.Batches) > 0 {
+		var err error
+		if batches, err = parseBatches(strings.Join(request.Batches, ",")); err != nil {
+			return capacityFactorFailResponse, err
+		}
+	}
+	dates, err := generateDateRange(request.Start, request.End)
+	if err != nil {
+		return capacityFactorFailResponse, err
+	}
+	if len(dates) > maxCapacityDays {
+		return capacityFactorFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxCapacityDays)
+	}
+
+	series, missing, err := pointsSeries(ctx, points, dates, batches)
+	if err != nil {
+		return capacityFactorFailResponse, err
+	}
+
+	// every date and batch is a time, sites without data there are null
+	times := make([]time.Time, 0, len(dates)*len(batches))
+	slot := make(map[string]int, len(dates)*len(batches))
+	for _, date := range dates {
+		for _, batch := range batches {
+			t, _ := time.Parse("2006010215", date+batch[:2])
+			slot[date+batch] = len(times)
+			times = append(times, t)
+		}
+	}
+	hours := float64(len(dates) * 24)
+
+	output := make([]float64, len(times)) // kW of the sites with data
+	capacity := make([]float64, len(times))
+	sites := make([]SiteCapacityFactor, len(request.Sites))
+	for i, site := range request.Sites {
+		result := SiteCapacityFactor{
+			ID:       site.ID,
+			Capacity: site.Capacity,
+			HubSpeed: nanFloats(len(times)),
+			CF:       nanFloats(len(times)),
+			Missing:  missing[i],
+		}
+		shear := math.Pow(site.HubHeight/10, alpha)
+		for _, sample := range series[i] {
+			k := slot[sample.Date+sample.Batch]
+			speed := math.Hypot(sample.U, sample.V) * shear
+			power := math.Min(site.PowerCurve.at(speed), site.Capacity)
+			result.HubSpeed[k] = speed
+			result.CF[k] = power / site.Capacity
+			output[k] += power
+			capacity[k] += site.Capacity
+		}
+		result.Summary = capacitySummary(result.CF, site.Capacity, hours)
+		meanSpeed, _ := meanStd(present(result.HubSpeed))
+		result.MeanSpeed = NullFloat(meanSpeed)
+		sites[i] = result
+	}
+
+	total := 0.0
+	for _, site := range request.Sites {
+		total += site.Capacity
+	}
+	portfolio := PortfolioCapacityFactor{Capacity: total, CF: nanFloats(len(times))}
+	for k := range times {
+		if capacity[k] > 0 {
+			portfolio.CF[k] = output[k] / capacity[k]
+		}
+	}
+	portfolio.Summary = capacitySummary(portfolio.CF, total, hours)
+	if portfolio.Summary.Samples == 0 {
+		return capacityFactorFailResponse, fmt.Errorf("%w: no data between %s and %s", ErrDataNotPublished, request.Start, request.End)
+	}
+	addLogCount(ctx, "points", int64(len(points)*len(times)))
+
+	return CapacityFactorResponse{
+		Times:     times,
+		Sites:     sites,
+		Portfolio: portfolio,
+		Status:    http.StatusOK,
+		Success:   true,
+	}, nil
+}
+
+func (s TurbineSite) validate() error {
+	curve := s.PowerCurve
+	switch {
+	case s.Lat < -90 || s.Lat > 90:
+		return fmt.Errorf("%w: site %q: latitude out of range [-90, 90]", ErrOutOfGrid, s.ID)
+	case s.HubHeight < 10 || s.HubHeight > 300:
+		return fmt.Errorf("%w: site %q: hub_height must be between 10 and 300 m", ErrInvalidParams, s.ID)
+	case len(curve.Speed) < 2 || len(curve.Speed) != len(curve.Power):
+		return fmt.Errorf("%w: site %q: power curve needs at least 2 speeds and a power per speed", ErrInvalidParams, s.ID)
+	case !slices.IsSorted(curve.Speed):
+		return fmt.Errorf("%w: site %q: power curve speeds must be ascending", ErrInvalidParams, s.ID)
+	case slices.Min(curve.Power) < 0 || s.Capacity < 0 || (s.Capacity == 0 && slices.Max(curve.Power) == 0):
+		return fmt.Errorf("%w: site %q: power and capacity must be positive", ErrInvalidParams, s.ID)
+	}
+	return nil
+}
+
+func nanFloats(n int) NullFloats {
+	values := make(NullFloats, n)
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	return values
+}
+
+// present returns the values that are not NaN.
+func present(values NullFloats) []float64 {
+	var kept []float64
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// capacitySummary summarizes a capacity factor series; energy is what
+// capacity kW produce over hours at the mean.
+func capacitySummary(cf NullFloats, capacity, hours float64) CapacitySummary {
+	values := present(cf)
+	slices.Sort(values)
+	mean, std := meanStd(values)
+	summary := CapacitySummary{
+		Mean:    NullFloat(mean),
+		Std:     NullFloat(std),
+		Min:     NullFloat(math.NaN()),
+		P10:     NullFloat(percentile(values, 10)),
+		P50:     NullFloat(percentile(values, 50)),
+		P90:     NullFloat(percentile(values, 90)),
+		Max:     NullFloat(math.NaN()),
+		Samples: len(values),
+		Energy:  NullFloat(mean * capacity * hours / 1000),
+	}
+	if len(values) > 0 {
+		summary.Min, summary.Max = NullFloat(values[0]), NullFloat(values[len(values)-1])
+	}
+	return summary
+}
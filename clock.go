@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Clock is the server's source of time. Everything that depends on "now" —
+// which batch is the latest, how long cached answers stay valid, when
+// background retries run — reads it through clock or clockFrom, so tests can
+// pin it and admins can replay a request with as_of=.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// fixedClock always reports the same instant. Its timers fire immediately,
+// since waiting on a clock that never moves would block forever.
+type fixedClock struct {
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+func (c fixedClock) After(d time.Duration) <-chan time.Time {
+	fired := make(chan time.Time, 1)
+	fired <- c.t.Add(d)
+	return fired
+}
+
+var clock Clock = systemClock{}
+
+type clockKey struct{}
+
+// clockFrom returns the request's clock, the server clock unless the request
+// was sent with as_of=.
+func clockFrom(ctx context.Context) Clock {
+	if c, ok := ctx.Value(clockKey{}).(Clock); ok {
+		return c
+	}
+	return clock
+}
+
+// parseAsOf accepts an RFC 3339 timestamp or a yyyymmddhh hour in UTC.
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006010215", s)
+}
+
+// timeTravel serves requests carrying as_of= with a clock fixed at that
+// instant. Only admins may move the clock, for anyone else as_of= is
+// rejected rather than ignored so a wrong answer is never mistaken for the
+// current one. Shared caches keep using the server clock.
+func timeTravel(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asOf := r.URL.Query().Get("as_of")
+		if asOf == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if roleRank[requestRole(r)] < roleRank[roleAdmin] {
+			sendAuthError(w, r, http.StatusForbidden)
+			return
+		}
+		t, err := parseAsOf(asOf)
+		if err != nil {
+			sendAuthError(w, r, http.StatusBadRequest)
+			return
+		}
+		setLogField(r.Context(), "as_of", t.Format(time.RFC3339))
+		ctx := context.WithValue(r.Context(), clockKey{}, Clock(fixedClock{t}))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxCompositeEvents = 1000 // dates listed, repeats included
+	maxCompositeDays   = 366  // distinct dates loaded
+
+	// step range of the common grid; the finest keeps a global grid within
+	// maxRegridPoints
+	minCompositeStep = LatStep / 2
+	maxCompositeStep = 10.0
+)
+
+type CompositeAPIParams struct {
+	Dates []string `json:"dates"` // yyyymmdd format
+	Batch string   `json:"batch"`
+	Step  float64  `json:"step"` // regrid to a global regular grid first, 0 keeps the native grid
+}
+
+type CompositeResponse struct {
+	Grid    GridSpec   `json:"grid"`
+	U       NullFloats `json:"u"`     // mean u, null where no date had data
+	V       NullFloats `json:"v"`     // mean v
+	Dates   []string   `json:"dates"` // dates that went into the composite
+	Missing []string   `json:"missing"`
+	Status  int        `json:"status"`
+	Success bool       `json:"success"`
+}
+
+var compositeFailResponse = CompositeResponse{
+	U:       NullFloats{},
+	V:       NullFloats{},
+	Dates:   []string{},
+	Missing: []string{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendCompositeJsonError(w http.ResponseWriter, statusCode int) {
+	response := compositeFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// compositeHandler serves GET /composite?dates=d1,d2,...&batch=&step= and
+// POST /composite with the CompositeAPIParams as JSON body, for date lists
+// too long for a URL.
+func compositeHandler(w http.ResponseWriter, r *http.Request) {
+	var params CompositeAPIParams
+	switch r.Method {
+	case http.MethodGet:
+		httpQuery := r.URL.Query()
+		if datesStr := httpQuery.Get("dates"); datesStr != "" {
+			params.Dates = strings.Split(datesStr, ",")
+		}
+		params.Batch = httpQuery.Get("batch")
+		if stepStr := httpQuery.Get("step"); stepStr != "" {
+			step, err := strconv.ParseFloat(stepStr, 64)
+			if err != nil {
+				sendCompositeJsonError(w, http.StatusBadRequest)
+				return
+			}
+			params.Step = step
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params); err != nil {
+			sendCompositeJsonError(w, http.StatusBadRequest)
+			return
+		}
+	default:
+		sendCompositeJsonError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(params.Dates) == 0 || params.Batch == "" {
+		sendCompositeJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "batch", params.Batch)
+	data, err := CompositeQuery(r.Context(), params)
+	if err != nil {
+		sendCompositeJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// CompositeQuery averages the wind field over the dates. Grids are added one
+// at a time into running sums, so memory stays at one output grid however
+// many dates there are; cells are averaged over the dates where they are
+// not missing.
+func CompositeQuery(ctx context.Context, params CompositeAPIParams) (CompositeResponse, error) {
+	if len(params.Dates) > maxCompositeEvents {
+		return compositeFailResponse, fmt.Errorf("%w: %d dates exceeds limit of %d", ErrInvalidParams, len(params.Dates), maxCompositeEvents)
+	}
+	days := make(map[string]bool, len(params.Dates))
+	for i, date := range params.Dates {
+		params.Dates[i] = strings.TrimSpace(date)
+		if err := validateDateBatch(params.Dates[i], params.Batch); err != nil {
+			return compositeFailResponse, err
+		}
+		days[params.Dates[i]] = true
+	}
+	if len(days) > maxCompositeDays {
+		return compositeFailResponse, fmt.Errorf("%w: %d distinct days exceeds limit of %d", ErrInvalidParams, len(days), maxCompositeDays)
+	}
+	if params.Step != 0 && !(params.Step >= minCompositeStep && params.Step <= maxCompositeStep) {
+		return compositeFailResponse, fmt.Errorf("%w: step must be 0 or between %g and %g", ErrInvalidParams, minCompositeStep, maxCompositeStep)
+	}
+
+	var target Grid
+	if params.Step > 0 {
+		// regridTarget bounds the grid size before anything is allocated
+		regular, err := regridTarget(90, -180, -90, 180, params.Step)
+		if err != nil {
+			return compositeFailResponse, err
+		}
+		target = regular
+	}
+
+	var sumU, sumV []float64
+	var count []int32
+	used := make([]string, 0, len(params.Dates))
+	missing := []string{}
+	seen := make(map[string]bool, len(params.Dates))
+	for _, date := range params.Dates {
+		if seen[date] {
+			continue
+		}
+		seen[date] = true
+		if err := ctx.Err(); err != nil {
+			return compositeFailResponse, err
+		}
+
+		filePath := filepath.Join(tmpDir, date+"-"+params.Batch+".json")
+		cache, err := getOrLoadFileCache(ctx, filePath, date, params.Batch)
+		if errors.Is(err, ErrMemoryPressure) {
+			return compositeFailResponse, err
+		}
+		if err != nil {
+			appendLogField(ctx, "missing_dates", date)
+			setLogField(ctx, "load_error", err)
+			missing = append(missing, date)
+			continue
+		}
+
+		u, v := cache.U, cache.V
+		if target == nil {
+			target = cache.Grid
+		}
+		if !sameGrid(cache.Grid, target) {
+			regular, ok := target.(RegularLatLonGrid)
+			if !ok || params.Step == 0 {
+				return compositeFailResponse, fmt.Errorf("%w: %s is on a different grid than the other dates, pass step to composite on a common grid", ErrInvalidParams, date)
+			}
+			if u, err = Regrid(cache.Grid, cache.U, regular, RegridBilinear); err != nil {
+				return compositeFailResponse, fmt.Errorf("failed to regrid 10u of %s: %w", date, err)
+			}
+			if v, err = Regrid(cache.Grid, cache.V, regular, RegridBilinear); err != nil {
+				return compositeFailResponse, fmt.Errorf("failed to regrid 10v of %s: %w", date, err)
+			}
+		}
+
+		if sumU == nil {
+			sumU = make([]float64, target.Size())
+			sumV = make([]float64, target.Size())
+			count = make([]int32, target.Size())
+		}
+		n := min(len(u), len(v), len(sumU))
+		for i := 0; i < n; i++ {
+			if math.IsNaN(u[i]) || math.IsNaN(v[i]) {
+				continue
+			}
+			sumU[i] += u[i]
+			sumV[i] += v[i]
+			count[i]++
+		}
+		used = append(used, date)
+	}
+
+	if len(used) == 0 {
+		return compositeFailResponse, fmt.Errorf("%w: none of the %d dates could be loaded", ErrDataNotPublished, len(params.Dates))
+	}
+
+	// turn the sums into means in place
+	for i := range sumU {
+		if count[i] == 0 {
+			sumU[i], sumV[i] = math.NaN(), math.NaN()
+			continue
+		}
+		sumU[i] /= float64(count[i])
+		sumV[i] /= float64(count[i])
+	}
+
+	response := CompositeResponse{
+		Grid:    specOf(target),
+		U:       sumU,
+		V:       sumV,
+		Dates:   used,
+		Missing: missing,
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	return response, nil
+}
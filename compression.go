@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Grid files in tmp/ can be compressed with zstd, wind fields shrink several
+// times. Files are decompressed while they are decoded, never in full. Files
+// written with gzip by earlier versions (.gz) are still read.
+
+const (
+	compressedSuffix     = ".zst"
+	gzipCompressedSuffix = ".gz"
+)
+
+// compressLevel is the zstd level, as for the zstd command, new grid files
+// are written with, 0 writes them uncompressed. Files keep the form they
+// were written in.
+var compressLevel = 0
+
+func parseCompressLevel(level int) (int, error) {
+	if level < 0 || level > 22 {
+		return 0, fmt.Errorf("level %d, want 0 (off) or 1-22", level)
+	}
+	return level, nil
+}
+
+// writeGridFile writes a grid file, compressed to path.zst when compression
+// is on, and drops the other forms of it so only one is ever on disk. The
+// file is written to a .partial file renamed in place, so a write cut short
+// never leaves half a grid under the real name.
+func writeGridFile(path string, data []byte) error {
+	target := path
+	if compressLevel != 0 {
+		target = path + compressedSuffix
+	}
+	partial := target + partialSuffix
+	if err := writeGridContent(partial, data); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	if err := os.Rename(partial, target); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	for _, other := range gridFileForms(path) {
+		if other != target {
+			os.Remove(other)
+		}
+	}
+	return nil
+}
+
+// gridFileForms returns the paths a grid file may be stored under.
+func gridFileForms(path string) []string {
+	return []string{path + compressedSuffix, path + gzipCompressedSuffix, path}
+}
+
+// removeGridFile removes a grid file in whichever form it is on disk.
+func removeGridFile(path string) {
+	for _, form := range gridFileForms(path) {
+		os.Remove(form)
+	}
+}
+
+func writeGridContent(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if compressLevel == 0 {
+		if _, err := file.Write(data); err != nil {
+			return err
+		}
+		return file.Close()
+	}
+	writer, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compressLevel)), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	// the frame records the raw size, which the cache listing reports
+	writer.ResetContentSize(file, int64(len(data)))
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+type zstdFile struct {
+	*zstd.Decoder
+	file *os.File
+}
+
+func (f zstdFile) Close() error {
+	f.Decoder.Close()
+	return f.file.Close()
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
+
+// openGridFile opens a grid file in whichever form it is on disk,
+// decompressing on the fly.
+func openGridFile(path string) (io.ReadCloser, error) {
+	for _, form := range gridFileForms(path) {
+		file, err := os.Open(form)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasSuffix(form, compressedSuffix):
+			reader, err := zstd.NewReader(file, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s: %w", form, err)
+			}
+			return zstdFile{Decoder: reader, file: file}, nil
+		case strings.HasSuffix(form, gzipCompressedSuffix):
+			reader, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s: %w", form, err)
+			}
+			return gzipFile{Reader: reader, file: file}, nil
+		default:
+			return file, nil
+		}
+	}
+	return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+}
+
+// gridFileExists reports whether path exists in any form.
+func gridFileExists(path string) bool {
+	for _, form := range gridFileForms(path) {
+		if _, err := os.Stat(form); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// compressedRawSize returns the uncompressed size of a compressed grid file,
+// ok false for files that are not compressed or do not record it.
+func compressedRawSize(path string) (int64, bool) {
+	switch {
+	case strings.HasSuffix(path, compressedSuffix):
+		size, err := zstdRawSize(path)
+		return size, err == nil
+	case strings.HasSuffix(path, gzipCompressedSuffix):
+		size, err := gzipRawSize(path)
+		return size, err == nil
+	}
+	return 0, false
+}
+
+// zstdRawSize returns the content size recorded in the header of a zstd
+// file's frame.
+func zstdRawSize(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	header := make([]byte, zstd.HeaderMaxSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	var frame zstd.Header
+	if err := frame.Decode(header[:n]); err != nil {
+		return 0, err
+	}
+	if !frame.HasFCS {
+		return 0, fmt.Errorf("%s: frame without a content size", path)
+	}
+	return int64(frame.FrameContentSize), nil
+}
+
+// gzipRawSize returns the uncompressed size recorded in a gzip file's
+// trailer, exact for files under 4 GiB.
+func gzipRawSize(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var trailer [4]byte
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.ReadAt(trailer[:], info.Size()-4); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:])), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Every setting of the server is a command line flag. The same settings can
+// come from a -config file, a JSON object keyed by flag name,
+//
+//	{
+//	  "listen": ":9090",
+//	  "tmp-dir": "/var/lib/griber",
+//	  "rate-limit": 600,
+//	  "rate-window": "1m",
+//	  "no-gcs-auth": true
+//	}
+//
+// and from GRIBER_* environment variables, the flag name in upper case with
+// dashes as underscores (GRIBER_RATE_LIMIT=600). The command line wins over
+// the environment, which wins over the file.
+
+const configEnvPrefix = "GRIBER_"
+
+var (
+	listenAddr = ":8080"
+	bucketName = "ecmwf-open-data"
+	tmpDir     = "tmp" // grid files, the manifest and content addressed objects
+)
+
+// configEnvName is the environment variable of a flag.
+func configEnvName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyConfig sets the flags of fs not given on the command line from the
+// environment or, failing that, the config file at path (none when empty).
+// Call it after fs.Parse.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	file := map[string]json.RawMessage{}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(content, &file); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for name := range file {
+			if fs.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+		}
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == "config" {
+			return
+		}
+		value, ok := os.LookupEnv(configEnvName(f.Name))
+		source := configEnvName(f.Name)
+		if !ok {
+			raw, inFile := file[f.Name]
+			if !inFile {
+				return
+			}
+			if value, err = configValue(raw); err != nil {
+				err = fmt.Errorf("%s: %s: %w", path, f.Name, err)
+				return
+			}
+			source = path
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %s: %w", source, f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// configValue turns a JSON string, number or bool into the text of a flag.
+func configValue(raw json.RawMessage) (string, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return fmt.Sprint(value), nil
+	}
+	return "", fmt.Errorf("want a string, number or bool")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Grid files are stored by content: tmp/objects/ab/<sha256>.bin holds a
+// param file (see binaryFormat.go) whose uncompressed bytes hash to that
+// name, objects stored before the binary format end in .json, and tmp/manifest.json
+// maps every (date, batch, param, step) to its object. Re-ingesting data that
+// did not change upstream reuses the object instead of writing it again, and
+// every read checks the hash, so a corrupted file is re-downloaded rather
+// than served.
+
+var errCorruptObject = errors.New("object does not match its hash")
+
+type ManifestEntry struct {
+	Hash      string    `json:"hash"`
+	Bytes     int64     `json:"bytes"`            // uncompressed size of the object
+	Format    string    `json:"format,omitempty"` // "bin", empty for the JSON objects written before
+	Packing   string    `json:"packing"`
+	MaxError  float64   `json:"max_error,omitempty"` // accuracy bound of packed values
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type gridManifest struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]ManifestEntry // nil until loaded
+}
+
+var manifest = &gridManifest{path: filepath.Join(tmpDir, "manifest.json")}
+
+func manifestKey(date, batch, param, step string) string {
+	return date + "|" + batch + "|" + param + "|" + step
+}
+
+const formatBinary = "bin"
+
+func objectPath(hash, format string) string {
+	extension := ".json"
+	if format == formatBinary {
+		extension = ".bin"
+	}
+	return filepath.Join(tmpDir, "objects", hash[:2], hash+extension)
+}
+
+func (e ManifestEntry) path() string {
+	return objectPath(e.Hash, e.Format)
+}
+
+// loadLocked reads the manifest on first use.
+func (m *gridManifest) loadLocked() error {
+	if m.entries != nil {
+		return nil
+	}
+	entries := make(map[string]ManifestEntry)
+	content, err := os.ReadFile(m.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &entries); err != nil {
+			return fmt.Errorf("failed to unmarshal %s: %w", m.path, err)
+		}
+	}
+	m.entries = entries
+	return nil
+}
+
+// saveLocked writes the manifest through a temporary file, so a crash never
+// leaves it truncated.
+func (m *gridManifest) saveLocked() error {
+	content, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	temp := m.path + ".tmp"
+	if err := os.WriteFile(temp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, m.path)
+}
+
+func (m *gridManifest) get(key string) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(); err != nil {
+		log.Printf("Failed to load grid manifest: %v", err)
+		return ManifestEntry{}, false
+	}
+	entry, ok := m.entries[key]
+	return entry, ok
+}
+
+// snapshot returns a copy of every entry.
+func (m *gridManifest) snapshot() (map[string]ManifestEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]ManifestEntry, len(m.entries))
+	for key, entry := range m.entries {
+		entries[key] = entry
+	}
+	return entries, nil
+}
+
+// set points key at entry, or drops it when entry is nil, and deletes the
+// object key pointed at before if nothing references it anymore.
+func (m *gridManifest) set(key string, entry *ManifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(); err != nil {
+		return err
+	}
+	previous, existed := m.entries[key]
+	if entry == nil {
+		delete(m.entries, key)
+	} else {
+		m.entries[key] = *entry
+	}
+	if err := m.saveLocked(); err != nil {
+		return err
+	}
+	if existed && (entry == nil || entry.Hash != previous.Hash) && !m.referencedLocked(previous.Hash) {
+		removeGridFile(previous.path())
+	}
+	return nil
+}
+
+func (m *gridManifest) referencedLocked(hash string) bool {
+	for _, entry := range m.entries {
+		if entry.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// storeParam writes a param file as a content addressed object and records
+// it in the manifest. It returns whether an identical object was already
+// stored.
+func storeParam(date, batch, param string, step int, file paramFile) (bool, error) {
+	content, err := encodeParamObject(file)
+	if err != nil {
+		return false, fmt.Errorf("fail to encode %s: %w", param, err)
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	path := objectPath(hash, formatBinary)
+
+	deduplicated := gridFileExists(path)
+	if !deduplicated {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return false, err
+		}
+		if err := writeGridFile(path, content); err != nil {
+			return false, fmt.Errorf("fail to write file: %w", err)
+		}
+	}
+
+	entry := ManifestEntry{Hash: hash, Bytes: int64(len(content)), Format: formatBinary, Packing: packingFloat, UpdatedAt: clock.Now().UTC()}
+	if file.Packing != nil {
+		entry.Packing, entry.MaxError = file.Packing.Type, file.Packing.MaxError
+	}
+	if err := manifest.set(manifestKey(date, batch, param, stepName(step)), &entry); err != nil {
+		return false, fmt.Errorf("fail to update manifest: %w", err)
+	}
+	return deduplicated, nil
+}
+
+// readStoredParam reads a param through the manifest, checking the object
+// against its hash. A corrupted object is dropped from the manifest and
+// reported as errCorruptObject, so the caller downloads it again.
+func readStoredParam(date, batch, param string, step int) (*ParamGrid, error) {
+	key := manifestKey(date, batch, param, stepName(step))
+	entry, ok := manifest.get(key)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	path := entry.path()
+	file, err := openGridFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer file.Close()
+
+	var data paramFile
+	content, decodeErr := io.ReadAll(file)
+	sum := sha256.Sum256(content)
+	if decodeErr == nil {
+		data, decodeErr = decodeParamObject(content)
+	}
+	if decodeErr != nil || hex.EncodeToString(sum[:]) != entry.Hash {
+		log.Printf("Dropping corrupt object %s of %s (decode error: %v)", path, key, decodeErr)
+		manifest.set(key, nil)
+		return nil, fmt.Errorf("%s: %w", path, errCorruptObject)
+	}
+	return decodeParamFile(path, data)
+}
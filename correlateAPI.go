@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+const maxCorrelateDays = 366
+
+type CorrelateAPIParams struct {
+	Lat1      float64  `json:"lat1"`
+	Lon1      float64  `json:"lon1"`
+	Lat2      float64  `json:"lat2"`
+	Lon2      float64  `json:"lon2"`
+	StartDate string   `json:"start"` // yyyymmdd format
+	EndDate   string   `json:"end"`   // yyyymmdd format
+	Batches   []string `json:"batches"`
+}
+
+// Correlation compares one variable at both points.
+type Correlation struct {
+	Pearson    NullFloat `json:"pearson"`
+	Covariance NullFloat `json:"covariance"`
+	Mean1      NullFloat `json:"mean1"`
+	Mean2      NullFloat `json:"mean2"`
+}
+
+type CorrelateResponse struct {
+	P1       [2]float64  `json:"p1"` // lat, lon
+	P2       [2]float64  `json:"p2"`
+	Start    string      `json:"start"`
+	End      string      `json:"end"`
+	Batches  []string    `json:"batches"`
+	Speed    Correlation `json:"speed"`
+	U        Correlation `json:"u"`
+	V        Correlation `json:"v"`
+	Samples  int         `json:"samples"`  // times with data at both points
+	Missing  int         `json:"missing"`  // times missing at either point
+	Distance float64     `json:"distance"` // km between the points
+	Status   int         `json:"status"`
+	Success  bool        `json:"success"`
+}
+
+var correlateFailResponse = CorrelateResponse{
+	Batches: []string{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendCorrelateJsonError(w http.ResponseWriter, statusCode int) {
+	response := correlateFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseLatLon parses a "lat,lon" pair.
+func parseLatLon(s string) (float64, float64, error) {
+	latStr, lonStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %q is not lat,lon", ErrInvalidParams, s)
+	}
+	lat, err := parseCoordinate(strings.TrimSpace(latStr))
+	if err != nil {
+		return 0, 0, err
+	}
+	lon, err := parseCoordinate(strings.TrimSpace(lonStr))
+	if err != nil {
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+// correlateHandler serves /correlate?p1=lat,lon&p2=lat,lon&start=&end=&batch=
+func correlateHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	lat1, lon1, err := parseLatLon(httpQuery.Get("p1"))
+	if err != nil {
+		sendCorrelateJsonError(w, http.StatusBadRequest)
+		return
+	}
+	lat2, lon2, err := parseLatLon(httpQuery.Get("p2"))
+	if err != nil {
+		sendCorrelateJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	start := httpQuery.Get("start")
+	end := httpQuery.Get("end")
+	if start == "" || end == "" {
+		sendCorrelateJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	batches, err := parseBatches(httpQuery.Get("batch"))
+	if err != nil {
+		sendCorrelateJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := CorrelateAPIParams{
+		Lat1:      lat1,
+		Lon1:      lon1,
+		Lat2:      lat2,
+		Lon2:      lon2,
+		StartDate: start,
+		EndDate:   end,
+		Batches:   batches,
+	}
+
+	setLogField(r.Context(), "date", start+"-"+end)
+	data, err2 := CorrelateQuery(r.Context(), params)
+	if err2 != nil {
+		sendCorrelateJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func CorrelateQuery(ctx context.Context, params CorrelateAPIParams) (CorrelateResponse, error) {
+	dates, err := generateDateRange(params.StartDate, params.EndDate)
+	if err != nil {
+		return correlateFailResponse, err
+	}
+	if len(dates) > maxCorrelateDays {
+		return correlateFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxCorrelateDays)
+	}
+
+	// the second series hits the files the first one just loaded
+	series1, _, err := pointSeries(ctx, params.Lat1, params.Lon1, dates, params.Batches)
+	if err != nil {
+		return correlateFailResponse, err
+	}
+	series2, _, err := pointSeries(ctx, params.Lat2, params.Lon2, dates, params.Batches)
+	if err != nil {
+		return correlateFailResponse, err
+	}
+
+	at2 := make(map[string]seriesSample, len(series2))
+	for _, sample := range series2 {
+		at2[sample.Date+sample.Batch] = sample
+	}
+	var speed1, speed2, u1, u2, v1, v2 []float64
+	for _, s1 := range series1 {
+		s2, ok := at2[s1.Date+s1.Batch]
+		if !ok {
+			continue
+		}
+		speed1 = append(speed1, math.Hypot(s1.U, s1.V))
+		speed2 = append(speed2, math.Hypot(s2.U, s2.V))
+		u1, u2 = append(u1, s1.U), append(u2, s2.U)
+		v1, v2 = append(v1, s1.V), append(v2, s2.V)
+	}
+	if len(speed1) < 2 {
+		return correlateFailResponse, fmt.Errorf("%w: %d times with data at both points between %s and %s", ErrDataNotPublished, len(speed1), params.StartDate, params.EndDate)
+	}
+
+	response := CorrelateResponse{
+		P1:       [2]float64{params.Lat1, params.Lon1},
+		P2:       [2]float64{params.Lat2, params.Lon2},
+		Start:    params.StartDate,
+		End:      params.EndDate,
+		Batches:  params.Batches,
+		Speed:    correlate(speed1, speed2),
+		U:        correlate(u1, u2),
+		V:        correlate(v1, v2),
+		Samples:  len(speed1),
+		Missing:  len(dates)*len(params.Batches) - len(speed1),
+		Distance: haversineKm(params.Lat1, params.Lon1, params.Lat2, params.Lon2),
+		Status:   http.StatusOK,
+		Success:  true,
+	}
+	return response, nil
+}
+
+// correlate computes the sample covariance and Pearson correlation of two
+// equally long series. The correlation is NaN when either series is constant.
+func correlate(a, b []float64) Correlation {
+	meanA, stdA := meanStd(a)
+	meanB, stdB := meanStd(b)
+	var sum float64
+	for i := range a {
+		sum += (a[i] - meanA) * (b[i] - meanB)
+	}
+	covariance := sum / float64(len(a)-1)
+	pearson := math.NaN()
+	if stdA > 0 && stdB > 0 {
+		pearson = covariance / (stdA * stdB)
+	}
+	return Correlation{
+		Pearson:    NullFloat(pearson),
+		Covariance: NullFloat(covariance),
+		Mean1:      NullFloat(meanA),
+		Mean2:      NullFloat(meanB),
+	}
+}
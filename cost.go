@@ -0,0 +1,189 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cost accounting: every request is charged to its client (bearer token
+// fingerprint, or IP without one) by month, from the counts the request
+// logged: points returned, bytes fetched upstream and milliseconds spent
+// decoding GRIB. Units weigh them into one number to charge back heavy
+// consumers with. Totals live in -costs, written every costFlushInterval,
+// and GET /admin/costs exports a month as JSON or CSV.
+
+const (
+	pointsPerUnit     = 1000    // points returned
+	bytesPerUnit      = 1 << 20 // bytes fetched upstream
+	decodeMsPerUnit   = 100     // milliseconds of decoding
+	costFlushInterval = time.Minute
+)
+
+var costsPath = "costs.json"
+
+type CostTotals struct {
+	Requests      int64   `json:"requests"`
+	Points        int64   `json:"points"`
+	UpstreamBytes int64   `json:"upstream_bytes"`
+	DecodeSeconds float64 `json:"decode_seconds"`
+	Units         float64 `json:"units"`
+}
+
+var (
+	costMutex sync.Mutex
+	costs     map[string]map[string]*CostTotals // month (2006-01) -> client -> totals, nil until loaded
+	costDirty bool
+)
+
+// loadCostsLocked reads -costs on first use.
+func loadCostsLocked() {
+	if costs != nil {
+		return
+	}
+	costs = make(map[string]map[string]*CostTotals)
+	if costsPath == "" {
+		return
+	}
+	content, err := os.ReadFile(costsPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Fail to read costs %s: %v", costsPath, err)
+		}
+		return
+	}
+	if err := json.Unmarshal(content, &costs); err != nil {
+		log.Printf("Fail to parse costs %s, starting over: %v", costsPath, err)
+		costs = make(map[string]map[string]*CostTotals)
+	}
+}
+
+// recordCost charges a completed request to its client.
+func recordCost(r *http.Request, fields *logFields) {
+	points, upstream, decodeMs := fields.count("points"), fields.count("bytes"), fields.count("decode_ms")
+	month := clock.Now().UTC().Format("2006-01")
+	client := rateClient(r)
+
+	costMutex.Lock()
+	defer costMutex.Unlock()
+	loadCostsLocked()
+	if costs[month] == nil {
+		costs[month] = make(map[string]*CostTotals)
+	}
+	totals := costs[month][client]
+	if totals == nil {
+		totals = &CostTotals{}
+		costs[month][client] = totals
+	}
+	totals.Requests++
+	totals.Points += points
+	totals.UpstreamBytes += upstream
+	totals.DecodeSeconds += float64(decodeMs) / 1000
+	totals.Units += float64(points)/pointsPerUnit + float64(upstream)/bytesPerUnit + float64(decodeMs)/decodeMsPerUnit
+	costDirty = true
+}
+
+// startCostFlusher writes the totals to -costs while they change.
+func startCostFlusher() {
+	if costsPath == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(costFlushInterval) {
+			if err := flushCosts(); err != nil {
+				log.Printf("Fail to write costs %s: %v", costsPath, err)
+			}
+		}
+	}()
+}
+
+func flushCosts() error {
+	costMutex.Lock()
+	if !costDirty {
+		costMutex.Unlock()
+		return nil
+	}
+	content, err := json.MarshalIndent(costs, "", "  ")
+	costDirty = false
+	costMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	temp := costsPath + ".tmp"
+	if err := os.WriteFile(temp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, costsPath)
+}
+
+type CostItem struct {
+	Client string `json:"client"`
+	CostTotals
+}
+
+type CostResponse struct {
+	Month   string     `json:"month"`
+	Clients []CostItem `json:"clients"` // most units first
+	Status  int        `json:"status"`
+	Success bool       `json:"success"`
+}
+
+// adminCostsHandler serves GET /admin/costs?month=2006-01&format=json|csv,
+// the current month by default.
+func adminCostsHandler(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = clock.Now().UTC().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		sendAdminResponse(w, r, "costs", fmt.Errorf("%w: month %q", ErrInvalidParams, month), "")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		sendAdminResponse(w, r, "costs", fmt.Errorf("%w: format %q", ErrInvalidParams, format), "")
+		return
+	}
+
+	response := CostResponse{Month: month, Clients: []CostItem{}, Status: http.StatusOK, Success: true}
+	costMutex.Lock()
+	loadCostsLocked()
+	for client, totals := range costs[month] {
+		response.Clients = append(response.Clients, CostItem{Client: client, CostTotals: *totals})
+	}
+	costMutex.Unlock()
+	slices.SortFunc(response.Clients, func(a, b CostItem) int {
+		return cmp.Or(cmp.Compare(b.Units, a.Units), cmp.Compare(a.Client, b.Client))
+	})
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"costs-%s.csv\"", month))
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"month", "client", "requests", "points", "upstream_bytes", "decode_seconds", "units"})
+		for _, item := range response.Clients {
+			writer.Write([]string{
+				month, item.Client,
+				strconv.FormatInt(item.Requests, 10),
+				strconv.FormatInt(item.Points, 10),
+				strconv.FormatInt(item.UpstreamBytes, 10),
+				strconv.FormatFloat(item.DecodeSeconds, 'f', 3, 64),
+				strconv.FormatFloat(item.Units, 'f', 3, 64),
+			})
+		}
+		writer.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
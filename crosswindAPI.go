@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Runway wind components from the 10 m wind, interpolated to the requested
+// instant between batches. Runway headings are in degrees true; the wind
+// direction is the one it blows from, so a wind straight down the runway is
+// all headwind.
+
+const maxCrosswindAirports = 500
+
+type CrosswindComponents struct {
+	RunwayHeading float64   `json:"runway_heading"`
+	Headwind      NullFloat `json:"headwind"`   // along the runway, negative for a tailwind
+	Tailwind      NullFloat `json:"tailwind"`   // 0 unless the wind is from behind
+	Crosswind     NullFloat `json:"crosswind"`  // across the runway, always positive
+	CrossFrom     string    `json:"cross_from"` // left or right, seen from the aircraft
+}
+
+type CrosswindResponse struct {
+	Lat        float64             `json:"lat"`
+	Lon        float64             `json:"lon"`
+	Time       time.Time           `json:"time"`
+	Unit       string              `json:"unit"`
+	WindSpeed  NullFloat           `json:"wind_speed"`
+	WindDir    NullFloat           `json:"wind_dir"` // degrees the wind blows from
+	Components CrosswindComponents `json:"components"`
+	Status     int                 `json:"status"`
+	Success    bool                `json:"success"`
+}
+
+var crosswindFailResponse = CrosswindResponse{
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+// CrosswindAirport is one entry of a batch request, with the runway headings
+// to compute.
+type CrosswindAirport struct {
+	ID      string    `json:"id"`
+	Lat     float64   `json:"lat"`
+	Lon     float64   `json:"lon"`
+	Runways []float64 `json:"runways"`
+}
+
+type CrosswindBatchRequest struct {
+	Time     string             `json:"time"`  // RFC 3339 or yyyymmddhh
+	Units    string             `json:"units"` // kts (default), m/s or km/h
+	Airports []CrosswindAirport `json:"airports"`
+}
+
+type AirportCrosswind struct {
+	ID        string                `json:"id"`
+	Lat       float64               `json:"lat"`
+	Lon       float64               `json:"lon"`
+	WindSpeed NullFloat             `json:"wind_speed"`
+	WindDir   NullFloat             `json:"wind_dir"`
+	Runways   []CrosswindComponents `json:"runways"`
+}
+
+type CrosswindBatchResponse struct {
+	Time     time.Time          `json:"time"`
+	Unit     string             `json:"unit"`
+	Airports []AirportCrosswind `json:"airports"`
+	Status   int                `json:"status"`
+	Success  bool               `json:"success"`
+}
+
+var crosswindBatchFailResponse = CrosswindBatchResponse{
+	Airports: []AirportCrosswind{},
+	Status:   http.StatusBadRequest,
+	Success:  false,
+}
+
+func sendCrosswindJsonError(w http.ResponseWriter, statusCode int) {
+	response := crosswindFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func sendCrosswindBatchJsonError(w http.ResponseWriter, statusCode int) {
+	response := crosswindBatchFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// crosswindHandler serves GET /aviation/crosswind?lat=&lon=&runway_heading=&time=&units=
+func crosswindHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	var values [3]float64
+	for i, name := range []string{"lat", "lon", "runway_heading"} {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendCrosswindJsonError(w, http.StatusBadRequest)
+			return
+		}
+		values[i] = value
+	}
+	at, err := parseAsOf(httpQuery.Get("time"))
+	if err != nil {
+		sendCrosswindJsonError(w, http.StatusBadRequest)
+		return
+	}
+	unit, err := parseCrosswindUnit(httpQuery.Get("units"))
+	if err != nil {
+		sendCrosswindJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", at.Format("2006010215"))
+	airport := CrosswindAirport{Lat: values[0], Lon: values[1], Runways: []float64{values[2]}}
+	data, err2 := CrosswindQuery(r.Context(), at, unit, []CrosswindAirport{airport})
+	if err2 != nil {
+		sendCrosswindJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	result := data.Airports[0]
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(CrosswindResponse{
+		Lat:        result.Lat,
+		Lon:        result.Lon,
+		Time:       data.Time,
+		Unit:       data.Unit,
+		WindSpeed:  result.WindSpeed,
+		WindDir:    result.WindDir,
+		Components: result.Runways[0],
+		Status:     http.StatusOK,
+		Success:    true,
+	})
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// crosswindBatchHandler serves POST /aviation/crosswind with a
+// CrosswindBatchRequest body, every airport at the same instant.
+func crosswindBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var request CrosswindBatchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&request); err != nil {
+		sendCrosswindBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+	at, err := parseAsOf(request.Time)
+	if err != nil {
+		sendCrosswindBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+	unit, err := parseCrosswindUnit(request.Units)
+	if err != nil {
+		sendCrosswindBatchJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", at.Format("2006010215"))
+	data, err2 := CrosswindQuery(r.Context(), at, unit, request.Airports)
+	if err2 != nil {
+		sendCrosswindBatchJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// parseCrosswindUnit is parseWindUnit defaulting to knots, the unit of
+// aircraft crosswind limits.
+func parseCrosswindUnit(s string) (string, error) {
+	if s == "" {
+		return windUnitKts, nil
+	}
+	unit, err := parseWindUnit(s)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidParams, err)
+	}
+	return unit, nil
+}
+
+func CrosswindQuery(ctx context.Context, at time.Time, unit string, airports []CrosswindAirport) (CrosswindBatchResponse, error) {
+	if len(airports) == 0 || len(airports) > maxCrosswindAirports {
+		return crosswindBatchFailResponse, fmt.Errorf("%w: between 1 and %d airports", ErrInvalidParams, maxCrosswindAirports)
+	}
+	for _, airport := range airports {
+		if airport.Lat < -90 || airport.Lat > 90 {
+			return crosswindBatchFailResponse, fmt.Errorf("%w: lat %g", ErrOutOfGrid, airport.Lat)
+		}
+		if len(airport.Runways) == 0 {
+			return crosswindBatchFailResponse, fmt.Errorf("%w: airport %q has no runways", ErrInvalidParams, airport.ID)
+		}
+		for _, heading := range airport.Runways {
+			if heading < 0 || heading > 360 {
+				return crosswindBatchFailResponse, fmt.Errorf("%w: runway heading %g", ErrInvalidParams, heading)
+			}
+		}
+	}
+
+	field := newWindField(ctx)
+	results := make([]AirportCrosswind, len(airports))
+	for i, airport := range airports {
+		u, v, err := field.at(at, airport.Lat, airport.Lon)
+		if err != nil {
+			return crosswindBatchFailResponse, err
+		}
+		speed, dir := math.Hypot(u, v), windDirection(u, v)
+		runways := make([]CrosswindComponents, len(airport.Runways))
+		for k, heading := range airport.Runways {
+			runways[k] = runwayComponents(speedIn(speed, unit), dir, heading)
+		}
+		results[i] = AirportCrosswind{
+			ID:        airport.ID,
+			Lat:       airport.Lat,
+			Lon:       airport.Lon,
+			WindSpeed: NullFloat(speedIn(speed, unit)),
+			WindDir:   NullFloat(dir),
+			Runways:   runways,
+		}
+	}
+	setLogField(ctx, "airports", len(airports))
+
+	return CrosswindBatchResponse{
+		Time:     at,
+		Unit:     unit,
+		Airports: results,
+		Status:   http.StatusOK,
+		Success:  true,
+	}, nil
+}
+
+// runwayComponents splits a wind of speed from dir into components along and
+// across a runway of the given heading.
+func runwayComponents(speed, dir, heading float64) CrosswindComponents {
+	angle := (dir - heading) * math.Pi / 180
+	head := speed * math.Cos(angle)
+	cross := speed * math.Sin(angle) // positive from the right
+	components := CrosswindComponents{
+		RunwayHeading: heading,
+		Headwind:      NullFloat(head),
+		Tailwind:      NullFloat(math.Max(-head, 0)),
+		Crosswind:     NullFloat(math.Abs(cross)),
+	}
+	switch {
+	case math.IsNaN(cross):
+	case cross > 0:
+		components.CrossFrom = "right"
+	case cross < 0:
+		components.CrossFrom = "left"
+	}
+	return components
+}
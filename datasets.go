@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// Virtual datasets are named in the -datasets file and selected with
+// dataset= on /api, /range and /daterange, instead of param= or params=:
+//
+//	{
+//	  "surface_wind":   {"params": ["10u", "10v", "10fg"]},
+//	  "best_available": {"prefer": ["surface_wind", "plain_wind"]},
+//	  "plain_wind":     {"params": ["10u", "10v"]}
+//	}
+//
+// A params dataset is an alias for a list of parameters. A prefer dataset
+// picks, at query time, the first of its datasets whose parameters are all
+// available for the requested batch step, stored or listed in the upstream
+// index (10fg, a gust since the previous step, is not there at step 0).
+
+type VirtualDataset struct {
+	Params []string `json:"params,omitempty"`
+	Prefer []string `json:"prefer,omitempty"`
+}
+
+// datasets is nil without a -datasets file.
+var datasets map[string]VirtualDataset
+
+func loadDatasets(path string) (map[string]VirtualDataset, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defined map[string]VirtualDataset
+	if err := json.Unmarshal(content, &defined); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, dataset := range defined {
+		if (len(dataset.Params) == 0) == (len(dataset.Prefer) == 0) {
+			return nil, fmt.Errorf("%s: dataset %q needs either params or prefer", path, name)
+		}
+		if len(dataset.Params) > maxQueryParams {
+			return nil, fmt.Errorf("%s: dataset %q has more than %d params", path, name, maxQueryParams)
+		}
+		for _, param := range dataset.Params {
+			if _, ok := paramRegistry[param]; !ok {
+				return nil, fmt.Errorf("%s: dataset %q: unknown param %q", path, name, param)
+			}
+		}
+		for _, member := range dataset.Prefer {
+			if len(defined[member].Params) == 0 {
+				return nil, fmt.Errorf("%s: dataset %q prefers %q, which is not a params dataset", path, name, member)
+			}
+		}
+	}
+	return defined, nil
+}
+
+// queryDataset resolves the dataset= argument of a query into the parameters
+// it loads, nil when there is none.
+func queryDataset(ctx context.Context, query url.Values, date, batch string, step int) ([]string, error) {
+	name := query.Get("dataset")
+	if name == "" {
+		return nil, nil
+	}
+	if query.Has("param") || query.Has("params") {
+		return nil, fmt.Errorf("%w: dataset= excludes param= and params=", ErrInvalidParams)
+	}
+	dataset, ok := datasets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown dataset %q", ErrInvalidParams, name)
+	}
+	if len(dataset.Params) > 0 {
+		setLogField(ctx, "dataset", name)
+		return dataset.Params, nil
+	}
+
+	if err := validateDateBatch(date, batch); err != nil {
+		return nil, err
+	}
+	var listed []string // parameters in the upstream index, read once if needed
+	indexRead := false
+	for _, member := range dataset.Prefer {
+		params := datasets[member].Params
+		if paramsStored(date, batch, step, params) {
+			setLogField(ctx, "dataset", name+">"+member)
+			return params, nil
+		}
+		if !indexRead {
+			indexRead = true
+			listed = indexedParams(ctx, date, batch, step)
+		}
+		if !slices.ContainsFunc(params, func(param string) bool { return !slices.Contains(listed, param) }) {
+			setLogField(ctx, "dataset", name+">"+member)
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no dataset of %q is available for %s-%s step %s", ErrDataNotPublished, name, date, batch, stepName(step))
+}
+
+func paramsStored(date, batch string, step int, params []string) bool {
+	for _, param := range params {
+		if _, ok := manifest.get(manifestKey(date, batch, param, stepName(step))); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// indexedParams returns the registered parameters the index of a batch step
+// lists, nil when it cannot be read.
+func indexedParams(ctx context.Context, date, batch string, step int) []string {
+	stream, err := streamForBatch(batch)
+	if err != nil {
+		return nil
+	}
+	index, err := source.Index(ctx, date, batch, stream, step)
+	if err != nil {
+		return nil
+	}
+	chunks, err := parseIndexResponse(index, registeredParams())
+	if err != nil {
+		return nil
+	}
+	var listed []string
+	for _, chunk := range chunks {
+		listed = append(listed, chunk.ParamName)
+	}
+	return listed
+}
+
+func registeredParams() []string {
+	names := make([]string, 0, len(paramRegistry))
+	for name := range paramRegistry {
+		names = append(names, name)
+	}
+	return names
+}
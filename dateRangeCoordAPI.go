@@ -1,58 +1,56 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"path/filepath"
-	"strconv"
-	"sync"
+	"slices"
 	"time"
 )
 
 type DateRangeAPIParams struct {
-	Lat       float64 `json:"lat"`
-	Lon       float64 `json:"lon"`
-	StartDate string  `json:"start_date"` // yyyymmdd format
-	EndDate   string  `json:"end_date"`   // yyyymmdd format
-	Batch     string  `json:"batch"`
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+	StartDate string   `json:"start_date"` // yyyymmdd format
+	EndDate   string   `json:"end_date"`   // yyyymmdd format
+	Batch     string   `json:"batch"`
+	Step      int      `json:"step"`    // forecast step in hours, 0 is the analysis
+	Param     string   `json:"param"`   // wind or a single parameter such as 2t
+	Params    []string `json:"params"`  // any parameters, overrides Param
+	Derived   []string `json:"derived"` // speed and/or dir
 }
 
 type DateRangeResponse struct {
-	Dates   []string  `json:"dates"`   // dates array yyyymmdd
-	U       []float64 `json:"u"`       // u array
-	V       []float64 `json:"v"`       // v array
-	Status  int       `json:"status"`  // HTTP status code
-	Success bool      `json:"success"` // whether success
+	Dates   []string              `json:"dates"`            // dates array yyyymmdd
+	U       NullFloats            `json:"u"`                // u array, missing cells are null
+	V       NullFloats            `json:"v"`                // v array, missing cells are null
+	Param   string                `json:"param,omitempty"`  // scalar param only
+	Values  NullFloats            `json:"values,omitempty"` // scalar param array, instead of u and v
+	Fields  map[string]NullFloats `json:"fields,omitempty"` // params= only, arrays keyed by param
+	Speed   NullFloats            `json:"speed,omitempty"`  // derived=speed only
+	Dir     NullFloats            `json:"dir,omitempty"`    // derived=dir only
+	Status  int                   `json:"status"`           // HTTP status code
+	Success bool                  `json:"success"`          // whether success
 }
 
 var dateRangeFailResponse = DateRangeResponse{
 	Dates:   []string{},
-	U:       []float64{},
-	V:       []float64{},
+	U:       NullFloats{},
+	V:       NullFloats{},
 	Status:  http.StatusBadRequest,
 	Success: false,
 }
 
-// file data cache structure
-type FileCache struct {
-	U []float64
-	V []float64
-}
-
-// global cache
-var (
-	fileCache   = make(map[string]*FileCache)
-	cacheMutex  sync.RWMutex
-	maxCacheSize = 100
-)
-
 func sendDateRangeJsonError(w http.ResponseWriter, statusCode int) {
+	response := dateRangeFailResponse
+	response.Status = statusCode
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(dateRangeFailResponse)
+	json.NewEncoder(w).Encode(response)
 }
 
 func dateRangeQueryHandler(w http.ResponseWriter, r *http.Request) {
@@ -64,7 +62,7 @@ func dateRangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendDateRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
-	lat, err := strconv.ParseFloat(latStr, 64)
+	lat, err := parseCoordinate(latStr)
 	if err != nil {
 		sendDateRangeJsonError(w, http.StatusBadRequest)
 		return
@@ -76,7 +74,7 @@ func dateRangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendDateRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
-	lon, err := strconv.ParseFloat(lonStr, 64)
+	lon, err := parseCoordinate(lonStr)
 	if err != nil {
 		sendDateRangeJsonError(w, http.StatusBadRequest)
 		return
@@ -113,7 +111,37 @@ func dateRangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// validate batch format
-	if batch != "00z" && batch != "06z" && batch != "12z" && batch != "18z" {
+	if !isValidBatch(batch) {
+		sendDateRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	// parse optional forecast step
+	step, err := parseStep(httpQuery.Get("step"), batch)
+	if err != nil {
+		sendDateRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	param, err := parseQueryParam(httpQuery.Get("param"))
+	if err != nil {
+		sendDateRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+	paramList, err := parseQueryParams(httpQuery.Get("params"))
+	if err != nil || (paramList != nil && httpQuery.Has("param")) {
+		sendDateRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+	// a prefer dataset is resolved for the first date
+	if datasetParams, err := queryDataset(r.Context(), httpQuery, startDate, batch, step); err != nil {
+		sendDateRangeJsonError(w, queryErrorStatus(err))
+		return
+	} else if datasetParams != nil {
+		paramList = datasetParams
+	}
+	derived, err := parseDerived(httpQuery.Get("derived"))
+	if err != nil {
 		sendDateRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
@@ -124,16 +152,49 @@ func dateRangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		StartDate: startDate,
 		EndDate:   endDate,
 		Batch:     batch,
+		Step:      step,
+		Param:     param,
+		Params:    paramList,
+		Derived:   derived,
+	}
+
+	format, err := parseFormat(r, formatXLSX)
+	if err != nil {
+		sendDateRangeJsonError(w, http.StatusBadRequest)
+		return
 	}
 
 	// execute query
-	data, err2 := DateRangeQuery(params)
+	if isValidateRequest(r) {
+		dates, err := generateDateRange(startDate, endDate)
+		sendQueryPlan(w, r, err, dates, batch)
+		return
+	}
+
+	setLogField(r.Context(), "date", startDate+"-"+endDate)
+	setLogField(r.Context(), "batch", batch)
+	data, err2 := DateRangeQuery(r.Context(), params)
 	if err2 != nil {
-		sendDateRangeJsonError(w, http.StatusBadRequest)
-		log.Println(err2)
+		sendDateRangeJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
 		return
 	}
 
+	name := "daterange-" + startDate + "-" + endDate
+	switch format {
+	case formatCSV, formatNDJSON:
+		writeTable(w, format, name, data.table())
+		return
+	case formatXLSX:
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".xlsx"))
+		w.WriteHeader(http.StatusOK)
+		title := fmt.Sprintf("%g, %g %s-%s %s", lat, lon, startDate, endDate, batch)
+		if err := writeXLSX(w, title, data.table(), "dir"); err != nil {
+			log.Printf("Met Error when writing xlsx to ResponseWriter: %v", err)
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(data)
@@ -142,17 +203,14 @@ func dateRangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func DateRangeQuery(params DateRangeAPIParams) (DateRangeResponse, error) {
+func DateRangeQuery(ctx context.Context, params DateRangeAPIParams) (DateRangeResponse, error) {
 	lat := params.Lat
 	lon := params.Lon
 	startDate := params.StartDate
 	endDate := params.EndDate
 	batch := params.Batch
-
-	// get coordinate index (one-time calculation)
-	valueIndex, err := GetIndexForCoord(lat, lon)
-	if err != nil {
-		return dateRangeFailResponse, fmt.Errorf("failed to get index for coord: %w", err)
+	if !isValidBatch(batch) {
+		return dateRangeFailResponse, fmt.Errorf("%w: %q", ErrInvalidBatch, batch)
 	}
 
 	// generate all dates in the date range
@@ -161,39 +219,58 @@ func DateRangeQuery(params DateRangeAPIParams) (DateRangeResponse, error) {
 		return dateRangeFailResponse, fmt.Errorf("failed to generate date range: %w", err)
 	}
 
+	names := selectedParams(params.Param, params.Params)
+	if len(params.Derived) > 0 {
+		if _, _, err := windComponents(names); err != nil {
+			return dateRangeFailResponse, err
+		}
+	}
+
 	var resultDates []string
-	var uValues []float64
-	var vValues []float64
+	outputs := make([][]float64, len(names)) // one array per param
 
 	// iterate through all dates
 	for _, date := range dates {
-		filePath := filepath.Join("tmp", date+"-"+batch+".json")
-		
+		filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+
 		// read data from cache or file
-		cache, err := getOrLoadFileCache(filePath, date, batch)
+		grid, fields, err := loadParamFields(ctx, filePath, date, batch, params.Step, names)
+		if errors.Is(err, ErrMemoryPressure) {
+			return dateRangeFailResponse, err
+		}
 		if err != nil {
-			log.Printf("Warning: failed to load data for date %s: %v", date, err)
+			appendLogField(ctx, "missing_dates", date)
+			setLogField(ctx, "load_error", err)
 			// set to 0 if data fetch failed
 			resultDates = append(resultDates, date)
-			uValues = append(uValues, 0)
-			vValues = append(vValues, 0)
+			for i := range outputs {
+				outputs[i] = append(outputs[i], 0)
+			}
 			continue
 		}
 
+		// files may be on different grids, so index per date
+		valueIndex, err := grid.Index(lat, lon)
+		if err != nil {
+			return dateRangeFailResponse, fmt.Errorf("failed to get index for coord: %w", err)
+		}
+
 		// boundary check
-		if valueIndex < 0 || valueIndex >= len(cache.U) || valueIndex >= len(cache.V) {
-			log.Printf("Warning: index %d out of bounds for date %s", valueIndex, date)
+		if valueIndex < 0 || slices.ContainsFunc(fields, func(field []float64) bool { return valueIndex >= len(field) }) {
+			appendLogField(ctx, "missing_dates", date)
 			// set to 0 if index out of bounds
 			resultDates = append(resultDates, date)
-			uValues = append(uValues, 0)
-			vValues = append(vValues, 0)
+			for i := range outputs {
+				outputs[i] = append(outputs[i], 0)
+			}
 			continue
 		}
 
 		// add to result
 		resultDates = append(resultDates, date)
-		uValues = append(uValues, cache.U[valueIndex])
-		vValues = append(vValues, cache.V[valueIndex])
+		for i, field := range fields {
+			outputs[i] = append(outputs[i], field[valueIndex])
+		}
 	}
 
 	if len(resultDates) == 0 {
@@ -202,87 +279,26 @@ func DateRangeQuery(params DateRangeAPIParams) (DateRangeResponse, error) {
 
 	response := DateRangeResponse{
 		Dates:   resultDates,
-		U:       uValues,
-		V:       vValues,
+		U:       NullFloats{},
+		V:       NullFloats{},
 		Status:  http.StatusOK,
 		Success: true,
 	}
-
-	return response, nil
-}
-
-// get or load file cache
-func getOrLoadFileCache(filePath string, date string, batch string) (*FileCache, error) {
-	// try to read from cache first
-	cacheMutex.RLock()
-	cache, exists := fileCache[filePath]
-	cacheMutex.RUnlock()
-
-	if exists {
-		return cache, nil
-	}
-
-	// cache not exist, read file
-	cache, err := loadFileToCache(filePath, date, batch)
-	if err != nil {
-		return nil, err
+	switch {
+	case len(params.Params) > 0:
+		response.Fields = fieldsByName(names, nullFloats(outputs))
+	case len(names) == 2:
+		response.U, response.V = outputs[0], outputs[1]
+	default:
+		response.Param, response.Values = names[0], outputs[0]
 	}
-
-	// write to cache
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-
-	// check cache size, if over limit, clear old cache
-	if len(fileCache) >= maxCacheSize {
-		// simple strategy: clear all cache
-		fileCache = make(map[string]*FileCache)
-		log.Printf("Cache size exceeded %d, cleared all cache", maxCacheSize)
+	if len(params.Derived) > 0 {
+		u, v, _ := windComponents(names)
+		response.Speed, response.Dir = deriveWind(params.Derived, outputs[u], outputs[v])
 	}
+	addLogCount(ctx, "points", int64(len(resultDates)))
 
-	fileCache[filePath] = cache
-	return cache, nil
-}
-
-// load data from file to cache
-func loadFileToCache(filePath string, date string, batch string) (*FileCache, error) {
-	// try to read file
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		// file not exist, try to download
-		if os.IsNotExist(err) {
-			if err := downloadAndSave(date, batch); err != nil {
-				return nil, fmt.Errorf("download failed: %w", err)
-			}
-			// read again
-			content, err = os.ReadFile(filePath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read file after download: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to read file: %w", err)
-		}
-	}
-
-	// parse JSON
-	var data struct {
-		U []float64 `json:"10u"`
-		V []float64 `json:"10v"`
-	}
-
-	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
-	}
-
-	if len(data.U) == 0 || len(data.V) == 0 {
-		return nil, fmt.Errorf("json data is empty or missing")
-	}
-
-	cache := &FileCache{
-		U: data.U,
-		V: data.V,
-	}
-
-	return cache, nil
+	return response, nil
 }
 
 // isValidDateFormat validates date format (yyyymmdd)
@@ -299,18 +315,18 @@ func generateDateRange(startDate, endDate string) ([]string, error) {
 	// parse start date
 	start, err := time.Parse("20060102", startDate)
 	if err != nil {
-		return nil, fmt.Errorf("invalid start_date format: %w", err)
+		return nil, fmt.Errorf("%w: start_date: %w", ErrInvalidDate, err)
 	}
 
 	// parse end date
 	end, err := time.Parse("20060102", endDate)
 	if err != nil {
-		return nil, fmt.Errorf("invalid end_date format: %w", err)
+		return nil, fmt.Errorf("%w: end_date: %w", ErrInvalidDate, err)
 	}
 
 	// check if start date is before or equal to end date
 	if start.After(end) {
-		return nil, fmt.Errorf("start_date (%s) must be before or equal to end_date (%s)", startDate, endDate)
+		return nil, fmt.Errorf("%w: start_date (%s) must be before or equal to end_date (%s)", ErrInvalidDate, startDate, endDate)
 	}
 
 	// generate all dates
@@ -324,11 +340,3 @@ func generateDateRange(startDate, endDate string) ([]string, error) {
 
 	return dates, nil
 }
-
-func ClearDateRangeCache() {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-	fileCache = make(map[string]*FileCache)
-	log.Println("DateRange API cache cleared")
-}
-
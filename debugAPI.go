@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+const maxNeighborhoodSize = 21
+
+type NeighborhoodAPIParams struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	N     int     `json:"n"` // odd edge length of the neighborhood
+	Date  string  `json:"date"`
+	Batch string  `json:"batch"`
+}
+
+type NeighborhoodCell struct {
+	Index int       `json:"index"`
+	Lat   float64   `json:"lat"`
+	Lon   float64   `json:"lon"`
+	U     NullFloat `json:"u"`
+	V     NullFloat `json:"v"`
+}
+
+type NeighborhoodResponse struct {
+	Lat     float64            `json:"lat"`
+	Lon     float64            `json:"lon"`
+	Nearest int                `json:"nearest"` // index /api answers with
+	Cells   []NeighborhoodCell `json:"cells"`
+	Status  int                `json:"status"`
+	Success bool               `json:"success"`
+}
+
+var neighborhoodFailResponse = NeighborhoodResponse{
+	Nearest: -1,
+	Cells:   []NeighborhoodCell{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendNeighborhoodJsonError(w http.ResponseWriter, statusCode int) {
+	response := neighborhoodFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// debugNeighborhoodHandler returns the raw grid values around a point with
+// their exact coordinates and indices, to diagnose indexing or
+// interpolation results.
+func debugNeighborhoodHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	lat, err := parseCoordinate(httpQuery.Get("lat"))
+	if err != nil {
+		sendNeighborhoodJsonError(w, http.StatusBadRequest)
+		return
+	}
+	lon, err := parseCoordinate(httpQuery.Get("lon"))
+	if err != nil {
+		sendNeighborhoodJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	n := 3
+	if nStr := httpQuery.Get("n"); nStr != "" {
+		n, err = strconv.Atoi(nStr)
+		if err != nil || n < 1 || n%2 == 0 || n > maxNeighborhoodSize {
+			sendNeighborhoodJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	date := httpQuery.Get("date")
+	batch := httpQuery.Get("batch")
+	if date == "" || batch == "" {
+		sendNeighborhoodJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := NeighborhoodAPIParams{
+		Lat:   lat,
+		Lon:   lon,
+		N:     n,
+		Date:  date,
+		Batch: batch,
+	}
+
+	setLogField(r.Context(), "date", date)
+	setLogField(r.Context(), "batch", batch)
+	data, err2 := NeighborhoodQuery(r.Context(), params)
+	if err2 != nil {
+		sendNeighborhoodJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func NeighborhoodQuery(ctx context.Context, params NeighborhoodAPIParams) (NeighborhoodResponse, error) {
+	if err := validateDateBatch(params.Date, params.Batch); err != nil {
+		return neighborhoodFailResponse, err
+	}
+	filePath := filepath.Join(tmpDir, params.Date+"-"+params.Batch+".json")
+	cache, err := getOrLoadFileCache(ctx, filePath, params.Date, params.Batch)
+	if err != nil {
+		return neighborhoodFailResponse, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+
+	nearest, err := cache.Grid.Index(params.Lat, params.Lon)
+	if err != nil {
+		return neighborhoodFailResponse, fmt.Errorf("failed to get index for coord: %w", err)
+	}
+
+	indices := cache.Grid.Neighborhood(params.Lat, params.Lon, params.N)
+	cells := make([]NeighborhoodCell, 0, len(indices))
+	for _, index := range indices {
+		if index >= len(cache.U) || index >= len(cache.V) {
+			continue
+		}
+		lat, lon := cache.Grid.Coord(index)
+		cells = append(cells, NeighborhoodCell{
+			Index: index,
+			Lat:   lat,
+			Lon:   lon,
+			U:     NullFloat(cache.U[index]),
+			V:     NullFloat(cache.V[index]),
+		})
+	}
+
+	response := NeighborhoodResponse{
+		Lat:     params.Lat,
+		Lon:     params.Lon,
+		Nearest: nearest,
+		Cells:   cells,
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	return response, nil
+}
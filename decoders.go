@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Decode backends turn one GRIB message into values and a grid. native is
+// pure Go and runs anywhere the binary does, Windows and macOS included;
+// grib_dump execs eccodes' tool, found in PATH once at startup. -grib-decoder
+// is a comma separated chain tried in order, a message the one backend does
+// not support (errUnsupportedGrib) going to the next, or auto: every backend
+// this host can run, native first, so no external tool is needed but eccodes
+// is still used when installed.
+//
+// A backend named in the chain but missing does not stop the server, which
+// still serves everything already ingested, but ingests that reach it fail
+// with ErrDecoderUnavailable: a 503 carrying an X-Remediation header instead
+// of an exec error buried in the logs, and no Retry-After as it does not
+// clear on its own. grib_get is only used to verify ingests, which are
+// skipped without it (see verify.go).
+
+const (
+	gribDecoderAuto     = "auto"
+	gribDecoderNative   = "native"
+	gribDecoderGribDump = "grib_dump"
+)
+
+const decoderRemediation = "install eccodes so grib_dump is in PATH, or run with -grib-decoder native"
+
+// gribBackend decodes GRIB messages one way.
+type gribBackend interface {
+	name() string
+	// available is nil when the backend can run on this host.
+	available() error
+	decode(ctx context.Context, param string, message []byte) ([]float64, GridSpec, error)
+}
+
+type nativeBackend struct{}
+
+func (nativeBackend) name() string     { return gribDecoderNative }
+func (nativeBackend) available() error { return nil }
+func (nativeBackend) decode(ctx context.Context, param string, message []byte) ([]float64, GridSpec, error) {
+	return decodeGrib2(message)
+}
+
+type gribDumpBackend struct{}
+
+func (gribDumpBackend) name() string { return gribDecoderGribDump }
+func (gribDumpBackend) available() error {
+	if decoderTools["grib_dump"] == "" {
+		return errors.New("grib_dump not found in PATH")
+	}
+	return nil
+}
+func (gribDumpBackend) decode(ctx context.Context, param string, message []byte) ([]float64, GridSpec, error) {
+	return gribDumpChunk(ctx, param, message)
+}
+
+// gribBackends are the known backends in the order auto tries them.
+var gribBackends = []gribBackend{nativeBackend{}, gribDumpBackend{}}
+
+var (
+	// gribDecoder is -grib-decoder as given.
+	gribDecoder = gribDecoderAuto
+	// decodeChain is the backends it names, nil for auto.
+	decodeChain []gribBackend
+)
+
+func parseGribDecoder(s string) (string, []gribBackend, error) {
+	s = strings.TrimSpace(s)
+	if s == gribDecoderAuto {
+		return s, nil, nil
+	}
+	var chain []gribBackend
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(gribBackends, func(b gribBackend) bool { return b.name() == name })
+		if i < 0 {
+			return "", nil, fmt.Errorf("unknown decoder %q, want %s or a comma separated list of %s", name, gribDecoderAuto, strings.Join(backendNames(gribBackends), ", "))
+		}
+		if slices.Contains(chain, gribBackends[i]) {
+			return "", nil, fmt.Errorf("decoder %s listed twice", name)
+		}
+		chain = append(chain, gribBackends[i])
+	}
+	return s, chain, nil
+}
+
+func backendNames(backends []gribBackend) []string {
+	names := make([]string, len(backends))
+	for i, b := range backends {
+		names[i] = b.name()
+	}
+	return names
+}
+
+// activeBackends is the chain decodes go through: the configured one, or
+// for auto the backends available now.
+func activeBackends() []gribBackend {
+	if decodeChain != nil {
+		return decodeChain
+	}
+	var chain []gribBackend
+	for _, b := range gribBackends {
+		if b.available() == nil {
+			chain = append(chain, b)
+		}
+	}
+	return chain
+}
+
+// decodeMessage decodes a message with the first backend of the chain
+// supporting it.
+func decodeMessage(ctx context.Context, param string, message []byte) ([]float64, GridSpec, error) {
+	err := errUnsupportedGrib
+	for _, backend := range activeBackends() {
+		if unavailable := backend.available(); unavailable != nil {
+			setLogField(ctx, "remediation", decoderRemediation)
+			return nil, GridSpec{}, fmt.Errorf("%w: %s, needed to decode %s: %v", ErrDecoderUnavailable, backend.name(), param, unavailable)
+		}
+		var values []float64
+		var spec GridSpec
+		values, spec, err = backend.decode(ctx, param, message)
+		if !errors.Is(err, errUnsupportedGrib) {
+			if err == nil {
+				setLogField(ctx, "decoder", backend.name())
+			}
+			return values, spec, err
+		}
+		log.Printf("Decoding %s: %s: %v", param, backend.name(), err)
+	}
+	return nil, GridSpec{}, err
+}
+
+// decodersAvailable fails with ErrDecoderUnavailable, noting the remediation
+// on the request, when the chain starts with a backend that cannot run: no
+// ingest could get past it.
+func decodersAvailable(ctx context.Context) error {
+	chain := activeBackends()
+	if len(chain) > 0 && chain[0].available() == nil {
+		return nil
+	}
+	setLogField(ctx, "remediation", decoderRemediation)
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no decoder available", ErrDecoderUnavailable)
+	}
+	return fmt.Errorf("%w: %s: %v", ErrDecoderUnavailable, chain[0].name(), chain[0].available())
+}
+
+// decoderTools maps the external tools to their path, "" when missing.
+var decoderTools = map[string]string{"grib_dump": "", "grib_get": ""}
+
+func detectDecoders() {
+	for tool := range decoderTools {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			path = ""
+		}
+		decoderTools[tool] = path
+	}
+	for _, backend := range decodeChain {
+		if err := backend.available(); err != nil {
+			log.Printf("Decoder %s unavailable, ingests needing it will fail: %v: %s", backend.name(), err, decoderRemediation)
+		}
+	}
+	log.Printf("Decoding GRIB with %s", strings.Join(backendNames(activeBackends()), ", "))
+}
+
+// decoderStatus is the decoder component of /readyz: not ok when the first
+// backend of the chain cannot run.
+func decoderStatus() ComponentStatus {
+	detail := gribDecoder + ": " + strings.Join(backendNames(activeBackends()), ", ")
+	if err := decodersAvailable(context.Background()); err != nil {
+		return ComponentStatus{OK: false, Detail: detail, Error: err.Error() + ": " + decoderRemediation}
+	}
+	return ComponentStatus{OK: true, Detail: detail}
+}
+
+type CapabilitiesResponse struct {
+	Decoder     string            `json:"decoder"`  // -grib-decoder
+	Chain       []string          `json:"chain"`    // backends decodes go through, in order
+	Decoders    map[string]bool   `json:"decoders"` // available backends and tools
+	Ingest      bool              `json:"ingest"`   // new data can be downloaded and decoded
+	Remediation string            `json:"remediation,omitempty"`
+	Verify      bool              `json:"verify"` // ingests are checked against grib_get
+	Params      []string          `json:"params"`
+	Units       map[string]string `json:"units"`
+	Features    map[string]bool   `json:"features"`
+	Status      int               `json:"status"`
+	Success     bool              `json:"success"`
+}
+
+// capabilitiesHandler serves GET /capabilities, what this server can do.
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	response := CapabilitiesResponse{
+		Decoder:  gribDecoder,
+		Chain:    backendNames(activeBackends()),
+		Decoders: map[string]bool{"grib_get": decoderTools["grib_get"] != ""},
+		Ingest:   decoderStatus().OK,
+		Verify:   verifySamples > 0 && decoderTools["grib_get"] != "",
+		Params:   slices.Sorted(maps.Keys(paramRegistry)),
+		Units:    make(map[string]string, len(paramRegistry)),
+		Features: enabledFeatures,
+		Status:   http.StatusOK,
+		Success:  true,
+	}
+	for _, backend := range gribBackends {
+		response.Decoders[backend.name()] = backend.available() == nil
+	}
+	if !response.Ingest {
+		response.Remediation = decoderRemediation
+	}
+	for name, def := range paramRegistry {
+		response.Units[name] = def.Unit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "golang.org/x/sys/cpu"
+
+// useAVX selects the four-wide windVectorsAVX kernel.
+var useAVX = cpu.X86.HasAVX
+
+// windVectorsAVX is the loop of windVectors over the first n cells, four at
+// a time, n a multiple of 4; see derived_amd64.s.
+//
+//go:noescape
+func windVectorsAVX(speed, dir, u, v *float64, n int)
+
+// windVectorsAsm runs windVectorsAVX over as many whole blocks of four cells
+// as it can and returns the number of cells done.
+func windVectorsAsm(speed, dir, u, v []float64) int {
+	n := len(u) &^ 3
+	if !useAVX || n == 0 {
+		return 0
+	}
+	windVectorsAVX(&speed[0], &dir[0], &u[0], &v[0], n)
+	return n
+}
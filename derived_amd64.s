@@ -0,0 +1,158 @@
+#include "textflag.h"
+
+// The constants of windVectors, bit for bit.
+DATA absMask<>+0(SB)/8, $0x7fffffffffffffff
+GLOBL absMask<>(SB), RODATA|NOPTR, $8
+DATA signMask<>+0(SB)/8, $0x8000000000000000
+GLOBL signMask<>(SB), RODATA|NOPTR, $8
+DATA one<>+0(SB)/8, $0x3ff0000000000000
+GLOBL one<>(SB), RODATA|NOPTR, $8
+DATA reduce<>+0(SB)/8, $0x3fe51eb851eb851f // 0.66
+GLOBL reduce<>(SB), RODATA|NOPTR, $8
+DATA p0<>+0(SB)/8, $0xbfec007fa1f72594
+GLOBL p0<>(SB), RODATA|NOPTR, $8
+DATA p1<>+0(SB)/8, $0xc03028545b6b807a
+GLOBL p1<>(SB), RODATA|NOPTR, $8
+DATA p2<>+0(SB)/8, $0xc052c08c36880273
+GLOBL p2<>(SB), RODATA|NOPTR, $8
+DATA p3<>+0(SB)/8, $0xc05eb8bf2d05ba25
+GLOBL p3<>(SB), RODATA|NOPTR, $8
+DATA p4<>+0(SB)/8, $0xc0503669fd28ec8e
+GLOBL p4<>(SB), RODATA|NOPTR, $8
+DATA q0<>+0(SB)/8, $0x4038dbc45b14603c
+GLOBL q0<>(SB), RODATA|NOPTR, $8
+DATA q1<>+0(SB)/8, $0x4064a0dd43b8fa25
+GLOBL q1<>(SB), RODATA|NOPTR, $8
+DATA q2<>+0(SB)/8, $0x407b0e18d2e2be3b
+GLOBL q2<>(SB), RODATA|NOPTR, $8
+DATA q3<>+0(SB)/8, $0x407e563f13b049ea
+GLOBL q3<>(SB), RODATA|NOPTR, $8
+DATA q4<>+0(SB)/8, $0x4068519efbbd62ec
+GLOBL q4<>(SB), RODATA|NOPTR, $8
+DATA piOver4<>+0(SB)/8, $0x3fe921fb54442d19 // Pi/4 + morebits/2
+GLOBL piOver4<>(SB), RODATA|NOPTR, $8
+DATA piOver2<>+0(SB)/8, $0x3ff921fb54442d18
+GLOBL piOver2<>(SB), RODATA|NOPTR, $8
+DATA pi<>+0(SB)/8, $0x400921fb54442d18
+GLOBL pi<>(SB), RODATA|NOPTR, $8
+DATA degrees<>+0(SB)/8, $0x404ca5dc1a63c1f8 // 180/Pi
+GLOBL degrees<>(SB), RODATA|NOPTR, $8
+DATA c270<>+0(SB)/8, $0x4070e00000000000
+GLOBL c270<>(SB), RODATA|NOPTR, $8
+DATA c360<>+0(SB)/8, $0x4076800000000000
+GLOBL c360<>(SB), RODATA|NOPTR, $8
+
+// func windVectorsAVX(speed, dir, u, v *float64, n int)
+//
+// The steps of the windVectors loop in the same order, without FMA, so the
+// results are identical; the branches become blends. Calm, infinite and NaN
+// cells come out NaN: (x-x)+(y-y) is added to every direction.
+TEXT ·windVectorsAVX(SB), NOSPLIT, $0-40
+	MOVQ speed+0(FP), DI
+	MOVQ dir+8(FP), DX
+	MOVQ u+16(FP), SI
+	MOVQ v+24(FP), BX
+	MOVQ n+32(FP), CX
+	XORQ AX, AX
+	VBROADCASTSD absMask<>(SB), Y15
+	VBROADCASTSD one<>(SB), Y14
+	VBROADCASTSD reduce<>(SB), Y13
+
+loop:
+	CMPQ AX, CX
+	JAE  done
+	VMOVUPD (SI)(AX*8), Y0 // x
+	VMOVUPD (BX)(AX*8), Y1 // y
+
+	// speed = sqrt(x*x + y*y)
+	VMULPD  Y0, Y0, Y2
+	VMULPD  Y1, Y1, Y3
+	VADDPD  Y3, Y2, Y2
+	VSQRTPD Y2, Y2
+	VMOVUPD Y2, (DI)(AX*8)
+
+	// r = min/max of |x| and |y|, reduced by (r-1)/(r+1) above 0.66
+	VANDPD    Y15, Y0, Y2      // ax
+	VANDPD    Y15, Y1, Y3      // ay
+	VMAXPD    Y3, Y2, Y4       // hi
+	VMINPD    Y3, Y2, Y5       // lo
+	VDIVPD    Y4, Y5, Y6       // r
+	VCMPPD    $0x1e, Y13, Y6, Y7 // r > 0.66
+	VSUBPD    Y14, Y6, Y8
+	VADDPD    Y14, Y6, Y9
+	VDIVPD    Y9, Y8, Y8
+	VBLENDVPD Y7, Y8, Y6, Y6
+	VBROADCASTSD piOver4<>(SB), Y8
+	VANDPD    Y8, Y7, Y7       // offset
+
+	// z = r*r; z = z*P(z)/Q(z)
+	VMULPD Y6, Y6, Y8
+	VBROADCASTSD p0<>(SB), Y9
+	VMULPD Y8, Y9, Y9
+	VBROADCASTSD p1<>(SB), Y10
+	VADDPD Y10, Y9, Y9
+	VMULPD Y8, Y9, Y9
+	VBROADCASTSD p2<>(SB), Y10
+	VADDPD Y10, Y9, Y9
+	VMULPD Y8, Y9, Y9
+	VBROADCASTSD p3<>(SB), Y10
+	VADDPD Y10, Y9, Y9
+	VMULPD Y8, Y9, Y9
+	VBROADCASTSD p4<>(SB), Y10
+	VADDPD Y10, Y9, Y9
+	VBROADCASTSD q0<>(SB), Y10
+	VADDPD Y10, Y8, Y10
+	VMULPD Y8, Y10, Y10
+	VBROADCASTSD q1<>(SB), Y11
+	VADDPD Y11, Y10, Y10
+	VMULPD Y8, Y10, Y10
+	VBROADCASTSD q2<>(SB), Y11
+	VADDPD Y11, Y10, Y10
+	VMULPD Y8, Y10, Y10
+	VBROADCASTSD q3<>(SB), Y11
+	VADDPD Y11, Y10, Y10
+	VMULPD Y8, Y10, Y10
+	VBROADCASTSD q4<>(SB), Y11
+	VADDPD Y11, Y10, Y10
+	VMULPD Y9, Y8, Y8
+	VDIVPD Y10, Y8, Y8
+
+	// a = offset + r*z + r, into the octant and quadrant of (x, y)
+	VMULPD    Y8, Y6, Y8
+	VADDPD    Y8, Y7, Y8
+	VADDPD    Y6, Y8, Y8
+	VCMPPD    $0x1e, Y2, Y3, Y10 // ay > ax
+	VBROADCASTSD piOver2<>(SB), Y11
+	VSUBPD    Y8, Y11, Y11
+	VBLENDVPD Y10, Y11, Y8, Y8
+	VXORPD    Y10, Y10, Y10
+	VCMPPD    $0x11, Y10, Y0, Y10 // x < 0
+	VBROADCASTSD pi<>(SB), Y11
+	VSUBPD    Y8, Y11, Y11
+	VBLENDVPD Y10, Y11, Y8, Y8
+	VANDPD    Y15, Y8, Y8
+	VBROADCASTSD signMask<>(SB), Y10
+	VANDPD    Y10, Y1, Y10
+	VORPD     Y10, Y8, Y8      // Copysign(a, y)
+
+	// dir = 270 - a*180/Pi, less 360 from 360 on
+	VBROADCASTSD degrees<>(SB), Y10
+	VMULPD    Y10, Y8, Y8
+	VBROADCASTSD c270<>(SB), Y10
+	VSUBPD    Y8, Y10, Y8
+	VBROADCASTSD c360<>(SB), Y10
+	VCMPPD    $0x1d, Y10, Y8, Y11 // dir >= 360
+	VANDPD    Y10, Y11, Y11
+	VSUBPD    Y11, Y8, Y8
+	VSUBPD    Y0, Y0, Y10
+	VSUBPD    Y1, Y1, Y11
+	VADDPD    Y11, Y10, Y10
+	VADDPD    Y10, Y8, Y8
+	VMOVUPD   Y8, (DX)(AX*8)
+
+	ADDQ $4, AX
+	JMP  loop
+
+done:
+	VZEROUPPER
+	RET
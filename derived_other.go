@@ -0,0 +1,7 @@
+//go:build !amd64
+
+package main
+
+var useAVX = false
+
+func windVectorsAsm(speed, dir, u, v []float64) int { return 0 }
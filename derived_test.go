@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// windPerCell is the math.Hypot and windDirection loop the handlers used per
+// cell.
+func windPerCell(speed, dir, u, v []float64) {
+	for i := range u {
+		speed[i] = math.Hypot(u[i], v[i])
+		if dir != nil {
+			dir[i] = windDirection(u[i], v[i])
+		}
+	}
+}
+
+func windGrid() (dst, u, v []float64) {
+	n := 1440 * 721
+	dst, u, v = make([]float64, n), make([]float64, n), make([]float64, n)
+	for i := range u {
+		u[i], v[i] = float64(i%41)-20, float64(i%23)-11
+	}
+	return dst, u, v
+}
+
+func TestWindSpeeds(t *testing.T) {
+	dst, u, v := windGrid()
+	u[5], v[6] = math.NaN(), math.NaN()
+	u, v = u[:1003], v[:1003]
+	windSpeeds(dst, u, v)
+	for i := range u {
+		want := math.Hypot(u[i], v[i])
+		if got := dst[i]; !(math.Abs(got-want) <= 1e-12*want) && !(math.IsNaN(got) && math.IsNaN(want)) {
+			t.Fatalf("cell %d = %g, want %g", i, got, want)
+		}
+	}
+}
+
+// windVectorsGeneric runs windVectors without the assembly kernel.
+func windVectorsGeneric(speed, dir, u, v []float64) {
+	avx := useAVX
+	useAVX = false
+	defer func() { useAVX = avx }()
+	windVectors(speed, dir, u, v)
+}
+
+func TestWindVectors(t *testing.T) {
+	var u, v []float64
+	for _, x := range []float64{0, math.Copysign(0, -1), 1e-300, 0.3, 1, 2.5, 17, 1e300, math.Inf(1), math.NaN()} {
+		for _, y := range []float64{0, 0.3, 0.66, 1, 1.7, 17, math.Inf(1)} {
+			for _, sx := range []float64{1, -1} {
+				u, v = append(u, sx*x, sx*x), append(v, y, -y)
+			}
+		}
+	}
+	_, gu, gv := windGrid()
+	u, v = append(u, gu[:2003]...), append(v, gv[:2003]...)
+	speed, dir := make([]float64, len(u)), make([]float64, len(u))
+	windVectors(speed, dir, u, v)
+	speeds, dirs := make([]float64, len(u)), make([]float64, len(u))
+	windVectorsGeneric(speeds, dirs, u, v)
+	for i := range u {
+		// the assembly kernel does the same operations in the same order
+		if speed[i] != speeds[i] && !(math.IsNaN(speed[i]) && math.IsNaN(speeds[i])) ||
+			dir[i] != dirs[i] && !(math.IsNaN(dir[i]) && math.IsNaN(dirs[i])) {
+			t.Fatalf("cell %d (%g, %g) = %g, %g, generic %g, %g", i, u[i], v[i], speed[i], dir[i], speeds[i], dirs[i])
+		}
+		if got, want := dir[i], windDirection(u[i], v[i]); !(math.Abs(got-want) <= 1e-12*360) && !(math.IsNaN(got) && math.IsNaN(want)) {
+			t.Fatalf("cell %d (%g, %g) dir = %g, want %g", i, u[i], v[i], got, want)
+		}
+	}
+}
+
+// BenchmarkWindSpeeds derives speed, and speed and direction, over a global
+// 0.25° grid with the bulk kernels and with the per-cell loop.
+func BenchmarkWindSpeeds(b *testing.B) {
+	speed, u, v := windGrid()
+	dir := make([]float64, len(u))
+	b.Run("windSpeeds", func(b *testing.B) {
+		for b.Loop() {
+			windSpeeds(speed, u, v)
+		}
+	})
+	b.Run("hypot", func(b *testing.B) {
+		for b.Loop() {
+			windPerCell(speed, nil, u, v)
+		}
+	})
+	b.Run("windVectors", func(b *testing.B) {
+		for b.Loop() {
+			windVectors(speed, dir, u, v)
+		}
+	})
+	b.Run("windVectors/generic", func(b *testing.B) {
+		for b.Loop() {
+			windVectorsGeneric(speed, dir, u, v)
+		}
+	})
+	b.Run("hypot+windDirection", func(b *testing.B) {
+		for b.Loop() {
+			windPerCell(speed, dir, u, v)
+		}
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Hourly 10 m wind for air quality dispersion models, interpolated in time
+// between batches:
+//
+//	format=pfl   an AERMOD profile file (the PFL file AERMET writes) at lat/lon,
+//	             one 10 m level per hour. The surface (SFC) file also needs
+//	             the surface energy balance, which is not ingested, so it is
+//	             left to AERMET.
+//	format=grid  a plain gridded file over a bbox for CALPUFF-style gridded
+//	             models: a header with the grid, then per hour a date line,
+//	             NY rows of wind direction and NY rows of wind speed, rows
+//	             from south to north and columns from west to east.
+
+const (
+	dispersionFormatPFL  = "pfl"
+	dispersionFormatGrid = "grid"
+
+	maxDispersionHours  = 31 * 24
+	maxDispersionValues = 5_000_000 // cells × hours
+
+	// missing value indicators of AERMOD profile files
+	pflMissingDir   = 999.0
+	pflMissingSpeed = -99.0
+	pflMissingTemp  = 999.0
+	pflMissingSigTh = 99.0
+	pflMissingSigW  = -99.0
+
+	gridMissing = -999.0
+)
+
+type DispersionAPIParams struct {
+	Format string
+	Start  time.Time
+	End    time.Time
+	// pfl
+	Lat, Lon float64
+	// grid
+	SLat, SLon, ELat, ELon, Step float64
+}
+
+// dispersionHandler serves /export/dispersion?format=pfl&lat=&lon=&start=&end=
+// and /export/dispersion?format=grid&slat=&slon=&elat=&elon=&step=&start=&end=
+func dispersionHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := DispersionAPIParams{Format: httpQuery.Get("format")}
+	var names []string
+	switch params.Format {
+	case dispersionFormatPFL:
+		names = []string{"lat", "lon"}
+	case dispersionFormatGrid:
+		names = []string{"slat", "slon", "elat", "elon", "step"}
+	default:
+		sendTileJsonError(w, http.StatusBadRequest)
+		return
+	}
+	dsts := map[string]*float64{
+		"lat": &params.Lat, "lon": &params.Lon,
+		"slat": &params.SLat, "slon": &params.SLon, "elat": &params.ELat, "elon": &params.ELon, "step": &params.Step,
+	}
+	for _, name := range names {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendTileJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dsts[name] = value
+	}
+	var err error
+	if params.Start, err = parseAsOf(httpQuery.Get("start")); err != nil {
+		sendTileJsonError(w, http.StatusBadRequest)
+		return
+	}
+	if params.End, err = parseAsOf(httpQuery.Get("end")); err != nil {
+		sendTileJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", params.Start.Format("2006010215")+"-"+params.End.Format("2006010215"))
+	data, err2 := DispersionQuery(r.Context(), params)
+	if err2 != nil {
+		sendTileJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	name := fmt.Sprintf("griber-%s-%s.%s", params.Start.Format("2006010215"), params.End.Format("2006010215"), params.Format)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Met Error when writing file to ResponseWriter: %v", err)
+	}
+}
+
+func DispersionQuery(ctx context.Context, params DispersionAPIParams) ([]byte, error) {
+	start, end := params.Start.UTC().Truncate(time.Hour), params.End.UTC()
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: end is before start", ErrInvalidDate)
+	}
+	hours := int(end.Sub(start)/time.Hour) + 1
+	if hours > maxDispersionHours {
+		return nil, fmt.Errorf("%w: %d hours exceeds limit of %d", ErrInvalidParams, hours, maxDispersionHours)
+	}
+
+	field := newWindField(ctx)
+	var buf bytes.Buffer
+	if params.Format == dispersionFormatPFL {
+		for h := range hours {
+			t := start.Add(time.Duration(h) * time.Hour)
+			u, v, err := field.at(t, params.Lat, params.Lon)
+			if err != nil {
+				return nil, err
+			}
+			dir, speed := pflMissingDir, pflMissingSpeed
+			if !math.IsNaN(u) && !math.IsNaN(v) {
+				dir, speed = windDirection(u, v), math.Hypot(u, v)
+			}
+			// AERMOD hours run 1-24, hour ending; 00 is hour 24 of the day before
+			day, hour := t, t.Hour()
+			if hour == 0 {
+				day, hour = t.Add(-time.Hour), 24
+			}
+			fmt.Fprintf(&buf, "%2d %2d %2d %2d %7.1f %1d %7.1f %8.2f %8.1f %8.1f %8.2f\n",
+				day.Year()%100, int(day.Month()), day.Day(), hour,
+				10.0, 1, dir, speed, pflMissingTemp, pflMissingSigTh, pflMissingSigW)
+		}
+		addLogCount(ctx, "points", int64(hours))
+		return buf.Bytes(), nil
+	}
+
+	target, err := regridTarget(params.SLat, params.SLon, params.ELat, params.ELon, params.Step)
+	if err != nil {
+		return nil, err
+	}
+	if size := target.Size() * hours; size > maxDispersionValues {
+		return nil, fmt.Errorf("%w: %d values exceed limit of %d", ErrInvalidParams, size, maxDispersionValues)
+	}
+	south := target.LatFirst - float64(target.Nj-1)*target.LatStep
+	fmt.Fprintf(&buf, "GRIBER-DISPERSION 1\n")
+	fmt.Fprintf(&buf, "10M WIND WD(DEG FROM) WS(M/S) MISSING %.1f\n", gridMissing)
+	fmt.Fprintf(&buf, "NX %d NY %d\n", target.Ni, target.Nj)
+	fmt.Fprintf(&buf, "SW %.4f %.4f STEP %.4f\n", south, target.LonFirst, target.LonStep)
+	fmt.Fprintf(&buf, "HOURS %d\n", hours)
+
+	dirs := make([]float64, target.Ni)
+	speeds := make([][]float64, target.Nj)
+	for h := range hours {
+		t := start.Add(time.Duration(h) * time.Hour)
+		fmt.Fprintf(&buf, "%04d %02d %02d %02d\n", t.Year(), int(t.Month()), t.Day(), t.Hour())
+		for j := range target.Nj {
+			lat := south + float64(j)*target.LatStep
+			speeds[j] = make([]float64, target.Ni)
+			for i := range target.Ni {
+				u, v, err := field.at(t, lat, target.LonFirst+float64(i)*target.LonStep)
+				if err != nil {
+					return nil, err
+				}
+				dirs[i], speeds[j][i] = gridMissing, gridMissing
+				if !math.IsNaN(u) && !math.IsNaN(v) {
+					dirs[i], speeds[j][i] = windDirection(u, v), math.Hypot(u, v)
+				}
+			}
+			writeGridRow(&buf, dirs)
+		}
+		for _, row := range speeds {
+			writeGridRow(&buf, row)
+		}
+	}
+	addLogCount(ctx, "points", int64(target.Size()*hours))
+	return buf.Bytes(), nil
+}
+
+func writeGridRow(buf *bytes.Buffer, row []float64) {
+	for i, value := range row {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(strconv.FormatFloat(value, 'f', 1, 64))
+	}
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+var diurnalPercentiles = []float64{10, 25, 50, 75, 90}
+
+const maxDiurnalDays = 366
+
+type DiurnalAPIParams struct {
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+	StartDate string   `json:"start"` // yyyymmdd format
+	EndDate   string   `json:"end"`   // yyyymmdd format
+	Batches   []string `json:"batches"`
+}
+
+type DiurnalHour struct {
+	Batch       string             `json:"batch"`
+	Hour        int                `json:"hour"` // UTC
+	Samples     int                `json:"samples"`
+	MeanSpeed   NullFloat          `json:"mean_speed"` // m/s
+	StdSpeed    NullFloat          `json:"std_speed"`
+	MeanU       NullFloat          `json:"mean_u"`
+	MeanV       NullFloat          `json:"mean_v"`
+	Direction   NullFloat          `json:"direction"` // of the mean vector, degrees the wind blows from
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+type DiurnalResponse struct {
+	Lat     float64       `json:"lat"`
+	Lon     float64       `json:"lon"`
+	Start   string        `json:"start"`
+	End     string        `json:"end"`
+	Hours   []DiurnalHour `json:"hours"`
+	Missing int           `json:"missing"`
+	Status  int           `json:"status"`
+	Success bool          `json:"success"`
+}
+
+var diurnalFailResponse = DiurnalResponse{
+	Hours:   []DiurnalHour{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendDiurnalJsonError(w http.ResponseWriter, statusCode int) {
+	response := diurnalFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// diurnalHandler serves /diurnal?lat=&lon=&start=&end=&batch=
+func diurnalHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	lat, err := parseCoordinate(httpQuery.Get("lat"))
+	if err != nil {
+		sendDiurnalJsonError(w, http.StatusBadRequest)
+		return
+	}
+	lon, err := parseCoordinate(httpQuery.Get("lon"))
+	if err != nil {
+		sendDiurnalJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	start := httpQuery.Get("start")
+	end := httpQuery.Get("end")
+	if start == "" || end == "" {
+		sendDiurnalJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	batches, err := parseBatches(httpQuery.Get("batch"))
+	if err != nil {
+		sendDiurnalJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := DiurnalAPIParams{
+		Lat:       lat,
+		Lon:       lon,
+		StartDate: start,
+		EndDate:   end,
+		Batches:   batches,
+	}
+
+	setLogField(r.Context(), "date", start+"-"+end)
+	data, err2 := DiurnalQuery(r.Context(), params)
+	if err2 != nil {
+		sendDiurnalJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func DiurnalQuery(ctx context.Context, params DiurnalAPIParams) (DiurnalResponse, error) {
+	dates, err := generateDateRange(params.StartDate, params.EndDate)
+	if err != nil {
+		return diurnalFailResponse, err
+	}
+	if len(dates) > maxDiurnalDays {
+		return diurnalFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxDiurnalDays)
+	}
+
+	samples, missing, err := pointSeries(ctx, params.Lat, params.Lon, dates, params.Batches)
+	if err != nil {
+		return diurnalFailResponse, err
+	}
+	if len(samples) == 0 {
+		return diurnalFailResponse, fmt.Errorf("%w: no data between %s and %s", ErrDataNotPublished, params.StartDate, params.EndDate)
+	}
+
+	byBatch := make(map[string][]seriesSample, len(params.Batches))
+	for _, sample := range samples {
+		byBatch[sample.Batch] = append(byBatch[sample.Batch], sample)
+	}
+
+	batches := slices.Clone(params.Batches)
+	slices.Sort(batches)
+	hours := make([]DiurnalHour, 0, len(batches))
+	for _, batch := range batches {
+		hours = append(hours, diurnalHour(batch, byBatch[batch]))
+	}
+
+	response := DiurnalResponse{
+		Lat:     params.Lat,
+		Lon:     params.Lon,
+		Start:   params.StartDate,
+		End:     params.EndDate,
+		Hours:   hours,
+		Missing: missing,
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	return response, nil
+}
+
+func diurnalHour(batch string, samples []seriesSample) DiurnalHour {
+	hour, _ := strconv.Atoi(batch[:2])
+	stats := DiurnalHour{
+		Batch:       batch,
+		Hour:        hour,
+		Samples:     len(samples),
+		Percentiles: make(map[string]float64, len(diurnalPercentiles)),
+	}
+	if len(samples) == 0 {
+		nan := NullFloat(math.NaN())
+		stats.MeanSpeed, stats.StdSpeed, stats.MeanU, stats.MeanV, stats.Direction = nan, nan, nan, nan, nan
+		return stats
+	}
+
+	speeds := make([]float64, len(samples))
+	var sumU, sumV float64
+	for i, sample := range samples {
+		speeds[i] = math.Hypot(sample.U, sample.V)
+		sumU += sample.U
+		sumV += sample.V
+	}
+	mean, std := meanStd(speeds)
+	meanU := sumU / float64(len(samples))
+	meanV := sumV / float64(len(samples))
+	stats.MeanSpeed = NullFloat(mean)
+	stats.StdSpeed = NullFloat(std)
+	stats.MeanU = NullFloat(meanU)
+	stats.MeanV = NullFloat(meanV)
+	stats.Direction = NullFloat(windDirection(meanU, meanV))
+
+	slices.Sort(speeds)
+	for _, p := range diurnalPercentiles {
+		stats.Percentiles[strconv.FormatFloat(p, 'f', -1, 64)] = percentile(speeds, p)
+	}
+	return stats
+}
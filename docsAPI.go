@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// /openapi.json is an OpenAPI 3 description of the query endpoints, for
+// client teams generating SDKs, and /docs renders it with Swagger UI. The
+// spec is built per request: the response schemas are reflected from the
+// response structs and the enums come from paramRegistry and datasets, so
+// it cannot drift from what the server does. Only the query parameters are
+// declared by hand, in apiOperations. The Swagger UI bundle is loaded from
+// -swagger-ui, point it at a self-hosted swagger-ui-dist when the browsers
+// have no internet access.
+
+var swaggerUIBase = "https://unpkg.com/swagger-ui-dist@5"
+
+// apiParam is a query parameter of an operation.
+type apiParam struct {
+	name        string
+	description string
+	kind        string // OpenAPI type: string, number or integer
+	required    bool
+	enum        []string
+}
+
+// apiOperation is a GET endpoint.
+type apiOperation struct {
+	path     string
+	feature  string // the feature gating it (features.go), "" when stable
+	id       string
+	summary  string
+	params   []apiParam
+	response any                             // the JSON response, errors included
+	formats  map[string]string               // format= values other than json, to their content type
+	example  func(warm warmQuery) url.Values // a query answered from warm, see examplesAPI.go
+}
+
+const (
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+	xlsxContentType   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// apiOperations lists the documented endpoints, leaving out those whose
+// feature is disabled so the spec matches what the server answers.
+func apiOperations() []apiOperation {
+	params := append([]string{paramWind}, slices.Sorted(maps.Keys(paramRegistry))...)
+	batches := []string{"00z", "06z", "12z", "18z"}
+	selection := []apiParam{
+		{name: "param", kind: "string", enum: params, description: "wind (u and v) or a single parameter, default wind"},
+		{name: "params", kind: "string", description: "comma separated parameters returned in fields, excludes param"},
+		{name: "dataset", kind: "string", enum: slices.Sorted(maps.Keys(datasets)), description: "a named set of parameters, excludes param and params"},
+		{name: "derived", kind: "string", description: "comma separated fields derived from u and v: speed, dir"},
+	}
+	operations := []apiOperation{
+		{
+			path:    "/api",
+			id:      "singleQuery",
+			summary: "Values at one point",
+			params: append([]apiParam{
+				{name: "lat", kind: "number", required: true},
+				{name: "lon", kind: "number", required: true},
+				{name: "date", kind: "string", required: true, description: "yyyymmdd"},
+				{name: "batch", kind: "string", enum: batches, description: "the cycle, required unless time is given"},
+				{name: "time", kind: "string", description: "HHMM, interpolated between the cycles around it, excludes batch and step"},
+				{name: "step", kind: "integer", description: fmt.Sprintf("forecast step in hours, 0 (the analysis) to %d in %dh increments", maxStep("00z"), stepInterval)},
+				{name: "format", kind: "string", enum: []string{formatJSON, formatCSV, formatNDJSON}},
+			}, selection...),
+			response: SingleResponse{},
+			formats:  map[string]string{formatCSV: csvContentType, formatNDJSON: ndjsonContentType},
+			example: func(warm warmQuery) url.Values {
+				return url.Values{"lat": {coord(warm.lat)}, "lon": {coord(warm.lon)}, "date": {warm.date}, "batch": {warm.batch}, "step": {warm.step}, "param": {warm.param}}
+			},
+		},
+		{
+			path:    "/range",
+			id:      "rangeQuery",
+			summary: "Values on a lat/lon grid",
+			params: append([]apiParam{
+				{name: "slat", kind: "number", required: true, description: "start latitude"},
+				{name: "slon", kind: "number", required: true, description: "start longitude"},
+				{name: "elat", kind: "number", required: true, description: "end latitude"},
+				{name: "elon", kind: "number", required: true, description: "end longitude"},
+				{name: "step", kind: "number", required: true, description: "grid spacing in degrees"},
+				{name: "date", kind: "string", required: true, description: "yyyymmdd"},
+				{name: "batch", kind: "string", required: true, enum: batches},
+				{name: "lead", kind: "integer", description: "forecast step in hours, 0 is the analysis"},
+				{name: "smooth", kind: "number", description: "smoothing scale in degrees up to 5, 0 disables"},
+				{name: "smooth_kernel", kind: "string", enum: []string{SmoothGaussian, SmoothBox}},
+				{name: "format", kind: "string", enum: []string{formatJSON, formatCSV, formatNDJSON, formatGeoJSON, formatShape}},
+			}, selection...),
+			response: RangeResponse{},
+			formats: map[string]string{
+				formatCSV:     csvContentType,
+				formatNDJSON:  ndjsonContentType,
+				formatGeoJSON: "application/geo+json",
+				formatShape:   "application/zip",
+			},
+			example: func(warm warmQuery) url.Values {
+				return url.Values{
+					"slat": {coord(warm.lat - 0.5)}, "slon": {coord(warm.lon - 0.5)},
+					"elat": {coord(warm.lat + 0.5)}, "elon": {coord(warm.lon + 0.5)},
+					"step": {"0.25"}, "date": {warm.date}, "batch": {warm.batch}, "lead": {warm.step}, "param": {warm.param},
+				}
+			},
+		},
+		{
+			path:    "/daterange",
+			id:      "dateRangeQuery",
+			summary: "Values at one point over a range of dates",
+			params: append([]apiParam{
+				{name: "lat", kind: "number", required: true},
+				{name: "lon", kind: "number", required: true},
+				{name: "start_date", kind: "string", required: true, description: "yyyymmdd"},
+				{name: "end_date", kind: "string", required: true, description: "yyyymmdd, inclusive"},
+				{name: "batch", kind: "string", required: true, enum: batches},
+				{name: "step", kind: "integer", description: "forecast step in hours, 0 is the analysis"},
+				{name: "format", kind: "string", enum: []string{formatJSON, formatCSV, formatNDJSON, formatXLSX}},
+			}, selection...),
+			response: DateRangeResponse{},
+			formats:  map[string]string{formatCSV: csvContentType, formatNDJSON: ndjsonContentType, formatXLSX: xlsxContentType},
+			example: func(warm warmQuery) url.Values {
+				return url.Values{"lat": {coord(warm.lat)}, "lon": {coord(warm.lon)}, "start_date": {warm.date}, "end_date": {warm.date}, "batch": {warm.batch}, "step": {warm.step}, "param": {warm.param}}
+			},
+		},
+		{
+			path:    "/typhoon",
+			id:      "typhoonQuery",
+			summary: "IBTrACS storms at a cycle and their tracks",
+			params: []apiParam{
+				{name: "date", kind: "string", required: true, description: "yyyymmdd"},
+				{name: "batch", kind: "string", required: true, enum: batches},
+				{name: "simplify", kind: "number", description: "Douglas-Peucker tolerance of the tracks in km, 0 keeps every point"},
+				{name: "format", kind: "string", enum: []string{formatJSON, formatGeoJSON}},
+				{name: "trace", kind: "string", description: "strings (the default) groups trace by name and number, each point a JSON string, and names now fields cma_lat, cma_lon, cma_cat, cma_wind and cma_pres; points returns a list of tracks with typed points and names them lat, lon, cat, wind and pres with their agency", enum: []string{"strings", "points"}},
+				{name: "lang", kind: "string", description: "language of nature_name and category_name, overrides Accept-Language", enum: []string{langEnglish, langChinese}},
+			},
+			response: TyphonAPIResponse{},
+			formats:  map[string]string{formatGeoJSON: "application/geo+json"},
+			example: func(warm warmQuery) url.Values {
+				return url.Values{"date": {warm.date}, "batch": {warm.batch}}
+			},
+		},
+	}
+	return slices.DeleteFunc(operations, func(op apiOperation) bool {
+		return op.feature != "" && !featureEnabled(op.feature)
+	})
+}
+
+// openAPISpec builds the spec, server being the URL the API is reached at.
+func openAPISpec(server string) map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]any)
+	for _, op := range apiOperations() {
+		parameters := make([]any, 0, len(op.params))
+		for _, p := range op.params {
+			schema := map[string]any{"type": p.kind}
+			if len(p.enum) > 0 {
+				schema["enum"] = p.enum
+			}
+			parameter := map[string]any{"name": p.name, "in": "query", "required": p.required, "schema": schema}
+			if p.description != "" {
+				parameter["description"] = p.description
+			}
+			parameters = append(parameters, parameter)
+		}
+
+		ref := schemaOf(reflect.TypeOf(op.response), schemas)
+		content := map[string]any{"application/json": map[string]any{"schema": ref}}
+		for _, format := range slices.Sorted(maps.Keys(op.formats)) {
+			content[op.formats[format]] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+		}
+		errorContent := map[string]any{"application/json": map[string]any{"schema": ref}}
+		paths[op.path] = map[string]any{"get": map[string]any{
+			"operationId": op.id,
+			"summary":     op.summary,
+			"parameters":  parameters,
+			"responses": map[string]any{
+				"200": map[string]any{"description": "OK, in the content type of format=", "content": content},
+				"default": map[string]any{
+					"description": "Error: the same body with status set to the HTTP status and success (some for /typhoon) false",
+					"content":     errorContent,
+				},
+			},
+		}}
+	}
+
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Griber",
+			"version":     "1",
+			"description": "ECMWF open data and IBTrACS typhoon tracks. Missing grid cells are null.",
+		},
+		"servers":    []any{map[string]any{"url": server}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+	if authTokens != nil {
+		spec["components"].(map[string]any)["securitySchemes"] = map[string]any{
+			"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		}
+		spec["security"] = []any{map[string]any{"bearer": []string{}}, map[string]any{"apiKey": []string{}}}
+	}
+	return spec
+}
+
+var (
+	nullFloatType  = reflect.TypeFor[NullFloat]()
+	nullFloatsType = reflect.TypeFor[NullFloats]()
+)
+
+// schemaOf returns the schema of t, named structs being added to schemas and
+// referenced. Fields follow encoding/json: json tags name them and "-" skips
+// them, unexported ones are left out.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t {
+	case nullFloatType:
+		return map[string]any{"type": "number", "nullable": true}
+	case nullFloatsType:
+		return map[string]any{"type": "array", "items": map[string]any{"type": "number", "nullable": true}, "nullable": true}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaOf(t.Elem(), schemas)
+		if _, ok := schema["$ref"]; ok {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Slice: // nil encodes as null
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas), "nullable": true}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas), "nullable": true}
+	case reflect.Struct:
+		if t.Name() != "" {
+			if _, ok := schemas[t.Name()]; !ok {
+				schemas[t.Name()] = map[string]any{} // placeholder for recursive types
+				schemas[t.Name()] = structSchema(t, schemas)
+			}
+			return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		}
+		return structSchema(t, schemas)
+	}
+	return map[string]any{} // interfaces: any value
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIHandler serves GET /openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(openAPISpec(base)); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Griber API</title>
+<link rel="stylesheet" href="%[1]s/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="%[1]s/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// docsHandler serves GET /docs, Swagger UI on /openapi.json.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, docsPage, html.EscapeString(strings.TrimSuffix(swaggerUIBase, "/")))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// griber doctor checks a deployment before it takes traffic and prints a
+// readiness report. It takes the server's flags and -config, so it checks the
+// settings the server would run with:
+//
+//	griber doctor -config griber.json
+//	griber doctor -fixtures testdata -tmp-dir /var/lib/griber
+//
+// The storage, tmp dir and decoder checks are those of /readyz; on top it
+// reaches -ibtracs-url, parses -ibtracs and fetches and decodes the smallest
+// chunk of the latest published cycle without storing it. It exits 1 when a
+// check failed, warnings only degrade what the server can do.
+
+const doctorTimeout = time.Minute
+
+type doctorState string
+
+const (
+	doctorOK   doctorState = "ok"
+	doctorWarn doctorState = "warn"
+	doctorFail doctorState = "FAIL"
+	doctorSkip doctorState = "skip"
+)
+
+type doctorResult struct {
+	state    doctorState
+	detail   string
+	duration time.Duration
+}
+
+type doctorCheck struct {
+	name  string
+	check func(ctx context.Context) doctorResult
+}
+
+var doctorChecks = []doctorCheck{
+	{"storage", doctorStorage},
+	{"ibtracs_url", doctorIBTrACSURL},
+	{"tmp_dir", doctorTmpDir},
+	{"decoder", doctorDecoder},
+	{"ibtracs", doctorIBTrACS},
+	{"fetch", doctorFetch},
+}
+
+// runDoctor runs every check, writing the report to w, and returns the exit
+// code.
+func runDoctor(w io.Writer) int {
+	fmt.Fprintf(w, "griber doctor, tmp dir %s, decoder %s\n", tmpDir, gribDecoder)
+	failed, warned := 0, 0
+	for _, c := range doctorChecks {
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		start := time.Now()
+		result := c.check(ctx)
+		cancel()
+		if result.duration == 0 {
+			result.duration = time.Since(start)
+		}
+		fmt.Fprintf(w, "  %-4s  %-12s %s (%s)\n", result.state, c.name, result.detail, result.duration.Round(time.Millisecond))
+		switch result.state {
+		case doctorFail:
+			failed++
+		case doctorWarn:
+			warned++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "not ready: %d of %d checks failed, %d warnings\n", failed, len(doctorChecks), warned)
+		return 1
+	}
+	fmt.Fprintf(w, "ready, %d warnings\n", warned)
+	return 0
+}
+
+// componentResult turns a /readyz component into a result, failing it when
+// not ok.
+func componentResult(status ComponentStatus) doctorResult {
+	result := doctorResult{state: doctorOK, detail: status.Detail, duration: time.Duration(status.DurationMs) * time.Millisecond}
+	if !status.OK {
+		result.state = doctorFail
+		result.detail += ": " + status.Error
+	}
+	return result
+}
+
+func doctorStorage(ctx context.Context) doctorResult {
+	return componentResult(checkStorage(ctx))
+}
+
+func doctorTmpDir(ctx context.Context) doctorResult {
+	return componentResult(checkTmpDir())
+}
+
+func doctorDecoder(ctx context.Context) doctorResult {
+	return componentResult(decoderStatus())
+}
+
+// doctorIBTrACSURL checks that -ibtracs-url answers, it is only needed while
+// -ibtracs is missing and for refreshes.
+func doctorIBTrACSURL(ctx context.Context) doctorResult {
+	if ibtracsURL == "" {
+		return doctorResult{state: doctorSkip, detail: "no -ibtracs-url"}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ibtracsURL, nil)
+	if err != nil {
+		return doctorResult{state: doctorFail, detail: err.Error()}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return doctorResult{state: doctorWarn, detail: ibtracsURL + ": " + err.Error()}
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return doctorResult{state: doctorWarn, detail: ibtracsURL + ": " + resp.Status}
+	}
+	return doctorResult{state: doctorOK, detail: ibtracsURL + ": " + resp.Status}
+}
+
+// doctorIBTrACS parses -ibtracs. A missing file only warns, the typhoon
+// endpoints being the only ones needing it.
+func doctorIBTrACS(ctx context.Context) doctorResult {
+	if _, err := os.Stat(ibtracsPath); errors.Is(err, os.ErrNotExist) {
+		detail := ibtracsPath + " missing, the typhoon endpoints are unavailable"
+		if ibtracsURL != "" {
+			detail = ibtracsPath + " missing, the server downloads it from -ibtracs-url at startup"
+		}
+		return doctorResult{state: doctorWarn, detail: detail}
+	}
+	records, _, err := readIBTrACS(ibtracsPath, ibtracsAgency)
+	if err != nil {
+		return doctorResult{state: doctorFail, detail: fmt.Sprintf("%s: %v", ibtracsPath, err)}
+	}
+	if len(records) == 0 {
+		return doctorResult{state: doctorWarn, detail: ibtracsPath + " has no records"}
+	}
+	return doctorResult{state: doctorOK, detail: fmt.Sprintf("%d records from %s", len(records), ibtracsPath)}
+}
+
+// doctorFetch fetches and decodes the smallest chunk of the analysis of the
+// latest published cycle.
+func doctorFetch(ctx context.Context) doctorResult {
+	fail := func(err error) doctorResult { return doctorResult{state: doctorFail, detail: err.Error()} }
+	date, batch, err := latestBatch(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	stream, err := streamForBatch(batch)
+	if err != nil {
+		return fail(err)
+	}
+	index, err := source.Index(ctx, date, batch, stream, 0)
+	if err != nil {
+		return fail(err)
+	}
+	chunks, err := parseIndexResponse(index, slices.Collect(maps.Keys(paramRegistry)))
+	if err != nil {
+		return fail(err)
+	}
+	if len(chunks) == 0 {
+		return fail(fmt.Errorf("no known parameter in the index of %s %s", date, batch))
+	}
+	chunk := slices.MinFunc(chunks, func(a, b GribChunkInfo) int { return int(a.Length - b.Length) })
+
+	object, err := source.OpenObject(ctx, bucketName, makeRelative(date, batch, 0, ".grib2", stream))
+	if err != nil {
+		return fail(err)
+	}
+	defer object.Close()
+	reader, err := object.NewRangeReader(ctx, chunk.Offset, chunk.Length)
+	if err != nil {
+		return fail(err)
+	}
+	message, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return fail(err)
+	}
+	values, spec, err := decodeMessage(ctx, chunk.ParamName, message)
+	if err != nil {
+		return fail(fmt.Errorf("%s of %s %s: %w", chunk.ParamName, date, batch, err))
+	}
+	return doctorResult{state: doctorOK, detail: fmt.Sprintf("%s of %s %s: %d bytes, %d values on a %s grid",
+		chunk.ParamName, date, batch, len(message), len(values), spec.Type)}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Flying windows for drone operations: every stepInterval over a date range
+// the 10 m wind and gust at a point, extrapolated to the flight altitude with
+// the power law v(z) = v10 (z/10)^alpha, and the spans of time where all of
+// them stay within the limits.
+//
+// Each instant is taken from a short-lead forecast: the step 3-6h after the
+// batch before it, since gusts (10fg, the maximum since the previous step) do
+// not exist at step 0. Instants past the latest published batch come from its
+// longer steps, as far as that run goes.
+
+const (
+	maxDroneDays    = 16
+	droneMinLead    = stepInterval * time.Hour
+	defaultAltitude = 120 // m, the usual ceiling for open-category flights
+	defaultAlpha    = 1.0 / 7
+)
+
+var droneParams = []string{"10u", "10v", "10fg"}
+
+type DroneAPIParams struct {
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	StartDate string  `json:"start"`     // yyyymmdd
+	EndDate   string  `json:"end"`       // yyyymmdd
+	MaxWind   float64 `json:"max_wind"`  // m/s
+	MaxGust   float64 `json:"max_gust"`  // m/s
+	Altitude  float64 `json:"altitude"`  // m above ground
+	Alpha     float64 `json:"alpha"`     // wind shear exponent
+	MinHours  float64 `json:"min_hours"` // shortest window worth reporting
+}
+
+type DroneSample struct {
+	Time        time.Time `json:"time"`
+	Run         string    `json:"run"` // yyyymmdd-batch the sample comes from
+	Step        int       `json:"step"`
+	Wind        NullFloat `json:"wind"` // 10 m, m/s
+	Gust        NullFloat `json:"gust"`
+	WindAloft   NullFloat `json:"wind_aloft"` // at altitude
+	GustAloft   NullFloat `json:"gust_aloft"`
+	WithinLimit bool      `json:"within_limit"`
+}
+
+type DroneWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Hours float64   `json:"hours"`
+}
+
+type DroneResponse struct {
+	Lat      float64       `json:"lat"`
+	Lon      float64       `json:"lon"`
+	Altitude float64       `json:"altitude"`
+	MaxWind  float64       `json:"max_wind"`
+	MaxGust  float64       `json:"max_gust"`
+	Windows  []DroneWindow `json:"windows"`
+	Samples  []DroneSample `json:"samples"`
+	Missing  int           `json:"missing"` // instants without data
+	Status   int           `json:"status"`
+	Success  bool          `json:"success"`
+}
+
+var droneFailResponse = DroneResponse{
+	Windows: []DroneWindow{},
+	Samples: []DroneSample{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendDroneJsonError(w http.ResponseWriter, statusCode int) {
+	response := droneFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// droneHandler serves /drone/windows?lat=&lon=&start=&end=&max_wind=&max_gust=&altitude=&alpha=&min_hours=
+func droneHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := DroneAPIParams{
+		StartDate: httpQuery.Get("start"),
+		EndDate:   httpQuery.Get("end"),
+		Altitude:  defaultAltitude,
+		Alpha:     defaultAlpha,
+	}
+	if params.EndDate == "" {
+		params.EndDate = params.StartDate
+	}
+	for name, dst := range map[string]*float64{
+		"lat": &params.Lat, "lon": &params.Lon, "max_wind": &params.MaxWind, "max_gust": &params.MaxGust,
+	} {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendDroneJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	for name, dst := range map[string]*float64{"altitude": &params.Altitude, "alpha": &params.Alpha, "min_hours": &params.MinHours} {
+		if s := httpQuery.Get(name); s != "" {
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				sendDroneJsonError(w, http.StatusBadRequest)
+				return
+			}
+			*dst = value
+		}
+	}
+
+	setLogField(r.Context(), "date", params.StartDate+"-"+params.EndDate)
+	data, err2 := DroneQuery(r.Context(), params)
+	if err2 != nil {
+		sendDroneJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func DroneQuery(ctx context.Context, params DroneAPIParams) (DroneResponse, error) {
+	switch {
+	case params.MaxWind <= 0 || params.MaxGust <= 0:
+		return droneFailResponse, fmt.Errorf("%w: max_wind and max_gust must be positive", ErrInvalidParams)
+	case params.Altitude < 10 || params.Altitude > 1000:
+		return droneFailResponse, fmt.Errorf("%w: altitude must be between 10 and 1000 m", ErrInvalidParams)
+	case params.Alpha < 0 || params.Alpha > 1:
+		return droneFailResponse, fmt.Errorf("%w: alpha must be between 0 and 1", ErrInvalidParams)
+	}
+	dates, err := generateDateRange(params.StartDate, params.EndDate)
+	if err != nil {
+		return droneFailResponse, err
+	}
+	if len(dates) > maxDroneDays {
+		return droneFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxDroneDays)
+	}
+
+	start, _ := time.Parse("20060102", dates[0])
+	end := start.Add(time.Duration(len(dates)) * 24 * time.Hour)
+	samples, missing, err := shortLeadWind(ctx, params.Lat, params.Lon, start, end)
+	if err != nil {
+		return droneFailResponse, err
+	}
+	shear := math.Pow(params.Altitude/10, params.Alpha)
+	for i := range samples {
+		sample := &samples[i]
+		wind, gust := float64(sample.Wind), float64(sample.Gust)
+		sample.WindAloft, sample.GustAloft = NullFloat(wind*shear), NullFloat(gust*shear)
+		// aloft is at least as strong as at 10 m, checking both states the rule
+		sample.WithinLimit = max(wind, wind*shear) <= params.MaxWind && max(gust, gust*shear) <= params.MaxGust
+	}
+	if len(samples) == 0 {
+		return droneFailResponse, fmt.Errorf("%w: no forecast between %s and %s", ErrDataNotPublished, params.StartDate, params.EndDate)
+	}
+
+	return DroneResponse{
+		Lat:      params.Lat,
+		Lon:      params.Lon,
+		Altitude: params.Altitude,
+		MaxWind:  params.MaxWind,
+		MaxGust:  params.MaxGust,
+		Windows:  droneWindows(samples, params.MinHours, func(sample DroneSample) bool { return sample.WithinLimit }),
+		Samples:  samples,
+		Missing:  missing,
+		Status:   http.StatusOK,
+		Success:  true,
+	}, nil
+}
+
+// shortLeadWind samples the 10 m wind and gust at a point every droneMinLead
+// from start to end, or as far as the latest run goes, and counts the
+// instants without data.
+func shortLeadWind(ctx context.Context, lat, lon float64, start, end time.Time) ([]DroneSample, int, error) {
+	latestDate, latestBatchName, err := latestBatch(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	latest, _ := time.Parse("2006010215", latestDate+latestBatchName[:2])
+
+	var samples []DroneSample
+	missing := 0
+	for t := start; t.Before(end); t = t.Add(droneMinLead) {
+		run := t.Add(-droneMinLead).Truncate(batchInterval)
+		if run.After(latest) {
+			run = latest
+		}
+		date, batch := run.Format("20060102"), fmt.Sprintf("%02dz", run.Hour())
+		step := int(t.Sub(run) / time.Hour)
+		if step > maxStep(batch) {
+			break // beyond the latest run's range
+		}
+
+		filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+		grid, fields, err := loadParamFields(ctx, filePath, date, batch, step, droneParams)
+		if errors.Is(err, ErrMemoryPressure) || ctx.Err() != nil {
+			return nil, 0, errors.Join(err, ctx.Err())
+		}
+		if err != nil {
+			appendLogField(ctx, "missing_dates", date+"-"+batch+"-"+stepName(step))
+			missing++
+			continue
+		}
+		index, err := grid.Index(lat, lon)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get index for coord: %w", err)
+		}
+		wind := math.Hypot(fields[0][index], fields[1][index])
+		gust := fields[2][index]
+		if math.IsNaN(wind) || math.IsNaN(gust) {
+			missing++
+			continue
+		}
+		samples = append(samples, DroneSample{
+			Time: t,
+			Run:  date + "-" + batch,
+			Step: step,
+			Wind: NullFloat(wind),
+			Gust: NullFloat(gust),
+		})
+	}
+	return samples, missing, nil
+}
+
+// droneWindows joins consecutive samples in into windows of at least
+// minHours. A gap in the data ends a window, nothing is known about the wind
+// there.
+func droneWindows(samples []DroneSample, minHours float64, in func(DroneSample) bool) []DroneWindow {
+	windows := []DroneWindow{}
+	var open *DroneWindow
+	for i, sample := range samples {
+		contiguous := i > 0 && sample.Time.Sub(samples[i-1].Time) == droneMinLead
+		if open != nil && (!in(sample) || !contiguous) {
+			open = nil
+		}
+		if !in(sample) {
+			continue
+		}
+		if open == nil {
+			windows = append(windows, DroneWindow{Start: sample.Time})
+			open = &windows[len(windows)-1]
+		}
+		open.End = sample.Time
+		open.Hours = open.End.Sub(open.Start).Hours()
+	}
+	kept := windows[:0]
+	for _, window := range windows {
+		if window.Hours >= minHours {
+			kept = append(kept, window)
+		}
+	}
+	return kept
+}
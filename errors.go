@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"grib_server/grid"
+)
+
+// Errors returned by the query functions (SingleQuery, RangeQuery,
+// DateRangeQuery, ...). They are wrapped with context, so test for them with
+// errors.Is rather than comparing messages.
+var (
+	// ErrDataNotPublished means the requested date/batch does not exist
+	// upstream (yet), e.g. a future date or a cycle that is still running.
+	ErrDataNotPublished = errors.New("data not published")
+	// ErrUpstreamUnavailable means the bucket could not be reached or
+	// answered with an error.
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	// ErrOutOfGrid means a coordinate does not fall on the data's grid.
+	ErrOutOfGrid = grid.ErrOutOfGrid
+	// ErrInvalidDate means a date is not a valid yyyymmdd date or a date
+	// range is reversed.
+	ErrInvalidDate = errors.New("invalid date")
+	// ErrInvalidBatch means a batch is not one of 00z, 06z, 12z, 18z.
+	ErrInvalidBatch = errors.New("invalid batch")
+	// ErrInvalidParams covers any other query parameter the query rejects.
+	ErrInvalidParams = errors.New("invalid parameters")
+	// ErrVerificationFailed means a freshly decoded grid disagreed with the
+	// eccodes reference values and the ingest was aborted.
+	ErrVerificationFailed = errors.New("decoded values do not match reference")
+	// ErrMemoryPressure is returned for cold ingests while the memory
+	// watchdog reports the process close to its ceiling.
+	ErrMemoryPressure = errors.New("server is under memory pressure, retry later")
+	// ErrOverloaded is returned for cold loads while too many are in flight
+	// already, see loadshed.go.
+	ErrOverloaded = errors.New("server is overloaded, retry later")
+	// ErrDatasetUnavailable means a dataset the query needs (IBTrACS) is
+	// not loaded, the server keeps retrying in the background.
+	ErrDatasetUnavailable = errors.New("dataset unavailable")
+	// ErrStormNotFound means no IBTrACS record matches the requested storm.
+	ErrStormNotFound = errors.New("storm not found")
+	// ErrSpotNotFound means the -spots file names no such spot.
+	ErrSpotNotFound = errors.New("spot not found")
+	// ErrRuleNotFound means the -rules file names no such rule, or the feed
+	// token does not match it.
+	ErrRuleNotFound = errors.New("rule not found")
+	// ErrDecoderUnavailable means an ingest needs an external GRIB decoder
+	// (grib_dump) that is not installed, see decoders.go.
+	ErrDecoderUnavailable = errors.New("grib decoder unavailable")
+)
+
+// queryErrorStatus maps an error returned by a query to the HTTP status the
+// handlers respond with.
+func queryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidDate), errors.Is(err, ErrInvalidBatch),
+		errors.Is(err, ErrInvalidParams), errors.Is(err, ErrOutOfGrid):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrDataNotPublished), errors.Is(err, ErrStormNotFound), errors.Is(err, ErrSpotNotFound),
+		errors.Is(err, ErrRuleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrMemoryPressure), errors.Is(err, ErrOverloaded), errors.Is(err, ErrDatasetUnavailable),
+		errors.Is(err, ErrDecoderUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// validateDateBatch checks the date and batch of a query.
+func validateDateBatch(date, batch string) error {
+	if !isValidDateFormat(date) {
+		return fmt.Errorf("%w: %q", ErrInvalidDate, date)
+	}
+	if !isValidBatch(batch) {
+		return fmt.Errorf("%w: %q", ErrInvalidBatch, batch)
+	}
+	return nil
+}
+
+func isValidBatch(batch string) bool {
+	return batch == "00z" || batch == "06z" || batch == "12z" || batch == "18z"
+}
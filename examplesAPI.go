@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /examples answers, for every operation of /openapi.json and every format
+// it offers, a request and the response this server gives to it right now,
+// so integrators can copy queries known to work. The requests are built from
+// the most recently stored field (see contentStore.go), so they are answered
+// from the cache and never start an ingest, and they are run in-process with
+// the caller's credentials. format=ipynb returns the same examples as a
+// Jupyter notebook of Python cells with their outputs.
+
+const (
+	formatIPynb = "ipynb"
+	// maxExampleBody bounds the bodies included, larger ones only have their
+	// size reported.
+	maxExampleBody = 16 << 10
+)
+
+// warmQuery is what the examples query: a stored field and a point of its
+// grid.
+type warmQuery struct {
+	date, batch, step, param string
+	lat, lon                 float64
+	updatedAt                time.Time
+}
+
+type Example struct {
+	Endpoint    string          `json:"endpoint"`
+	Format      string          `json:"format"`
+	Request     string          `json:"request"` // path and query
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type"`
+	Bytes       int             `json:"bytes"`
+	Response    json.RawMessage `json:"response,omitempty"` // JSON bodies up to maxExampleBody
+	Text        string          `json:"text,omitempty"`     // text bodies up to maxExampleBody
+}
+
+type ExamplesResponse struct {
+	Date     string    `json:"date"`
+	Batch    string    `json:"batch"`
+	Step     string    `json:"step"`
+	Param    string    `json:"param"`
+	Examples []Example `json:"examples"`
+	Status   int       `json:"status"`
+	Success  bool      `json:"success"`
+}
+
+func sendExamplesJsonError(w http.ResponseWriter, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(ExamplesResponse{Examples: []Example{}, Status: statusCode})
+}
+
+// examplesHandler serves GET /examples?format=json|ipynb
+func examplesHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != formatJSON && format != formatIPynb {
+		sendExamplesJsonError(w, http.StatusBadRequest)
+		return
+	}
+	warm, err := newestWarmQuery()
+	if err != nil {
+		sendExamplesJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+	setLogField(r.Context(), "date", warm.date+"-"+warm.batch)
+
+	response := ExamplesResponse{
+		Date:     warm.date,
+		Batch:    warm.batch,
+		Step:     warm.step,
+		Param:    warm.param,
+		Examples: []Example{},
+		Status:   http.StatusOK,
+		Success:  true,
+	}
+	for _, op := range apiOperations() {
+		for _, f := range operationFormats(op) {
+			response.Examples = append(response.Examples, runExample(r, op, f, warm))
+		}
+	}
+
+	if format == formatIPynb {
+		w.Header().Set("Content-Type", "application/x-ipynb+json")
+		w.Header().Set("Content-Disposition", `attachment; filename="griber-examples.ipynb"`)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(examplesNotebook(r, response)); err != nil {
+			log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// newestWarmQuery picks the most recently stored field, wind when both of
+// its components are stored, and the middle point of its grid.
+func newestWarmQuery() (warmQuery, error) {
+	entries, err := manifest.snapshot()
+	if err != nil {
+		return warmQuery{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var warm warmQuery
+	for key, entry := range entries {
+		parts := strings.Split(key, "|")
+		if len(parts) != 4 || !entry.UpdatedAt.After(warm.updatedAt) {
+			continue
+		}
+		warm = warmQuery{date: parts[0], batch: parts[1], param: parts[2], step: strings.TrimSuffix(parts[3], "h"), updatedAt: entry.UpdatedAt}
+	}
+	if warm.date == "" {
+		return warmQuery{}, fmt.Errorf("%w: nothing is stored yet to build examples from", ErrDataNotPublished)
+	}
+	step, err := strconv.Atoi(warm.step)
+	if err != nil {
+		return warmQuery{}, fmt.Errorf("stored step %q: %w", warm.step, err)
+	}
+
+	stored, err := readStoredParam(warm.date, warm.batch, warm.param, step)
+	if err != nil {
+		return warmQuery{}, err
+	}
+	warm.lat, warm.lon = stored.Grid.Coord(stored.Grid.Size() / 2)
+	if slices.Contains(windParams, warm.param) && paramsStored(warm.date, warm.batch, step, windParams) {
+		warm.param = paramWind
+	}
+	return warm, nil
+}
+
+// operationFormats lists the format= values of an operation, json first.
+func operationFormats(op apiOperation) []string {
+	for _, p := range op.params {
+		if p.name == "format" {
+			return p.enum
+		}
+	}
+	return []string{formatJSON}
+}
+
+// runExample sends the example query of op in format to the handlers,
+// bypassing the middleware but not the role check.
+func runExample(r *http.Request, op apiOperation, format string, warm warmQuery) Example {
+	query := op.example(warm)
+	if format != formatJSON {
+		query.Set("format", format)
+	}
+	target := op.path + "?" + query.Encode()
+	req := httptest.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	for _, header := range []string{"Authorization", "X-API-Key"} {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(recorder, req)
+
+	body := recorder.Body.Bytes()
+	example := Example{
+		Endpoint:    op.path,
+		Format:      format,
+		Request:     target,
+		Status:      recorder.Code,
+		ContentType: recorder.Header().Get("Content-Type"),
+		Bytes:       len(body),
+	}
+	if len(body) > maxExampleBody {
+		return example
+	}
+	switch {
+	case strings.Contains(example.ContentType, "json") && json.Valid(body):
+		example.Response = json.RawMessage(body)
+	case strings.HasPrefix(example.ContentType, "text/"), example.ContentType == ndjsonContentType:
+		example.Text = string(body)
+	}
+	return example
+}
+
+// examplesNotebook is the examples as an nbformat 4 notebook, one markdown and
+// one Python cell per example, with the response as the cell's output.
+func examplesNotebook(r *http.Request, response ExamplesResponse) map[string]any {
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+	setup := []string{"import requests\n", "\n", fmt.Sprintf("BASE = %q\n", base)}
+	if authTokens != nil {
+		setup = append(setup, "HEADERS = {\"X-API-Key\": \"<your key>\"}\n")
+	} else {
+		setup = append(setup, "HEADERS = {}\n")
+	}
+	cells := []any{
+		markdownCell(fmt.Sprintf("# Griber examples\n\nQueries of %s %s step %sh, param %s, as answered by %s.",
+			response.Date, response.Batch, response.Step, response.Param, base)),
+		codeCell(setup, nil),
+	}
+	for _, example := range response.Examples {
+		cells = append(cells, markdownCell(fmt.Sprintf("## %s, format %s", example.Endpoint, example.Format)))
+		source := []string{fmt.Sprintf("r = requests.get(BASE + %q, headers=HEADERS)\n", example.Request)}
+		var output string
+		switch {
+		case example.Response != nil:
+			source = append(source, "r.json()")
+			output = string(example.Response)
+		case example.Text != "":
+			source = append(source, "print(r.text)")
+			output = example.Text
+		default:
+			source = append(source, "r.status_code, r.headers[\"Content-Type\"], len(r.content)")
+			output = fmt.Sprintf("(%d, %q, %d)", example.Status, example.ContentType, example.Bytes)
+		}
+		cells = append(cells, codeCell(source, []any{map[string]any{
+			"output_type":     "execute_result",
+			"execution_count": nil,
+			"metadata":        map[string]any{},
+			"data":            map[string]any{"text/plain": output},
+		}}))
+	}
+	return map[string]any{
+		"nbformat":       4,
+		"nbformat_minor": 4,
+		"metadata": map[string]any{
+			"kernelspec":    map[string]any{"name": "python3", "display_name": "Python 3", "language": "python"},
+			"language_info": map[string]any{"name": "python"},
+		},
+		"cells": cells,
+	}
+}
+
+func markdownCell(text string) map[string]any {
+	return map[string]any{"cell_type": "markdown", "metadata": map[string]any{}, "source": text}
+}
+
+func codeCell(source []string, outputs []any) map[string]any {
+	if outputs == nil {
+		outputs = []any{}
+	}
+	return map[string]any{
+		"cell_type":       "code",
+		"execution_count": nil,
+		"metadata":        map[string]any{},
+		"source":          source,
+		"outputs":         outputs,
+	}
+}
+
+func coord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
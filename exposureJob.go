@@ -0,0 +1,379 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Insurance exposure scoring: a portfolio of insured locations is run against
+// a storm or a date window, as an async job. The peak wind at each location
+// is the highest archive 10 m wind over the period and, for a storm, the
+// parametric swath of its IBTrACS track if higher; the loss is the location's
+// value times a damage ratio from the Emanuel (2011) curve
+//
+//	f = vn^3 / (1 + vn^3),  vn = max(v - vThresh, 0) / (vHalf - vThresh)
+//
+// which is 0 below vThresh and reaches half the value at vHalf.
+
+const (
+	maxExposureLocations = 50000
+	maxExposureDays      = 62
+
+	defaultDamageThreshold = 25.7 // m/s
+	defaultDamageHalf      = 74.7 // m/s
+
+	// Rankine vortex of the swath: vmax (rmax/r)^0.5 outside rmax; inside
+	// it the eyewall swept over the point, so vmax
+	swathRmaxKm  = 40.0
+	swathDecay   = 0.5
+	swathSubstep = 6 // positions interpolated per track interval
+)
+
+// exposureBands are the Saffir-Simpson categories in m/s the report counts
+// locations by, each from its lower bound.
+var exposureBands = []struct {
+	Name  string
+	Lower float64
+}{
+	{"below_ts", 0},
+	{"tropical_storm", 18},
+	{"cat1", 33},
+	{"cat2", 43},
+	{"cat3", 50},
+	{"cat4", 58},
+	{"cat5", 70},
+}
+
+type ExposureLocation struct {
+	ID    string  `json:"id"`
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Value float64 `json:"value"`
+}
+
+type ExposureParams struct {
+	SID             string  `json:"sid,omitempty"`
+	Start           string  `json:"start"` // yyyymmdd, from the track when sid is set
+	End             string  `json:"end"`
+	DamageThreshold float64 `json:"v_thresh"`
+	DamageHalf      float64 `json:"v_half"`
+}
+
+type LocationExposure struct {
+	ExposureLocation
+	PeakWind    NullFloat `json:"peak_wind"`    // m/s
+	ArchiveWind NullFloat `json:"archive_wind"` // m/s, highest archive sample
+	SwathWind   NullFloat `json:"swath_wind"`   // m/s, from the track, storms only
+	Band        string    `json:"band"`
+	DamageRatio float64   `json:"damage_ratio"`
+	Loss        float64   `json:"loss"`
+}
+
+type ExposureBand struct {
+	Name      string  `json:"name"`
+	Locations int     `json:"locations"`
+	Value     float64 `json:"value"`
+	Loss      float64 `json:"loss"`
+}
+
+type ExposureReport struct {
+	Params     ExposureParams     `json:"params"`
+	Locations  []LocationExposure `json:"locations"`
+	Bands      []ExposureBand     `json:"bands"`
+	TotalValue float64            `json:"total_value"`
+	TotalLoss  float64            `json:"total_loss"`
+	LossRatio  float64            `json:"loss_ratio"`
+	MaxLoss    *LocationExposure  `json:"max_loss,omitempty"`
+	Missing    int                `json:"missing"` // locations without any wind
+}
+
+// exposureJobHandler serves POST /jobs/exposure?sid= or ?start=&end=, with
+// v_thresh and v_half optional, the body a CSV portfolio with columns id,
+// lat, lon and value. It answers 202 with the job to poll.
+func exposureJobHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := ExposureParams{
+		SID:             httpQuery.Get("sid"),
+		Start:           httpQuery.Get("start"),
+		End:             httpQuery.Get("end"),
+		DamageThreshold: defaultDamageThreshold,
+		DamageHalf:      defaultDamageHalf,
+	}
+	for name, dst := range map[string]*float64{"v_thresh": &params.DamageThreshold, "v_half": &params.DamageHalf} {
+		if s := httpQuery.Get(name); s != "" {
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				writeJobResponse(w, http.StatusBadRequest, nil)
+				return
+			}
+			*dst = value
+		}
+	}
+	locations, err := parseExposureCSV(io.LimitReader(r.Body, 16<<20))
+	if err != nil {
+		writeJobResponse(w, http.StatusBadRequest, nil)
+		setLogField(r.Context(), "error", err)
+		return
+	}
+	if err := params.resolve(); err != nil {
+		writeJobResponse(w, queryErrorStatus(err), nil)
+		setLogField(r.Context(), "error", err)
+		return
+	}
+
+	setLogField(r.Context(), "date", params.Start+"-"+params.End)
+	addLogCount(r.Context(), "points", int64(len(locations)))
+	job := submitJob(r, "exposure", func(ctx context.Context) (any, error) {
+		return ExposureQuery(ctx, params, locations)
+	})
+	sendJobAccepted(w, job)
+}
+
+// parseExposureCSV reads a portfolio, columns found by their header names in
+// any order. Rows without an id are numbered from 1.
+func parseExposureCSV(r io.Reader) ([]ExposureLocation, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading CSV header: %v", ErrInvalidParams, err)
+	}
+	columns := map[string]int{"id": -1, "lat": -1, "lon": -1, "value": -1}
+	for i, name := range header {
+		if _, ok := columns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+	}
+	for _, name := range []string{"lat", "lon", "value"} {
+		if columns[name] < 0 {
+			return nil, fmt.Errorf("%w: CSV has no %s column", ErrInvalidParams, name)
+		}
+	}
+
+	var locations []ExposureLocation
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidParams, err)
+		}
+		field := func(name string) string {
+			if i := columns[name]; i >= 0 && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		location := ExposureLocation{ID: field("id")}
+		if location.ID == "" {
+			location.ID = strconv.Itoa(len(locations) + 1)
+		}
+		for name, dst := range map[string]*float64{"lat": &location.Lat, "lon": &location.Lon, "value": &location.Value} {
+			value, err := strconv.ParseFloat(field(name), 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, fmt.Errorf("%w: line %d: invalid %s", ErrInvalidParams, line, name)
+			}
+			*dst = value
+		}
+		if location.Lat < -90 || location.Lat > 90 || location.Value < 0 {
+			return nil, fmt.Errorf("%w: line %d: latitude out of range or negative value", ErrInvalidParams, line)
+		}
+		if len(locations) == maxExposureLocations {
+			return nil, fmt.Errorf("%w: more than %d locations", ErrInvalidParams, maxExposureLocations)
+		}
+		locations = append(locations, location)
+	}
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("%w: CSV has no locations", ErrInvalidParams)
+	}
+	return locations, nil
+}
+
+// resolve checks params, taking the window from the storm's track when a
+// SID is given.
+func (params *ExposureParams) resolve() error {
+	if params.DamageThreshold < 0 || params.DamageHalf <= params.DamageThreshold {
+		return fmt.Errorf("%w: v_half must be above v_thresh >= 0", ErrInvalidParams)
+	}
+	if params.SID == "" {
+		if params.Start == "" || params.End == "" {
+			return fmt.Errorf("%w: sid or start and end required", ErrInvalidParams)
+		}
+	} else {
+		records, err := TrackQuery(TrackAPIParams{SID: params.SID})
+		if err != nil {
+			return err
+		}
+		params.Start = records[0][colIsoTime][:8]
+		params.End = records[len(records)-1][colIsoTime][:8]
+	}
+	dates, err := generateDateRange(params.Start, params.End)
+	if err != nil {
+		return err
+	}
+	if len(dates) > maxExposureDays {
+		return fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxExposureDays)
+	}
+	return nil
+}
+
+func ExposureQuery(ctx context.Context, params ExposureParams, locations []ExposureLocation) (ExposureReport, error) {
+	dates, err := generateDateRange(params.Start, params.End)
+	if err != nil {
+		return ExposureReport{}, err
+	}
+	points := make([][2]float64, len(locations))
+	for i, location := range locations {
+		points[i] = [2]float64{location.Lat, location.Lon}
+	}
+	series, _, err := pointsSeries(ctx, points, dates, allBatches)
+	if err != nil {
+		return ExposureReport{}, err
+	}
+	var swath []float64
+	if params.SID != "" {
+		records, err := TrackQuery(TrackAPIParams{SID: params.SID})
+		if err != nil {
+			return ExposureReport{}, err
+		}
+		swath = trackSwath(records, points)
+	}
+
+	report := ExposureReport{
+		Params:    params,
+		Locations: make([]LocationExposure, len(locations)),
+		Bands:     make([]ExposureBand, len(exposureBands)),
+	}
+	for i, band := range exposureBands {
+		report.Bands[i].Name = band.Name
+	}
+	for i, location := range locations {
+		result := LocationExposure{
+			ExposureLocation: location,
+			ArchiveWind:      NullFloat(math.NaN()),
+			SwathWind:        NullFloat(math.NaN()),
+		}
+		for _, sample := range series[i] {
+			speed := math.Hypot(sample.U, sample.V)
+			if math.IsNaN(float64(result.ArchiveWind)) || speed > float64(result.ArchiveWind) {
+				result.ArchiveWind = NullFloat(speed)
+			}
+		}
+		if swath != nil {
+			result.SwathWind = NullFloat(swath[i])
+		}
+		peak := float64(result.ArchiveWind)
+		if swath != nil && (math.IsNaN(peak) || swath[i] > peak) {
+			peak = swath[i]
+		}
+		result.PeakWind = NullFloat(peak)
+		report.TotalValue += location.Value
+		if math.IsNaN(peak) {
+			report.Missing++
+			report.Locations[i] = result
+			continue
+		}
+
+		result.DamageRatio = damageRatio(peak, params.DamageThreshold, params.DamageHalf)
+		result.Loss = location.Value * result.DamageRatio
+		band := 0
+		for band+1 < len(exposureBands) && peak >= exposureBands[band+1].Lower {
+			band++
+		}
+		result.Band = exposureBands[band].Name
+		report.Bands[band].Locations++
+		report.Bands[band].Value += location.Value
+		report.Bands[band].Loss += result.Loss
+		report.TotalLoss += result.Loss
+		report.Locations[i] = result
+	}
+	if report.Missing == len(locations) {
+		return ExposureReport{}, fmt.Errorf("%w: no wind for any location between %s and %s", ErrDataNotPublished, params.Start, params.End)
+	}
+	if report.TotalValue > 0 {
+		report.LossRatio = report.TotalLoss / report.TotalValue
+	}
+	if worst := slices.MaxFunc(report.Locations, func(a, b LocationExposure) int {
+		return cmp.Compare(a.Loss, b.Loss)
+	}); worst.Loss > 0 {
+		report.MaxLoss = &worst
+	}
+	return report, nil
+}
+
+// damageRatio is the Emanuel (2011) damage function at wind v m/s.
+func damageRatio(v, threshold, half float64) float64 {
+	vn := math.Max(v-threshold, 0) / (half - threshold)
+	cube := vn * vn * vn
+	return cube / (1 + cube)
+}
+
+// stormFix is a storm's position and maximum wind in m/s.
+type stormFix struct{ lat, lon, wind float64 }
+
+// trackFixes returns the positions of a track with wind, substeps of them
+// per interval between records, interpolated linearly (across the
+// antimeridian the short way).
+func trackFixes(records [][]string, substeps int) []stormFix {
+	var fixes []stormFix
+	for _, record := range records {
+		point := typhoonPointFor(record)
+		if math.IsNaN(point.Lat) || math.IsNaN(point.Lon) || math.IsNaN(point.Wind) {
+			continue
+		}
+		fixes = append(fixes, stormFix{point.Lat, point.Lon, point.Wind * 0.514444})
+	}
+	if len(fixes) < 2 {
+		return fixes
+	}
+	positions := make([]stormFix, 0, (len(fixes)-1)*substeps+1)
+	for i := 1; i < len(fixes); i++ {
+		a, b := fixes[i-1], fixes[i]
+		dlon := normalizeLon(b.lon - a.lon)
+		for k := range substeps {
+			f := float64(k) / float64(substeps)
+			positions = append(positions, stormFix{a.lat + f*(b.lat-a.lat), normalizeLon(a.lon + f*dlon), a.wind + f*(b.wind-a.wind)})
+		}
+	}
+	return append(positions, fixes[len(fixes)-1])
+}
+
+// trackSwath returns the highest wind the storm brought to each point, in
+// m/s: a Rankine vortex of the track's reported maximum wind, moved along
+// the track with positions interpolated between the records. Points the
+// storm never reported wind near are 0.
+func trackSwath(records [][]string, points [][2]float64) []float64 {
+	positions := trackFixes(records, swathSubstep)
+	swath := make([]float64, len(points))
+	for i, point := range points {
+		for _, p := range positions {
+			r := haversineKm(point[0], point[1], p.lat, p.lon)
+			v := p.wind
+			if r > swathRmaxKm {
+				v = p.wind * math.Pow(swathRmaxKm/r, swathDecay)
+			}
+			swath[i] = math.Max(swath[i], v)
+		}
+	}
+	return swath
+}
+
+// isotachRadiusKm is how far from the centre the swath vortex of maximum
+// wind vmax blows at least speed, 0 if it never does.
+func isotachRadiusKm(vmax, speed float64) float64 {
+	if speed > vmax {
+		return 0
+	}
+	return swathRmaxKm * math.Pow(vmax/speed, 1/swathDecay)
+}
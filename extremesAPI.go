@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// /extremes fits a Gumbel distribution to block maxima of the wind speed at
+// a point (method of moments) and derives return levels. The archive only
+// holds what has been ingested, so blocks are whatever the period covers;
+// partially covered blocks underestimate their maximum.
+
+const (
+	eulerGamma      = 0.5772156649015329
+	minExtremeBlock = 3    // fewer maxima make the fit meaningless
+	maxExtremesDays = 3653 // ten years, yearly blocks need several
+)
+
+var (
+	defaultReturnPeriods = []float64{2, 5, 10, 25, 50, 100}
+	extremePercentiles   = []float64{50, 90, 95, 99, 99.9}
+)
+
+type ExtremesAPIParams struct {
+	Lat           float64   `json:"lat"`
+	Lon           float64   `json:"lon"`
+	StartDate     string    `json:"start"` // yyyymmdd format
+	EndDate       string    `json:"end"`   // yyyymmdd format
+	Batches       []string  `json:"batches"`
+	Block         string    `json:"block"`          // year or month
+	ReturnPeriods []float64 `json:"return_periods"` // years
+}
+
+type BlockMaximum struct {
+	Block string  `json:"block"` // yyyy or yyyymm
+	Speed float64 `json:"speed"` // m/s
+}
+
+type ReturnLevel struct {
+	PeriodYears float64 `json:"period_years"`
+	Speed       float64 `json:"speed"` // m/s
+	Lower       float64 `json:"lower"` // 95% confidence interval
+	Upper       float64 `json:"upper"`
+}
+
+type GumbelFit struct {
+	Location float64 `json:"location"`
+	Scale    float64 `json:"scale"`
+}
+
+type ExtremesResponse struct {
+	Lat          float64            `json:"lat"`
+	Lon          float64            `json:"lon"`
+	Start        string             `json:"start"`
+	End          string             `json:"end"`
+	Batches      []string           `json:"batches"`
+	Block        string             `json:"block"`
+	Maxima       []BlockMaximum     `json:"maxima"`
+	Gumbel       GumbelFit          `json:"gumbel"`
+	ReturnLevels []ReturnLevel      `json:"return_levels"`
+	Percentiles  map[string]float64 `json:"percentiles"` // of all samples, m/s
+	Samples      int                `json:"samples"`
+	Missing      int                `json:"missing"`
+	Status       int                `json:"status"`
+	Success      bool               `json:"success"`
+}
+
+var extremesFailResponse = ExtremesResponse{
+	Batches:      []string{},
+	Maxima:       []BlockMaximum{},
+	ReturnLevels: []ReturnLevel{},
+	Percentiles:  map[string]float64{},
+	Status:       http.StatusBadRequest,
+	Success:      false,
+}
+
+func sendExtremesJsonError(w http.ResponseWriter, statusCode int) {
+	response := extremesFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// extremesHandler serves /extremes?lat=&lon=&start=&end=&batch=&block=&periods=
+func extremesHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	lat, err := parseCoordinate(httpQuery.Get("lat"))
+	if err != nil {
+		sendExtremesJsonError(w, http.StatusBadRequest)
+		return
+	}
+	lon, err := parseCoordinate(httpQuery.Get("lon"))
+	if err != nil {
+		sendExtremesJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	start := httpQuery.Get("start")
+	end := httpQuery.Get("end")
+	if start == "" || end == "" {
+		sendExtremesJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	batches, err := parseBatches(httpQuery.Get("batch"))
+	if err != nil {
+		sendExtremesJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	block := httpQuery.Get("block")
+	if block == "" {
+		block = "year"
+	}
+
+	periods := defaultReturnPeriods
+	if periodsStr := httpQuery.Get("periods"); periodsStr != "" {
+		periods = nil
+		for _, s := range strings.Split(periodsStr, ",") {
+			period, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil || period <= 1 || math.IsInf(period, 0) {
+				sendExtremesJsonError(w, http.StatusBadRequest)
+				return
+			}
+			periods = append(periods, period)
+		}
+	}
+
+	params := ExtremesAPIParams{
+		Lat:           lat,
+		Lon:           lon,
+		StartDate:     start,
+		EndDate:       end,
+		Batches:       batches,
+		Block:         block,
+		ReturnPeriods: periods,
+	}
+
+	setLogField(r.Context(), "date", start+"-"+end)
+	data, err2 := ExtremesQuery(r.Context(), params)
+	if err2 != nil {
+		sendExtremesJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func ExtremesQuery(ctx context.Context, params ExtremesAPIParams) (ExtremesResponse, error) {
+	var blockLen, blocksPerYear int
+	switch params.Block {
+	case "year":
+		blockLen, blocksPerYear = 4, 1
+	case "month":
+		blockLen, blocksPerYear = 6, 12
+	default:
+		return extremesFailResponse, fmt.Errorf("%w: block must be year or month", ErrInvalidParams)
+	}
+	dates, err := generateDateRange(params.StartDate, params.EndDate)
+	if err != nil {
+		return extremesFailResponse, err
+	}
+	if len(dates) > maxExtremesDays {
+		return extremesFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxExtremesDays)
+	}
+
+	samples, missing, err := pointSeries(ctx, params.Lat, params.Lon, dates, params.Batches)
+	if err != nil {
+		return extremesFailResponse, err
+	}
+
+	speeds := make([]float64, len(samples))
+	var maxima []BlockMaximum
+	for i, sample := range samples {
+		speeds[i] = math.Hypot(sample.U, sample.V)
+		block := sample.Date[:blockLen]
+		if n := len(maxima); n == 0 || maxima[n-1].Block != block {
+			maxima = append(maxima, BlockMaximum{Block: block, Speed: speeds[i]})
+		} else if speeds[i] > maxima[n-1].Speed {
+			maxima[n-1].Speed = speeds[i]
+		}
+	}
+	if len(maxima) < minExtremeBlock {
+		return extremesFailResponse, fmt.Errorf("%w: %d %s maxima with data, need at least %d", ErrInvalidParams, len(maxima), params.Block, minExtremeBlock)
+	}
+
+	values := make([]float64, len(maxima))
+	for i, m := range maxima {
+		values[i] = m.Speed
+	}
+	mean, std := meanStd(values)
+	fit := GumbelFit{Scale: std * math.Sqrt(6) / math.Pi}
+	fit.Location = mean - eulerGamma*fit.Scale
+
+	levels := make([]ReturnLevel, 0, len(params.ReturnPeriods))
+	for _, period := range params.ReturnPeriods {
+		levels = append(levels, gumbelReturnLevel(fit, mean, std, len(values), period, blocksPerYear))
+	}
+
+	slices.Sort(speeds)
+	percentiles := make(map[string]float64, len(extremePercentiles))
+	for _, p := range extremePercentiles {
+		percentiles[strconv.FormatFloat(p, 'f', -1, 64)] = percentile(speeds, p)
+	}
+
+	response := ExtremesResponse{
+		Lat:          params.Lat,
+		Lon:          params.Lon,
+		Start:        params.StartDate,
+		End:          params.EndDate,
+		Batches:      params.Batches,
+		Block:        params.Block,
+		Maxima:       maxima,
+		Gumbel:       fit,
+		ReturnLevels: levels,
+		Percentiles:  percentiles,
+		Samples:      len(samples),
+		Missing:      missing,
+		Status:       http.StatusOK,
+		Success:      true,
+	}
+	return response, nil
+}
+
+// gumbelReturnLevel is the speed exceeded once every periodYears on average,
+// with the 95% interval from the standard error of the moments estimator
+// (Gumbel 1958): SE = s/√n · √(1 + 1.1396·K + 1.1·K²), K the frequency factor.
+func gumbelReturnLevel(fit GumbelFit, mean, std float64, n int, periodYears float64, blocksPerYear int) ReturnLevel {
+	periodBlocks := periodYears * float64(blocksPerYear)
+	y := -math.Log(-math.Log(1 - 1/periodBlocks))
+	speed := fit.Location + fit.Scale*y
+	k := -math.Sqrt(6) / math.Pi * (eulerGamma + math.Log(-math.Log(1-1/periodBlocks)))
+	se := std / math.Sqrt(float64(n)) * math.Sqrt(1+1.1396*k+1.1*k*k)
+	return ReturnLevel{
+		PeriodYears: periodYears,
+		Speed:       speed,
+		Lower:       math.Max(0, speed-1.96*se),
+		Upper:       speed + 1.96*se,
+	}
+}
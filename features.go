@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Feature flags gate experimental endpoints per deployment. An endpoint
+// registered through experimental answers 404 unless its feature is enabled,
+// so new surfaces can ship dark. Endpoints registered directly are stable.
+
+type feature struct {
+	Description string
+	Default     bool
+}
+
+// features lists every flag, enable them with -features=name,... and
+// disable a default one with -features=-name. A new feature is off unless it
+// says otherwise; composite and extremes were public before they were gated
+// and stay on, so upgrading keeps them.
+var features = map[string]feature{
+	"composite":  {Description: "/composite event composite maps", Default: true},
+	"extremes":   {Description: "/extremes Gumbel return levels", Default: true},
+	"tiles":      {Description: "/vector-tile/{z}/{x}/{y} Mapbox vector tiles"},
+	"trajectory": {Description: "/trajectory and /trajectory/origin particle trajectories"},
+}
+
+var enabledFeatures = defaultFeatures()
+
+func defaultFeatures() map[string]bool {
+	enabled := make(map[string]bool, len(features))
+	for name, f := range features {
+		enabled[name] = f.Default
+	}
+	return enabled
+}
+
+// parseFeatures applies a comma separated list of feature names, each
+// optionally prefixed with - to disable it, on top of the defaults.
+func parseFeatures(s string) (map[string]bool, error) {
+	enabled := defaultFeatures()
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		on := true
+		if trimmed, ok := strings.CutPrefix(name, "-"); ok {
+			name, on = trimmed, false
+		}
+		if _, ok := features[name]; !ok {
+			known := make([]string, 0, len(features))
+			for name := range features {
+				known = append(known, name)
+			}
+			slices.Sort(known)
+			return nil, fmt.Errorf("unknown feature %q, known: %s", name, strings.Join(known, ", "))
+		}
+		enabled[name] = on
+	}
+	return enabled, nil
+}
+
+func featureEnabled(name string) bool {
+	return enabledFeatures[name]
+}
+
+// experimental serves next only while the feature is enabled. It is checked
+// per request, so the route exists either way and disabled features look
+// exactly like unknown paths.
+func experimental(name string, next http.HandlerFunc) http.HandlerFunc {
+	if _, ok := features[name]; !ok {
+		panic("experimental: unregistered feature " + name)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(name) {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Atom and RSS feeds for monitoring teams that would rather subscribe in a
+// feed reader than poll the API:
+//
+//	/feeds/cycles  batches ingested into the manifest, newest first, updated
+//	               whenever another parameter or step of them is stored
+//	/feeds/storms  IBTrACS storms with a track point within activeStormAge
+//	               of the newest one, the storms running at the edge of the
+//	               data, which is published days behind real time
+//
+// format=atom (the default) or rss. Item ids are stable, so readers only
+// show an item again when it was updated.
+
+const (
+	feedCycles     = "cycles"
+	feedStorms     = "storms"
+	feedFormatAtom = "atom"
+	feedFormatRSS  = "rss"
+	maxFeedItems   = 50
+	activeStormAge = 72 * time.Hour
+)
+
+// feedItem is an entry of either feed format.
+type feedItem struct {
+	ID        string // urn, never changes for the same cycle or storm
+	Title     string
+	Summary   string
+	Link      string
+	Published time.Time
+	Updated   time.Time
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Link      atomLink `xml:"link"`
+	Summary   string   `xml:"summary"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func sendFeedJsonError(w http.ResponseWriter, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]any{"status": statusCode, "success": false})
+}
+
+// feedHandler serves GET /feeds/{feed}?format=atom|rss, feed being cycles or
+// storms.
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = feedFormatAtom
+	}
+	if format != feedFormatAtom && format != feedFormatRSS {
+		sendFeedJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+	var title string
+	var items []feedItem
+	var err error
+	switch r.PathValue("feed") {
+	case feedCycles:
+		title = "Griber ingested cycles"
+		items, err = cycleFeedItems(base)
+	case feedStorms:
+		title = "Griber active storms"
+		items, err = stormFeedItems(base)
+	default:
+		sendFeedJsonError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendFeedJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+
+	self := base + r.URL.RequestURI()
+	if format == feedFormatRSS {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		err = writeFeedXML(w, rssFeedOf(title, self, items))
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		err = writeFeedXML(w, atomFeedOf(title, self, "urn:griber:feed:"+r.PathValue("feed"), items))
+	}
+	if err != nil {
+		log.Printf("Met Error when writing feed to ResponseWriter: %v", err)
+	}
+}
+
+// cycleFeedItems lists the batches in the manifest, most recently updated
+// first.
+func cycleFeedItems(base string) ([]feedItem, error) {
+	entries, err := manifest.snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	type cycle struct {
+		date, batch      string
+		params, steps    map[string]bool
+		bytes            int64
+		first, lastStore time.Time
+	}
+	cycles := make(map[string]*cycle)
+	for key, entry := range entries {
+		parts := strings.Split(key, "|")
+		if len(parts) != 4 {
+			continue
+		}
+		c, ok := cycles[parts[0]+"-"+parts[1]]
+		if !ok {
+			c = &cycle{date: parts[0], batch: parts[1], params: map[string]bool{}, steps: map[string]bool{}, first: entry.UpdatedAt}
+			cycles[parts[0]+"-"+parts[1]] = c
+		}
+		c.params[parts[2]] = true
+		c.steps[parts[3]] = true
+		c.bytes += entry.Bytes
+		if entry.UpdatedAt.Before(c.first) {
+			c.first = entry.UpdatedAt
+		}
+		if entry.UpdatedAt.After(c.lastStore) {
+			c.lastStore = entry.UpdatedAt
+		}
+	}
+
+	items := make([]feedItem, 0, len(cycles))
+	for name, c := range cycles {
+		params, steps := slices.Sorted(maps.Keys(c.params)), slices.Sorted(maps.Keys(c.steps))
+		items = append(items, feedItem{
+			ID:    "urn:griber:cycle:" + name,
+			Title: fmt.Sprintf("Cycle %s %s ingested", c.date, c.batch),
+			Summary: fmt.Sprintf("%d parameters (%s) at %d steps (%s), %.1f MB stored.",
+				len(params), strings.Join(params, ", "), len(steps), strings.Join(steps, ", "), float64(c.bytes)/1e6),
+			Link:      base + "/manifest?" + url.Values{"date": {c.date}, "batch": {c.batch}}.Encode(),
+			Published: c.first,
+			Updated:   c.lastStore,
+		})
+	}
+	return newestFeedItems(items), nil
+}
+
+// stormFeedItems lists the storms with a track point within activeStormAge
+// of the newest track point.
+func stormFeedItems(base string) ([]feedItem, error) {
+	records, err := typhonRecords()
+	if err != nil {
+		return nil, err
+	}
+	newest := ""
+	for _, record := range records {
+		if len(record) >= numColumns && record[colIsoTime] > newest {
+			newest = record[colIsoTime]
+		}
+	}
+	edge, err := time.Parse("20060102150405", newest)
+	if err != nil {
+		return []feedItem{}, nil
+	}
+	since := edge.Add(-activeStormAge).Format("20060102150405")
+	storms := summarizeStorms(records,
+		func(record []string) bool { return true },
+		func(record []string) bool { return record[colIsoTime] >= since })
+
+	items := make([]feedItem, 0, len(storms))
+	for _, storm := range storms {
+		start, _ := time.Parse("20060102150405", storm.Start)
+		end, _ := time.Parse("20060102150405", storm.End)
+		summary := fmt.Sprintf("%s, basins %s, tracked from %s to %s UTC over %d points.",
+			storm.StormID, strings.Join(storm.Basins, " "), start.Format("2006-01-02 15:04"), end.Format("2006-01-02 15:04"), storm.Points)
+		if storm.MaxWind != nil {
+			summary += fmt.Sprintf(" Max wind %.0f %s.", *storm.MaxWind, windUnit)
+		}
+		if storm.MinPres != nil {
+			summary += fmt.Sprintf(" Min pressure %.0f hPa.", *storm.MinPres)
+		}
+		items = append(items, feedItem{
+			ID:        "urn:griber:storm:" + storm.SID,
+			Title:     fmt.Sprintf("%s (%s)", storm.Name, storm.SID),
+			Summary:   summary,
+			Link:      base + "/typhoon/tracks/" + url.PathEscape(storm.SID),
+			Published: start,
+			Updated:   end,
+		})
+	}
+	return newestFeedItems(items), nil
+}
+
+// newestFeedItems sorts items most recently updated first and keeps
+// maxFeedItems of them.
+func newestFeedItems(items []feedItem) []feedItem {
+	slices.SortFunc(items, func(a, b feedItem) int {
+		return cmp.Or(b.Updated.Compare(a.Updated), cmp.Compare(a.ID, b.ID))
+	})
+	if len(items) > maxFeedItems {
+		items = items[:maxFeedItems]
+	}
+	return items
+}
+
+func atomFeedOf(title, self, id string, items []feedItem) atomFeed {
+	feed := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		ID:      id,
+		Title:   title,
+		Author:  "Griber",
+		Links:   []atomLink{{Href: self, Rel: "self"}},
+		Entries: []atomEntry{},
+	}
+	var updated time.Time
+	for _, item := range items {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        item.ID,
+			Title:     item.Title,
+			Published: item.Published.Format(time.RFC3339),
+			Updated:   item.Updated.Format(time.RFC3339),
+			Link:      atomLink{Href: item.Link},
+			Summary:   item.Summary,
+		})
+		if item.Updated.After(updated) {
+			updated = item.Updated
+		}
+	}
+	if updated.IsZero() {
+		updated = clock.Now() // required even when empty
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	return feed
+}
+
+func rssFeedOf(title, self string, items []feedItem) rssFeed {
+	channel := rssChannel{Title: title, Link: self, Description: title + ", newest first"}
+	var updated time.Time
+	for _, item := range items {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Description: item.Summary,
+			GUID:        rssGUID{Value: item.ID},
+			PubDate:     item.Updated.Format(time.RFC1123Z),
+		})
+		if item.Updated.After(updated) {
+			updated = item.Updated
+		}
+	}
+	if !updated.IsZero() {
+		channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	return rssFeed{Version: "2.0", Channel: channel}
+}
+
+func writeFeedXML(w io.Writer, feed any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
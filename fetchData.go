@@ -2,15 +2,18 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
-
-	"cloud.google.com/go/storage"
+	"sync"
 )
 
 type GribChunkInfo struct {
@@ -19,39 +22,179 @@ type GribChunkInfo struct {
 	Length    int64
 }
 
-func getGribData(gribChunk []GribChunkInfo, bucketName string, objectName string) (map[string]string, error) {
-	// GCS auth context
-	ctx := context.Background()
+// decodedChunk is one decoded parameter of a GRIB file.
+type decodedChunk struct {
+	Values []float64
+	Grid   GridSpec
+}
 
-	client, err := storage.NewClient(ctx)
+func getGribData(ctx context.Context, gribChunk []GribChunkInfo, bucketName string, objectName string) (map[string]decodedChunk, error) {
+	object, err := source.OpenObject(ctx, bucketName, objectName)
 	if err != nil {
-		return nil, fmt.Errorf("fail to init GCS (Check gcloud auth): %w", err)
+		return nil, err
 	}
-	defer func(client *storage.Client) {
-		err := client.Close()
+	defer func(object objectReader) {
+		err := object.Close()
 		if err != nil {
 			log.Printf("Fail to close GCS: %v", err)
 		}
-	}(client)
+	}(object)
+
+	setLogField(ctx, "object", objectName)
 
-	log.Printf("GCS Connected processing obj: %s", objectName)
+	// 相邻的数据块合并成少量的大范围请求，并行下载到内存
+	spans, err := fetchSpans(ctx, object, coalesceChunks(gribChunk))
+	if err != nil {
+		return nil, err
+	}
 
-	// 遍历并处理您需要的每一个数据块
-	resultJsonMap := make(map[string]string)
+	return decodeChunks(ctx, spans, gribChunk)
+}
+
+// chunkWorkers bounds how many chunks of one object are decoded at once.
+var chunkWorkers = 4
+
+// decodeChunks decodes the chunks with a pool of chunkWorkers. The first
+// failure cancels the chunks not started yet, the error returned joins every
+// failure.
+func decodeChunks(ctx context.Context, spans []*byteSpan, gribChunk []GribChunkInfo) (map[string]decodedChunk, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]decodedChunk, len(gribChunk))
+		errs    []error
+		jobs    = make(chan GribChunkInfo)
+	)
+	for range min(max(chunkWorkers, 1), len(gribChunk)) {
+		wg.Go(func() {
+			for chunk := range jobs {
+				values, spec, err := fetchAndProcessGribChunk(ctx, spanFor(spans, chunk), chunk)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("fail to fetch and process chunk %s: %w", chunk.ParamName, err))
+					cancel()
+				} else {
+					results[chunk.ParamName] = decodedChunk{Values: values, Grid: spec}
+				}
+				mu.Unlock()
+			}
+		})
+	}
 	for _, chunk := range gribChunk {
-		result, err := fetchAndProcessGribChunk(ctx, client, bucketName, objectName, chunk)
-		if err != nil {
-			return nil, fmt.Errorf("fail to fetch and process chunk %s: %w", chunk.ParamName, err)
+		if ctx.Err() != nil {
+			break
 		}
-		resultJsonMap[chunk.ParamName] = result
+		jobs <- chunk
 	}
-	return resultJsonMap, nil
+	close(jobs)
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+const (
+	maxCoalesceGap   = 64 << 10 // bytes between two chunks read and thrown away to merge them
+	maxCoalescedSpan = 64 << 20 // largest merged range request
+	rangeReaders     = 4        // parallel range requests per object
+)
+
+// byteSpan is one range request covering one or more chunks, data holds the
+// bytes once fetched.
+type byteSpan struct {
+	Offset int64
+	Length int64
+	data   []byte
 }
 
-func queryIndex(url string) (string, error) {
-	resp, err := http.Get(url)
+// coalesceChunks merges chunks that are adjacent in the object, or separated
+// by at most maxCoalesceGap, into spans of at most maxCoalescedSpan.
+func coalesceChunks(chunks []GribChunkInfo) []*byteSpan {
+	sorted := slices.Clone(chunks)
+	slices.SortFunc(sorted, func(a, b GribChunkInfo) int { return cmp.Compare(a.Offset, b.Offset) })
+	var spans []*byteSpan
+	for _, chunk := range sorted {
+		end := chunk.Offset + chunk.Length
+		if n := len(spans); n > 0 {
+			last := spans[n-1]
+			lastEnd := last.Offset + last.Length
+			if chunk.Offset-lastEnd <= maxCoalesceGap && max(end, lastEnd)-last.Offset <= maxCoalescedSpan {
+				last.Length = max(end, lastEnd) - last.Offset
+				continue
+			}
+		}
+		spans = append(spans, &byteSpan{Offset: chunk.Offset, Length: chunk.Length})
+	}
+	return spans
+}
+
+// fetchSpans reads the spans into memory, rangeReaders at a time.
+func fetchSpans(ctx context.Context, object objectReader, spans []*byteSpan) ([]*byteSpan, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		slots    = make(chan struct{}, rangeReaders)
+	)
+	addLogCount(ctx, "ranges", int64(len(spans)))
+	for _, span := range spans {
+		slots <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			buffer := bytes.NewBuffer(make([]byte, 0, span.Length))
+			if err := copyRange(ctx, buffer, object, span.Offset, span.Length); err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("fail to read bytes %d-%d: %w", span.Offset, span.Offset+span.Length-1, err)
+					cancel()
+				})
+				return
+			}
+			span.data = buffer.Bytes()
+		})
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return spans, nil
+}
+
+// spanFor returns a reader over the fetched span containing chunk.
+func spanFor(spans []*byteSpan, chunk GribChunkInfo) objectReader {
+	for _, span := range spans {
+		if chunk.Offset >= span.Offset && chunk.Offset+chunk.Length <= span.Offset+span.Length {
+			return span
+		}
+	}
+	return nil
+}
+
+func (s *byteSpan) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	start := offset - s.Offset
+	if start < 0 || start+length > int64(len(s.data)) {
+		return nil, fmt.Errorf("range %d+%d outside of fetched span %d+%d", offset, length, s.Offset, len(s.data))
+	}
+	return io.NopCloser(bytes.NewReader(s.data[start : start+length])), nil
+}
+
+func (s *byteSpan) Close() error { return nil }
+
+func queryIndex(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("fail to build index request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("fail to get index url: %w", err)
+		return "", fmt.Errorf("fail to get index url: %w: %w", ErrUpstreamUnavailable, err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -60,6 +203,14 @@ func queryIndex(url string) (string, error) {
 		}
 	}(resp.Body)
 
+	// the bucket answers 404 for cycles that are not (yet) published
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("index %s: %w", url, ErrDataNotPublished)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("index %s: %w: %s", url, ErrUpstreamUnavailable, resp.Status)
+	}
+
 	scanner := bufio.NewScanner(resp.Body)
 	buffer := ""
 	for scanner.Scan() {
@@ -71,7 +222,9 @@ func queryIndex(url string) (string, error) {
 
 type IndexData map[string]interface{}
 
-func parseIndexResponse(index string) ([]GribChunkInfo, error) {
+// parseIndexResponse returns the chunks of the wanted parameters, named as in
+// paramRegistry.
+func parseIndexResponse(index string, params []string) ([]GribChunkInfo, error) {
 	scanner := bufio.NewScanner(strings.NewReader(index))
 	var data []GribChunkInfo
 	for scanner.Scan() {
@@ -82,9 +235,16 @@ func parseIndexResponse(index string) ([]GribChunkInfo, error) {
 			log.Printf("%s", line)
 			return nil, fmt.Errorf("fail to unmarshal index line: %w", err)
 		}
-		if (lineData["param"].(string) == "10u" || lineData["param"].(string) == "10v") && (lineData["levtype"].(string) == "sfc") {
+		gribParam, _ := lineData["param"].(string)
+		levtype, _ := lineData["levtype"].(string)
+		levelist, _ := lineData["levelist"].(string)
+		for _, name := range params {
+			def := paramRegistry[name]
+			if def.GribParam != gribParam || def.Levtype != levtype || def.Levelist != levelist {
+				continue
+			}
 			gribChunk := GribChunkInfo{
-				ParamName: lineData["param"].(string),
+				ParamName: name,
 				Offset:    int64(lineData["_offset"].(float64)),
 				Length:    int64(lineData["_length"].(float64)),
 			}
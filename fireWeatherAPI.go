@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fire weather at a point, one day at a time: the noon conditions the
+// Canadian Forest Fire Weather Index System is computed from (temperature,
+// relative humidity, 10 m wind and the precipitation of the last 24 hours)
+// and the temperatures and humidity aloft of the Haines index. With
+// index=fwi the FWI codes are run over the days from the standard startup
+// values, index=haines adds the Haines index.
+//
+// Noon is local solar noon, taken from the batch nearest to it. Precipitation
+// is the sum of the first 6 hours of the four runs before noon, tp being
+// accumulated since the start of each run.
+
+const (
+	maxFireWeatherDays = 92
+
+	indexFWI    = "fwi"
+	indexHaines = "haines"
+
+	// FWI startup values, Van Wagner (1987)
+	startFFMC = 85.0
+	startDMC  = 6.0
+	startDC   = 15.0
+)
+
+var (
+	fireWeatherParams = []string{"2t", "2d", "10u", "10v"}
+	hainesParams      = map[string][]string{
+		"mid":  {"t850", "t700", "r850"}, // stability T850-T700, moisture T850-Td850
+		"high": {"t700", "t500", "r700"}, // stability T700-T500, moisture T700-Td700
+	}
+)
+
+type FireWeatherAPIParams struct {
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+	StartDate string   `json:"start"` // yyyymmdd
+	EndDate   string   `json:"end"`   // yyyymmdd
+	Indices   []string `json:"index"` // fwi, haines
+	Haines    string   `json:"haines"`
+}
+
+type FireWeatherDay struct {
+	Date        string    `json:"date"`
+	Time        time.Time `json:"time"`         // the batch taken as noon
+	Temperature NullFloat `json:"temperature"`  // °C
+	Humidity    NullFloat `json:"humidity"`     // %
+	Wind        NullFloat `json:"wind"`         // km/h, as the FWI System takes it
+	Rain        NullFloat `json:"rain"`         // mm over the 24 hours before noon
+	FFMC        NullFloat `json:"ffmc"`         // fine fuel moisture code
+	DMC         NullFloat `json:"dmc"`          // duff moisture code
+	DC          NullFloat `json:"dc"`           // drought code
+	ISI         NullFloat `json:"isi"`          // initial spread index
+	BUI         NullFloat `json:"bui"`          // buildup index
+	FWI         NullFloat `json:"fwi"`          // fire weather index
+	Haines      *int      `json:"haines"`       // 2-6, null when not computed
+	HainesInput []float64 `json:"haines_input"` // stability and moisture terms, °C
+}
+
+type FireWeatherResponse struct {
+	Lat     float64          `json:"lat"`
+	Lon     float64          `json:"lon"`
+	Days    []FireWeatherDay `json:"days"`
+	Missing int              `json:"missing"` // days without noon conditions
+	Status  int              `json:"status"`
+	Success bool             `json:"success"`
+}
+
+var fireWeatherFailResponse = FireWeatherResponse{
+	Days:    []FireWeatherDay{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendFireWeatherJsonError(w http.ResponseWriter, statusCode int) {
+	response := fireWeatherFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// fireWeatherHandler serves /fire-weather?lat=&lon=&start=&end=&index=fwi,haines&haines=mid|high
+func fireWeatherHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := FireWeatherAPIParams{
+		StartDate: httpQuery.Get("start"),
+		EndDate:   httpQuery.Get("end"),
+		Haines:    "mid",
+	}
+	if params.EndDate == "" {
+		params.EndDate = params.StartDate
+	}
+	for name, dst := range map[string]*float64{"lat": &params.Lat, "lon": &params.Lon} {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendFireWeatherJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	for index := range strings.SplitSeq(httpQuery.Get("index"), ",") {
+		if index = strings.TrimSpace(index); index != "" && !slices.Contains(params.Indices, index) {
+			params.Indices = append(params.Indices, index)
+		}
+	}
+	if s := httpQuery.Get("haines"); s != "" {
+		params.Haines = s
+	}
+
+	setLogField(r.Context(), "date", params.StartDate+"-"+params.EndDate)
+	data, err2 := FireWeatherQuery(r.Context(), params)
+	if err2 != nil {
+		sendFireWeatherJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func FireWeatherQuery(ctx context.Context, params FireWeatherAPIParams) (FireWeatherResponse, error) {
+	for _, index := range params.Indices {
+		if index != indexFWI && index != indexHaines {
+			return fireWeatherFailResponse, fmt.Errorf("%w: index must be %s or %s", ErrInvalidParams, indexFWI, indexHaines)
+		}
+	}
+	levels, ok := hainesParams[params.Haines]
+	if !ok {
+		return fireWeatherFailResponse, fmt.Errorf("%w: haines must be mid or high", ErrInvalidParams)
+	}
+	dates, err := generateDateRange(params.StartDate, params.EndDate)
+	if err != nil {
+		return fireWeatherFailResponse, err
+	}
+	if len(dates) > maxFireWeatherDays {
+		return fireWeatherFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxFireWeatherDays)
+	}
+	computeFWI := slices.Contains(params.Indices, indexFWI)
+	computeHaines := slices.Contains(params.Indices, indexHaines)
+
+	// sample reads names at the point from a batch's step
+	sample := func(t time.Time, step int, names []string) ([]float64, error) {
+		date, batch := t.Format("20060102"), fmt.Sprintf("%02dz", t.Hour())
+		filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+		grid, fields, err := loadParamFields(ctx, filePath, date, batch, step, names)
+		if err != nil {
+			if !errors.Is(err, ErrMemoryPressure) && ctx.Err() == nil {
+				appendLogField(ctx, "missing_dates", date+"-"+batch+"-"+stepName(step))
+			}
+			return nil, err
+		}
+		index, err := grid.Index(params.Lat, params.Lon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get index for coord: %w", err)
+		}
+		values := make([]float64, len(fields))
+		for i, field := range fields {
+			values[i] = field[index]
+			if math.IsNaN(values[i]) {
+				return nil, fmt.Errorf("%w: %s missing at the point", ErrDataNotPublished, names[i])
+			}
+		}
+		return values, nil
+	}
+	fatal := func(err error) bool {
+		return errors.Is(err, ErrMemoryPressure) || errors.Is(err, ErrOutOfGrid) || ctx.Err() != nil
+	}
+
+	ffmc, dmc, dc := startFFMC, startDMC, startDC
+	days := make([]FireWeatherDay, 0, len(dates))
+	missing := 0
+	for _, date := range dates {
+		day, _ := time.Parse("20060102", date)
+		// local solar noon, to the nearest batch
+		noon := day.Add(12*time.Hour - time.Duration(params.Lon/15*float64(time.Hour))).Round(batchInterval)
+		result := FireWeatherDay{Date: date, Time: noon}
+		for _, v := range []*NullFloat{&result.Temperature, &result.Humidity, &result.Wind, &result.Rain,
+			&result.FFMC, &result.DMC, &result.DC, &result.ISI, &result.BUI, &result.FWI} {
+			*v = NullFloat(math.NaN())
+		}
+
+		surface, surfaceErr := sample(noon, 0, fireWeatherParams)
+		if fatal(surfaceErr) {
+			return fireWeatherFailResponse, errors.Join(surfaceErr, ctx.Err())
+		}
+		if surfaceErr == nil {
+			t, td := surface[0]-273.15, surface[1]-273.15
+			result.Temperature = NullFloat(t)
+			result.Humidity = NullFloat(relativeHumidity(t, td))
+			result.Wind = NullFloat(math.Hypot(surface[2], surface[3]) * 3.6)
+		}
+		rain := 0.0
+		for k := range 4 {
+			run := noon.Add(-time.Duration(4-k) * batchInterval)
+			accumulated, err := sample(run, stepInterval, []string{"tp"})
+			if fatal(err) {
+				return fireWeatherFailResponse, errors.Join(err, ctx.Err())
+			}
+			if err != nil {
+				rain = math.NaN()
+				break
+			}
+			rain += math.Max(accumulated[0], 0) * 1000
+		}
+		result.Rain = NullFloat(rain)
+
+		complete := surfaceErr == nil && !math.IsNaN(rain)
+		if !complete {
+			missing++
+		}
+		if computeFWI && complete {
+			// the codes carry over days without data unchanged
+			month := int(noon.Month())
+			if params.Lat < 0 {
+				month = (month+5)%12 + 1 // day length factors of the other hemisphere
+			}
+			t, rh, wind := float64(result.Temperature), float64(result.Humidity), float64(result.Wind)
+			ffmc = fineFuelMoistureCode(ffmc, t, rh, wind, rain)
+			dmc = duffMoistureCode(dmc, t, rh, rain, month)
+			dc = droughtCode(dc, t, rain, month)
+			isi := initialSpreadIndex(ffmc, wind)
+			bui := buildupIndex(dmc, dc)
+			result.FFMC, result.DMC, result.DC = NullFloat(ffmc), NullFloat(dmc), NullFloat(dc)
+			result.ISI, result.BUI, result.FWI = NullFloat(isi), NullFloat(bui), NullFloat(fireWeatherIndex(isi, bui))
+		}
+		if computeHaines {
+			aloft, err := sample(noon, 0, levels)
+			if fatal(err) {
+				return fireWeatherFailResponse, errors.Join(err, ctx.Err())
+			}
+			if err == nil {
+				stability := aloft[0] - aloft[1]
+				t := aloft[0] - 273.15
+				moisture := t - dewPoint(t, aloft[2])
+				haines := hainesIndex(params.Haines, stability, moisture)
+				result.Haines = &haines
+				result.HainesInput = []float64{stability, moisture}
+			}
+		}
+		days = append(days, result)
+	}
+	if missing == len(dates) {
+		return fireWeatherFailResponse, fmt.Errorf("%w: no noon conditions between %s and %s", ErrDataNotPublished, params.StartDate, params.EndDate)
+	}
+
+	return FireWeatherResponse{
+		Lat:     params.Lat,
+		Lon:     params.Lon,
+		Days:    days,
+		Missing: missing,
+		Status:  http.StatusOK,
+		Success: true,
+	}, nil
+}
+
+// relativeHumidity in percent from temperature and dew point in °C (Magnus).
+func relativeHumidity(t, td float64) float64 {
+	rh := 100 * math.Exp(17.625*td/(243.04+td)-17.625*t/(243.04+t))
+	return math.Max(0, math.Min(rh, 100))
+}
+
+// dewPoint in °C from temperature in °C and relative humidity in percent,
+// the inverse of relativeHumidity.
+func dewPoint(t, rh float64) float64 {
+	gamma := math.Log(math.Max(rh, 1)/100) + 17.625*t/(243.04+t)
+	return 243.04 * gamma / (17.625 - gamma)
+}
+
+// hainesIndex adds the stability and moisture terms, each scored 1-3 with
+// the thresholds of Haines (1988) for the variant.
+func hainesIndex(variant string, stability, moisture float64) int {
+	score := func(x, low, high float64) int {
+		switch {
+		case x < low:
+			return 1
+		case x < high:
+			return 2
+		default:
+			return 3
+		}
+	}
+	if variant == "high" {
+		return score(stability, 18, 22) + score(moisture, 15, 21)
+	}
+	return score(stability, 6, 11) + score(moisture, 6, 13)
+}
+
+// The FWI System equations below follow Van Wagner (1987), Development and
+// structure of the Canadian Forest Fire Weather Index System. Temperature is
+// in °C, humidity in percent, wind in km/h and rain in mm.
+
+func fineFuelMoistureCode(prev, t, rh, wind, rain float64) float64 {
+	mo := 147.2 * (101 - prev) / (59.5 + prev)
+	if rain > 0.5 {
+		rf := rain - 0.5
+		wetting := 42.5 * rf * math.Exp(-100/(251-mo)) * (1 - math.Exp(-6.93/rf))
+		if mo > 150 {
+			wetting += 0.0015 * (mo - 150) * (mo - 150) * math.Sqrt(rf)
+		}
+		mo = math.Min(mo+wetting, 250)
+	}
+	ed := 0.942*math.Pow(rh, 0.679) + 11*math.Exp((rh-100)/10) + 0.18*(21.1-t)*(1-math.Exp(-0.115*rh))
+	m := mo
+	if mo > ed {
+		ko := 0.424*(1-math.Pow(rh/100, 1.7)) + 0.0694*math.Sqrt(wind)*(1-math.Pow(rh/100, 8))
+		kd := ko * 0.581 * math.Exp(0.0365*t)
+		m = ed + (mo-ed)*math.Pow(10, -kd)
+	} else {
+		ew := 0.618*math.Pow(rh, 0.753) + 10*math.Exp((rh-100)/10) + 0.18*(21.1-t)*(1-math.Exp(-0.115*rh))
+		if mo < ew {
+			k1 := 0.424*(1-math.Pow((100-rh)/100, 1.7)) + 0.0694*math.Sqrt(wind)*(1-math.Pow((100-rh)/100, 8))
+			kw := k1 * 0.581 * math.Exp(0.0365*t)
+			m = ew - (ew-mo)*math.Pow(10, -kw)
+		}
+	}
+	return math.Max(0, math.Min(59.5*(250-m)/(147.2+m), 101))
+}
+
+// effective day length and day length adjustment by month, northern hemisphere
+var (
+	dmcDayLength = []float64{6.5, 7.5, 9.0, 12.8, 13.9, 13.9, 12.4, 10.9, 9.4, 8.0, 7.0, 6.0}
+	dcDayLength  = []float64{-1.6, -1.6, -1.6, 0.9, 3.8, 5.8, 6.4, 5.0, 2.4, 0.4, -1.6, -1.6}
+)
+
+func duffMoistureCode(prev, t, rh, rain float64, month int) float64 {
+	t = math.Max(t, -1.1)
+	rk := 1.894 * (t + 1.1) * (100 - rh) * dmcDayLength[month-1] * 1e-4
+	pr := prev
+	if rain > 1.5 {
+		re := 0.92*rain - 1.27
+		mo := 20 + math.Exp(5.6348-prev/43.43)
+		var b float64
+		switch {
+		case prev <= 33:
+			b = 100 / (0.5 + 0.3*prev)
+		case prev <= 65:
+			b = 14 - 1.3*math.Log(prev)
+		default:
+			b = 6.2*math.Log(prev) - 17.2
+		}
+		mr := mo + 1000*re/(48.77+b*re)
+		pr = math.Max(244.72-43.43*math.Log(mr-20), 0)
+	}
+	return math.Max(pr+rk, 0)
+}
+
+func droughtCode(prev, t, rain float64, month int) float64 {
+	t = math.Max(t, -2.8)
+	pe := math.Max((0.36*(t+2.8)+dcDayLength[month-1])/2, 0)
+	dr := prev
+	if rain > 2.8 {
+		rd := 0.83*rain - 1.27
+		qo := 800 * math.Exp(-prev/400)
+		qr := qo + 3.937*rd
+		dr = math.Max(400*math.Log(800/qr), 0)
+	}
+	return dr + pe
+}
+
+func initialSpreadIndex(ffmc, wind float64) float64 {
+	m := 147.2 * (101 - ffmc) / (59.5 + ffmc)
+	ff := 91.9 * math.Exp(-0.1386*m) * (1 + math.Pow(m, 5.31)/4.93e7)
+	return 0.208 * math.Exp(0.05039*wind) * ff
+}
+
+func buildupIndex(dmc, dc float64) float64 {
+	if dmc == 0 && dc == 0 {
+		return 0
+	}
+	if dmc <= 0.4*dc {
+		return 0.8 * dmc * dc / (dmc + 0.4*dc)
+	}
+	return math.Max(dmc-(1-0.8*dc/(dmc+0.4*dc))*(0.92+math.Pow(0.0114*dmc, 1.7)), 0)
+}
+
+func fireWeatherIndex(isi, bui float64) float64 {
+	var fd float64
+	if bui <= 80 {
+		fd = 0.626*math.Pow(bui, 0.809) + 2
+	} else {
+		fd = 1000 / (25 + 108.64*math.Exp(-0.023*bui))
+	}
+	b := 0.1 * isi * fd
+	if b <= 1 {
+		return b
+	}
+	return math.Exp(2.72 * math.Pow(0.434*math.Log(b), 0.647))
+}
@@ -8,69 +8,131 @@ import (
 	"os"
 	"os/exec"
 	"strings"
-
-	"cloud.google.com/go/storage"
+	"time"
 )
 
-func fetchAndProcessGribChunk(ctx context.Context, client *storage.Client, bucketName, objectName string, chunk GribChunkInfo) (string, error) {
-	log.Printf("Fetching: %s (Offset: %d, Length: %d)", chunk.ParamName, chunk.Offset, chunk.Length)
-
-	// 1. 获取 GCS 对象句柄
-	obj := client.Bucket(bucketName).Object(objectName)
+// fetchAndProcessGribChunk decodes one GRIB message of a fetched span,
+// natively unless -grib-decoder says otherwise or the message uses something
+// the native decoder does not support.
+func fetchAndProcessGribChunk(ctx context.Context, obj objectReader, chunk GribChunkInfo) ([]float64, GridSpec, error) {
+	appendLogField(ctx, "param", chunk.ParamName)
 
+	// 数据已由 fetchSpans 下载到内存，这里只取出本数据块
 	reader, err := obj.NewRangeReader(ctx, chunk.Offset, chunk.Length)
 	if err != nil {
-		return "", fmt.Errorf("fail to create RangeReader for %s: %w", chunk.ParamName, err)
+		return nil, GridSpec{}, fmt.Errorf("fail to read %s: %w", chunk.ParamName, err)
+	}
+	message, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, GridSpec{}, fmt.Errorf("fail to read %s: %w", chunk.ParamName, err)
 	}
-	defer reader.Close()
 
-	tempFile, err := os.CreateTemp("", fmt.Sprintf("gribchunk-%s-*.grib2", chunk.ParamName))
+	decodeStart := time.Now()
+	values, spec, err := decodeMessage(ctx, chunk.ParamName, message)
 	if err != nil {
-		return "", fmt.Errorf("fail to create tmp file for %s: %w", chunk.ParamName, err)
+		return nil, GridSpec{}, fmt.Errorf("fail to decode %s: %w", chunk.ParamName, err)
 	}
+	addLogCount(ctx, "decode_ms", time.Since(decodeStart).Milliseconds())
 
-	defer func(name string) {
-		err := os.Remove(name)
+	// 抽样对比 grib_get 的结果
+	if verifySamples > 0 {
+		gribPath, err := writeTempGrib(chunk.ParamName, message)
 		if err != nil {
-			log.Printf("Fail to remove temp file %s: %v", tempFile.Name(), err)
+			return nil, GridSpec{}, err
+		}
+		defer removeTempGrib(gribPath)
+		if err := verifyDecodedChunk(ctx, gribPath, chunk.ParamName, values, spec); err != nil {
+			return nil, GridSpec{}, err
 		}
-	}(tempFile.Name())
-	//defer tempFile.Close()
-
-	// 4. 将 GCS 范围读取器的数据流复制到临时文件中
-	if _, err := io.Copy(tempFile, reader); err != nil {
-		return "", fmt.Errorf("fail to copy gcs data for %s: %w", chunk.ParamName, err)
 	}
+	return values, spec, nil
+}
 
-	// 确保在调用 exec 之前关闭文件句柄
-	err = tempFile.Close()
+// gribDumpChunk decodes a GRIB message with eccodes' grib_dump, the
+// grib_dump backend.
+func gribDumpChunk(ctx context.Context, param string, message []byte) ([]float64, GridSpec, error) {
+	gribPath, err := writeTempGrib(param, message)
 	if err != nil {
-		return "", fmt.Errorf("fail to close temp file: %w", err)
+		return nil, GridSpec{}, err
 	}
-
-	log.Printf("Parameter %s has downloaded to %s", chunk.ParamName, tempFile.Name())
-
-	// 5. 使用 os/exec 调用 grib_to_json
-	log.Printf("Transforming %s by grib_to_json...", chunk.ParamName)
+	defer removeTempGrib(gribPath)
 
 	// grib_dump -j 会自动将 JSON 输出到 stdout
-	cmd := exec.Command("grib_dump", "-j", tempFile.Name())
-
+	cmd := exec.CommandContext(ctx, "grib_dump", "-j", gribPath)
 	// CombinedOutput 会同时捕获 stdout 和 stderr，便于调试
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Printf("Fail to exec grib_to_json %s: %v", chunk.ParamName, err)
-		log.Printf("grib_to_json error output: %s", string(output))
-		return "", fmt.Errorf("fail to exec grib_to_json %s: %w", chunk.ParamName, err)
+		return nil, GridSpec{}, fmt.Errorf("fail to exec grib_dump %s: %w: %s", param, err, strings.TrimSpace(string(output)))
+	}
+	return unwarpGribRawJsonValue(strings.TrimSpace(string(output)))
+}
+
+func writeTempGrib(param string, message []byte) (string, error) {
+	tempFile, err := os.CreateTemp("", fmt.Sprintf("gribchunk-%s-*.grib2", param))
+	if err != nil {
+		return "", fmt.Errorf("fail to create tmp file for %s: %w", param, err)
 	}
+	if _, err := tempFile.Write(message); err != nil {
+		tempFile.Close()
+		removeTempGrib(tempFile.Name())
+		return "", fmt.Errorf("fail to write tmp file for %s: %w", param, err)
+	}
+	// 确保在调用 exec 之前关闭文件句柄
+	if err := tempFile.Close(); err != nil {
+		removeTempGrib(tempFile.Name())
+		return "", fmt.Errorf("fail to close temp file: %w", err)
+	}
+	return tempFile.Name(), nil
+}
+
+func removeTempGrib(name string) {
+	if err := os.Remove(name); err != nil {
+		log.Printf("Fail to remove temp file %s: %v", name, err)
+	}
+}
 
-	// 6. 成功！打印 JSON (或您需要的任何处理)
-	//log.Printf("--- %s 的 JSON 数据 (前 500 字节) ---", chunk.ParamName)
-	//if len(output) > 500 {
-	//	fmt.Println(string(output[:500]) + "\n... (数据已截断)")
-	//} else {
-	//	fmt.Println(string(output))
-	//}
-	log.Printf("%s done.", chunk.ParamName)
-	return strings.TrimSpace(string(output)), nil
+const (
+	maxRangeResumes    = 5                      // follow-up requests per chunk after a dropped read
+	rangeResumeBackoff = 500 * time.Millisecond // doubled after every resume
+)
+
+// copyRange copies length bytes at offset of obj into dst. A read that drops
+// midway is resumed with a range request from the first byte not received
+// yet, so a flaky link costs the missing tail rather than the whole chunk.
+func copyRange(ctx context.Context, dst io.Writer, obj objectReader, offset, length int64) error {
+	var received int64
+	backoff := rangeResumeBackoff
+	for attempt := 0; ; attempt++ {
+		reader, err := obj.NewRangeReader(ctx, offset+received, length-received)
+		if err != nil {
+			if received == 0 {
+				return fmt.Errorf("fail to create RangeReader: %w", err)
+			}
+		} else {
+			var written int64
+			written, err = io.Copy(dst, reader)
+			reader.Close()
+			received += written
+			addLogCount(ctx, "bytes", written)
+			if err == nil && received < length {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil {
+				return nil
+			}
+		}
+
+		if ctx.Err() != nil || attempt == maxRangeResumes {
+			return fmt.Errorf("%w: after %d of %d bytes: %w", ErrUpstreamUnavailable, received, length, err)
+		}
+		log.Printf("Range read at %d dropped after %d of %d bytes, resuming: %v", offset, received, length, err)
+		addLogCount(ctx, "resumes", 1)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: after %d of %d bytes: %w", ErrUpstreamUnavailable, received, length, ctx.Err())
+		case <-clock.After(backoff):
+		}
+		backoff *= 2
+	}
 }
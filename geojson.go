@@ -0,0 +1,164 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"time"
+)
+
+// GeoJSON output (format=geojson) for map clients such as Leaflet or Mapbox
+// to render directly: /range as one Point feature per grid point carrying
+// the values as properties, /typhoon as one LineString per storm track plus
+// the current positions as Points, /trajectory as one LineString.
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"` // FeatureCollection
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string         `json:"type"`     // Feature
+	Geometry   any            `json:"geometry"` // GeoJSONPoint or GeoJSONLineString
+	Properties map[string]any `json:"properties"`
+}
+
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`        // Point
+	Coordinates [2]float64 `json:"coordinates"` // lon, lat
+}
+
+type GeoJSONLineString struct {
+	Type        string       `json:"type"`        // LineString
+	Coordinates [][2]float64 `json:"coordinates"` // lon, lat
+}
+
+// rangeGeoJSON converts a /range response. Wind points get u, v and speed,
+// a scalar param its name, params= every field, derived= its fields.
+func rangeGeoJSON(response RangeResponse) GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]GeoJSONFeature, len(response.Lats))}
+	wind := len(response.U) == len(response.Lats) && len(response.V) == len(response.Lats)
+	for i := range collection.Features {
+		properties := make(map[string]any)
+		if wind {
+			u, v := response.U[i], response.V[i]
+			properties["u"], properties["v"] = NullFloat(u), NullFloat(v)
+			properties["speed"] = NullFloat(math.Sqrt(u*u + v*v))
+		}
+		if response.Param != "" && i < len(response.Values) {
+			properties[response.Param] = NullFloat(response.Values[i])
+		}
+		for name, values := range response.Fields {
+			properties[name] = NullFloat(values[i])
+		}
+		if i < len(response.Speed) {
+			properties["speed"] = NullFloat(response.Speed[i])
+		}
+		if i < len(response.Dir) {
+			properties["dir"] = NullFloat(response.Dir[i])
+		}
+		collection.Features[i] = GeoJSONFeature{
+			Type:       "Feature",
+			Geometry:   GeoJSONPoint{Type: "Point", Coordinates: [2]float64{response.Lons[i], response.Lats[i]}},
+			Properties: properties,
+		}
+	}
+	return collection
+}
+
+// typhoonGeoJSON converts a /typhoon response. Each track becomes a
+// LineString whose properties hold the storm's identity and one array entry
+// per vertex (time, wind, pressure, category, nature); each entry of now
+// becomes a Point with the record's fields. Longitudes along a track are
+// unwrapped so lines crossing the antimeridian stay continuous.
+func typhoonGeoJSON(response TyphonAPIResponse) GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	for _, track := range response.Trace {
+		var coordinates [][2]float64
+		var times, natures, natureNames, categoryNames []string
+		var wind, pressure, category NullFloats
+		for _, point := range track.Points {
+			if math.IsNaN(float64(point.Lat)) || math.IsNaN(float64(point.Lon)) {
+				continue
+			}
+			lon := float64(point.Lon)
+			if len(coordinates) > 0 {
+				previous := coordinates[len(coordinates)-1][0]
+				lon = previous + normalizeLon(lon-previous)
+			}
+			coordinates = append(coordinates, [2]float64{lon, float64(point.Lat)})
+			times = append(times, point.Time)
+			natures = append(natures, point.Nature)
+			natureNames = append(natureNames, point.NatureName)
+			categoryNames = append(categoryNames, point.CategoryName)
+			wind = append(wind, float64(point.Wind))
+			pressure = append(pressure, float64(point.Pressure))
+			category = append(category, float64(point.Category))
+		}
+		if len(coordinates) == 0 {
+			continue
+		}
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: GeoJSONLineString{Type: "LineString", Coordinates: coordinates},
+			Properties: map[string]any{
+				"kind":     "track",
+				"sid":      track.SID,
+				"name":     track.Name,
+				"number":   track.Number,
+				"time":     times,
+				"wind":     wind,
+				"pressure": pressure,
+				"category": category,
+				"nature":   natures,
+
+				"nature_name":   natureNames,
+				"category_name": categoryNames,
+			},
+		})
+	}
+
+	for _, record := range response.Now {
+		// the records keep their cma_ names unless trace=points
+		lat, latOK := cmp.Or(record["lat"], record["cma_lat"]).(NullFloat)
+		lon, lonOK := cmp.Or(record["lon"], record["cma_lon"]).(NullFloat)
+		if !latOK || !lonOK || math.IsNaN(float64(lat)) || math.IsNaN(float64(lon)) {
+			continue
+		}
+		properties := maps.Clone(record)
+		properties["kind"] = "current"
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type:       "Feature",
+			Geometry:   GeoJSONPoint{Type: "Point", Coordinates: [2]float64{float64(lon), float64(lat)}},
+			Properties: properties,
+		})
+	}
+	return collection
+}
+
+// trajectoryGeoJSON converts a /trajectory response into one LineString with
+// the time of each vertex, longitudes unwrapped like typhoon tracks.
+func trajectoryGeoJSON(response TrajectoryResponse) GeoJSONFeatureCollection {
+	coordinates := make([][2]float64, len(response.Points))
+	times := make([]time.Time, len(response.Points))
+	for i, point := range response.Points {
+		lon := point.Lon
+		if i > 0 {
+			previous := coordinates[i-1][0]
+			lon = previous + normalizeLon(lon-previous)
+		}
+		coordinates[i] = [2]float64{lon, point.Lat}
+		times[i] = point.Time
+	}
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{{
+		Type:     "Feature",
+		Geometry: GeoJSONLineString{Type: "LineString", Coordinates: coordinates},
+		Properties: map[string]any{
+			"kind":      "trajectory",
+			"time":      times,
+			"hours":     response.Hours,
+			"completed": response.Completed,
+			"distance":  response.Distance,
+		},
+	}}}
+}
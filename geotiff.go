@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// A minimal Cloud-Optimized GeoTIFF writer for float32 rasters on a regular
+// lat-lon grid, written by hand against the TIFF 6.0 and GeoTIFF 1.1 specs.
+// The layout is what COG readers expect: the IFD first, then its tag data,
+// then the deflated 256x256 tiles in order. Rasters are small enough that no
+// overviews are written. Bands are pixel interleaved, NaN is nodata.
+
+const geotiffTile = 256
+
+// TIFF field types
+const (
+	tiffASCII  = 2
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffDouble = 12
+)
+
+type tiffEntry struct {
+	tag, kind uint16
+	count     uint32
+	data      []byte // little endian values
+}
+
+func tiffShorts(tag uint16, values ...uint16) tiffEntry {
+	data := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint16(data, v)
+	}
+	return tiffEntry{tag, tiffShort, uint32(len(values)), data}
+}
+
+func tiffLongs(tag uint16, values ...uint32) tiffEntry {
+	data := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	return tiffEntry{tag, tiffLong, uint32(len(values)), data}
+}
+
+func tiffDoubles(tag uint16, values ...float64) tiffEntry {
+	data := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+	}
+	return tiffEntry{tag, tiffDouble, uint32(len(values)), data}
+}
+
+func tiffString(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag, tiffASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+// writeGeoTIFF writes bands, each g.Size() values scanned like g, as a
+// GeoTIFF in EPSG:4326 with one sample per band, named by names.
+func writeGeoTIFF(w io.Writer, g RegularLatLonGrid, bands [][]float64, names []string) error {
+	samples := len(bands)
+	for _, band := range bands {
+		if len(band) != g.Size() {
+			return fmt.Errorf("band has %d values, grid %d", len(band), g.Size())
+		}
+	}
+	across := (g.Ni + geotiffTile - 1) / geotiffTile
+	down := (g.Nj + geotiffTile - 1) / geotiffTile
+
+	// tiles are always full size, padded with nodata past the raster edges
+	tiles := make([][]byte, 0, across*down)
+	raw := make([]byte, geotiffTile*geotiffTile*samples*4)
+	nan := math.Float32bits(float32(math.NaN()))
+	for ty := range down {
+		for tx := range across {
+			for y := range geotiffTile {
+				for x := range geotiffTile {
+					row, col := ty*geotiffTile+y, tx*geotiffTile+x
+					for s := range samples {
+						bits := nan
+						if row < g.Nj && col < g.Ni {
+							bits = math.Float32bits(float32(bands[s][row*g.Ni+col]))
+						}
+						binary.LittleEndian.PutUint32(raw[((y*geotiffTile+x)*samples+s)*4:], bits)
+					}
+				}
+			}
+			var buf bytes.Buffer
+			zw := zlib.NewWriter(&buf)
+			zw.Write(raw)
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			tiles = append(tiles, buf.Bytes())
+		}
+	}
+
+	perSample := func(v uint16) []uint16 {
+		values := make([]uint16, samples)
+		for i := range values {
+			values[i] = v
+		}
+		return values
+	}
+	var metadata strings.Builder
+	metadata.WriteString("<GDALMetadata>")
+	for i, name := range names {
+		fmt.Fprintf(&metadata, `<Item name="DESCRIPTION" sample="%d" role="description">%s</Item>`, i, name)
+	}
+	metadata.WriteString("</GDALMetadata>")
+
+	offsets := make([]uint32, len(tiles))
+	counts := make([]uint32, len(tiles))
+	entries := []tiffEntry{
+		tiffLongs(256, uint32(g.Ni)),                // ImageWidth
+		tiffLongs(257, uint32(g.Nj)),                // ImageLength
+		tiffShorts(258, perSample(32)...),           // BitsPerSample
+		tiffShorts(259, 8),                          // Compression: deflate
+		tiffShorts(262, 1),                          // PhotometricInterpretation: BlackIsZero
+		tiffShorts(277, uint16(samples)),            // SamplesPerPixel
+		tiffShorts(284, 1),                          // PlanarConfiguration: interleaved
+		tiffShorts(322, geotiffTile),                // TileWidth
+		tiffShorts(323, geotiffTile),                // TileLength
+		tiffLongs(324, offsets...),                  // TileOffsets, filled in below
+		tiffLongs(325, counts...),                   // TileByteCounts
+		tiffShorts(339, perSample(3)...),            // SampleFormat: IEEE float
+		tiffDoubles(33550, g.LonStep, g.LatStep, 0), // ModelPixelScale
+		// ModelTiepoint: the raster's top left corner, half a cell out from
+		// the first grid point since pixels are areas centred on the points
+		tiffDoubles(33922, 0, 0, 0, g.LonFirst-g.LonStep/2, g.LatFirst+g.LatStep/2, 0),
+		tiffShorts(34735, // GeoKeyDirectory
+			1, 1, 0, 3,
+			1024, 0, 1, 2, // GTModelType: geographic
+			1025, 0, 1, 1, // GTRasterType: PixelIsArea
+			2048, 0, 1, 4326, // GeographicType: WGS 84
+		),
+		tiffString(42112, metadata.String()), // GDAL_METADATA
+		tiffString(42113, "nan"),             // GDAL_NODATA
+	}
+	if samples > 1 {
+		entries = append(entries, tiffShorts(338, perSample(0)[1:]...)) // ExtraSamples: unspecified
+	}
+	slices.SortFunc(entries, func(a, b tiffEntry) int { return int(a.tag) - int(b.tag) })
+
+	// layout: header, IFD, tag data too long to sit in the IFD, tiles
+	ifdSize := 2 + 12*len(entries) + 4
+	dataOffset := 8 + ifdSize
+	external := 0
+	for _, entry := range entries {
+		if len(entry.data) > 4 {
+			external += (len(entry.data) + 1) &^ 1 // word aligned
+		}
+	}
+	next := uint32(dataOffset + external)
+	for i, tile := range tiles {
+		offsets[i] = next
+		counts[i] = uint32(len(tile))
+		next += uint32(len(tile))
+	}
+	for i, entry := range entries {
+		switch entry.tag {
+		case 324:
+			entries[i] = tiffLongs(324, offsets...)
+		case 325:
+			entries[i] = tiffLongs(325, counts...)
+		}
+	}
+
+	out := make([]byte, 0, next)
+	out = append(out, 'I', 'I', 42, 0)
+	out = binary.LittleEndian.AppendUint32(out, 8)
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(entries)))
+	var data []byte
+	for _, entry := range entries {
+		out = binary.LittleEndian.AppendUint16(out, entry.tag)
+		out = binary.LittleEndian.AppendUint16(out, entry.kind)
+		out = binary.LittleEndian.AppendUint32(out, entry.count)
+		if len(entry.data) <= 4 {
+			value := make([]byte, 4)
+			copy(value, entry.data)
+			out = append(out, value...)
+			continue
+		}
+		out = binary.LittleEndian.AppendUint32(out, uint32(dataOffset+len(data)))
+		data = append(data, entry.data...)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	out = binary.LittleEndian.AppendUint32(out, 0) // no further IFD
+	out = append(out, data...)
+	for _, tile := range tiles {
+		out = append(out, tile...)
+	}
+	_, err := w.Write(out)
+	return err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GeoTIFF export of the wind over a bbox, for GIS users to load straight into
+// QGIS or ArcGIS: one band of speed or two of u and v, in m/s, resampled
+// bilinearly onto a regular grid at res degrees. See geotiff.go.
+
+const (
+	geotiffBandsSpeed = "speed"
+	geotiffBandsUV    = "uv"
+
+	minGeoTIFFRes    = 0.01        // degrees, well below the 0.25° source grid
+	maxGeoTIFFPixels = TotalPoints // a global raster at the source resolution
+)
+
+type GeoTIFFAPIParams struct {
+	Date  string
+	Batch string
+	Step  int
+	West  float64
+	South float64
+	East  float64
+	North float64
+	Res   float64 // degrees
+	Bands string  // speed or uv
+}
+
+// geotiffHandler serves /export/geotiff?bbox=west,south,east,north&date=&batch=&step=&res=&bands=speed|uv
+func geotiffHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := GeoTIFFAPIParams{
+		Date:  httpQuery.Get("date"),
+		Batch: httpQuery.Get("batch"),
+		Res:   LatStep,
+		Bands: geotiffBandsSpeed,
+	}
+	bbox := strings.Split(httpQuery.Get("bbox"), ",")
+	if len(bbox) != 4 {
+		sendTileJsonError(w, http.StatusBadRequest)
+		return
+	}
+	for i, dst := range []*float64{&params.West, &params.South, &params.East, &params.North} {
+		value, err := strconv.ParseFloat(strings.TrimSpace(bbox[i]), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			sendTileJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	if s := httpQuery.Get("res"); s != "" {
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(value >= minGeoTIFFRes) || math.IsInf(value, 0) {
+			sendTileJsonError(w, http.StatusBadRequest)
+			return
+		}
+		params.Res = value
+	}
+	if s := httpQuery.Get("bands"); s != "" {
+		params.Bands = s
+	}
+	var err error
+	if params.Step, err = parseStep(httpQuery.Get("step"), params.Batch); err != nil {
+		sendTileJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", params.Date)
+	setLogField(r.Context(), "batch", params.Batch)
+	var buf bytes.Buffer
+	if err2 := GeoTIFFQuery(r.Context(), params, &buf); err2 != nil {
+		sendTileJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	filename := fmt.Sprintf("wind-%s-%s-%03d-%s.tif", params.Date, params.Batch, params.Step, params.Bands)
+	w.Header().Set("Content-Type", "image/tiff; application=geotiff; profile=cloud-optimized")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("Met Error when writing geotiff to ResponseWriter: %v", err)
+	}
+}
+
+func GeoTIFFQuery(ctx context.Context, params GeoTIFFAPIParams, buf *bytes.Buffer) error {
+	switch {
+	case params.Bands != geotiffBandsSpeed && params.Bands != geotiffBandsUV:
+		return fmt.Errorf("%w: bands must be %s or %s", ErrInvalidParams, geotiffBandsSpeed, geotiffBandsUV)
+	case params.North <= params.South:
+		return fmt.Errorf("%w: bbox latitudes", ErrOutOfGrid)
+	case !(params.Res >= minGeoTIFFRes) || math.IsInf(params.Res, 0):
+		return fmt.Errorf("%w: res must be at least %g degrees", ErrInvalidParams, minGeoTIFFRes)
+	}
+	if err := validateDateBatch(params.Date, params.Batch); err != nil {
+		return err
+	}
+	target, err := regridTarget(params.North, params.West, params.South, params.East, params.Res)
+	if err != nil {
+		return err
+	}
+	if target.Size() > maxGeoTIFFPixels {
+		return fmt.Errorf("%w: raster of %d pixels exceeds limit of %d, raise res", ErrInvalidParams, target.Size(), maxGeoTIFFPixels)
+	}
+
+	filePath := filepath.Join(tmpDir, params.Date+"-"+params.Batch+".json")
+	source, fields, err := loadParamFields(ctx, filePath, params.Date, params.Batch, params.Step, windParams)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+	u, err := Regrid(source, fields[0], target, RegridBilinear)
+	if err != nil {
+		return err
+	}
+	v, err := Regrid(source, fields[1], target, RegridBilinear)
+	if err != nil {
+		return err
+	}
+	addLogCount(ctx, "points", int64(target.Size()))
+
+	if params.Bands == geotiffBandsUV {
+		return writeGeoTIFF(buf, target, [][]float64{u, v}, []string{"u", "v"})
+	}
+	speed := make([]float64, len(u))
+	for i := range speed {
+		speed[i] = math.Hypot(u[i], v[i])
+	}
+	return writeGeoTIFF(buf, target, [][]float64{speed}, []string{"speed"})
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Native GRIB2 decoding. Every chunk listed in an .index file is one GRIB2
+// message, decoded here straight from its bytes: the grid from section 3, the
+// bitmap from section 6 and the values from sections 5 and 7. Supported are
+// regular_ll and reduced_gg grids scanned the ECMWF way (west to east, north
+// to south) with simple (5.0), complex (5.2, 5.3) or CCSDS (5.42) packing,
+// which covers ECMWF open data. Other messages are errUnsupportedGrib and go
+// to the next decode backend (see decoders.go).
+
+var errUnsupportedGrib = errors.New("unsupported GRIB2 message")
+
+// maxGribPoints bounds the grids decoded, well above the 6.6 million points
+// of the finest ECMWF grid (O1280), so a malformed message cannot make the
+// decoder allocate gigabytes. Counts read from a message are also checked
+// against the bytes that would have to hold them before anything is
+// allocated.
+const maxGribPoints = 1 << 24
+
+// octets returns length octets of a section from octet n, numbered from 1
+// like in the WMO tables.
+func octets(section []byte, n, length int) []byte {
+	return section[n-1 : n-1+length]
+}
+
+func gribUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// gribInt decodes a GRIB2 signed integer, stored as sign and magnitude.
+func gribInt(b []byte) int64 {
+	return signMagnitude(gribUint(b), 8*len(b))
+}
+
+func signMagnitude(v uint64, width int) int64 {
+	sign := uint64(1) << (width - 1)
+	if v&sign != 0 {
+		return -int64(v &^ sign)
+	}
+	return int64(v)
+}
+
+// decodeGrib2 decodes the first field of a GRIB2 message into its values, on
+// the grid returned with them. Points masked by the bitmap or marked missing
+// by the packing are NaN.
+func decodeGrib2(message []byte) ([]float64, GridSpec, error) {
+	if len(message) < 16 || string(message[:4]) != "GRIB" {
+		return nil, GridSpec{}, fmt.Errorf("not a GRIB message")
+	}
+	if message[7] != 2 {
+		return nil, GridSpec{}, fmt.Errorf("%w: edition %d", errUnsupportedGrib, message[7])
+	}
+	total := binary.BigEndian.Uint64(message[8:16])
+	if total > uint64(len(message)) || total < 20 {
+		return nil, GridSpec{}, fmt.Errorf("GRIB message of %d bytes truncated to %d", total, len(message))
+	}
+	message = message[:total]
+	if string(message[total-4:]) != "7777" {
+		return nil, GridSpec{}, fmt.Errorf("GRIB message does not end with 7777")
+	}
+
+	sections := make(map[byte][]byte)
+	for pos := 16; pos < len(message)-4; {
+		if pos+5 > len(message)-4 {
+			return nil, GridSpec{}, fmt.Errorf("malformed GRIB section at %d", pos)
+		}
+		length := int(binary.BigEndian.Uint32(message[pos:]))
+		number := message[pos+4]
+		if length < 5 || pos+length > len(message)-4 {
+			return nil, GridSpec{}, fmt.Errorf("malformed GRIB section %d at %d", number, pos)
+		}
+		sections[number] = message[pos : pos+length]
+		pos += length
+		if number == 7 {
+			break // further fields of the message are not read
+		}
+	}
+	for _, number := range []byte{3, 5, 7} {
+		if sections[number] == nil {
+			return nil, GridSpec{}, fmt.Errorf("GRIB message has no section %d", number)
+		}
+	}
+
+	spec, points, err := gribGridSpec(sections[3])
+	if err != nil {
+		return nil, GridSpec{}, err
+	}
+	bitmap, err := gribBitmap(sections[6], points)
+	if err != nil {
+		return nil, GridSpec{}, err
+	}
+	packed, err := gribUnpack(sections[5], sections[7][5:], points)
+	if err != nil {
+		return nil, GridSpec{}, err
+	}
+
+	values := packed
+	if bitmap != nil {
+		values = make([]float64, points)
+		next := 0
+		for i := range values {
+			if bitmap[i/8]&(0x80>>(i%8)) == 0 {
+				values[i] = math.NaN()
+				continue
+			}
+			if next == len(packed) {
+				return nil, GridSpec{}, fmt.Errorf("bitmap selects more than the %d packed values", len(packed))
+			}
+			values[i] = packed[next]
+			next++
+		}
+	}
+	if len(values) != points {
+		return nil, GridSpec{}, fmt.Errorf("%s grid has %d points but message has %d values", spec.Type, points, len(values))
+	}
+	return values, spec, nil
+}
+
+// gribGridSpec reads the grid definition section.
+func gribGridSpec(section []byte) (GridSpec, int, error) {
+	if len(section) < 72 {
+		return GridSpec{}, 0, fmt.Errorf("%w: grid definition of %d bytes", errUnsupportedGrib, len(section))
+	}
+	points := int(gribUint(octets(section, 7, 4)))
+	if points == 0 || points > maxGribPoints {
+		return GridSpec{}, 0, fmt.Errorf("%w: grid of %d points", errUnsupportedGrib, points)
+	}
+	listOctets := int(section[10])
+	template := gribUint(octets(section, 13, 2))
+	if template != 0 && template != 40 {
+		return GridSpec{}, 0, fmt.Errorf("%w: grid template 3.%d", errUnsupportedGrib, template)
+	}
+	if scanning := section[71]; scanning != 0 {
+		return GridSpec{}, 0, fmt.Errorf("%w: scanning mode %#x", errUnsupportedGrib, scanning)
+	}
+
+	ni := gribUint(octets(section, 31, 4))
+	nj := int(gribUint(octets(section, 35, 4)))
+	// angles are in microdegrees unless a basic angle is given
+	basic, subdivisions := gribUint(octets(section, 39, 4)), gribUint(octets(section, 43, 4))
+	degrees := func(v float64) float64 { return v / 1e6 }
+	if basic != 0 && basic != math.MaxUint32 && subdivisions != 0 && subdivisions != math.MaxUint32 {
+		degrees = func(v float64) float64 { return v * float64(basic) / float64(subdivisions) }
+	}
+
+	switch template {
+	case 0:
+		if ni == math.MaxUint32 {
+			return GridSpec{}, 0, fmt.Errorf("%w: reduced lat/lon grid", errUnsupportedGrib)
+		}
+		spec := GridSpec{
+			Type:     "regular_ll",
+			Ni:       int(ni),
+			Nj:       nj,
+			LatFirst: degrees(float64(gribInt(octets(section, 47, 4)))),
+			LonFirst: degrees(float64(gribInt(octets(section, 51, 4)))),
+			LonStep:  degrees(float64(gribUint(octets(section, 64, 4)))),
+			LatStep:  degrees(float64(gribUint(octets(section, 68, 4)))),
+		}
+		if uint64(spec.Ni)*uint64(spec.Nj) != uint64(points) {
+			return GridSpec{}, 0, fmt.Errorf("regular_ll grid of %dx%d has %d points", spec.Ni, spec.Nj, points)
+		}
+		return spec, points, nil
+	default:
+		if ni != math.MaxUint32 {
+			return GridSpec{}, 0, fmt.Errorf("%w: regular gaussian grid", errUnsupportedGrib)
+		}
+		if listOctets == 0 || len(section) < 72+listOctets*nj {
+			return GridSpec{}, 0, fmt.Errorf("reduced gaussian grid without its list of %d row lengths", nj)
+		}
+		spec := GridSpec{Type: "reduced_gg", N: int(gribUint(octets(section, 68, 4))), PL: make([]int, nj)}
+		sum := 0
+		for row := range spec.PL {
+			spec.PL[row] = int(gribUint(octets(section, 73+row*listOctets, listOctets)))
+			sum += spec.PL[row]
+		}
+		if sum != points {
+			return GridSpec{}, 0, fmt.Errorf("reduced_gg rows hold %d points, grid has %d", sum, points)
+		}
+		return spec, points, nil
+	}
+}
+
+// gribBitmap returns the bitmap of section 6, nil when every point has a value.
+func gribBitmap(section []byte, points int) ([]byte, error) {
+	if section == nil || len(section) < 6 || section[5] == 255 {
+		return nil, nil
+	}
+	if section[5] != 0 {
+		return nil, fmt.Errorf("%w: bitmap indicator %d", errUnsupportedGrib, section[5])
+	}
+	bitmap := section[6:]
+	if len(bitmap) < (points+7)/8 {
+		return nil, fmt.Errorf("bitmap of %d bytes for %d points", len(bitmap), points)
+	}
+	return bitmap, nil
+}
+
+// gribScaling turns packed integers into values, Y = (R + X·2^E) / 10^D.
+type gribScaling struct {
+	reference float64
+	binary    float64 // 2^E
+	decimal   float64 // 10^-D
+	bits      int
+}
+
+func (s gribScaling) value(x int64) float64 {
+	return (s.reference + float64(x)*s.binary) * s.decimal
+}
+
+// gribUnpack decodes the packed values of section 7 as described by the data
+// representation section, at most points of them.
+func gribUnpack(section, data []byte, points int) ([]float64, error) {
+	if len(section) < 21 {
+		return nil, fmt.Errorf("data representation of %d bytes", len(section))
+	}
+	count := int(gribUint(octets(section, 6, 4)))
+	template := gribUint(octets(section, 10, 2))
+	scaling := gribScaling{
+		reference: float64(math.Float32frombits(uint32(gribUint(octets(section, 12, 4))))),
+		binary:    math.Pow(2, float64(gribInt(octets(section, 16, 2)))),
+		decimal:   math.Pow(10, -float64(gribInt(octets(section, 18, 2)))),
+		bits:      int(section[19]),
+	}
+	if scaling.bits > 32 {
+		return nil, fmt.Errorf("%w: %d bits per value", errUnsupportedGrib, scaling.bits)
+	}
+	if count > points {
+		return nil, fmt.Errorf("data representation of %d values for a grid of %d points", count, points)
+	}
+
+	values := make([]float64, count)
+	if scaling.bits == 0 && template != 2 && template != 3 {
+		// a constant field carries no data
+		for i := range values {
+			values[i] = scaling.value(0)
+		}
+		return values, nil
+	}
+	switch template {
+	case 0:
+		if count*scaling.bits > 8*len(data) {
+			return nil, fmt.Errorf("%d simple packed values overrun %d bytes", count, len(data))
+		}
+		reader := bitReader{data: data}
+		for i := range values {
+			values[i] = scaling.value(int64(reader.read(scaling.bits)))
+		}
+		if reader.overrun {
+			return nil, fmt.Errorf("%d simple packed values overrun %d bytes", count, len(data))
+		}
+		return values, nil
+	case 2, 3:
+		return unpackComplex(section, data, scaling, values, template == 3)
+	case 42:
+		if len(section) < 25 {
+			return nil, fmt.Errorf("CCSDS data representation of %d bytes", len(section))
+		}
+		samples, err := aecDecode(data, scaling.bits, int(section[22]), int(gribUint(octets(section, 24, 2))), int(section[21]), count)
+		if err != nil {
+			return nil, err
+		}
+		for i, x := range samples {
+			values[i] = scaling.value(int64(x))
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: data template 5.%d", errUnsupportedGrib, template)
+	}
+}
+
+// unpackComplex decodes complex packing, with spatial differencing for
+// template 5.3. Values are split into groups, each with its own reference
+// and bit width, stored as all group references, then widths, then lengths,
+// then the values of every group.
+func unpackComplex(section, data []byte, scaling gribScaling, values []float64, spatial bool) ([]float64, error) {
+	if len(section) < 47 || spatial && len(section) < 49 {
+		return nil, fmt.Errorf("complex packing data representation of %d bytes", len(section))
+	}
+	missingManagement := section[22]
+	if missingManagement > 2 {
+		return nil, fmt.Errorf("%w: missing value management %d", errUnsupportedGrib, missingManagement)
+	}
+	groups := int(gribUint(octets(section, 32, 4)))
+	widthReference := int(section[35])
+	widthBits := int(section[36])
+	lengthReference := int(gribUint(octets(section, 38, 4)))
+	lengthIncrement := int(section[41])
+	lastLength := int(gribUint(octets(section, 43, 4)))
+	lengthBits := int(section[46])
+	if widthBits > 32 || lengthBits > 32 {
+		return nil, fmt.Errorf("complex packing group widths of %d bits and lengths of %d bits", widthBits, lengthBits)
+	}
+	// the group references, widths and lengths alone take this many bytes
+	if groups > len(values) || groups*scaling.bits/8+groups*widthBits/8+groups*lengthBits/8 > len(data) {
+		return nil, fmt.Errorf("%d complex packing groups for %d values overrun %d bytes", groups, len(values), len(data))
+	}
+
+	reader := bitReader{data: data}
+	var order int
+	var first [2]int64
+	var minimum int64
+	if spatial {
+		order = int(section[47])
+		extra := 8 * int(section[48])
+		if order != 1 && order != 2 || extra == 0 || extra > 64 {
+			return nil, fmt.Errorf("%w: spatial differencing of order %d with %d bit descriptors", errUnsupportedGrib, order, extra)
+		}
+		for i := range order {
+			first[i] = int64(reader.read(extra))
+		}
+		minimum = signMagnitude(reader.read(extra), extra)
+	}
+
+	references := make([]int64, groups)
+	for g := range references {
+		references[g] = int64(reader.read(scaling.bits))
+	}
+	reader.align()
+	widths := make([]int, groups)
+	for g := range widths {
+		widths[g] = widthReference + int(reader.read(widthBits))
+		if widths[g] < 0 || widths[g] > 32 {
+			return nil, fmt.Errorf("%w: group of %d bit values", errUnsupportedGrib, widths[g])
+		}
+	}
+	reader.align()
+	lengths := make([]int, groups)
+	total := 0
+	for g := range lengths {
+		lengths[g] = lengthReference + int(reader.read(lengthBits))*lengthIncrement
+		if g == groups-1 {
+			lengths[g] = lastLength
+		}
+		if lengths[g] < 0 || lengths[g] > len(values)-total {
+			return nil, fmt.Errorf("complex packing group of %d values after %d, expected %d in all", lengths[g], total, len(values))
+		}
+		total += lengths[g]
+	}
+	reader.align()
+	if total != len(values) {
+		return nil, fmt.Errorf("complex packing groups hold %d values, expected %d", total, len(values))
+	}
+
+	packed := make([]int64, len(values))
+	var missing []bool
+	if missingManagement > 0 {
+		missing = make([]bool, len(values))
+	}
+	i := 0
+	for g := range groups {
+		width := widths[g]
+		for range lengths[g] {
+			if width == 0 {
+				packed[i] = references[g]
+				if missing != nil {
+					all := int64(1)<<scaling.bits - 1
+					missing[i] = references[g] == all || missingManagement == 2 && references[g] == all-1
+				}
+			} else {
+				x := int64(reader.read(width))
+				packed[i] = references[g] + x
+				if missing != nil {
+					all := int64(1)<<width - 1
+					missing[i] = x == all || missingManagement == 2 && x == all-1
+				}
+			}
+			i++
+		}
+	}
+	if reader.overrun {
+		return nil, fmt.Errorf("complex packed values overrun %d bytes", len(data))
+	}
+
+	if spatial {
+		// undo the differencing along the points that have values
+		n := 0
+		var last, penultimate int64
+		for i := range packed {
+			if missing != nil && missing[i] {
+				continue
+			}
+			switch {
+			case n < order:
+				packed[i] = first[n]
+			case order == 1:
+				packed[i] += minimum + last
+			default:
+				packed[i] += minimum + 2*last - penultimate
+			}
+			penultimate, last = last, packed[i]
+			n++
+		}
+	}
+	for i, x := range packed {
+		if missing != nil && missing[i] {
+			values[i] = math.NaN()
+			continue
+		}
+		values[i] = scaling.value(x)
+	}
+	return values, nil
+}
+
+// Flags of CCSDS packing (ccsdsFlags), as defined by libaec.
+const (
+	aecDataSigned     = 1
+	aecData3Byte      = 2
+	aecDataMSB        = 4
+	aecDataPreprocess = 8
+	aecRestricted     = 16
+	aecPadRSI         = 32
+)
+
+// aecDecode decodes count samples of bitsPerSample bits compressed with the
+// CCSDS 121.0 adaptive entropy coder, the algorithm of libaec. The data is
+// cut into reference sample intervals of rsi blocks of blockSize samples,
+// each block coded with whichever option suited it: zero blocks, the second
+// extension, a split sample (Rice) code or no compression.
+func aecDecode(data []byte, bitsPerSample, blockSize, rsi, flags, count int) ([]uint32, error) {
+	if bitsPerSample < 1 || bitsPerSample > 32 || blockSize < 2 || blockSize%2 != 0 || rsi < 1 {
+		return nil, fmt.Errorf("%w: CCSDS %d bits, block size %d, rsi %d", errUnsupportedGrib, bitsPerSample, blockSize, rsi)
+	}
+	if flags&(aecDataSigned|aecRestricted) != 0 {
+		return nil, fmt.Errorf("%w: CCSDS flags %#x", errUnsupportedGrib, flags)
+	}
+	idLength := 3
+	if bitsPerSample > 16 {
+		idLength = 5
+	} else if bitsPerSample > 8 {
+		idLength = 4
+	}
+	uncompressed := 1<<idLength - 1
+	preprocess := flags&aecDataPreprocess != 0
+
+	reader := bitReader{data: data}
+	samples := make([]uint32, 0, count+min(rsi, 64)*blockSize)
+	split := make([]uint64, blockSize)
+	for len(samples) < count {
+		start := len(samples)
+		for block := 0; block < rsi && len(samples) < count && !reader.overrun; {
+			reference := 0
+			if preprocess && block == 0 {
+				reference = 1 // the interval starts with a raw reference sample
+			}
+			id := int(reader.read(idLength))
+			switch {
+			case id == 0 && reader.read(1) == 0:
+				if reference == 1 {
+					samples = append(samples, uint32(reader.read(bitsPerSample)))
+				}
+				zeroBlocks := int(min(reader.unary(), uint64(rsi))) + 1
+				if zeroBlocks == 5 {
+					// "remainder of segment", segments being 64 blocks
+					zeroBlocks = min(rsi-block, 64-block%64)
+				} else if zeroBlocks > 5 {
+					zeroBlocks--
+				}
+				if zeroBlocks > rsi-block {
+					return nil, fmt.Errorf("CCSDS run of %d zero blocks overruns its interval of %d", zeroBlocks, rsi)
+				}
+				samples = append(samples, make([]uint32, zeroBlocks*blockSize-reference)...)
+				block += zeroBlocks
+				continue
+			case id == 0:
+				// second extension, pairs of samples coded together
+				if reference == 1 {
+					samples = append(samples, uint32(reader.read(bitsPerSample)))
+				}
+				for i := reference; i < blockSize && !reader.overrun; {
+					m := reader.unary()
+					beta := uint64((math.Sqrt(float64(8*m+1)) - 1) / 2)
+					for beta*(beta+1)/2 > m {
+						beta--
+					}
+					for (beta+1)*(beta+2)/2 <= m {
+						beta++
+					}
+					delta := m - beta*(beta+1)/2
+					if i%2 == 0 {
+						samples = append(samples, uint32(beta-delta))
+						i++
+					}
+					samples = append(samples, uint32(delta))
+					i++
+				}
+			case id == uncompressed:
+				for range blockSize {
+					samples = append(samples, uint32(reader.read(bitsPerSample)))
+				}
+			default:
+				k := id - 1
+				if reference == 1 {
+					samples = append(samples, uint32(reader.read(bitsPerSample)))
+				}
+				coded := split[:blockSize-reference]
+				for i := range coded {
+					coded[i] = reader.unary() << k
+				}
+				for i := range coded {
+					samples = append(samples, uint32(coded[i]|reader.read(k)))
+				}
+			}
+			block++
+		}
+		if reader.overrun {
+			return nil, fmt.Errorf("CCSDS data of %d bytes ends after %d of %d samples", len(data), start, count)
+		}
+		if preprocess {
+			aecPostprocess(samples[start:], bitsPerSample)
+		}
+		if flags&aecPadRSI != 0 {
+			reader.align()
+		}
+	}
+	return samples[:count], nil
+}
+
+// aecPostprocess inverts the unit delay predictor and the mapping of its
+// residuals to unsigned numbers, in place over one reference sample interval.
+func aecPostprocess(samples []uint32, bitsPerSample int) {
+	if len(samples) == 0 {
+		return
+	}
+	xmax := uint64(1)<<bitsPerSample - 1
+	med := xmax/2 + 1
+	data := uint64(samples[0])
+	for i := 1; i < len(samples); i++ {
+		d := uint64(samples[i])
+		halfD := d>>1 + d&1
+		var mask uint64
+		if data&med != 0 {
+			mask = xmax
+		}
+		switch {
+		case halfD > mask^data:
+			data = mask ^ d
+		case d&1 != 0:
+			data -= halfD
+		default:
+			data += halfD
+		}
+		samples[i] = uint32(data)
+	}
+}
+
+// bitReader reads big endian bit fields. Reading past the end yields zeros
+// and sets overrun.
+type bitReader struct {
+	data    []byte
+	pos     int // in bits
+	overrun bool
+}
+
+func (r *bitReader) read(n int) uint64 {
+	var v uint64
+	for n > 0 {
+		index := r.pos >> 3
+		if index >= len(r.data) {
+			r.overrun = true
+			return v << n
+		}
+		available := 8 - r.pos&7
+		take := min(available, n)
+		v = v<<take | uint64(r.data[index]>>(available-take))&(1<<take-1)
+		n -= take
+		r.pos += take
+	}
+	return v
+}
+
+// unary counts the zero bits before the next one bit and skips them both.
+func (r *bitReader) unary() uint64 {
+	var zeros uint64
+	for {
+		index := r.pos >> 3
+		if index >= len(r.data) {
+			r.overrun = true
+			return zeros
+		}
+		rest := r.data[index] << (r.pos & 7)
+		if rest == 0 {
+			zeros += uint64(8 - r.pos&7)
+			r.pos = (index + 1) << 3
+			continue
+		}
+		leading := bits.LeadingZeros8(rest)
+		zeros += uint64(leading)
+		r.pos += leading + 1
+		return zeros
+	}
+}
+
+// align skips to the next byte boundary.
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// The messages below are built octet by octet after the WMO GRIB2 templates,
+// on a 3x2 regular_ll grid, with the packed bits worked out by hand.
+
+// gribSection returns an empty section of length octets.
+func gribSection(number byte, length int) []byte {
+	section := make([]byte, length)
+	binary.BigEndian.PutUint32(section, uint32(length))
+	section[4] = number
+	return section
+}
+
+// putOctets stores v in length octets of a section from octet n.
+func putOctets(section []byte, n, length int, v uint64) {
+	for i := range length {
+		section[n-1+length-1-i] = byte(v >> (8 * i))
+	}
+}
+
+func gribMessage(sections ...[]byte) []byte {
+	message := []byte("GRIB\x00\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00")
+	message = append(message, gribSection(1, 21)...)
+	for _, section := range sections {
+		message = append(message, section...)
+	}
+	message = append(message, "7777"...)
+	binary.BigEndian.PutUint64(message[8:], uint64(len(message)))
+	return message
+}
+
+// testGridSection is 3.0, 3 columns from 0°E by 1° and 2 rows from 10°N.
+func testGridSection() []byte {
+	section := gribSection(3, 72)
+	putOctets(section, 7, 4, 6)
+	putOctets(section, 31, 4, 3)
+	putOctets(section, 35, 4, 2)
+	putOctets(section, 47, 4, 10e6)
+	putOctets(section, 64, 4, 1e6)
+	putOctets(section, 68, 4, 1e6)
+	return section
+}
+
+// testDataSection is the start of a 5.x section: Y = (R + X·2^E) / 10^D.
+func testDataSection(length, count, template int, reference float32, e, d, bits int) []byte {
+	section := gribSection(5, length)
+	putOctets(section, 6, 4, uint64(count))
+	putOctets(section, 10, 2, uint64(template))
+	putOctets(section, 12, 4, uint64(math.Float32bits(reference)))
+	putOctets(section, 16, 2, signMagnitudeBits(e, 16))
+	putOctets(section, 18, 2, signMagnitudeBits(d, 16))
+	section[19] = byte(bits)
+	return section
+}
+
+func signMagnitudeBits(v, width int) uint64 {
+	if v < 0 {
+		return uint64(-v) | 1<<(width-1)
+	}
+	return uint64(v)
+}
+
+func testValuesSection(data []byte) []byte {
+	section := gribSection(7, 5+len(data))
+	copy(section[5:], data)
+	return section
+}
+
+// bitWriter packs big endian bit fields, the inverse of bitReader.
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (w *bitWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if v>>i&1 != 0 {
+			w.data[len(w.data)-1] |= 0x80 >> (w.bits % 8)
+		}
+		w.bits++
+	}
+}
+
+func (w *bitWriter) unary(zeros uint64) {
+	for range zeros {
+		w.write(0, 1)
+	}
+	w.write(1, 1)
+}
+
+func (w *bitWriter) align() {
+	w.bits = (w.bits + 7) &^ 7
+}
+
+func assertValues(t *testing.T, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d values %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if !(math.Abs(got[i]-want[i]) <= 1e-9) && !(math.IsNaN(got[i]) && math.IsNaN(want[i])) {
+			t.Fatalf("value %d = %g, want %g (all %v)", i, got[i], want[i], got)
+		}
+	}
+}
+
+func TestDecodeGrib2Grid(t *testing.T) {
+	var w bitWriter
+	for range 6 {
+		w.write(0, 8)
+	}
+	_, spec, err := decodeGrib2(gribMessage(testGridSection(), testDataSection(21, 6, 0, 0, 0, 0, 8), testValuesSection(w.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GridSpec{Type: "regular_ll", Ni: 3, Nj: 2, LatFirst: 10, LonFirst: 0, LatStep: 1, LonStep: 1}
+	if spec.Type != want.Type || spec.Ni != want.Ni || spec.Nj != want.Nj || spec.LatFirst != want.LatFirst ||
+		spec.LonFirst != want.LonFirst || spec.LatStep != want.LatStep || spec.LonStep != want.LonStep {
+		t.Fatalf("grid %+v, want %+v", spec, want)
+	}
+}
+
+func TestDecodeGrib2SimplePacking(t *testing.T) {
+	// 12 bit values straddle octets; Y = (-5 + X·2) / 10
+	var w bitWriter
+	for _, x := range []uint64{0, 1, 2, 3, 4, 4000} {
+		w.write(x, 12)
+	}
+	values, _, err := decodeGrib2(gribMessage(testGridSection(), testDataSection(21, 6, 0, -5, 1, 1, 12), testValuesSection(w.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, values, []float64{-0.5, -0.3, -0.1, 0.1, 0.3, 799.5})
+}
+
+func TestDecodeGrib2ConstantField(t *testing.T) {
+	values, _, err := decodeGrib2(gribMessage(testGridSection(), testDataSection(21, 6, 0, 7.5, 0, 0, 0), testValuesSection(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, values, []float64{7.5, 7.5, 7.5, 7.5, 7.5, 7.5})
+}
+
+func TestDecodeGrib2Bitmap(t *testing.T) {
+	bitmap := gribSection(6, 7)
+	bitmap[6] = 0b10110100 // points 1 and 4 are missing
+	values, _, err := decodeGrib2(gribMessage(testGridSection(), testDataSection(21, 4, 0, 0, 0, 0, 8), bitmap, testValuesSection([]byte{1, 2, 3, 4})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nan := math.NaN()
+	assertValues(t, values, []float64{1, nan, 2, 3, nan, 4})
+}
+
+// testComplexSection is 5.2 with 8 bit group references, 4 bit widths and
+// 1 bit lengths of 3 + X.
+func testComplexSection(template, missing, groups, lastLength int) []byte {
+	length := 47
+	if template == 3 {
+		length = 49
+	}
+	section := testDataSection(length, 6, template, 0, 0, 0, 8)
+	section[22] = byte(missing)
+	putOctets(section, 32, 4, uint64(groups))
+	section[36] = 4
+	putOctets(section, 38, 4, 3)
+	section[41] = 1
+	putOctets(section, 43, 4, uint64(lastLength))
+	section[46] = 1
+	return section
+}
+
+func TestDecodeGrib2ComplexPacking(t *testing.T) {
+	var w bitWriter
+	w.write(10, 8) // group references
+	w.write(20, 8)
+	w.align()
+	w.write(2, 4) // widths
+	w.write(3, 4)
+	w.align()
+	w.write(0, 1) // lengths, the last one is given in section 5
+	w.write(0, 1)
+	w.align()
+	for _, x := range []uint64{0, 1, 3} {
+		w.write(x, 2)
+	}
+	for _, x := range []uint64{0, 5, 7} {
+		w.write(x, 3)
+	}
+	values, _, err := decodeGrib2(gribMessage(testGridSection(), testComplexSection(2, 0, 2, 3), testValuesSection(w.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, values, []float64{10, 11, 13, 20, 25, 27})
+}
+
+func TestDecodeGrib2ComplexPackingMissing(t *testing.T) {
+	// an all ones value is missing, as is a whole group with an all ones
+	// reference and no width
+	var w bitWriter
+	w.write(10, 8)
+	w.write(255, 8)
+	w.align()
+	w.write(2, 4)
+	w.write(0, 4)
+	w.align()
+	w.write(0, 1)
+	w.write(0, 1)
+	w.align()
+	for _, x := range []uint64{0, 3, 1} {
+		w.write(x, 2)
+	}
+	values, _, err := decodeGrib2(gribMessage(testGridSection(), testComplexSection(2, 1, 2, 3), testValuesSection(w.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nan := math.NaN()
+	assertValues(t, values, []float64{10, nan, 11, nan, nan, nan})
+}
+
+func TestDecodeGrib2SpatialDifferencing(t *testing.T) {
+	// 5, 7, 10, 14, 20, 25 differenced twice is 1, 1, 2, -1 after the
+	// first two values; the minimum -1 is taken off what is packed
+	section := testComplexSection(3, 0, 1, 6)
+	section[36] = 0 // widths are the reference 2
+	section[35] = 2
+	section[46] = 0
+	section[47] = 2 // order
+	section[48] = 2 // octets per extra descriptor
+	var w bitWriter
+	w.write(5, 16)
+	w.write(7, 16)
+	w.write(signMagnitudeBits(-1, 16), 16)
+	w.write(0, 8) // group reference
+	w.align()
+	for _, x := range []uint64{0, 0, 2, 2, 3, 0} {
+		w.write(x, 2)
+	}
+	values, _, err := decodeGrib2(gribMessage(testGridSection(), section, testValuesSection(w.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, values, []float64{5, 7, 10, 14, 20, 25})
+}
+
+func TestAecDecode(t *testing.T) {
+	// 8 bit samples have 3 bit option ids; blocks of 8 samples, 4 per
+	// interval: uncompressed, split with k=2, zero and second extension
+	var w bitWriter
+	w.write(7, 3)
+	for _, x := range []uint64{9, 8, 7, 6, 5, 4, 3, 2} {
+		w.write(x, 8)
+	}
+	split := []uint64{0, 1, 2, 3, 4, 5, 6, 13}
+	w.write(3, 3)
+	for _, x := range split {
+		w.unary(x >> 2)
+	}
+	for _, x := range split {
+		w.write(x&3, 2)
+	}
+	w.write(0, 3)
+	w.write(0, 1)
+	w.unary(0) // one zero block
+	w.write(0, 3)
+	w.write(1, 1)
+	for _, pair := range [][2]uint64{{1, 0}, {0, 2}, {3, 1}, {2, 2}} {
+		beta := pair[0] + pair[1]
+		w.unary(beta*(beta+1)/2 + pair[1])
+	}
+
+	samples, err := aecDecode(w.data, 8, 8, 4, aecDataMSB, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint32{
+		9, 8, 7, 6, 5, 4, 3, 2,
+		0, 1, 2, 3, 4, 5, 6, 13,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		1, 0, 0, 2, 3, 1, 2, 2,
+	}
+	for i := range want {
+		if samples[i] != want[i] {
+			t.Fatalf("samples %v, want %v", samples, want)
+		}
+	}
+}
+
+func TestDecodeGrib2CCSDSPreprocessed(t *testing.T) {
+	// 100, 102, 101, 101, 105, 90 predicted from the previous sample map to
+	// residuals 4, 1, 0, 8, 29, split with k=1 after the reference sample
+	var w bitWriter
+	w.write(2, 3)
+	w.write(100, 8)
+	residuals := []uint64{4, 1, 0, 8, 29}
+	for _, x := range residuals {
+		w.unary(x >> 1)
+	}
+	for _, x := range residuals {
+		w.write(x&1, 1)
+	}
+	section := testDataSection(25, 6, 42, 0, 0, 1, 8)
+	section[21] = aecDataMSB | aecDataPreprocess
+	section[22] = 6
+	putOctets(section, 24, 2, 1)
+	values, _, err := decodeGrib2(gribMessage(testGridSection(), section, testValuesSection(w.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertValues(t, values, []float64{10, 10.2, 10.1, 10.1, 10.5, 9})
+}
+
+func TestDecodeGrib2Malformed(t *testing.T) {
+	grid := testGridSection()
+	hugeGrid := testGridSection()
+	putOctets(hugeGrid, 7, 4, math.MaxUint32)
+	groups := testComplexSection(2, 0, 6, 1)
+	groups[36], groups[46] = 255, 255
+	// all ones descriptors of more than 32 bits read as a width of -1 and
+	// a first group length of -10
+	wideWidths := testComplexSection(2, 1, 1, 6)
+	wideWidths[36] = 64
+	wideLengths := testComplexSection(2, 0, 2, 16)
+	putOctets(wideLengths, 38, 4, 0)
+	wideLengths[41], wideLengths[46] = 10, 64
+	wideGroup := testComplexSection(2, 0, 2, 3)
+	wideGroup[35] = 40
+	ones := make([]byte, 32)
+	for i := range ones {
+		ones[i] = 0xff
+	}
+	var zeros bitWriter
+	zeros.write(0, 4)
+	zeros.unary(1000)
+	ccsds := testDataSection(25, 6, 42, 0, 0, 0, 8)
+	ccsds[21], ccsds[22] = aecDataMSB, 2
+	putOctets(ccsds, 24, 2, 1)
+
+	for name, message := range map[string][]byte{
+		"huge grid":          gribMessage(hugeGrid, testDataSection(21, 6, 0, 0, 0, 0, 0), testValuesSection(nil)),
+		"more values":        gribMessage(grid, testDataSection(21, math.MaxUint32, 0, 0, 0, 0, 0), testValuesSection(nil)),
+		"truncated values":   gribMessage(grid, testDataSection(21, 6, 0, 0, 0, 0, 12), testValuesSection([]byte{1, 2})),
+		"group descriptors":  gribMessage(grid, groups, testValuesSection([]byte{1, 2, 3})),
+		"group width bits":   gribMessage(grid, wideWidths, testValuesSection(ones)),
+		"group length bits":  gribMessage(grid, wideLengths, testValuesSection(ones)),
+		"group width":        gribMessage(grid, wideGroup, testValuesSection(ones)),
+		"zero block overrun": gribMessage(grid, ccsds, testValuesSection(zeros.data)),
+	} {
+		if _, _, err := decodeGrib2(message); err == nil {
+			t.Errorf("%s: decoded without an error", name)
+		}
+	}
+}
+
+// Corrupting any octet of the messages above must give an error or values,
+// never a panic or a huge allocation.
+func TestDecodeGrib2Corrupted(t *testing.T) {
+	var w bitWriter
+	for range 6 {
+		w.write(3, 12)
+	}
+	var complexData bitWriter
+	for range 64 {
+		complexData.write(0xa5, 8)
+	}
+	ccsds := testDataSection(25, 6, 42, 0, 0, 0, 8)
+	ccsds[21], ccsds[22] = aecDataMSB|aecDataPreprocess, 2
+	putOctets(ccsds, 24, 2, 2)
+	bitmap := gribSection(6, 7)
+	bitmap[6] = 0xff
+
+	for _, message := range [][]byte{
+		gribMessage(testGridSection(), testDataSection(21, 6, 0, 0, 0, 0, 12), bitmap, testValuesSection(w.data)),
+		gribMessage(testGridSection(), testComplexSection(2, 1, 2, 3), testValuesSection(complexData.data)),
+		gribMessage(testGridSection(), testComplexSection(3, 2, 2, 3), testValuesSection(complexData.data)),
+		gribMessage(testGridSection(), ccsds, testValuesSection(complexData.data)),
+	} {
+		for i := range message {
+			for _, b := range []byte{0x00, 0x01, 0x7f, 0xff} {
+				corrupted := append([]byte(nil), message...)
+				corrupted[i] = b
+				values, _, err := decodeGrib2(corrupted)
+				if err == nil && len(values) != 6 {
+					t.Fatalf("octet %d = %#x: %d values without an error", i+1, b, len(values))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"grib_server/grid"
+)
+
+// The grid code lives in the grib_server/grid package, which has no
+// dependencies so it can also be compiled to WebAssembly; these names keep
+// the rest of the server unchanged.
+type (
+	Grid                = grid.Grid
+	GridSpec            = grid.Spec
+	RegularLatLonGrid   = grid.RegularLatLon
+	ReducedGaussianGrid = grid.ReducedGaussian
+)
+
+// specOf describes g as a GridSpec, the inverse of GridSpec.Grid.
+func specOf(g Grid) GridSpec {
+	return grid.SpecOf(g)
+}
+
+// sameGrid reports whether a and b have the same points in the same order.
+func sameGrid(a, b Grid) bool {
+	return grid.Same(a, b)
+}
+
+// normalizeLon maps lon to [-180, 180).
+func normalizeLon(lon float64) float64 {
+	return grid.NormalizeLon(lon)
+}
+
+// defaultGrid is the 0.25° global grid of the ECMWF open data, used for cache
+// files written before the grid was recorded.
+var defaultGrid = RegularLatLonGrid{
+	Ni:       Ni,
+	Nj:       Nj,
+	LatFirst: LatFirst,
+	LonFirst: LonFirst,
+	LatStep:  LatStep,
+	LonStep:  LonStep,
+}
+
+var defaultGridSpec = GridSpec{
+	Type:     "regular_ll",
+	Ni:       Ni,
+	Nj:       Nj,
+	LatFirst: LatFirst,
+	LonFirst: LonFirst,
+	LatStep:  LatStep,
+	LonStep:  LonStep,
+}
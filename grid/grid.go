@@ -0,0 +1,410 @@
+// Package grid maps coordinates onto the value arrays of decoded GRIB
+// messages: nearest-point lookup, bilinear interpolation and the grid
+// descriptions Griber serves next to its data. It depends on nothing but the
+// standard library so the same code runs in the server and, compiled to
+// WebAssembly (see grid/wasm), in the browser.
+package grid
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// ErrOutOfGrid means a coordinate does not fall on the grid.
+var ErrOutOfGrid = errors.New("coordinate out of grid")
+
+// Grid maps coordinates onto the flat value array of one decoded GRIB message.
+// lat: (-90 to 90), lon: any value, it is normalized by the grid.
+type Grid interface {
+	// Index returns the slice index of the grid point nearest to (lat, lon).
+	Index(lat, lon float64) (int, error)
+	// Coord returns the coordinate of a slice index, lon in [-180, 180).
+	Coord(index int) (lat, lon float64)
+	// Size is the number of values a message on this grid carries.
+	Size() int
+	// Bilinear returns the four points surrounding (lat, lon) and their
+	// bilinear interpolation weights, which sum to 1.
+	Bilinear(lat, lon float64) ([4]int, [4]float64)
+	// Neighborhood returns the indices of the n×n points centred on the point
+	// nearest to (lat, lon), north to south, west to east. Rows beyond the
+	// poles and columns beyond the edge of a regional grid are left out.
+	Neighborhood(lat, lon float64, n int) []int
+}
+
+// Spec is the serializable description of a grid. Griber stores it next to
+// the values of every cached field, so whoever holds both can index them.
+type Spec struct {
+	Type     string  `json:"type"` // "regular_ll" or "reduced_gg"
+	Ni       int     `json:"ni,omitempty"`
+	Nj       int     `json:"nj,omitempty"`
+	LatFirst float64 `json:"lat_first,omitempty"`
+	LonFirst float64 `json:"lon_first,omitempty"`
+	LatStep  float64 `json:"lat_step,omitempty"`
+	LonStep  float64 `json:"lon_step,omitempty"`
+	N        int     `json:"n,omitempty"`  // gaussian number, reduced_gg only
+	PL       []int   `json:"pl,omitempty"` // points per latitude row, reduced_gg only
+}
+
+// Grid builds the Grid described by the spec.
+func (s Spec) Grid() (Grid, error) {
+	switch s.Type {
+	case "regular_ll":
+		if s.Ni <= 0 || s.Nj <= 0 || s.LatStep <= 0 || s.LonStep <= 0 {
+			return nil, fmt.Errorf("invalid regular_ll grid %dx%d step %g/%g", s.Ni, s.Nj, s.LatStep, s.LonStep)
+		}
+		return RegularLatLon{
+			Ni:       s.Ni,
+			Nj:       s.Nj,
+			LatFirst: s.LatFirst,
+			LonFirst: s.LonFirst,
+			LatStep:  s.LatStep,
+			LonStep:  s.LonStep,
+		}, nil
+	case "reduced_gg":
+		return NewReducedGaussian(s.N, s.PL)
+	default:
+		return nil, fmt.Errorf("unsupported grid type %q", s.Type)
+	}
+}
+
+// SpecOf describes g as a Spec, the inverse of Spec.Grid.
+func SpecOf(g Grid) Spec {
+	switch g := g.(type) {
+	case RegularLatLon:
+		return Spec{
+			Type:     "regular_ll",
+			Ni:       g.Ni,
+			Nj:       g.Nj,
+			LatFirst: g.LatFirst,
+			LonFirst: g.LonFirst,
+			LatStep:  g.LatStep,
+			LonStep:  g.LonStep,
+		}
+	case *ReducedGaussian:
+		return Spec{Type: "reduced_gg", N: g.N, PL: g.PL}
+	}
+	return Spec{}
+}
+
+// Same reports whether a and b have the same points in the same order.
+func Same(a, b Grid) bool {
+	specA, specB := SpecOf(a), SpecOf(b)
+	return specA.Type != "" && specA.Type == specB.Type &&
+		specA.Ni == specB.Ni && specA.Nj == specB.Nj &&
+		specA.LatFirst == specB.LatFirst && specA.LonFirst == specB.LonFirst &&
+		specA.LatStep == specB.LatStep && specA.LonStep == specB.LonStep &&
+		specA.N == specB.N && slices.Equal(specA.PL, specB.PL)
+}
+
+// RegularLatLon is a lat-lon grid scanned west to east, then north to south.
+type RegularLatLon struct {
+	Ni       int
+	Nj       int
+	LatFirst float64
+	LonFirst float64
+	LatStep  float64
+	LonStep  float64
+}
+
+// Global reports whether the grid wraps around the globe in longitude.
+func (g RegularLatLon) Global() bool {
+	return float64(g.Ni)*g.LonStep >= 360-g.LonStep/2
+}
+
+func (g RegularLatLon) Index(lat, lon float64) (int, error) {
+	if !finite(lat, lon) {
+		return -1, fmt.Errorf("%w: lat %g, lon %g", ErrOutOfGrid, lat, lon)
+	}
+	// Offset from LonFirst, wrapping around 360
+	lonOffset := math.Mod(lon-g.LonFirst, 360)
+	if lonOffset < 0 {
+		lonOffset += 360
+	}
+
+	// calc nearest lon index
+	i := int(math.Round(lonOffset / g.LonStep))
+	if g.Global() {
+		i %= g.Ni
+	} else if i >= g.Ni {
+		return -1, fmt.Errorf("%w: lon %g", ErrOutOfGrid, lon)
+	}
+
+	// GRIB scan from North to South
+	j := int(math.Round((g.LatFirst - lat) / g.LatStep))
+
+	// no looping but constraint
+	if j < 0 {
+		j = 0
+	}
+	if j >= g.Nj {
+		j = g.Nj - 1
+	}
+
+	index := (j * g.Ni) + i
+	if index < 0 || index >= g.Size() {
+		return -1, fmt.Errorf("%w: index %d out of range [0, %d)", ErrOutOfGrid, index, g.Size())
+	}
+	return index, nil
+}
+
+func (g RegularLatLon) Coord(index int) (float64, float64) {
+	j := index / g.Ni
+	i := index % g.Ni
+	return g.LatFirst - float64(j)*g.LatStep, NormalizeLon(g.LonFirst + float64(i)*g.LonStep)
+}
+
+func (g RegularLatLon) Size() int {
+	return g.Ni * g.Nj
+}
+
+func (g RegularLatLon) Bilinear(lat, lon float64) ([4]int, [4]float64) {
+	lonOffset := math.Mod(lon-g.LonFirst, 360)
+	if lonOffset < 0 {
+		lonOffset += 360
+	}
+	fi := lonOffset / g.LonStep
+	i0 := int(math.Floor(fi))
+	i1 := i0 + 1
+	if g.Global() {
+		i0 %= g.Ni
+		i1 %= g.Ni
+	} else {
+		i0 = clampInt(i0, 0, g.Ni-1)
+		i1 = clampInt(i1, 0, g.Ni-1)
+		fi = math.Min(fi, float64(g.Ni-1))
+	}
+
+	fj := math.Max(0, math.Min((g.LatFirst-lat)/g.LatStep, float64(g.Nj-1)))
+	j0 := int(math.Floor(fj))
+	j1 := clampInt(j0+1, 0, g.Nj-1)
+
+	wi := fi - math.Floor(fi)
+	wj := fj - float64(j0)
+	return [4]int{j0*g.Ni + i0, j0*g.Ni + i1, j1*g.Ni + i0, j1*g.Ni + i1},
+		[4]float64{(1 - wi) * (1 - wj), wi * (1 - wj), (1 - wi) * wj, wi * wj}
+}
+
+func (g RegularLatLon) Neighborhood(lat, lon float64, n int) []int {
+	center, err := g.Index(lat, lon)
+	if err != nil {
+		return nil
+	}
+	j, i := center/g.Ni, center%g.Ni
+	half := n / 2
+	indices := make([]int, 0, n*n)
+	for jj := j - half; jj <= j+half; jj++ {
+		if jj < 0 || jj >= g.Nj {
+			continue
+		}
+		for ii := i - half; ii <= i+half; ii++ {
+			col := ii
+			if g.Global() {
+				col = (ii%g.Ni + g.Ni) % g.Ni
+			} else if col < 0 || col >= g.Ni {
+				continue
+			}
+			indices = append(indices, jj*g.Ni+col)
+		}
+	}
+	return indices
+}
+
+// ReducedGaussian is a global reduced Gaussian grid: rows sit on the
+// Gaussian latitudes, each row i holds PL[i] equally spaced points starting at
+// 0° longitude. Rows are stored north to south.
+type ReducedGaussian struct {
+	N       int
+	PL      []int
+	lats    []float64 // row latitudes, north to south
+	offsets []int     // slice index of the first point of each row
+}
+
+func NewReducedGaussian(n int, pl []int) (*ReducedGaussian, error) {
+	if n <= 0 || len(pl) != 2*n {
+		return nil, fmt.Errorf("invalid reduced_gg grid: N=%d with %d rows", n, len(pl))
+	}
+	offsets := make([]int, len(pl)+1)
+	for row, points := range pl {
+		if points <= 0 {
+			return nil, fmt.Errorf("invalid reduced_gg grid: row %d has %d points", row, points)
+		}
+		offsets[row+1] = offsets[row] + points
+	}
+	return &ReducedGaussian{
+		N:       n,
+		PL:      pl,
+		lats:    gaussianLatitudes(n),
+		offsets: offsets,
+	}, nil
+}
+
+func (g *ReducedGaussian) Index(lat, lon float64) (int, error) {
+	if !finite(lat, lon) {
+		return -1, fmt.Errorf("%w: lat %g, lon %g", ErrOutOfGrid, lat, lon)
+	}
+	// first row south of (or on) lat, then pick the closer of it and the one above
+	row := sort.Search(len(g.lats), func(k int) bool { return g.lats[k] <= lat })
+	if row == len(g.lats) {
+		row--
+	} else if row > 0 && g.lats[row-1]-lat < lat-g.lats[row] {
+		row--
+	}
+
+	lonOffset := math.Mod(lon, 360)
+	if lonOffset < 0 {
+		lonOffset += 360
+	}
+	points := g.PL[row]
+	i := int(math.Round(lonOffset/(360/float64(points)))) % points
+	return g.offsets[row] + i, nil
+}
+
+func (g *ReducedGaussian) Coord(index int) (float64, float64) {
+	row := sort.Search(len(g.PL), func(k int) bool { return g.offsets[k+1] > index })
+	if row == len(g.PL) {
+		row--
+	}
+	i := index - g.offsets[row]
+	return g.lats[row], NormalizeLon(float64(i) * 360 / float64(g.PL[row]))
+}
+
+func (g *ReducedGaussian) Size() int {
+	return g.offsets[len(g.PL)]
+}
+
+// Neighborhood takes the rows above and below the nearest row, and on each row
+// the n points around the one nearest to lon.
+func (g *ReducedGaussian) Neighborhood(lat, lon float64, n int) []int {
+	center, _ := g.Index(lat, lon)
+	row := sort.Search(len(g.PL), func(k int) bool { return g.offsets[k+1] > center })
+	lonOffset := math.Mod(lon, 360)
+	if lonOffset < 0 {
+		lonOffset += 360
+	}
+	half := n / 2
+	indices := make([]int, 0, n*n)
+	for r := row - half; r <= row+half; r++ {
+		if r < 0 || r >= len(g.PL) {
+			continue
+		}
+		points := g.PL[r]
+		i := int(math.Round(lonOffset / (360 / float64(points))))
+		for ii := i - half; ii <= i+half; ii++ {
+			indices = append(indices, g.offsets[r]+(ii%points+points)%points)
+		}
+	}
+	return indices
+}
+
+// Bilinear interpolates along the rows north and south of lat, then between
+// the two rows. North of the first row (and south of the last) the nearest
+// row is used on its own.
+func (g *ReducedGaussian) Bilinear(lat, lon float64) ([4]int, [4]float64) {
+	south := sort.Search(len(g.lats), func(k int) bool { return g.lats[k] <= lat })
+	north := south - 1
+	wj := 0.0
+	switch {
+	case south == len(g.lats):
+		south, north = len(g.lats)-1, len(g.lats)-1
+	case north < 0:
+		north = 0
+	default:
+		wj = (g.lats[north] - lat) / (g.lats[north] - g.lats[south])
+	}
+
+	lonOffset := math.Mod(lon, 360)
+	if lonOffset < 0 {
+		lonOffset += 360
+	}
+	rowPoints := func(row int) (int, int, float64) {
+		points := g.PL[row]
+		fi := lonOffset / (360 / float64(points))
+		i0 := int(math.Floor(fi))
+		return g.offsets[row] + i0%points, g.offsets[row] + (i0+1)%points, fi - float64(i0)
+	}
+	n0, n1, wn := rowPoints(north)
+	s0, s1, ws := rowPoints(south)
+	return [4]int{n0, n1, s0, s1},
+		[4]float64{(1 - wn) * (1 - wj), wn * (1 - wj), (1 - ws) * wj, ws * wj}
+}
+
+// Interpolate returns the bilinear estimate at (lat, lon), renormalizing the
+// weights over the non-missing (NaN) neighbours.
+func Interpolate(g Grid, values []float64, lat, lon float64) float64 {
+	indices, weights := g.Bilinear(lat, lon)
+	sum, weight := 0.0, 0.0
+	for k, index := range indices {
+		if weights[k] == 0 || math.IsNaN(values[index]) {
+			continue
+		}
+		sum += weights[k] * values[index]
+		weight += weights[k]
+	}
+	if weight == 0 {
+		return math.NaN()
+	}
+	return sum / weight
+}
+
+// gaussianLatitudes returns the 2n Gaussian latitudes (roots of the Legendre
+// polynomial of degree 2n) in degrees, north to south.
+func gaussianLatitudes(n int) []float64 {
+	nlat := 2 * n
+	lats := make([]float64, nlat)
+	for k := 0; k < n; k++ {
+		x := math.Cos(math.Pi * (float64(k) + 0.75) / (float64(nlat) + 0.5))
+		for iter := 0; iter < 100; iter++ {
+			p, dp := legendre(nlat, x)
+			dx := p / dp
+			x -= dx
+			if math.Abs(dx) < 1e-15 {
+				break
+			}
+		}
+		lat := math.Asin(x) * 180 / math.Pi
+		lats[k] = lat
+		lats[nlat-1-k] = -lat
+	}
+	return lats
+}
+
+// legendre evaluates P_n(x) and its derivative.
+func legendre(n int, x float64) (float64, float64) {
+	p0, p1 := 1.0, x
+	for k := 2; k <= n; k++ {
+		p0, p1 = p1, (float64(2*k-1)*x*p1-float64(k-1)*p0)/float64(k)
+	}
+	dp := float64(n) * (x*p1 - p0) / (x*x - 1)
+	return p1, dp
+}
+
+// NormalizeLon maps lon to [-180, 180).
+func NormalizeLon(lon float64) float64 {
+	if lon >= -180 && lon < 180 {
+		return lon // avoid rounding noise from the round trip below
+	}
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
+
+// finite reports whether lat and lon are both numbers that are not infinite;
+// anything else would index a wrong point, not none.
+func finite(lat, lon float64) bool {
+	return !math.IsNaN(lat) && !math.IsInf(lat, 0) && !math.IsNaN(lon) && !math.IsInf(lon, 0)
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
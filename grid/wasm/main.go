@@ -0,0 +1,116 @@
+//go:build js && wasm
+
+// Command wasm exposes the grid package to JavaScript, so browser front ends
+// can interpolate points on fields fetched from Griber (e.g. /admin/export or
+// /regrid) without a request per point.
+//
+//	GOOS=js GOARCH=wasm go build -o griber-grid.wasm ./grid/wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" .
+//
+// Once loaded it defines a global griberGrid(spec) that takes a grid spec
+// (the "grid" object of a response) and returns an object with
+//
+//	index(lat, lon)               nearest point index, throws off the grid
+//	coord(index)                  [lat, lon]
+//	size()                        number of points
+//	interpolate(values, lat, lon) bilinear value, NaN where all neighbours are missing
+//
+// values is a Float32Array or Float64Array in grid order; null entries of a
+// plain array are taken as missing.
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"syscall/js"
+
+	"grib_server/grid"
+)
+
+func main() {
+	js.Global().Set("griberGrid", js.FuncOf(newGrid))
+	select {}
+}
+
+func newGrid(this js.Value, args []js.Value) any {
+	if len(args) != 1 {
+		return jsError("griberGrid(spec) takes one argument")
+	}
+	var spec grid.Spec
+	if err := json.Unmarshal([]byte(js.Global().Get("JSON").Call("stringify", args[0]).String()), &spec); err != nil {
+		return jsError("invalid grid spec: " + err.Error())
+	}
+	g, err := spec.Grid()
+	if err != nil {
+		return jsError(err.Error())
+	}
+
+	// values are copied once per array and reused while the same array is
+	// passed again, the usual case when interpolating many points of a field
+	var lastValues js.Value
+	var values []float64
+
+	object := map[string]any{
+		"index": js.FuncOf(func(this js.Value, args []js.Value) any {
+			index, err := g.Index(args[0].Float(), args[1].Float())
+			if err != nil {
+				return jsError(err.Error())
+			}
+			return index
+		}),
+		"coord": js.FuncOf(func(this js.Value, args []js.Value) any {
+			lat, lon := g.Coord(args[0].Int())
+			return []any{lat, lon}
+		}),
+		"size": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return g.Size()
+		}),
+		"interpolate": js.FuncOf(func(this js.Value, args []js.Value) any {
+			if !args[0].Equal(lastValues) {
+				values = copyValues(args[0])
+				lastValues = args[0]
+			}
+			if len(values) != g.Size() {
+				return jsError("values do not match the grid size")
+			}
+			return grid.Interpolate(g, values, args[1].Float(), args[2].Float())
+		}),
+	}
+	return js.ValueOf(object)
+}
+
+// copyValues reads a typed array through its bytes, one call instead of one
+// per element; wasm memory is little-endian like the typed arrays.
+func copyValues(array js.Value) []float64 {
+	n := array.Get("length").Int()
+	values := make([]float64, n)
+	switch array.Get("constructor").Get("name").String() {
+	case "Float32Array", "Float64Array":
+		size := array.Get("BYTES_PER_ELEMENT").Int()
+		raw := make([]byte, n*size)
+		bytes := js.Global().Get("Uint8Array").New(array.Get("buffer"), array.Get("byteOffset"), n*size)
+		js.CopyBytesToGo(raw, bytes)
+		for i := range values {
+			if size == 4 {
+				values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:])))
+			} else {
+				values[i] = math.Float64frombits(binary.LittleEndian.Uint64(raw[i*8:]))
+			}
+		}
+	default:
+		for i := range values {
+			v := array.Index(i)
+			if v.IsNull() || v.IsUndefined() {
+				values[i] = math.NaN()
+				continue
+			}
+			values[i] = v.Float()
+		}
+	}
+	return values
+}
+
+func jsError(message string) js.Value {
+	return js.Global().Get("Error").New(message)
+}
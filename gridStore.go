@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The grid store holds decoded parameter grids in memory, one entry per
+// date, batch and parameter, so a query needing only 10u never loads 10v.
+// Each parameter lives in its own file in tmp/ and is loaded, downloaded and
+// evicted on its own.
+
+var windParams = []string{"10u", "10v"}
+
+// ParamGrid is one decoded parameter of a batch.
+type ParamGrid struct {
+	Grid   Grid
+	Values []float64
+}
+
+// file data cache structure, both wind components of a batch
+type FileCache struct {
+	Grid Grid
+	U    []float64
+	V    []float64
+}
+
+// global cache
+var (
+	paramCache   = make(map[string]*ParamGrid)
+	cacheMutex   sync.RWMutex
+	maxCacheSize = 200 // parameter grids
+)
+
+func paramCacheKey(date, batch, param string, step int) string {
+	return date + "|" + batch + "|" + param + "|" + stepName(step)
+}
+
+// get or load file cache, the wind components of a batch. filePath is the
+// legacy combined file of the batch, read if the per parameter files are
+// missing.
+func getOrLoadFileCache(ctx context.Context, filePath string, date string, batch string) (*FileCache, error) {
+	grids, err := getOrLoadParams(ctx, filePath, date, batch, 0, windParams)
+	if err != nil {
+		return nil, err
+	}
+	u, v := grids[0], grids[1]
+	if !sameGrid(u.Grid, v.Grid) || len(u.Values) != len(v.Values) {
+		return nil, fmt.Errorf("10u and 10v of %s-%s are on different grids", date, batch)
+	}
+	return &FileCache{Grid: u.Grid, U: u.Values, V: v.Values}, nil
+}
+
+// getOrLoadParams returns the grids of params at a forecast step in order,
+// loading the ones that are not in memory with a single read or download.
+func getOrLoadParams(ctx context.Context, filePath string, date string, batch string, step int, params []string) ([]*ParamGrid, error) {
+	grids := make([]*ParamGrid, len(params))
+	var missing []string
+	refresh := refreshRequested(ctx)
+	cacheMutex.RLock()
+	for i, param := range params {
+		if !refresh {
+			grids[i] = paramCache[paramCacheKey(date, batch, param, step)]
+		}
+		if grids[i] == nil {
+			missing = append(missing, param)
+		}
+	}
+	cacheMutex.RUnlock()
+	addLogCount(ctx, "cache_hits", int64(len(params)-len(missing)))
+	if len(missing) == 0 {
+		return grids, nil
+	}
+	addLogCount(ctx, "cache_misses", int64(len(missing)))
+
+	// cache not exist, read file
+	done, err := beginColdLoad()
+	if err != nil {
+		setLogField(ctx, "shed", true)
+		return nil, err
+	}
+	loaded, err := loadParams(ctx, filePath, date, batch, step, missing)
+	done()
+	if err != nil {
+		return nil, err
+	}
+
+	// write to cache
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	for _, param := range missing {
+		// check cache size, if over limit, clear old cache
+		if len(paramCache) >= maxCacheSize {
+			// simple strategy: clear all cache
+			clear(paramCache)
+			log.Printf("Cache size exceeded %d, cleared all cache", maxCacheSize)
+		}
+		paramCache[paramCacheKey(date, batch, param, step)] = loaded[param]
+	}
+	for i, param := range params {
+		if grids[i] == nil {
+			grids[i] = loaded[param]
+		}
+	}
+	return grids, nil
+}
+
+// loadParams reads params from their files, falling back to the legacy
+// combined file and then to downloading whatever is still missing. A
+// refresh=true request downloads them all.
+func loadParams(ctx context.Context, filePath string, date string, batch string, step int, params []string) (map[string]*ParamGrid, error) {
+	start := time.Now()
+	source := cacheStateDisk
+	loaded := make(map[string]*ParamGrid, len(params))
+
+	var missing []string
+	refresh := refreshRequested(ctx)
+	if refresh {
+		missing, params = params, nil
+	}
+	for _, param := range params {
+		grid, err := readStoredParam(date, batch, param, step)
+		if errors.Is(err, os.ErrNotExist) && step == 0 {
+			grid, err = readParamFile(paramFilePath(date, batch, param))
+		}
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, errCorruptObject) {
+			missing = append(missing, param)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		loaded[param] = grid
+	}
+	if len(missing) > 0 && !refresh && step == 0 {
+		legacy, err := readLegacyCacheFile(filePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		missing = slices.DeleteFunc(missing, func(param string) bool {
+			if grid, ok := legacy[param]; ok {
+				loaded[param] = grid
+				return true
+			}
+			return false
+		})
+	}
+	if len(missing) > 0 {
+		// file not exist, try to download
+		source = cacheStateRemote
+		key := date + "-" + batch + "-" + stepName(step) + "-" + strings.Join(missing, ",")
+		shared, err := downloads.Do(ctx, key, func(ctx context.Context) error {
+			return downloadAndSave(ctx, date, batch, step, missing)
+		})
+		if shared {
+			setLogField(ctx, "download_shared", true)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("download failed: %w", err)
+		}
+		// read again
+		for _, param := range missing {
+			grid, err := readStoredParam(date, batch, param, step)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file after download: %w", err)
+			}
+			loaded[param] = grid
+		}
+	}
+
+	observeLoadLatency(source, time.Since(start))
+	return loaded, nil
+}
+
+// readParamFile reads a tmp/<date>-<batch>-<param>.json file, written before
+// grids were content addressed.
+func readParamFile(path string) (*ParamGrid, error) {
+	file, err := openGridFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	defer file.Close()
+	var data paramFile
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json from %s: %w", path, err)
+	}
+	return decodeParamFile(path, data)
+}
+
+func decodeParamFile(path string, data paramFile) (*ParamGrid, error) {
+	values, err := data.values()
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack %s: %w", path, err)
+	}
+	if len(values) == 0 || data.Grid == nil {
+		return nil, fmt.Errorf("json data in %s is empty or missing", path)
+	}
+	grid, err := data.Grid.Grid()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read grid of %s: %w", path, err)
+	}
+	return &ParamGrid{Grid: grid, Values: values}, nil
+}
+
+// readLegacyCacheFile reads a combined tmp/<date>-<batch>.json file.
+func readLegacyCacheFile(path string) (map[string]*ParamGrid, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	var data cacheFile
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json from %s: %w", path, err)
+	}
+	if len(data.U) == 0 || len(data.V) == 0 {
+		return nil, fmt.Errorf("json data in %s is empty or missing", path)
+	}
+	grid, err := data.grid()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read grid of %s: %w", path, err)
+	}
+	return map[string]*ParamGrid{
+		"10u": {Grid: grid, Values: data.U},
+		"10v": {Grid: grid, Values: data.V},
+	}, nil
+}
+
+// batchOnDisk reports whether the wind components of a batch are in tmp/,
+// either per parameter or in a legacy combined file.
+func batchOnDisk(filePath, date, batch string) bool {
+	if _, err := os.Stat(filePath); err == nil {
+		return true
+	}
+	for _, param := range windParams {
+		entry, ok := manifest.get(manifestKey(date, batch, param, stepName(0)))
+		if !ok || !gridFileExists(entry.path()) {
+			if !gridFileExists(paramFilePath(date, batch, param)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// batchCached reports whether the wind components of a batch are in memory.
+func batchCached(date, batch string) bool {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	for _, param := range windParams {
+		if paramCache[paramCacheKey(date, batch, param, 0)] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// fileCacheStats returns the number of cached parameter grids and the bytes
+// their values occupy.
+func fileCacheStats() (int, int64) {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	var bytes int64
+	for _, grid := range paramCache {
+		bytes += int64(len(grid.Values)) * 8
+	}
+	return len(paramCache), bytes
+}
+
+func ClearDateRangeCache() {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	clear(paramCache)
+	log.Println("DateRange API cache cleared")
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// With -grpc-listen the queries of /api, /range, /daterange and /typhoon are
+// also served as the gRPC service griber.v1.Griber, for internal services
+// that want typed clients. Its schema is served on GET /griber.proto (see
+// grpcProto.go) and through server reflection, so grpcurl works without it.
+// Range streams the lattice in chunks instead of one message, large grids
+// being over gRPC's default 4MB message limit. Requests are validated as
+// their HTTP counterparts; tokens are sent as "authorization: Bearer <token>"
+// or "x-api-key" metadata and need the reader role, and "accept-language"
+// metadata localizes the status messages. Errors carry the gRPC code of the
+// HTTP status the endpoint would answer. The HTTP rate limits do not apply.
+
+const (
+	grpcServiceName = "Griber"
+	grpcRangeChunk  = 16384 // points per RangeChunk
+)
+
+// grpcListen is -grpc-listen, the address of the gRPC service, "" disables
+// it.
+var grpcListen = ""
+
+var (
+	grpcFile   protoreflect.FileDescriptor
+	grpcServer *grpc.Server
+)
+
+// startGRPC serves the gRPC service on grpcListen.
+func startGRPC() error {
+	var err error
+	if grpcFile, err = grpcFileDescriptor(); err != nil {
+		return fmt.Errorf("griber.proto: %w", err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(grpcFile); err != nil {
+		return fmt.Errorf("griber.proto: %w", err)
+	}
+	listener, err := net.Listen("tcp", grpcListen)
+	if err != nil {
+		return err
+	}
+
+	grpcServer = grpc.NewServer(grpc.UnaryInterceptor(grpcUnaryCall), grpc.StreamInterceptor(grpcStreamCall))
+	grpcServer.RegisterService(&grpc.ServiceDesc{
+		ServiceName: grpcPackage + "." + grpcServiceName,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			grpcUnaryMethod("Single", "SingleRequest", grpcSingle),
+			grpcUnaryMethod("DateRange", "DateRangeRequest", grpcDateRange),
+			grpcUnaryMethod("Typhoon", "TyphoonRequest", grpcTyphoon),
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Range", Handler: grpcRange, ServerStreams: true},
+		},
+		Metadata: grpcFile.Path(),
+	}, struct{}{})
+	reflection.Register(grpcServer)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopGRPC lets the calls in flight finish until ctx is done, then cancels
+// them.
+func stopGRPC(ctx context.Context) {
+	if grpcServer == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+	}
+}
+
+// grpcProtoHandler serves GET /griber.proto
+func grpcProtoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(grpcProtoText())); err != nil {
+		log.Printf("Met Error when writing griber.proto to ResponseWriter: %v", err)
+	}
+}
+
+// grpcUnaryMethod adapts call, taking a message named input, to a unary
+// method.
+func grpcUnaryMethod(name, input string, call func(ctx context.Context, req protoMsg) (protoMsg, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := newProtoMsg(input)
+			if err := dec(req.Message); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, _ any) (any, error) {
+				reply, err := call(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				return reply.Message, nil
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcPackage + "." + grpcServiceName + "/" + name}
+			return interceptor(ctx, req.Message, info, handler)
+		},
+	}
+}
+
+// grpcCall checks the caller's role and gives the call its language and log
+// fields, like the HTTP middleware does for requests.
+func grpcCall(ctx context.Context) (context.Context, *logFields, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	lang := parseAcceptLanguage(first("accept-language"))
+	ctx = context.WithValue(ctx, languageKey{}, lang)
+	ctx, fields := withLogFields(ctx)
+
+	token, ok := strings.CutPrefix(first("authorization"), "Bearer ")
+	if !ok {
+		token = first("x-api-key")
+	}
+	role := tokenRole(token)
+	setLogField(ctx, "role", role)
+	switch {
+	case role == "":
+		return ctx, fields, status.Error(codes.Unauthenticated, statusText(lang, http.StatusUnauthorized))
+	case roleRank[role] < roleRank[roleReader]:
+		return ctx, fields, status.Error(codes.PermissionDenied, statusText(lang, http.StatusForbidden))
+	}
+	return ctx, fields, nil
+}
+
+func grpcUnaryCall(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, fields, err := grpcCall(ctx)
+	var reply any
+	if err == nil {
+		reply, err = handler(ctx, req)
+	}
+	logGRPC(info.FullMethod, start, err, fields)
+	return reply, err
+}
+
+// grpcContextStream is a stream whose calls see ctx.
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s grpcContextStream) Context() context.Context { return s.ctx }
+
+func grpcStreamCall(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, fields, err := grpcCall(stream.Context())
+	if err == nil {
+		err = handler(srv, grpcContextStream{stream, ctx})
+	}
+	logGRPC(info.FullMethod, start, err, fields)
+	return err
+}
+
+func logGRPC(method string, start time.Time, err error, fields *logFields) {
+	log.Printf("GRPC %s code=%s duration=%s%s", method, status.Code(err), time.Since(start).Round(time.Microsecond), fields)
+}
+
+// grpcError turns a query error into the status of the HTTP status it maps
+// to.
+func grpcError(ctx context.Context, err error) error {
+	setLogField(ctx, "error", err)
+	httpStatus := queryErrorStatus(err)
+	code := codes.Internal
+	switch httpStatus {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	if errors.Is(err, context.Canceled) {
+		code = codes.Canceled
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, statusText(languageFrom(ctx), httpStatus))
+}
+
+// grpcParams parses the param, params and derived fields of req.
+func grpcParams(req protoMsg) (param string, paramList, derived []string, err error) {
+	if param, err = parseQueryParam(req.str("param")); err != nil {
+		return "", nil, nil, err
+	}
+	paramList, err = parseQueryParams(strings.Join(req.strings("params"), ","))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if paramList != nil && req.str("param") != "" {
+		return "", nil, nil, fmt.Errorf("%w: both param and params", ErrInvalidParams)
+	}
+	if derived, err = parseDerived(strings.Join(req.strings("derived"), ",")); err != nil {
+		return "", nil, nil, err
+	}
+	return param, paramList, derived, nil
+}
+
+// tableSeries returns the value columns of table, those after its keys.
+func tableSeries(table queryTable, keys int) map[string][]float64 {
+	series := make(map[string][]float64, len(table.Columns)-keys)
+	for j, name := range table.Columns[keys:] {
+		values := make([]float64, len(table.Rows))
+		for i, row := range table.Rows {
+			values[i], _ = row[keys+j].(float64)
+		}
+		series[name] = values
+	}
+	return series
+}
+
+func grpcSingle(ctx context.Context, req protoMsg) (protoMsg, error) {
+	date, batch, timeOfDay := req.str("date"), req.str("batch"), req.str("time")
+	if (batch == "") == (timeOfDay == "") || (timeOfDay != "" && req.int("step") != 0) {
+		return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: either batch or time", ErrInvalidBatch))
+	}
+	if timeOfDay != "" {
+		at, err := parseTimeOfDay(date, timeOfDay)
+		if err != nil {
+			return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		}
+		batch = fmt.Sprintf("%02dz", at.Truncate(batchInterval).Hour()) // the batch before
+	}
+	if err := validateDateBatch(date, batch); err != nil {
+		return protoMsg{}, grpcError(ctx, err)
+	}
+	step, err := parseStep(strconv.Itoa(req.int("step")), batch)
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+	}
+	param, paramList, derived, err := grpcParams(req)
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+	}
+
+	setLogField(ctx, "date", date)
+	setLogField(ctx, "batch", batch)
+	data, err := SingleQuery(ctx, SingleAPIParams{
+		Lat:     req.float("lat"),
+		Lon:     req.float("lon"),
+		Date:    date,
+		Batch:   batch,
+		Step:    step,
+		Param:   param,
+		Params:  paramList,
+		Derived: derived,
+		Time:    timeOfDay,
+	})
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, err)
+	}
+	reply := newProtoMsg("SingleReply")
+	values := make(map[string]float64)
+	for name, series := range tableSeries(data.table(), 0) {
+		values[name] = series[0]
+	}
+	reply.setValues("values", values)
+	return reply, nil
+}
+
+func grpcRange(_ any, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	req := newProtoMsg("RangeRequest")
+	if err := stream.RecvMsg(req.Message); err != nil {
+		return err
+	}
+	date, batch := req.str("date"), req.str("batch")
+	if err := validateDateBatch(date, batch); err != nil {
+		return grpcError(ctx, err)
+	}
+	step := req.float("step")
+	if !(step > 0) || math.IsInf(step, 0) {
+		return grpcError(ctx, fmt.Errorf("%w: step %g", ErrInvalidParams, step))
+	}
+	smooth := req.float("smooth")
+	if smooth != 0 {
+		var err error
+		if smooth, err = parseSmooth(strconv.FormatFloat(smooth, 'g', -1, 64)); err != nil {
+			return grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+		}
+	}
+	smoothKernel := req.str("smooth_kernel")
+	if smoothKernel == "" {
+		smoothKernel = SmoothGaussian
+	}
+	if smoothKernel != SmoothGaussian && smoothKernel != SmoothBox {
+		return grpcError(ctx, fmt.Errorf("%w: smooth_kernel %q", ErrInvalidParams, smoothKernel))
+	}
+	lead, err := parseStep(strconv.Itoa(req.int("lead")), batch)
+	if err != nil {
+		return grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+	}
+	param, paramList, derived, err := grpcParams(req)
+	if err != nil {
+		return grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+	}
+
+	setLogField(ctx, "date", date)
+	setLogField(ctx, "batch", batch)
+	data, err := RangeQuery(ctx, RangeAPIParams{
+		SLat:         req.float("slat"),
+		SLon:         req.float("slon"),
+		ELat:         req.float("elat"),
+		ELon:         req.float("elon"),
+		Step:         step,
+		Date:         date,
+		Batch:        batch,
+		Lead:         lead,
+		Param:        param,
+		Params:       paramList,
+		Derived:      derived,
+		Smooth:       smooth,
+		SmoothKernel: smoothKernel,
+	})
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+
+	series := tableSeries(data.table(), 2)
+	total := len(data.Lats)
+	for offset := 0; offset < total || offset == 0; offset += grpcRangeChunk {
+		end := min(offset+grpcRangeChunk, total)
+		chunk := newProtoMsg("RangeChunk")
+		chunk.set("offset", uint32(offset))
+		chunk.set("total", uint32(total))
+		chunk.setFloats("lats", data.Lats[offset:end])
+		chunk.setFloats("lons", data.Lons[offset:end])
+		columns := make(map[string][]float64, len(series))
+		for name, values := range series {
+			columns[name] = values[offset:end]
+		}
+		chunk.setColumns("columns", columns)
+		if err := stream.SendMsg(chunk.Message); err != nil {
+			return err
+		}
+		addLogCount(ctx, "chunks", 1)
+	}
+	return nil
+}
+
+func grpcDateRange(ctx context.Context, req protoMsg) (protoMsg, error) {
+	startDate, endDate, batch := req.str("start_date"), req.str("end_date"), req.str("batch")
+	if err := validateDateBatch(startDate, batch); err != nil {
+		return protoMsg{}, grpcError(ctx, err)
+	}
+	if !isValidDateFormat(endDate) {
+		return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: %q", ErrInvalidDate, endDate))
+	}
+	step, err := parseStep(strconv.Itoa(req.int("step")), batch)
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+	}
+	param, paramList, derived, err := grpcParams(req)
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: %w", ErrInvalidParams, err))
+	}
+
+	setLogField(ctx, "date", startDate+"-"+endDate)
+	setLogField(ctx, "batch", batch)
+	data, err := DateRangeQuery(ctx, DateRangeAPIParams{
+		Lat:       req.float("lat"),
+		Lon:       req.float("lon"),
+		StartDate: startDate,
+		EndDate:   endDate,
+		Batch:     batch,
+		Step:      step,
+		Param:     param,
+		Params:    paramList,
+		Derived:   derived,
+	})
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, err)
+	}
+	reply := newProtoMsg("DateRangeReply")
+	reply.setStrings("dates", data.Dates)
+	reply.setColumns("columns", tableSeries(data.table(), 1))
+	return reply, nil
+}
+
+func grpcTyphoon(ctx context.Context, req protoMsg) (protoMsg, error) {
+	date, batch := req.str("date"), req.str("batch")
+	if err := validateDateBatch(date, batch); err != nil {
+		return protoMsg{}, grpcError(ctx, err)
+	}
+	simplifyKm := req.float("simplify_km")
+	if simplifyKm < 0 || math.IsInf(simplifyKm, 0) || math.IsNaN(simplifyKm) {
+		return protoMsg{}, grpcError(ctx, fmt.Errorf("%w: simplify_km %g", ErrInvalidParams, simplifyKm))
+	}
+	lang := languageFrom(ctx)
+	if req.str("lang") != "" {
+		lang = parseAcceptLanguage(req.str("lang"))
+	}
+
+	dataset, err := loadedTyphonData()
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, err)
+	}
+	closest, tracks, err := typhoonStorms(dataset, date, batch)
+	if err != nil {
+		return protoMsg{}, grpcError(ctx, err)
+	}
+	stormPoint := func(record []string) protoMsg {
+		point := typhoonPointFor(record)
+		message := newProtoMsg("StormPoint")
+		for name, column := range map[string]int{"sid": colSID, "season": colSeason, "number": colNumber, "basin": colBasin,
+			"subbasin": colSubbasin, "name": colName, "iso_time": colIsoTime, "nature": colNature} {
+			message.set(name, record[column])
+		}
+		message.set("storm_id", stormIDFor(record))
+		message.set("nature_name", natureName(lang, record[colNature]))
+		message.set("lat", point.Lat)
+		message.set("lon", point.Lon)
+		message.set("cat", point.Cat)
+		message.set("category_name", categoryName(lang, dataset.catScale, point.Cat))
+		message.set("wind", convertWind(point.Wind))
+		message.set("pres", point.Pres)
+		return message
+	}
+
+	reply := newProtoMsg("TyphoonReply")
+	for _, sid := range slices.Sorted(maps.Keys(closest)) {
+		reply.append("now", stormPoint(closest[sid]))
+	}
+	for _, name := range slices.Sorted(maps.Keys(tracks)) {
+		for _, number := range slices.Sorted(maps.Keys(tracks[name])) {
+			track := newProtoMsg("Track")
+			track.set("name", name)
+			track.set("number", int32(number))
+			for _, record := range simplifyTrack(tracks[name][number], simplifyKm) {
+				track.append("points", stormPoint(record))
+			}
+			reply.append("tracks", track)
+		}
+	}
+	reply.set("wind_unit", windUnit)
+	setLogField(ctx, "date", date)
+	setLogField(ctx, "batch", batch)
+	return reply, nil
+}
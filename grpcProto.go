@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// The protobuf schema of the gRPC service (see grpcAPI.go) is declared once
+// below. The server builds its descriptors from it at startup and serves it
+// as griber.proto on GET /griber.proto for clients to generate code from, so
+// the two cannot drift apart. Fields are "type name", "repeated type name" or
+// "map<key, value> name", optionally followed by "// comment", and are
+// numbered in order: only ever append fields.
+
+const grpcPackage = "griber.v1"
+
+type protoMessage struct {
+	name   string
+	doc    string
+	fields []string
+}
+
+type protoMethod struct {
+	name, input, output string
+	stream              bool // server streaming
+	doc                 string
+}
+
+var grpcMessages = []protoMessage{
+	{"SingleRequest", "A point, as /api.", []string{
+		"double lat",
+		"double lon",
+		"string date // yyyymmdd",
+		"string batch // 00z, 06z, 12z or 18z, or empty with time",
+		"int32 step // forecast step in hours, 0 is the analysis",
+		"string param // wind (default) or a single parameter such as 2t",
+		"repeated string params // any parameters, instead of param",
+		"repeated string derived // speed and/or dir",
+		"string time // HHMM, interpolated between the batches around it, instead of batch",
+	}},
+	{"SingleReply", "", []string{
+		"map<string, double> values // u and v for wind, else the parameters, and speed and dir; NaN where missing",
+	}},
+	{"RangeRequest", "A lattice, as /range.", []string{
+		"double slat",
+		"double slon",
+		"double elat",
+		"double elon",
+		"double step // spacing in degrees",
+		"string date",
+		"string batch",
+		"int32 lead // forecast step in hours",
+		"string param",
+		"repeated string params",
+		"repeated string derived",
+		"double smooth // smoothing scale in degrees, 0 disables",
+		"string smooth_kernel // gaussian (default) or box",
+	}},
+	{"Column", "", []string{
+		"repeated double values // NaN where missing",
+	}},
+	{"RangeChunk", "Consecutive points of a lattice, streamed in order.", []string{
+		"uint32 offset // of the first point in the lattice",
+		"uint32 total // points in the lattice",
+		"repeated double lats",
+		"repeated double lons",
+		"map<string, Column> columns // keyed like SingleReply.values",
+	}},
+	{"DateRangeRequest", "A point on every date of a range, as /daterange.", []string{
+		"double lat",
+		"double lon",
+		"string start_date",
+		"string end_date",
+		"string batch",
+		"int32 step",
+		"string param",
+		"repeated string params",
+		"repeated string derived",
+	}},
+	{"DateRangeReply", "", []string{
+		"repeated string dates",
+		"map<string, Column> columns // one value per date",
+	}},
+	{"TyphoonRequest", "The storms active at a batch, as /typhoon.", []string{
+		"string date",
+		"string batch",
+		"double simplify_km // Douglas-Peucker tolerance of the tracks, 0 keeps every point",
+		"string lang // of nature_name and category_name, en (default) or zh",
+	}},
+	{"StormPoint", "", []string{
+		"string sid",
+		"string storm_id",
+		"string season",
+		"string number",
+		"string basin",
+		"string subbasin",
+		"string name",
+		"string iso_time",
+		"string nature",
+		"string nature_name",
+		"double lat // NaN where missing, as the following",
+		"double lon",
+		"double cat",
+		"string category_name",
+		"double wind // in wind_unit",
+		"double pres // hPa",
+	}},
+	{"Track", "", []string{
+		"string name",
+		"int32 number",
+		"repeated StormPoint points",
+	}},
+	{"TyphoonReply", "", []string{
+		"repeated StormPoint now // the record of each storm closest to the batch",
+		"repeated Track tracks",
+		"string wind_unit",
+	}},
+}
+
+var grpcMethods = []protoMethod{
+	{"Single", "SingleRequest", "SingleReply", false, ""},
+	{"Range", "RangeRequest", "RangeChunk", true, "Streams the lattice in chunks of up to 16384 points."},
+	{"DateRange", "DateRangeRequest", "DateRangeReply", false, ""},
+	{"Typhoon", "TyphoonRequest", "TyphoonReply", false, ""},
+}
+
+var protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+}
+
+// protoField splits a field declaration into its type, name and comment.
+func protoField(declaration string) (typ, name, comment string) {
+	declaration, comment, _ = strings.Cut(declaration, "//")
+	declaration = strings.TrimSpace(declaration)
+	i := strings.LastIndex(declaration, " ")
+	return declaration[:i], declaration[i+1:], strings.TrimSpace(comment)
+}
+
+// fieldType sets the type of field to typ, a scalar or a message.
+func fieldType(field *descriptorpb.FieldDescriptorProto, typ string) {
+	if kind, ok := protoScalars[typ]; ok {
+		field.Type = kind.Enum()
+		return
+	}
+	field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	field.TypeName = proto.String("." + grpcPackage + "." + typ)
+}
+
+// grpcFileDescriptor builds the descriptor of griber.proto.
+func grpcFileDescriptor() (protoreflect.FileDescriptor, error) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("griber.proto"),
+		Package: proto.String(grpcPackage),
+		Syntax:  proto.String("proto3"),
+	}
+	for _, m := range grpcMessages {
+		message := &descriptorpb.DescriptorProto{Name: proto.String(m.name)}
+		for i, declaration := range m.fields {
+			typ, name, _ := protoField(declaration)
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(name),
+				JsonName: proto.String(protoJSONName(name)),
+				Number:   proto.Int32(int32(i + 1)),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}
+			switch {
+			case strings.HasPrefix(typ, "repeated "):
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+				fieldType(field, strings.TrimPrefix(typ, "repeated "))
+			case strings.HasPrefix(typ, "map<"):
+				key, value, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(typ, "map<"), ">"), ",")
+				if !ok {
+					return nil, fmt.Errorf("%s.%s: bad map type %q", m.name, name, typ)
+				}
+				entryName := strings.ToUpper(name[:1]) + protoJSONName(name)[1:] + "Entry"
+				keyField := &descriptorpb.FieldDescriptorProto{Name: proto.String("key"), JsonName: proto.String("key"), Number: proto.Int32(1), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+				valueField := &descriptorpb.FieldDescriptorProto{Name: proto.String("value"), JsonName: proto.String("value"), Number: proto.Int32(2), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+				fieldType(keyField, strings.TrimSpace(key))
+				fieldType(valueField, strings.TrimSpace(value))
+				message.NestedType = append(message.NestedType, &descriptorpb.DescriptorProto{
+					Name:    proto.String(entryName),
+					Field:   []*descriptorpb.FieldDescriptorProto{keyField, valueField},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				})
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String("." + grpcPackage + "." + m.name + "." + entryName)
+			default:
+				fieldType(field, typ)
+			}
+			message.Field = append(message.Field, field)
+		}
+		file.MessageType = append(file.MessageType, message)
+	}
+	service := &descriptorpb.ServiceDescriptorProto{Name: proto.String(grpcServiceName)}
+	for _, m := range grpcMethods {
+		service.Method = append(service.Method, &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(m.name),
+			InputType:       proto.String("." + grpcPackage + "." + m.input),
+			OutputType:      proto.String("." + grpcPackage + "." + m.output),
+			ServerStreaming: proto.Bool(m.stream),
+		})
+	}
+	file.Service = append(file.Service, service)
+	return protodesc.NewFile(file, nil)
+}
+
+// protoJSONName is the lowerCamelCase JSON name protoc gives a field.
+func protoJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, c := range name {
+		switch {
+		case c == '_':
+			upper = true
+		case upper:
+			b.WriteString(strings.ToUpper(string(c)))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcProtoText is griber.proto.
+func grpcProtoText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Generated by griber, served on /griber.proto.\n\nsyntax = \"proto3\";\n\npackage %s;\n", grpcPackage)
+	for _, m := range grpcMessages {
+		b.WriteString("\n")
+		if m.doc != "" {
+			fmt.Fprintf(&b, "// %s\n", m.doc)
+		}
+		fmt.Fprintf(&b, "message %s {\n", m.name)
+		for i, declaration := range m.fields {
+			typ, name, comment := protoField(declaration)
+			fmt.Fprintf(&b, "  %s %s = %d;", typ, name, i+1)
+			if comment != "" {
+				fmt.Fprintf(&b, " // %s", comment)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("}\n")
+	}
+	fmt.Fprintf(&b, "\nservice %s {\n", grpcServiceName)
+	for _, m := range grpcMethods {
+		if m.doc != "" {
+			fmt.Fprintf(&b, "  // %s\n", m.doc)
+		}
+		output := m.output
+		if m.stream {
+			output = "stream " + output
+		}
+		fmt.Fprintf(&b, "  rpc %s(%s) returns (%s);\n", m.name, m.input, output)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// protoMsg wraps a dynamic message of griber.proto with accessors by field
+// name.
+type protoMsg struct {
+	*dynamicpb.Message
+}
+
+func newProtoMsg(name string) protoMsg {
+	desc := grpcFile.Messages().ByName(protoreflect.Name(name))
+	return protoMsg{dynamicpb.NewMessage(desc)}
+}
+
+func (m protoMsg) field(name string) protoreflect.FieldDescriptor {
+	field := m.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if field == nil {
+		panic(fmt.Sprintf("%s has no field %s", m.Descriptor().Name(), name))
+	}
+	return field
+}
+
+func (m protoMsg) float(name string) float64 { return m.Get(m.field(name)).Float() }
+func (m protoMsg) str(name string) string    { return m.Get(m.field(name)).String() }
+func (m protoMsg) int(name string) int       { return int(m.Get(m.field(name)).Int()) }
+
+func (m protoMsg) strings(name string) []string {
+	list := m.Get(m.field(name)).List()
+	values := make([]string, list.Len())
+	for i := range values {
+		values[i] = list.Get(i).String()
+	}
+	return values
+}
+
+func (m protoMsg) set(name string, value any) {
+	m.Set(m.field(name), protoreflect.ValueOf(value))
+}
+
+func (m protoMsg) setStrings(name string, values []string) {
+	list := m.Mutable(m.field(name)).List()
+	for _, value := range values {
+		list.Append(protoreflect.ValueOfString(value))
+	}
+}
+
+func (m protoMsg) setFloats(name string, values []float64) {
+	list := m.Mutable(m.field(name)).List()
+	for _, value := range values {
+		list.Append(protoreflect.ValueOfFloat64(value))
+	}
+}
+
+func (m protoMsg) append(name string, message protoMsg) {
+	m.Mutable(m.field(name)).List().Append(protoreflect.ValueOfMessage(message.Message))
+}
+
+// setColumns sets a map<string, Column> field.
+func (m protoMsg) setColumns(name string, columns map[string][]float64) {
+	entries := m.Mutable(m.field(name)).Map()
+	for key, values := range columns {
+		column := newProtoMsg("Column")
+		column.setFloats("values", values)
+		entries.Set(protoreflect.ValueOfString(key).MapKey(), protoreflect.ValueOfMessage(column.Message))
+	}
+}
+
+// setValues sets a map<string, double> field.
+func (m protoMsg) setValues(name string, values map[string]float64) {
+	entries := m.Mutable(m.field(name)).Map()
+	for key, value := range values {
+		entries.Set(protoreflect.ValueOfString(key).MapKey(), protoreflect.ValueOfFloat64(value))
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// /healthz is the liveness probe, answering as long as the process serves
+// HTTP. /readyz is the readiness probe and checks what the server depends
+// on: tmp/ must be writable or nothing can be ingested, so it makes the
+// server unready; the GRIB decoder (see decoders.go), the storage bucket and
+// IBTrACS only set degraded, as cached grids and the other endpoints are
+// still served without them.
+
+const (
+	storageCheckTimeout = 2 * time.Second
+	// storageCheckTTL spaces the requests to storage.googleapis.com, probes
+	// come every few seconds from every replica.
+	storageCheckTTL = 30 * time.Second
+)
+
+var startedAt = time.Now()
+
+type ComponentStatus struct {
+	OK         bool       `json:"ok"`
+	Error      string     `json:"error,omitempty"`
+	Detail     string     `json:"detail,omitempty"`
+	Records    int        `json:"records,omitempty"`
+	LoadedAt   *time.Time `json:"loaded_at,omitempty"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+}
+
+type ReadyResponse struct {
+	Ready      bool                       `json:"ready"`
+	Degraded   bool                       `json:"degraded"` // some endpoints are unavailable
+	Components map[string]ComponentStatus `json:"components"`
+	Features   map[string]bool            `json:"features"`   // experimental features and whether they are on
+	ColdLoads  int64                      `json:"cold_loads"` // grid loads in flight, see loadshed.go
+	ColdShed   int64                      `json:"cold_shed"`  // cold loads rejected since start
+	Status     int                        `json:"status"`
+}
+
+// healthzHandler reports that the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"alive":          true,
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+		"status":         http.StatusOK,
+	})
+}
+
+// readyzHandler reports whether the server can take traffic. A missing
+// optional dataset does not make it unready, it only sets degraded.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	response := ReadyResponse{
+		Ready:      true,
+		Components: make(map[string]ComponentStatus),
+		Features:   enabledFeatures,
+		ColdLoads:  coldDepth.Load(),
+		ColdShed:   coldShed.Load(),
+		Status:     http.StatusOK,
+	}
+
+	dataset := currentTyphonData()
+	ibtracs := ComponentStatus{OK: dataset.err == nil, Records: len(dataset.records)}
+	if dataset.err != nil {
+		ibtracs.Error = dataset.err.Error()
+		response.Degraded = true
+	} else {
+		ibtracs.LoadedAt = &dataset.loadedAt
+	}
+	response.Components["ibtracs"] = ibtracs
+
+	tmp := checkTmpDir()
+	if !tmp.OK {
+		response.Ready = false
+		response.Status = http.StatusServiceUnavailable
+	}
+	response.Components["tmp_dir"] = tmp
+
+	decoder := decoderStatus()
+	if !decoder.OK {
+		response.Degraded = true
+	}
+	response.Components["decoder"] = decoder
+
+	storage := storageCheck.status(r.Context())
+	if !storage.OK {
+		response.Degraded = true
+	}
+	response.Components["storage"] = storage
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkTmpDir creates and removes a file in tmp/.
+func checkTmpDir() ComponentStatus {
+	start := time.Now()
+	status := ComponentStatus{OK: true, Detail: tmpDir}
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		status.OK, status.Error = false, err.Error()
+		return status
+	}
+	file, err := os.CreateTemp(tmpDir, ".readyz-*"+partialSuffix)
+	if err != nil {
+		status.OK, status.Error = false, err.Error()
+		return status
+	}
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		status.OK, status.Error = false, err.Error()
+	}
+	status.DurationMs = time.Since(start).Milliseconds()
+	return status
+}
+
+var storageCheck = &cachedCheck{check: checkStorage}
+
+// cachedCheck runs check at most once per storageCheckTTL, concurrent
+// probes in between get the last result.
+type cachedCheck struct {
+	mu      sync.Mutex
+	check   func(ctx context.Context) ComponentStatus
+	last    ComponentStatus
+	checked time.Time
+}
+
+func (c *cachedCheck) status(ctx context.Context) ComponentStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checked.IsZero() || time.Since(c.checked) >= storageCheckTTL {
+		c.checked = time.Now()
+		c.last = c.check(ctx)
+		checkedAt := c.checked.UTC()
+		c.last.CheckedAt = &checkedAt
+	}
+	return c.last
+}
+
+// checkStorage reports whether the bucket answers, any HTTP response but a
+// server error counts. With -fixtures there is nothing to reach.
+func checkStorage(ctx context.Context) ComponentStatus {
+	if fixtures, ok := source.(fixtureSource); ok {
+		_, err := os.Stat(fixtures.dir)
+		status := ComponentStatus{OK: err == nil, Detail: "fixtures " + fixtures.dir}
+		if err != nil {
+			status.Error = err.Error()
+		}
+		return status
+	}
+
+	start := time.Now()
+	target := makeUrl("storage.googleapis.com", "/"+bucketName)
+	status := ComponentStatus{Detail: target}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), storageCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	resp, err := http.DefaultClient.Do(req)
+	status.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	resp.Body.Close()
+	status.OK = resp.StatusCode < http.StatusInternalServerError
+	if !status.OK {
+		status.Error = resp.Status
+	}
+	return status
+}
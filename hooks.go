@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Post-processing hooks run after a batch step has been ingested and stored,
+// with the freshly decoded values. Built-in hooks are registered below,
+// programs built from this package can RegisterIngestHook their own, and
+// -hooks picks the ones that run. A failing hook is logged, it does not fail
+// the ingest.
+
+type IngestMetadata struct {
+	Date   string
+	Batch  string
+	Step   int
+	Grid   GridSpec
+	Params []string
+}
+
+type IngestHook func(meta IngestMetadata, values map[string][]float32) error
+
+var (
+	hooksMutex     sync.RWMutex
+	ingestHooks    = make(map[string]IngestHook)
+	enabledHooks   []string
+	hookAlertSpeed = 32.7 // m/s, hurricane force
+	hookExportDir  = "exports"
+)
+
+func init() {
+	RegisterIngestHook("wind_alert", windAlertHook)
+	RegisterIngestHook("export", exportHook)
+}
+
+// RegisterIngestHook makes a hook available to -hooks under name.
+func RegisterIngestHook(name string, hook IngestHook) {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	ingestHooks[name] = hook
+}
+
+// parseHooks checks a comma separated -hooks list against the registered hooks.
+func parseHooks(s string) ([]string, error) {
+	hooksMutex.RLock()
+	defer hooksMutex.RUnlock()
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		if _, ok := ingestHooks[name]; !ok {
+			return nil, fmt.Errorf("unknown hook %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// runIngestHooks runs the enabled hooks on the values of an ingest.
+func runIngestHooks(meta IngestMetadata, decoded map[string]decodedChunk) {
+	if len(enabledHooks) == 0 {
+		return
+	}
+	values := make(map[string][]float32, len(meta.Params))
+	for _, param := range meta.Params {
+		converted := make([]float32, len(decoded[param].Values))
+		for i, v := range decoded[param].Values {
+			converted[i] = float32(v)
+		}
+		values[param] = converted
+	}
+	hooksMutex.RLock()
+	defer hooksMutex.RUnlock()
+	for _, name := range enabledHooks {
+		if err := ingestHooks[name](meta, values); err != nil {
+			log.Printf("Hook %s failed on %s-%s step %s: %v", name, meta.Date, meta.Batch, stepName(meta.Step), err)
+		}
+	}
+}
+
+// windAlertHook logs the strongest wind of an ingest when it reaches
+// hookAlertSpeed.
+func windAlertHook(meta IngestMetadata, values map[string][]float32) error {
+	u, v := values["10u"], values["10v"]
+	if u == nil || v == nil {
+		return nil
+	}
+	strongest, at := 0.0, -1
+	for i := range min(len(u), len(v)) {
+		speed := math.Hypot(float64(u[i]), float64(v[i]))
+		if speed > strongest { // NaN never is
+			strongest, at = speed, i
+		}
+	}
+	if strongest < hookAlertSpeed {
+		return nil
+	}
+	grid, err := meta.Grid.Grid()
+	if err != nil {
+		return err
+	}
+	lat, lon := grid.Coord(at)
+	log.Printf("ALERT wind %.1f m/s at (%g, %g) in %s-%s step %s", strongest, lat, lon, meta.Date, meta.Batch, stepName(meta.Step))
+	return nil
+}
+
+// exportHook writes every ingested parameter to hookExportDir as raw
+// little-endian float32s, <date>-<batch>-<step>-<param>.f32, with the grid
+// in a .json next to it.
+func exportHook(meta IngestMetadata, values map[string][]float32) error {
+	if err := os.MkdirAll(hookExportDir, 0o755); err != nil {
+		return err
+	}
+	grid, err := json.Marshal(meta.Grid)
+	if err != nil {
+		return err
+	}
+	for param, data := range values {
+		base := filepath.Join(hookExportDir, fmt.Sprintf("%s-%s-%s-%s", meta.Date, meta.Batch, stepName(meta.Step), param))
+		raw := make([]byte, 4*len(data))
+		for i, v := range data {
+			binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(v))
+		}
+		if err := os.WriteFile(base+".f32", raw, 0o644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(base+".json", grid, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,21 +4,64 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 type TyphonAPIParams struct {
-	date  string
-	batch string
+	date       string
+	batch      string
+	simplifyKm float64 // Douglas-Peucker tolerance for Trace, 0 keeps every point
+	lang       string  // of nature_name and category_name
+	legacy     bool    // cma_ names in Now and Trace as JSON strings by name and number, unless trace=points
 }
 
 type TyphonAPIResponse struct {
-	Now    []map[string]string         `json:"now"`
-	Trace  map[string]map[int][]string `json:"trace"`
-	Status int                         `json:"status"`
-	Some   bool                        `json:"some"`
+	Now         []map[string]any            `json:"now"`
+	Trace       []TyphoonTrack              `json:"trace"`
+	LegacyTrace map[string]map[int][]string `json:"-"`      // encoded as trace when set, the default
+	Agency      string                      `json:"agency"` // whose positions, winds and pressures these are, "" for the combined columns
+	WindUnit    string                      `json:"wind_unit,omitempty"`
+	Status      int                         `json:"status"`
+	Some        bool                        `json:"some"`
+}
+
+// TyphoonTrack is the track of one storm, points in time order.
+type TyphoonTrack struct {
+	Name   string       `json:"name"`
+	Number int          `json:"number"`
+	SID    string       `json:"sid"`
+	Points []TracePoint `json:"points"`
+}
+
+type TracePoint struct {
+	Time         string    `json:"time"` // yyyymmddHHMMSS
+	Lat          NullFloat `json:"lat"`
+	Lon          NullFloat `json:"lon"`
+	Wind         NullFloat `json:"wind"`     // in wind_unit
+	Pressure     NullFloat `json:"pressure"` // hPa
+	Category     NullFloat `json:"category"`
+	Nature       string    `json:"nature"`
+	NatureName   string    `json:"nature_name"`
+	CategoryName string    `json:"category_name"`
+}
+
+// MarshalJSON writes LegacyTrace in place of Trace when it is set. That is
+// the shape /typhoon always had and stays the default so existing clients
+// keep working; trace=points opts in to the typed tracks.
+func (r TyphonAPIResponse) MarshalJSON() ([]byte, error) {
+	type plain TyphonAPIResponse
+	if r.LegacyTrace == nil {
+		return json.Marshal(plain(r))
+	}
+	return json.Marshal(struct {
+		plain
+		Trace map[string]map[int][]string `json:"trace"`
+	}{plain(r), r.LegacyTrace})
 }
 
 var typhonAPIErrorResponse = TyphonAPIResponse{
@@ -28,12 +71,30 @@ var typhonAPIErrorResponse = TyphonAPIResponse{
 	Some:   false,
 }
 
-var typhonData, typhonErr = readCSV("data/ibtracs.csv")
+// CSV 列索引
+const (
+	colSID      = 0
+	colSeason   = 1
+	colNumber   = 2
+	colBasin    = 3
+	colSubbasin = 4
+	colName     = 5
+	colIsoTime  = 6
+	colNature   = 7
+	colLat      = 8
+	colLon      = 9
+	colCat      = 10
+	colWind     = 11
+	colPres     = 12
+	numColumns  = 13
+)
 
 func sendTyphonAPIError(w http.ResponseWriter, statusCode int) {
+	response := typhonAPIErrorResponse
+	response.Status = statusCode
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode) // 写入HTTP状态码 (例如 400, 500)
-	json.NewEncoder(w).Encode(typhonAPIErrorResponse)
+	json.NewEncoder(w).Encode(response)
 }
 
 func typhonAPIHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,14 +103,53 @@ func typhonAPIHandler(w http.ResponseWriter, r *http.Request) {
 	batch := httpQuery.Get("batch")
 	if date == "" || batch == "" {
 		sendTyphonAPIError(w, http.StatusBadRequest)
+		return
+	}
+
+	var simplifyKm float64
+	if simplifyStr := httpQuery.Get("simplify"); simplifyStr != "" {
+		var err error
+		simplifyKm, err = strconv.ParseFloat(simplifyStr, 64)
+		if err != nil || simplifyKm < 0 || math.IsInf(simplifyKm, 0) || math.IsNaN(simplifyKm) {
+			sendTyphonAPIError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := httpQuery.Get("format")
+	if format != "" && format != formatJSON && format != formatGeoJSON {
+		sendTyphonAPIError(w, http.StatusBadRequest)
+		return
+	}
+
+	traceFormat := httpQuery.Get("trace")
+	if traceFormat != "" && traceFormat != "strings" && traceFormat != "points" {
+		sendTyphonAPIError(w, http.StatusBadRequest)
+		return
 	}
 
 	params := TyphonAPIParams{
-		date:  date,
-		batch: batch,
+		date:       date,
+		batch:      batch,
+		simplifyKm: simplifyKm,
+		lang:       languageFrom(r.Context()),
+		legacy:     traceFormat != "points",
 	}
 
-	resp, err := getTyphon(params)
+	resp, err := getTyphonCached(params)
+	if err != nil {
+		sendTyphonAPIError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+	if format == formatGeoJSON {
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(typhoonGeoJSON(resp)); err != nil {
+			log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(resp)
@@ -58,148 +158,109 @@ func typhonAPIHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getTyphon(params TyphonAPIParams) (TyphonAPIResponse, error) {
-	if typhonErr != nil {
-		fmt.Printf("Met Error when reading csv: %v", typhonErr)
-		return typhonAPIErrorResponse, typhonErr
+// typhoonRecordMap is the JSON form of one IBTrACS record. Numeric fields are
+// numbers (null when missing), wind in windUnit and pressure in hPa, taken
+// from the columns of agency. The nature and category are also named in lang.
+func typhoonRecordMap(record []string, lang string, dataset *typhonDataset) map[string]any {
+	point := typhoonPointFor(record)
+	return map[string]any{
+		"sid":      record[colSID],
+		"storm_id": stormIDFor(record),
+		"season":   record[colSeason],
+		"number":   record[colNumber],
+		"basin":    record[colBasin],
+		"subbasin": record[colSubbasin],
+		"name":     record[colName],
+		"iso_time": record[colIsoTime],
+		"nature":   record[colNature],
+		"agency":   dataset.agency,
+		"lat":      NullFloat(point.Lat),
+		"lon":      NullFloat(point.Lon),
+		"cat":      NullFloat(point.Cat),
+		"wind":     NullFloat(convertWind(point.Wind)),
+		"pres":     NullFloat(point.Pres),
+
+		"nature_name":   natureName(lang, record[colNature]),
+		"category_name": categoryName(lang, dataset.catScale, point.Cat),
 	}
+}
 
-	// 将 batch (如 "00z", "06z") 转换为小时数
-	batchHour := strings.TrimSuffix(strings.ToLower(params.batch), "z")
-	// 确保小时数是两位数
-	if len(batchHour) == 1 {
-		batchHour = "0" + batchHour
+// legacyRecordMap renames the fields of a typhoonRecordMap to the cma_ names
+// they had before the agency was configurable, for the now records and trace
+// strings of trace=strings, the default.
+func legacyRecordMap(recordMap map[string]any) map[string]any {
+	delete(recordMap, "agency")
+	for _, name := range []string{"lat", "lon", "cat", "wind", "pres"} {
+		recordMap["cma_"+name] = recordMap[name]
+		delete(recordMap, name)
 	}
-	// 构建目标 ISO_TIME 格式: yyyymmddHH0000
-	targetIsoTimeStr := params.date + batchHour + "0000"
-	// 转换为整数以便比较
-	targetIsoTime, err := strconv.ParseInt(targetIsoTimeStr, 10, 64)
+	return recordMap
+}
+
+// typhoonTracePoint is one record as a point of a track, converted like
+// typhoonRecordMap.
+func typhoonTracePoint(record []string, lang, catScale string) TracePoint {
+	point := typhoonPointFor(record)
+	return TracePoint{
+		Time:         record[colIsoTime],
+		Lat:          NullFloat(point.Lat),
+		Lon:          NullFloat(point.Lon),
+		Wind:         NullFloat(convertWind(point.Wind)),
+		Pressure:     NullFloat(point.Pres),
+		Category:     NullFloat(point.Cat),
+		Nature:       record[colNature],
+		NatureName:   natureName(lang, record[colNature]),
+		CategoryName: categoryName(lang, catScale, point.Cat),
+	}
+}
+
+func getTyphon(params TyphonAPIParams) (TyphonAPIResponse, error) {
+	dataset, err := loadedTyphonData()
 	if err != nil {
 		return typhonAPIErrorResponse, err
 	}
-
-	// CSV 列索引
-	// 0: SID, 1: SEASON, 2: NUMBER, 3: BASIN, 4: SUBBASIN, 5: NAME, 6: ISO_TIME
-	// 7: NATURE, 8: CMA_LAT, 9: CMA_LON, 10: CMA_CAT, 11: CMA_WIND, 12: CMA_PRES
-
-	// 用于存储每个 SID 在当天最接近目标时间的记录
-	sidClosestRecord := make(map[string][]string)
-	sidMinDiff := make(map[string]int64) // 存储每个 SID 与目标时间的最小差值
-
-	// 提取目标日期（yyyymmdd）
-	targetDate := params.date
-
-	// 第一遍遍历：找到每个台风在当天最接近目标小时的记录
-	for i := 1; i < len(typhonData); i++ {
-		record := typhonData[i]
-		if len(record) < 13 {
-			continue
-		}
-
-		isoTimeStr := record[6]
-		sid := record[0]
-
-		// 检查是否是当天的数据（只比较日期部分 yyyymmdd）
-		if len(isoTimeStr) < 8 || isoTimeStr[:8] != targetDate {
-			continue
-		}
-
-		// 解析 ISO_TIME 为整数
-		isoTime, err := strconv.ParseInt(isoTimeStr, 10, 64)
-		if err != nil {
-			continue
-		}
-
-		// 计算时间差的绝对值（只比较小时部分）
-		diff := isoTime - targetIsoTime
-		absDiff := diff
-		if absDiff < 0 {
-			absDiff = -absDiff
-		}
-
-		// 如果是第一个记录，或者这个记录更接近目标时间
-		if _, exists := sidMinDiff[sid]; !exists || absDiff < sidMinDiff[sid] {
-			sidMinDiff[sid] = absDiff
-			sidClosestRecord[sid] = record
-		}
+	closest, tracks, err := typhoonStorms(dataset, params.date, params.batch)
+	if err != nil {
+		return typhonAPIErrorResponse, err
 	}
 
 	// 构建 Now 数组
-	var now []map[string]string
-	matchedSIDs := make(map[string]bool)
-
-	for sid, record := range sidClosestRecord {
-		matchedSIDs[sid] = true
-		nowItem := map[string]string{
-			"sid":      record[0],
-			"season":   record[1],
-			"number":   record[2],
-			"basin":    record[3],
-			"subbasin": record[4],
-			"name":     record[5],
-			"iso_time": record[6],
-			"nature":   record[7],
-			"cma_lat":  record[8],
-			"cma_lon":  record[9],
-			"cma_cat":  record[10],
-			"cma_wind": record[11],
-			"cma_pres": record[12],
+	var now []map[string]any
+	for _, record := range closest {
+		recordMap := typhoonRecordMap(record, params.lang, dataset)
+		if params.legacy {
+			recordMap = legacyRecordMap(recordMap)
 		}
-		now = append(now, nowItem)
+		now = append(now, recordMap)
 	}
 
-	// 输出匹配的 SID 数量（用于调试）
-	fmt.Printf("Found %d typhoons on date %s\n", len(now), targetDate)
-
-	// 第二遍遍历：为匹配的台风构建 Trace（所有轨迹点）
-	// 只包含与 Now 中 SID 相同的台风数据
-	trace := make(map[string]map[int][]string)
-	for i := 1; i < len(typhonData); i++ {
-		record := typhonData[i]
-		if len(record) < 13 {
-			continue
-		}
-
-		sid := record[0]
-		name := record[5]
-		numberStr := record[2]
-
-		// 只处理在 Now 中出现的 SID（确保 trace 中的内容与 now 中的 SID 相同）
-		if !matchedSIDs[sid] {
-			continue
-		}
-
-		// 将 number 转换为 int
-		number, err := strconv.Atoi(numberStr)
-		if err != nil {
-			continue
-		}
+	// 按需简化轨迹，按名称和编号排序
+	trace := []TyphoonTrack{}
+	var legacyTrace map[string]map[int][]string
+	if params.legacy {
+		legacyTrace = make(map[string]map[int][]string)
+	}
+	for _, name := range slices.Sorted(maps.Keys(tracks)) {
+		numbers := tracks[name]
+		for _, number := range slices.Sorted(maps.Keys(numbers)) {
+			records := simplifyTrack(numbers[number], params.simplifyKm)
+			track := TyphoonTrack{Name: name, Number: number, SID: records[0][colSID], Points: make([]TracePoint, len(records))}
+			for i, record := range records {
+				track.Points[i] = typhoonTracePoint(record, params.lang, dataset.catScale)
+			}
+			trace = append(trace, track)
 
-		// 构建 Trace: 按名称和编号组织轨迹数据
-		// 只添加 SID 在 matchedSIDs 中的记录（确保 trace 中的内容与 now 中的 SID 相同）
-		if name != "" {
-			if trace[name] == nil {
-				trace[name] = make(map[int][]string)
+			if legacyTrace == nil {
+				continue
 			}
-			// 将轨迹点转换为 JSON 字符串
-			tracePoint := map[string]string{
-				"sid":      record[0],
-				"season":   record[1],
-				"number":   record[2],
-				"basin":    record[3],
-				"subbasin": record[4],
-				"name":     record[5],
-				"iso_time": record[6],
-				"nature":   record[7],
-				"cma_lat":  record[8],
-				"cma_lon":  record[9],
-				"cma_cat":  record[10],
-				"cma_wind": record[11],
-				"cma_pres": record[12],
+			if legacyTrace[name] == nil {
+				legacyTrace[name] = make(map[int][]string)
 			}
-			traceJson, err := json.Marshal(tracePoint)
-			if err == nil {
-				trace[name][number] = append(trace[name][number], string(traceJson))
+			for _, record := range records {
+				traceJson, err := json.Marshal(legacyRecordMap(typhoonRecordMap(record, params.lang, dataset)))
+				if err == nil {
+					legacyTrace[name][number] = append(legacyTrace[name][number], string(traceJson))
+				}
 			}
 		}
 	}
@@ -208,11 +269,75 @@ func getTyphon(params TyphonAPIParams) (TyphonAPIResponse, error) {
 	some := len(now) > 0
 
 	response := TyphonAPIResponse{
-		Now:    now,
-		Trace:  trace,
-		Status: http.StatusOK,
-		Some:   some,
+		Now:         now,
+		Trace:       trace,
+		LegacyTrace: legacyTrace,
+		Agency:      dataset.agency,
+		WindUnit:    windUnit,
+		Status:      http.StatusOK,
+		Some:        some,
 	}
 
 	return response, nil
 }
+
+// typhoonStorms returns the record of each storm closest to the batch of
+// date, and the tracks of those storms by name and number.
+func typhoonStorms(dataset *typhonDataset, date, batch string) (map[string][]string, map[string]map[int][][]string, error) {
+	// 将 batch (如 "00z", "06z") 转换为小时数
+	batchHour := strings.TrimSuffix(strings.ToLower(batch), "z")
+	// 确保小时数是两位数
+	if len(batchHour) == 1 {
+		batchHour = "0" + batchHour
+	}
+	// 构建目标 ISO_TIME 格式: yyyymmddHH0000
+	targetIsoTimeStr := date + batchHour + "0000"
+	// 转换为整数以便比较
+	targetIsoTime, err := strconv.ParseInt(targetIsoTimeStr, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidDate, err)
+	}
+
+	// 每个 SID 在当天最接近目标时间的记录
+	sidClosestRecord := closestTyphonRecords(dataset.byDate[date], targetIsoTime)
+
+	// 为匹配的台风构建 Trace（所有轨迹点），按名称和编号组织
+	tracks := make(map[string]map[int][][]string)
+	for sid := range sidClosestRecord {
+		for _, record := range dataset.tracks[sid] {
+			name := record[colName]
+			number, err := strconv.Atoi(record[colNumber])
+			if err != nil || name == "" {
+				continue
+			}
+			if tracks[name] == nil {
+				tracks[name] = make(map[int][][]string)
+			}
+			tracks[name][number] = append(tracks[name][number], record)
+		}
+	}
+	return sidClosestRecord, tracks, nil
+}
+
+// closestTyphonRecords returns, for each storm among the records of one day,
+// the record closest to targetIsoTime (yyyymmddHHMMSS).
+func closestTyphonRecords(dayRecords [][]string, targetIsoTime int64) map[string][]string {
+	closest := make(map[string][]string)
+	minDiff := make(map[string]int64) // 每个 SID 与目标时间的最小差值
+	for _, record := range dayRecords {
+		isoTime, err := strconv.ParseInt(record[colIsoTime], 10, 64)
+		if err != nil {
+			continue
+		}
+		diff := isoTime - targetIsoTime
+		if diff < 0 {
+			diff = -diff
+		}
+		sid := record[colSID]
+		if _, exists := minDiff[sid]; !exists || diff < minDiff[sid] {
+			minDiff[sid] = diff
+			closest[sid] = record
+		}
+	}
+	return closest
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// IBTrACS CSVs come in several layouts: the trimmed file shipped in data/
+// has 13 columns, the full release has 160+ with one set of position,
+// wind and pressure columns per reporting agency. The loader maps columns
+// by header name and projects every row onto the 13 col* columns the
+// handlers use, taking the agency's columns where the file has them.
+
+var (
+	ibtracsPath   = "data/ibtracs.csv"
+	ibtracsAgency = "CMA" // prefix of the agency columns to expose, e.g. CMA, TOKYO, USA
+	// ibtracsURL is downloaded to ibtracsPath when the file is missing.
+	ibtracsURL = "https://www.ncei.noaa.gov/data/international-best-track-archive-for-climate-stewardship-ibtracs/v04r01/access/csv/ibtracs.last3years.list.v04r01.csv"
+)
+
+const (
+	ibtracsRetryMin = 30 * time.Second
+	ibtracsRetryMax = 30 * time.Minute
+)
+
+var errTyphonNotLoaded = errors.New("ibtracs data not loaded")
+
+// typhonDataset is one load of the IBTrACS table with everything derived
+// from it. Reloads build a new dataset and swap it in as a whole, so a query
+// that took it from currentTyphonData sees consistent data throughout.
+type typhonDataset struct {
+	records  [][]string            // col* layout
+	byDate   map[string][][]string // yyyymmdd -> records of that day
+	tracks   map[string][][]string // SID -> records of the storm in time order
+	points   map[string]typhoonPoint
+	stormIDs map[string]string
+	catScale string // scale of the category column, see categoryName
+	agency   string // whose position columns were taken, "" for the combined ones
+	err      error
+	loadedAt time.Time
+}
+
+var typhonState atomic.Pointer[typhonDataset]
+
+func currentTyphonData() *typhonDataset {
+	if dataset := typhonState.Load(); dataset != nil {
+		return dataset
+	}
+	return &typhonDataset{err: errTyphonNotLoaded}
+}
+
+// typhonRecords returns the loaded records, or ErrDatasetUnavailable while
+// the table could not be loaded.
+func typhonRecords() ([][]string, error) {
+	dataset, err := loadedTyphonData()
+	if err != nil {
+		return nil, err
+	}
+	return dataset.records, nil
+}
+
+// loadedTyphonData is currentTyphonData, or ErrDatasetUnavailable while the
+// table could not be loaded.
+func loadedTyphonData() (*typhonDataset, error) {
+	dataset := currentTyphonData()
+	if dataset.err != nil {
+		return nil, fmt.Errorf("%w: ibtracs: %w", ErrDatasetUnavailable, dataset.err)
+	}
+	return dataset, nil
+}
+
+// ibtracsCandidates lists, for each col* column, the header names to take it
+// from in order of preference. AGENCY is replaced by ibtracsAgency.
+var ibtracsCandidates = [numColumns][]string{
+	colSID:      {"SID"},
+	colSeason:   {"SEASON"},
+	colNumber:   {"NUMBER"},
+	colBasin:    {"BASIN"},
+	colSubbasin: {"SUBBASIN"},
+	colName:     {"NAME"},
+	colIsoTime:  {"ISO_TIME"},
+	colNature:   {"NATURE"},
+	colLat:      {"AGENCY_LAT", "LAT"},
+	colLon:      {"AGENCY_LON", "LON"},
+	colCat:      {"AGENCY_CAT", "CAT", "USA_SSHS"},
+	colWind:     {"AGENCY_WIND", "WIND", "WMO_WIND"},
+	colPres:     {"AGENCY_PRES", "PRES", "WMO_PRES"},
+}
+
+// startTyphonLoader loads the IBTrACS table. The server does not depend on
+// it for the grib endpoints, so a failed load only puts the typhoon
+// endpoints in degraded mode (see /readyz) while loading is retried in the
+// background, downloading the file from ibtracsURL if it is missing.
+func startTyphonLoader() {
+	if loadTyphonData() == nil {
+		return
+	}
+	log.Printf("Typhoon endpoints degraded until %s can be loaded, retrying in the background", ibtracsPath)
+	go func() {
+		delay := ibtracsRetryMin
+		for {
+			<-clock.After(delay)
+			if loadTyphonData() == nil {
+				return
+			}
+			delay = min(delay*2, ibtracsRetryMax)
+		}
+	}()
+}
+
+// loadTyphonData (re)loads the IBTrACS table and everything derived from it.
+// A failed reload keeps the previously loaded data.
+func loadTyphonData() error {
+	if _, err := os.Stat(ibtracsPath); errors.Is(err, os.ErrNotExist) && ibtracsURL != "" {
+		if err := downloadIBTrACS(ibtracsURL, ibtracsPath); err != nil {
+			log.Printf("Failed to download IBTrACS from %s: %v", ibtracsURL, err)
+		}
+	}
+
+	records, layout, err := readIBTrACS(ibtracsPath, ibtracsAgency)
+	if err != nil {
+		log.Printf("Failed to load IBTrACS from %s: %v", ibtracsPath, err)
+		if previous := typhonState.Load(); previous == nil || previous.err != nil {
+			typhonState.Store(&typhonDataset{err: err})
+		}
+		return err
+	}
+
+	log.Printf("Loaded %d IBTrACS records from %s", len(records), ibtracsPath)
+	storeTyphonData(records, layout)
+	return nil
+}
+
+// storeTyphonData swaps in a new dataset built from records.
+func storeTyphonData(records [][]string, layout ibtracsLayout) {
+	byDate, tracks := indexTyphonRecords(records)
+	typhonState.Store(&typhonDataset{
+		records:  records,
+		byDate:   byDate,
+		tracks:   tracks,
+		points:   parseTyphoonPoints(records),
+		stormIDs: buildStormIDs(records),
+		catScale: layout.catScale,
+		agency:   layout.agency,
+		loadedAt: clock.Now(),
+	})
+	typhonDataGeneration.Add(1)
+}
+
+// indexTyphonRecords groups the records by day and by storm, so queries
+// touch only the storms they are about instead of scanning the table.
+func indexTyphonRecords(records [][]string) (map[string][][]string, map[string][][]string) {
+	byDate := make(map[string][][]string)
+	tracks := make(map[string][][]string)
+	for _, record := range records {
+		if len(record) < numColumns {
+			continue
+		}
+		if isoTime := record[colIsoTime]; len(isoTime) >= 8 {
+			byDate[isoTime[:8]] = append(byDate[isoTime[:8]], record)
+		}
+		tracks[record[colSID]] = append(tracks[record[colSID]], record)
+	}
+	return byDate, tracks
+}
+
+// downloadIBTrACS fetches the CSV into path, through a temporary file so a
+// failed download never leaves a truncated table behind.
+func downloadIBTrACS(url, path string) error {
+	tmp, _, err := fetchIBTrACS(url, filepath.Dir(path), "")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	log.Printf("Downloaded IBTrACS from %s", url)
+	return os.Rename(tmp, path)
+}
+
+// fetchIBTrACS downloads url into a temporary file in dir and returns its
+// path and the Last-Modified of the response. When lastModified is set and
+// the file has not changed since, it returns an empty path and no error.
+func fetchIBTrACS(url, dir, lastModified string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && lastModified != "" {
+		return "", lastModified, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".ibtracs-*.csv")
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	return tmp.Name(), resp.Header.Get("Last-Modified"), nil
+}
+
+// ibtracsLayout describes the columns an IBTrACS CSV was read from.
+type ibtracsLayout struct {
+	catScale string // scale of the category column, see categoryName
+	agency   string // prefix of the position columns, "" for LAT and LON
+}
+
+// readIBTrACS reads an IBTrACS CSV into rows of numColumns columns, and the
+// layout of the columns taken. Rows without a SID, like the units row below
+// the header, are dropped.
+func readIBTrACS(path string, agency string) ([][]string, ibtracsLayout, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ibtracsLayout{}, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ibtracsLayout{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	columns, err := ibtracsColumnMapping(header, agency)
+	if err != nil {
+		return nil, ibtracsLayout{}, err
+	}
+	layout := ibtracsLayout{catScale: categoryScaleSSHS}
+	if columns[colCat] >= 0 && strings.EqualFold(strings.TrimSpace(header[columns[colCat]]), "CMA_CAT") {
+		layout.catScale = categoryScaleCMA
+	}
+	latName := strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(header[columns[colLat]], "\ufeff")))
+	if prefix, ok := strings.CutSuffix(latName, "_LAT"); ok {
+		layout.agency = prefix
+	}
+
+	var records [][]string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ibtracsLayout{}, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		record := make([]string, numColumns)
+		for col, index := range columns {
+			if index >= 0 && index < len(row) {
+				record[col] = strings.TrimSpace(row[index])
+			}
+		}
+		if record[colSID] == "" {
+			continue
+		}
+		record[colIsoTime] = normalizeIsoTime(record[colIsoTime])
+		records = append(records, record)
+	}
+	return records, layout, nil
+}
+
+// ibtracsColumnMapping returns the index in header of every col* column, -1
+// for optional columns the file does not have.
+func ibtracsColumnMapping(header []string, agency string) ([numColumns]int, error) {
+	byName := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := byName[name]; !ok {
+			byName[name] = i
+		}
+	}
+
+	var columns [numColumns]int
+	for col, candidates := range ibtracsCandidates {
+		columns[col] = -1
+		for _, candidate := range candidates {
+			if strings.HasPrefix(candidate, "AGENCY_") {
+				if agency == "" {
+					continue
+				}
+				candidate = strings.ToUpper(agency) + strings.TrimPrefix(candidate, "AGENCY")
+			}
+			if index, ok := byName[candidate]; ok {
+				columns[col] = index
+				break
+			}
+		}
+	}
+
+	for _, required := range []int{colSID, colIsoTime, colLat, colLon} {
+		if columns[required] < 0 {
+			return columns, fmt.Errorf("missing column for %s in header", ibtracsCandidates[required][len(ibtracsCandidates[required])-1])
+		}
+	}
+	return columns, nil
+}
+
+// normalizeIsoTime turns the full release's "2022-01-08 00:00:00" into the
+// yyyymmddHHMMSS form the handlers compare against.
+func normalizeIsoTime(isoTime string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, isoTime)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The IBTrACS release is updated upstream every few days. The refresher
+// downloads it again every ibtracsRefreshInterval (and on POST
+// /typhoon/refresh), checks the new file parses and is not much smaller than
+// the table in use, then replaces the file on disk and swaps the dataset in.
+// Queries keep using the old dataset until the swap, never a partial one.
+
+var ibtracsRefreshInterval = 24 * time.Hour // 0 disables the refresher
+
+// A new table must keep at least this share of the current records, so a
+// truncated or wrong file is never swapped in.
+const ibtracsMinRetained = 0.5
+
+var (
+	ibtracsRefreshMutex sync.Mutex // one refresh at a time
+	ibtracsLastModified string     // Last-Modified of the last download
+)
+
+// startTyphonRefresher refreshes the IBTrACS table in the background.
+func startTyphonRefresher() {
+	if ibtracsRefreshInterval <= 0 || ibtracsURL == "" {
+		return
+	}
+	go func() {
+		for {
+			<-clock.After(ibtracsRefreshInterval)
+			if _, err := refreshTyphonData(); err != nil {
+				log.Printf("Failed to refresh IBTrACS from %s: %v", ibtracsURL, err)
+			}
+		}
+	}()
+}
+
+// refreshTyphonData downloads, validates and swaps in the latest IBTrACS
+// table. It reports whether the table changed; a file not modified upstream
+// since the last refresh is not downloaded again.
+func refreshTyphonData() (bool, error) {
+	ibtracsRefreshMutex.Lock()
+	defer ibtracsRefreshMutex.Unlock()
+
+	tmp, lastModified, err := fetchIBTrACS(ibtracsURL, filepath.Dir(ibtracsPath), ibtracsLastModified)
+	if err != nil {
+		return false, fmt.Errorf("%w: ibtracs: %w", ErrUpstreamUnavailable, err)
+	}
+	if tmp == "" {
+		return false, nil
+	}
+	defer os.Remove(tmp)
+
+	records, layout, err := readIBTrACS(tmp, ibtracsAgency)
+	if err != nil {
+		return false, fmt.Errorf("%w: invalid ibtracs download: %w", ErrDatasetUnavailable, err)
+	}
+	if current := currentTyphonData(); len(records) == 0 || float64(len(records)) < ibtracsMinRetained*float64(len(current.records)) {
+		return false, fmt.Errorf("%w: ibtracs download has %d records, the loaded table %d", ErrDatasetUnavailable, len(records), len(current.records))
+	}
+
+	if err := os.Rename(tmp, ibtracsPath); err != nil {
+		return false, err
+	}
+	ibtracsLastModified = lastModified
+	storeTyphonData(records, layout)
+	log.Printf("Refreshed IBTrACS from %s: %d records", ibtracsURL, len(records))
+	return true, nil
+}
+
+// typhoonRefreshHandler serves POST /typhoon/refresh, refreshing the IBTrACS
+// table now instead of at the next interval.
+func typhoonRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	changed, err := refreshTyphonData()
+	if err != nil {
+		sendAdminResponse(w, r, "typhoon_refresh", err, "")
+		return
+	}
+	message := "IBTrACS not modified upstream"
+	if changed {
+		message = fmt.Sprintf("loaded %d IBTrACS records", len(currentTyphonData().records))
+	}
+	sendAdminResponse(w, r, "typhoon_refresh", nil, message)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Idempotency keys for job submitting endpoints (POST /admin/prefetch and
+// POST /jobs/exposure). A request carrying an Idempotency-Key header runs
+// once: retries with the same key get the response of the first run, or wait
+// for it while it is still running, instead of starting the work again. Keys
+// are per client and kept for idempotencyTTL; reusing one for a different
+// request, or the same one with a different body, is rejected with 422.
+
+const (
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyBodyLimit is as much of a body as the wrapped handlers read.
+	idempotencyBodyLimit = 16 << 20
+)
+
+type idempotentResult struct {
+	request   string   // method and URL the key was first used for
+	bodyHash  [32]byte // SHA-256 of the body sent with it
+	done      chan struct{}
+	status    int
+	header    http.Header
+	body      []byte
+	createdAt time.Time
+}
+
+var (
+	idempotencyMutex   sync.Mutex
+	idempotencyResults = make(map[string]*idempotentResult)
+)
+
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		setLogField(r.Context(), "idempotency_key", key)
+		request := r.Method + " " + r.URL.RequestURI()
+		scoped := rateClient(r) + " " + key
+		body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyBodyLimit))
+		if err != nil {
+			sendIdempotencyError(w, http.StatusBadRequest, "request body could not be read")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		bodyHash := sha256.Sum256(body)
+
+		idempotencyMutex.Lock()
+		now := clock.Now()
+		for k, result := range idempotencyResults {
+			if now.Sub(result.createdAt) > idempotencyTTL {
+				delete(idempotencyResults, k)
+			}
+		}
+		result, seen := idempotencyResults[scoped]
+		if !seen {
+			result = &idempotentResult{request: request, bodyHash: bodyHash, done: make(chan struct{}), createdAt: now}
+			idempotencyResults[scoped] = result
+		}
+		idempotencyMutex.Unlock()
+
+		if seen {
+			if result.request != request {
+				sendIdempotencyError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used for "+result.request)
+				return
+			}
+			if result.bodyHash != bodyHash {
+				sendIdempotencyError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used with a different body")
+				return
+			}
+			select {
+			case <-result.done:
+			case <-r.Context().Done():
+				return
+			}
+			setLogField(r.Context(), "idempotent_replay", true)
+			for name, values := range result.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(result.status)
+			w.Write(result.body)
+			return
+		}
+
+		recorder := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		finished := false
+		defer func() {
+			if !finished {
+				// next panicked, the waiting retries get a 500
+				result.status, result.header = http.StatusInternalServerError, http.Header{"Content-Type": {"application/json"}}
+				result.body, _ = json.Marshal(map[string]any{"error": "request failed", "status": result.status, "success": false})
+			}
+			if result.status >= 500 {
+				// let a retry run it again after a server side failure
+				idempotencyMutex.Lock()
+				delete(idempotencyResults, scoped)
+				idempotencyMutex.Unlock()
+			}
+			close(result.done)
+		}()
+		next(recorder, r)
+		result.status, result.header, result.body = recorder.status, replayHeader(w.Header()), recorder.body.Bytes()
+		finished = true
+		w.WriteHeader(result.status)
+		w.Write(result.body)
+	}
+}
+
+// replayHeader is the part of a response header worth replaying: the rate
+// limit state is that of the request it was sent to, a replay carries its
+// own.
+func replayHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"} {
+		header.Del(name)
+	}
+	return header
+}
+
+func sendIdempotencyError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   message,
+		"status":  statusCode,
+		"success": false,
+	})
+}
+
+// bufferedResponse holds a response back in full.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Asynchronous jobs, for work too long to answer within a request. The
+// submitting endpoint responds 202 Accepted with the job, which the client
+// then polls at GET /jobs/{id} until its state is done or failed, the result
+// coming with it. Jobs are kept in memory for jobTTL after they finish and
+// are only visible to the client that submitted them.
+
+const (
+	jobTTL         = 24 * time.Hour
+	jobTimeout     = 30 * time.Minute
+	maxRunningJobs = 2
+
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+type Job struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	State    string     `json:"state"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Result   any        `json:"result,omitempty"`
+	owner    string
+}
+
+type JobResponse struct {
+	Job     *Job `json:"job"`
+	Status  int  `json:"status"`
+	Success bool `json:"success"`
+}
+
+var (
+	jobsMutex sync.Mutex
+	jobs      = make(map[string]*Job)
+	jobSlots  = make(chan struct{}, maxRunningJobs)
+)
+
+// submitJob queues run as a job of the client of r. It keeps the values of
+// the request's context (role, clock) but not its cancellation, the job
+// outliving the request; it is cancelled on shutdown.
+func submitJob(r *http.Request, jobType string, run func(ctx context.Context) (any, error)) Job {
+	id := make([]byte, 12)
+	rand.Read(id)
+	job := &Job{
+		ID:      hex.EncodeToString(id),
+		Type:    jobType,
+		State:   jobQueued,
+		Created: clock.Now(),
+		owner:   rateClient(r),
+	}
+
+	jobsMutex.Lock()
+	for id, old := range jobs {
+		if old.Finished != nil && job.Created.Sub(*old.Finished) > jobTTL {
+			delete(jobs, id)
+		}
+	}
+	jobs[job.ID] = job
+	snapshot := *job
+	jobsMutex.Unlock()
+
+	ctx, done := detach(r.Context())
+	go func() {
+		defer done()
+		jobSlots <- struct{}{}
+		defer func() { <-jobSlots }()
+		setJobState(job.ID, jobRunning, nil, nil)
+
+		ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+		defer cancel()
+		result, err := run(ctx)
+		if err != nil {
+			log.Printf("Job %s (%s) failed: %v", job.ID, jobType, err)
+			setJobState(job.ID, jobFailed, nil, err)
+			return
+		}
+		setJobState(job.ID, jobDone, result, nil)
+	}()
+	return snapshot
+}
+
+func setJobState(id, state string, result any, err error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job := jobs[id]
+	job.State = state
+	if state == jobDone || state == jobFailed {
+		now := clock.Now()
+		job.Finished = &now
+		job.Result = result
+		if err != nil {
+			job.Error = err.Error()
+		}
+	}
+}
+
+func writeJobResponse(w http.ResponseWriter, statusCode int, job *Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(JobResponse{Job: job, Status: statusCode, Success: job != nil})
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// sendJobAccepted answers the submission of job.
+func sendJobAccepted(w http.ResponseWriter, job Job) {
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJobResponse(w, http.StatusAccepted, &job)
+}
+
+// jobHandler serves GET /jobs/{id}.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	jobsMutex.Lock()
+	job, ok := jobs[r.PathValue("id")]
+	var snapshot Job
+	if ok {
+		snapshot = *job
+	}
+	jobsMutex.Unlock()
+
+	// another client's job is reported as missing, not forbidden, so ids
+	// cannot be probed
+	if !ok || snapshot.owner != rateClient(r) {
+		writeJobResponse(w, http.StatusNotFound, nil)
+		return
+	}
+	setLogField(r.Context(), "job", snapshot.ID)
+	writeJobResponse(w, http.StatusOK, &snapshot)
+}
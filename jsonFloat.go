@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Missing-value policy:
+// grid cells without a value (GRIB bitmap holes, fill values) are carried as
+// NaN inside the server. JSON has no NaN, so every float leaving the process,
+// both API responses and the tmp/*.json cache files, encodes NaN and ±Inf as
+// null. Decoding reverses this (null -> NaN) so a missing cell survives the
+// cache round-trip instead of silently turning into 0.
+//
+// Numbers are always formatted with strconv, never fmt/locale aware code, so
+// the decimal separator is "." regardless of the host locale.
+
+// NullFloat is a float64 that encodes NaN and ±Inf as JSON null.
+type NullFloat float64
+
+func (f NullFloat) MarshalJSON() ([]byte, error) {
+	return appendNullFloat(nil, float64(f)), nil
+}
+
+func (f *NullFloat) UnmarshalJSON(data []byte) error {
+	v, err := parseNullFloat(bytes.TrimSpace(data))
+	if err != nil {
+		return err
+	}
+	*f = NullFloat(v)
+	return nil
+}
+
+// NullFloats is a []float64 that applies the NullFloat policy to every element.
+type NullFloats []float64
+
+func (s NullFloats) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	buf := make([]byte, 0, len(s)*8+2)
+	buf = append(buf, '[')
+	for i, v := range s {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendNullFloat(buf, v)
+	}
+	return append(buf, ']'), nil
+}
+
+func (s *NullFloats) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	if len(data) < 2 || data[0] != '[' || data[len(data)-1] != ']' {
+		return fmt.Errorf("expected JSON array of numbers")
+	}
+	body := data[1 : len(data)-1]
+	values := make([]float64, 0, bytes.Count(body, []byte{','})+1)
+	if len(bytes.TrimSpace(body)) == 0 {
+		*s = values
+		return nil
+	}
+	for {
+		end := bytes.IndexByte(body, ',')
+		field := body
+		if end >= 0 {
+			field = body[:end]
+		}
+		v, err := parseNullFloat(bytes.TrimSpace(field))
+		if err != nil {
+			return fmt.Errorf("element %d: %w", len(values), err)
+		}
+		values = append(values, v)
+		if end < 0 {
+			break
+		}
+		body = body[end+1:]
+	}
+	*s = values
+	return nil
+}
+
+func parseNullFloat(field []byte) (float64, error) {
+	if string(field) == "null" {
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(string(field), 64)
+}
+
+// appendNullFloat formats v the same way encoding/json does, except that
+// NaN and ±Inf become null instead of an UnsupportedValueError.
+func appendNullFloat(buf []byte, v float64) []byte {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return append(buf, "null"...)
+	}
+	abs := math.Abs(v)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	buf = strconv.AppendFloat(buf, v, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
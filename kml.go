@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KML 2.2 output of typhoon tracks for Google Earth, still common in
+// emergency management: the track line, a placemark per record styled by
+// category, and the 34, 50 and 64 kt isotach footprints of the swath model
+// (exposureJob.go) as circles along the track. IBTrACS holds best tracks
+// only, so there are no forecast cones. KMZ is the same document zipped.
+
+const kmlNamespace = "http://www.opengis.net/kml/2.2"
+
+// kmlIsotachs are the footprint thresholds in kts, with their fill colours
+// (aabbggrr).
+var kmlIsotachs = []struct {
+	Kts   float64
+	Color string
+}{
+	{34, "4000ffff"},
+	{50, "40008cff"},
+	{64, "400000ff"},
+}
+
+// kmlCategoryColors are the point colours (aabbggrr) by Saffir-Simpson
+// category, tropical storm and weaker first.
+var kmlCategoryColors = []string{"ffffaa00", "ff00ffff", "ff00c8ff", "ff008cff", "ff0000ff", "ffff00ff"}
+
+type kmlDocument struct {
+	XMLName  xml.Name `xml:"kml"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Document kmlBody  `xml:"Document"`
+}
+
+type kmlBody struct {
+	Name        string      `xml:"name"`
+	Description string      `xml:"description,omitempty"`
+	Styles      []kmlStyle  `xml:"Style"`
+	Folders     []kmlFolder `xml:"Folder"`
+}
+
+type kmlStyle struct {
+	ID        string        `xml:"id,attr"`
+	IconStyle *kmlIconStyle `xml:"IconStyle,omitempty"`
+	LineStyle *kmlLineStyle `xml:"LineStyle,omitempty"`
+	PolyStyle *kmlPolyStyle `xml:"PolyStyle,omitempty"`
+}
+
+type kmlIconStyle struct {
+	Color string  `xml:"color"`
+	Scale float64 `xml:"scale"`
+	Icon  string  `xml:"Icon>href"`
+}
+
+type kmlLineStyle struct {
+	Color string  `xml:"color"`
+	Width float64 `xml:"width"`
+}
+
+type kmlPolyStyle struct {
+	Color   string `xml:"color"`
+	Outline int    `xml:"outline"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name          string            `xml:"name,omitempty"`
+	Description   string            `xml:"description,omitempty"`
+	When          string            `xml:"TimeStamp>when,omitempty"`
+	StyleURL      string            `xml:"styleUrl,omitempty"`
+	Point         *kmlCoordinates   `xml:"Point,omitempty"`
+	LineString    *kmlLineString    `xml:"LineString,omitempty"`
+	MultiGeometry *kmlMultiGeometry `xml:"MultiGeometry,omitempty"`
+}
+
+type kmlCoordinates struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlLineString struct {
+	Tessellate  int    `xml:"tessellate"`
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlMultiGeometry struct {
+	Polygons []kmlPolygon `xml:"Polygon"`
+}
+
+type kmlPolygon struct {
+	Outer string `xml:"outerBoundaryIs>LinearRing>coordinates"`
+}
+
+// kmlCoordinate formats a position as KML's lon,lat.
+func kmlCoordinate(lat, lon float64) string {
+	return strconv.FormatFloat(lon, 'f', 4, 64) + "," + strconv.FormatFloat(lat, 'f', 4, 64)
+}
+
+// kmlCircle is a ring of radius km around (lat, lon).
+func kmlCircle(lat, lon, km float64) string {
+	const vertices = 48
+	coords := make([]string, 0, vertices+1)
+	for k := range vertices + 1 {
+		plat, plon := destinationPoint(lat, lon, float64(k%vertices)*360/vertices, km)
+		coords = append(coords, kmlCoordinate(plat, plon))
+	}
+	return strings.Join(coords, " ")
+}
+
+// trackKML builds the KML document of a storm's records.
+func trackKML(records [][]string) kmlDocument {
+	first := records[0]
+	body := kmlBody{
+		Name:        strings.TrimSpace(first[colName] + " " + first[colSID]),
+		Description: "IBTrACS " + first[colSeason] + " " + first[colBasin] + ". Isotach footprints are modelled from the reported maximum wind, not observed.",
+		Styles:      []kmlStyle{{ID: "track", LineStyle: &kmlLineStyle{Color: "ffffffff", Width: 2}}},
+	}
+	for i, color := range kmlCategoryColors {
+		body.Styles = append(body.Styles, kmlStyle{
+			ID:        "cat" + strconv.Itoa(i),
+			IconStyle: &kmlIconStyle{Color: color, Scale: 0.6, Icon: "http://maps.google.com/mapfiles/kml/shapes/placemark_circle.png"},
+		})
+	}
+
+	var line []string
+	points := kmlFolder{Name: "Positions"}
+	for _, record := range records {
+		lat, lon, ok := recordLatLon(record)
+		if !ok {
+			continue
+		}
+		lon = normalizeLon(lon)
+		line = append(line, kmlCoordinate(lat, lon))
+		category := int(parseCSVFloat(record[colCat]))
+		category = max(0, min(category, len(kmlCategoryColors)-1)) // NaN and depressions go to 0
+		placemark := kmlPlacemark{
+			Description: fmt.Sprintf("wind=%s kts pres=%s mb cat=%s nature=%s", record[colWind], record[colPres], record[colCat], record[colNature]),
+			StyleURL:    "#cat" + strconv.Itoa(category),
+			Point:       &kmlCoordinates{kmlCoordinate(lat, lon)},
+		}
+		if t, err := time.Parse("20060102150405", record[colIsoTime]); err == nil {
+			placemark.Name = t.UTC().Format("01-02 15Z")
+			placemark.When = t.UTC().Format(time.RFC3339)
+		}
+		points.Placemarks = append(points.Placemarks, placemark)
+	}
+	track := kmlFolder{Name: "Track", Placemarks: []kmlPlacemark{{
+		Name:       body.Name,
+		StyleURL:   "#track",
+		LineString: &kmlLineString{Tessellate: 1, Coordinates: strings.Join(line, " ")},
+	}}}
+	body.Folders = append(body.Folders, track, points)
+
+	fixes := trackFixes(records, swathSubstep)
+	for _, isotach := range kmlIsotachs {
+		id := fmt.Sprintf("isotach%.0f", isotach.Kts)
+		body.Styles = append(body.Styles, kmlStyle{
+			ID:        id,
+			LineStyle: &kmlLineStyle{Color: "00000000", Width: 0},
+			PolyStyle: &kmlPolyStyle{Color: isotach.Color, Outline: 0},
+		})
+		footprint := &kmlMultiGeometry{}
+		for _, fix := range fixes {
+			if radius := isotachRadiusKm(fix.wind, isotach.Kts*0.514444); radius > 0 {
+				footprint.Polygons = append(footprint.Polygons, kmlPolygon{kmlCircle(fix.lat, fix.lon, radius)})
+			}
+		}
+		folder := kmlFolder{Name: fmt.Sprintf("%.0f kt footprint", isotach.Kts)}
+		if len(footprint.Polygons) > 0 {
+			folder.Placemarks = []kmlPlacemark{{Name: folder.Name, StyleURL: "#" + id, MultiGeometry: footprint}}
+		}
+		body.Folders = append(body.Folders, folder)
+	}
+	return kmlDocument{Xmlns: kmlNamespace, Document: body}
+}
+
+func writeTrackKML(w io.Writer, records [][]string) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(trackKML(records)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeTrackKMZ writes the KML zipped as doc.kml, the name Google Earth
+// opens.
+func writeTrackKMZ(w io.Writer, records [][]string) error {
+	archive := zip.NewWriter(w)
+	file, err := archive.Create("doc.kml")
+	if err != nil {
+		return err
+	}
+	if err := writeTrackKML(file, records); err != nil {
+		return err
+	}
+	return archive.Close()
+}
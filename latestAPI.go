@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// A run is never published sooner than this after its nominal time.
+	batchPublishLag = 7 * time.Hour
+	// How far back latestBatch looks before giving up, in batches.
+	maxLatestLookback = 8
+	// How long a batch found missing upstream is not asked for again.
+	unpublishedTTL = 5 * time.Minute
+)
+
+type LatestResponse struct {
+	Date    string `json:"date"`
+	Batch   string `json:"batch"`
+	AsOf    string `json:"as_of"`
+	Status  int    `json:"status"`
+	Success bool   `json:"success"`
+}
+
+var latestFailResponse = LatestResponse{
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendLatestJsonError(w http.ResponseWriter, statusCode int) {
+	response := latestFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Negative cache of batches upstream did not have, keyed by date|batch and
+// holding when the entry expires.
+var (
+	unpublishedMutex sync.Mutex
+	unpublished      = make(map[string]time.Time)
+)
+
+// batchPublished reports whether the batch is upstream, remembering misses
+// for unpublishedTTL so /latest does not hit the bucket on every request.
+func batchPublished(ctx context.Context, date, batch string) bool {
+	key := date + "|" + batch
+	now := clock.Now()
+	unpublishedMutex.Lock()
+	expires, ok := unpublished[key]
+	if ok && now.After(expires) {
+		delete(unpublished, key)
+		ok = false
+	}
+	unpublishedMutex.Unlock()
+	if ok {
+		return false
+	}
+
+	stream, err := streamForBatch(batch)
+	if err != nil {
+		return false
+	}
+	if source.Published(ctx, date, batch, stream, 0) {
+		return true
+	}
+	unpublishedMutex.Lock()
+	unpublished[key] = now.Add(unpublishedTTL)
+	unpublishedMutex.Unlock()
+	return false
+}
+
+// latestBatch returns the most recent batch that is published as of the
+// request's clock.
+func latestBatch(ctx context.Context) (date, batch string, err error) {
+	now := clockFrom(ctx).Now().UTC()
+	run := now.Add(-batchPublishLag).Truncate(6 * time.Hour)
+	for range maxLatestLookback {
+		date, batch = run.Format("20060102"), fmt.Sprintf("%02dz", run.Hour())
+		if batchPublished(ctx, date, batch) {
+			return date, batch, nil
+		}
+		run = run.Add(-6 * time.Hour)
+	}
+	return "", "", fmt.Errorf("%w: none of the %d batches before %s is published", ErrDataNotPublished, maxLatestLookback, now.Format(time.RFC3339))
+}
+
+// latestHandler serves /latest, the newest published date and batch.
+func latestHandler(w http.ResponseWriter, r *http.Request) {
+	date, batch, err := latestBatch(r.Context())
+	if err != nil {
+		sendLatestJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+	response := LatestResponse{
+		Date:    date,
+		Batch:   batch,
+		AsOf:    clockFrom(r.Context()).Now().UTC().Format(time.RFC3339),
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Load shedding: every load of a grid that is not in memory (a disk read or
+// a download, including prefetches) holds a slot while it runs. Once
+// maxColdDepth of them are in flight, further cold loads fail right away
+// with ErrOverloaded rather than queueing behind them, so a burst of cold
+// queries cannot push the latency of everything up. Warm cache hits never
+// take a slot and are served as usual. 0 disables shedding.
+
+var (
+	maxColdDepth   = 32
+	shedRetryAfter = 5 * time.Second
+
+	coldDepth atomic.Int64
+	coldShed  atomic.Int64 // cold loads rejected since start
+)
+
+// beginColdLoad admits a cold load, or refuses it under memory pressure or
+// when too many are in flight. The returned func releases the slot.
+func beginColdLoad() (func(), error) {
+	if err := checkColdIngest(); err != nil {
+		return nil, err
+	}
+	depth := coldDepth.Add(1)
+	if maxColdDepth > 0 && depth > int64(maxColdDepth) {
+		coldDepth.Add(-1)
+		if coldShed.Add(1)%100 == 1 {
+			log.Printf("Shedding cold loads: %d in flight, %d rejected so far", depth-1, coldShed.Load())
+		}
+		return nil, ErrOverloaded
+	}
+	return func() { coldDepth.Add(-1) }, nil
+}
+
+// retryAfter adds a Retry-After header to 503 responses, which the server
+// sends for conditions that clear on their own, save those that need an
+// operator, which carry an X-Remediation header instead.
+func retryAfter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&retryAfterWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+type retryAfterWriter struct {
+	http.ResponseWriter
+	r *http.Request
+}
+
+func (w *retryAfterWriter) WriteHeader(status int) {
+	remediation := logField(w.r.Context(), "remediation")
+	if status == http.StatusServiceUnavailable && remediation != "" {
+		w.Header().Set("X-Remediation", remediation)
+	} else if status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", strconv.Itoa(int(shedRetryAfter.Seconds())))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
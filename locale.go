@@ -0,0 +1,170 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Human readable strings of responses, storm natures, category names and
+// error messages, are in the language asked for with Accept-Language, or
+// lang= which overrides it for links and clients that cannot set headers.
+// English and Chinese are supported, anything else gets English. Responses
+// say which they are in with Content-Language. Codes (nature "TS", category
+// 3, status 404) never change, the names are added next to them.
+
+const (
+	langEnglish = "en"
+	langChinese = "zh"
+)
+
+const (
+	categoryScaleSSHS = "sshs" // Saffir-Simpson with IBTrACS' negative categories
+	categoryScaleCMA  = "cma"
+)
+
+type languageKey struct{}
+
+// negotiateLanguage puts the response language in the request's context.
+func negotiateLanguage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := parseAcceptLanguage(r.Header.Get("Accept-Language"))
+		if query := r.URL.Query().Get("lang"); query != "" {
+			lang = parseAcceptLanguage(query)
+		}
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), languageKey{}, lang)))
+	})
+}
+
+// languageFrom returns the response language of the request, English
+// outside of one.
+func languageFrom(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey{}).(string); ok {
+		return lang
+	}
+	return langEnglish
+}
+
+// parseAcceptLanguage picks the supported language with the highest q of an
+// Accept-Language header, zh-CN, zh-TW and zh-Hans all being Chinese.
+func parseAcceptLanguage(header string) string {
+	type preference struct {
+		lang string
+		q    float64
+	}
+	var preferences []preference
+	for i, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if (primary == langEnglish || primary == langChinese) && q > 0 {
+			// earlier entries win ties
+			preferences = append(preferences, preference{primary, q - float64(i)*1e-6})
+		}
+	}
+	if len(preferences) == 0 {
+		return langEnglish
+	}
+	return slices.MaxFunc(preferences, func(a, b preference) int { return cmp.Compare(a.q, b.q) }).lang
+}
+
+// message is a human readable string in every supported language.
+type message struct {
+	en, zh string
+}
+
+func (m message) in(lang string) string {
+	if lang == langChinese {
+		return m.zh
+	}
+	return m.en
+}
+
+var natureNames = map[string]message{
+	"DS": {"Disturbance", "扰动"},
+	"TS": {"Tropical", "热带系统"},
+	"ET": {"Extratropical", "温带系统"},
+	"SS": {"Subtropical", "副热带系统"},
+	"NR": {"Not reported", "未报告"},
+	"MX": {"Mixed reports", "各机构报告不一"},
+}
+
+// natureName is the name of an IBTrACS NATURE code, "" for unknown codes.
+func natureName(lang, nature string) string {
+	if name, ok := natureNames[nature]; ok {
+		return name.in(lang)
+	}
+	return ""
+}
+
+var categoryNames = map[string]map[int]message{
+	categoryScaleSSHS: {
+		-5: {"Unknown", "未知"},
+		-4: {"Post-tropical", "后热带系统"},
+		-3: {"Miscellaneous disturbance", "其他扰动"},
+		-2: {"Subtropical", "副热带系统"},
+		-1: {"Tropical depression", "热带低压"},
+		0:  {"Tropical storm", "热带风暴"},
+		1:  {"Category 1", "一级"},
+		2:  {"Category 2", "二级"},
+		3:  {"Category 3", "三级"},
+		4:  {"Category 4", "四级"},
+		5:  {"Category 5", "五级"},
+	},
+	categoryScaleCMA: {
+		0: {"Weaker than tropical depression", "弱于热带低压"},
+		1: {"Tropical depression", "热带低压"},
+		2: {"Tropical storm", "热带风暴"},
+		3: {"Severe tropical storm", "强热带风暴"},
+		4: {"Typhoon", "台风"},
+		5: {"Severe typhoon", "强台风"},
+		6: {"Super typhoon", "超强台风"},
+		9: {"Extratropical transition", "变性温带气旋"},
+	},
+}
+
+// categoryName is the name of a category on scale, "" for missing or unknown
+// ones.
+func categoryName(lang, scale string, category float64) string {
+	if math.IsNaN(category) || category != math.Trunc(category) {
+		return ""
+	}
+	if name, ok := categoryNames[scale][int(category)]; ok {
+		return name.in(lang)
+	}
+	return ""
+}
+
+var statusMessages = map[int]message{
+	http.StatusBadRequest:          {"Bad Request", "请求无效"},
+	http.StatusUnauthorized:        {"Unauthorized", "未认证"},
+	http.StatusForbidden:           {"Forbidden", "无权访问"},
+	http.StatusNotFound:            {"Not Found", "未找到"},
+	http.StatusMethodNotAllowed:    {"Method Not Allowed", "不支持该请求方法"},
+	http.StatusConflict:            {"Conflict", "请求冲突"},
+	http.StatusTooManyRequests:     {"Too Many Requests", "请求过于频繁"},
+	http.StatusInternalServerError: {"Internal Server Error", "服务器内部错误"},
+	http.StatusBadGateway:          {"Bad Gateway", "上游数据源不可用"},
+	http.StatusServiceUnavailable:  {"Service Unavailable", "服务暂时不可用"},
+}
+
+// statusText is http.StatusText in lang.
+func statusText(lang string, code int) string {
+	if text, ok := statusMessages[code]; ok {
+		return text.in(lang)
+	}
+	return http.StatusText(code)
+}
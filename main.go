@@ -1,24 +1,272 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-const bucketName = "ecmwf-open-data"
+func registerHandlers() {
+	http.HandleFunc("/api", requireRole(roleReader, singleQueryHandler))
+	http.HandleFunc("POST /batch", requireRole(roleReader, batchHandler))
+	http.HandleFunc("/range", requireRole(roleReader, rangeQueryHandler))
+	http.HandleFunc("/daterange", requireRole(roleReader, dateRangeQueryHandler))
+	http.HandleFunc("/typhoon", requireRole(roleReader, typhonAPIHandler))
+	http.HandleFunc("/typhoon/seasons/{year}", requireRole(roleReader, typhoonSeasonHandler))
+	http.HandleFunc("/typhoon/tracks/{sid}", requireRole(roleReader, typhoonTrackHandler))
+	http.HandleFunc("/typhoon/resolve", requireRole(roleReader, typhoonResolveHandler))
+	http.HandleFunc("POST /typhoon/refresh", requireRole(roleAdmin, typhoonRefreshHandler))
+	http.HandleFunc("/regrid", requireRole(roleReader, regridHandler))
+	http.HandleFunc("GET /vector-tile/{z}/{x}/{y}", experimental("tiles", requireRole(roleReader, vectorTileHandler)))
+	http.HandleFunc("GET /render", requireRole(roleReader, renderHandler))
+	http.HandleFunc("/windrose", requireRole(roleReader, windRoseHandler))
+	http.HandleFunc("/extremes", experimental("extremes", requireRole(roleReader, extremesHandler)))
+	http.HandleFunc("/diurnal", requireRole(roleReader, diurnalHandler))
+	http.HandleFunc("/correlate", requireRole(roleReader, correlateHandler))
+	http.HandleFunc("/stats", requireRole(roleReader, statsHandler))
+	http.HandleFunc("/transect", requireRole(roleReader, transectHandler))
+	http.HandleFunc("/trajectory", experimental("trajectory", requireRole(roleReader, trajectoryHandler)))
+	http.HandleFunc("/trajectory/origin", experimental("trajectory", requireRole(roleReader, originHandler)))
+	http.HandleFunc("/routing/cost", requireRole(roleReader, routingCostHandler))
+	http.HandleFunc("/drone/windows", requireRole(roleReader, droneHandler))
+	http.HandleFunc("POST /energy/capacity-factor", requireRole(roleReader, capacityFactorHandler))
+	http.HandleFunc("GET /export/dispersion", requireRole(roleReader, dispersionHandler))
+	http.HandleFunc("GET /export/geotiff", requireRole(roleReader, geotiffHandler))
+	http.HandleFunc("/fire-weather", requireRole(roleReader, fireWeatherHandler))
+	http.HandleFunc("GET /spots", requireRole(roleReader, spotsHandler))
+	http.HandleFunc("GET /spots/{name}/conditions", requireRole(roleReader, spotConditionsHandler))
+	http.HandleFunc("GET /rules/{id}/calendar.ics", ruleCalendarHandler) // the rule's feed token is its credential
+	http.HandleFunc("POST /jobs/exposure", requireRole(roleReader, idempotent(exposureJobHandler)))
+	http.HandleFunc("GET /jobs/{id}", requireRole(roleReader, jobHandler))
+	http.HandleFunc("GET /aviation/crosswind", requireRole(roleReader, crosswindHandler))
+	http.HandleFunc("POST /aviation/crosswind", requireRole(roleReader, crosswindBatchHandler))
+	http.HandleFunc("/composite", experimental("composite", requireRole(roleReader, compositeHandler)))
+	http.HandleFunc("/debug/neighborhood", requireRole(roleReader, debugNeighborhoodHandler))
+	http.HandleFunc("GET /feeds/{feed}", requireRole(roleReader, feedHandler))
+	http.HandleFunc("/latest", requireRole(roleReader, latestHandler))
+	http.HandleFunc("/steps", requireRole(roleReader, stepsHandler))
+	http.HandleFunc("/manifest", requireRole(roleReader, manifestHandler))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("GET /capabilities", requireRole(roleReader, capabilitiesHandler))
+	http.HandleFunc("GET /signing-key", signingKeyHandler)
+	http.HandleFunc("GET /openapi.json", openAPIHandler)
+	http.HandleFunc("GET /docs", docsHandler)
+	http.HandleFunc("GET /griber.proto", grpcProtoHandler)
+	http.HandleFunc("GET /examples", requireRole(roleReader, examplesHandler))
+
+	http.HandleFunc("GET /admin/cache", requireRole(roleIngester, adminCacheHandler))
+	http.HandleFunc("POST /admin/prefetch", requireRole(roleIngester, idempotent(adminPrefetchHandler)))
+	http.HandleFunc("POST /admin/cache/purge", requireRole(roleAdmin, adminPurgeHandler))
+	http.HandleFunc("POST /admin/reload", requireRole(roleAdmin, adminReloadHandler))
+	http.HandleFunc("GET /admin/export", requireRole(roleIngester, adminExportHandler))
+	http.HandleFunc("GET /admin/costs", requireRole(roleAdmin, adminCostsHandler))
+	http.HandleFunc("GET /admin/audit", requireRole(roleAdmin, adminAuditHandler))
+}
 
 func main() {
-	http.HandleFunc("/api", singleQueryHandler)
-	http.HandleFunc("/range", rangeQueryHandler)
-	http.HandleFunc("/daterange", dateRangeQueryHandler)
-	http.HandleFunc("/typhoon", typhonAPIHandler)
-	port := ":8080"
-	fmt.Printf("Listening on http://localhost%s\n", port)
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	// griber doctor takes the server's flags, parsed below
+	doctor := len(os.Args) > 1 && os.Args[1] == "doctor"
+
+	configPath := flag.String("config", "", "JSON file of settings keyed by flag name; GRIBER_* environment variables and flags override it")
+	flag.StringVar(&listenAddr, "listen", listenAddr, "address to serve HTTP on")
+	flag.StringVar(&grpcListen, "grpc-listen", grpcListen, "address to also serve the query endpoints on as a gRPC service (empty disables)")
+	flag.StringVar(&bucketName, "bucket", bucketName, "GCS bucket of the ECMWF open data")
+	flag.StringVar(&tmpDir, "tmp-dir", tmpDir, "directory grid files, the manifest and objects are stored in")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "how long requests in flight are drained on SIGTERM before they are cancelled")
+	flag.IntVar(&maxCacheSize, "cache-grids", maxCacheSize, "parameter grids kept in memory before the cache is cleared")
+	memoryLimit := flag.String("memory-limit", "0", "memory ceiling, e.g. 4GiB; evicts cached grids and rejects cold ingests when approached (0 disables)")
+	flag.IntVar(&rateLimit, "rate-limit", rateLimit, "requests per -rate-window each client (token, or IP without one) may make, in bursts of up to as many (0 disables)")
+	flag.IntVar(&ipRateLimit, "ip-rate-limit", ipRateLimit, "requests per -rate-window each IP may make whatever tokens it sends (0 disables)")
+	flag.DurationVar(&rateWindow, "rate-window", rateWindow, "time the rate limits refill over")
+	flag.IntVar(&maxColdDepth, "max-cold-loads", maxColdDepth, "cold grid loads in flight beyond which further ones are rejected with 503 (0 disables)")
+	flag.DurationVar(&shedRetryAfter, "retry-after", shedRetryAfter, "Retry-After sent with 503 responses")
+	flag.IntVar(&chunkWorkers, "chunk-workers", chunkWorkers, "GRIB chunks of one file decoded in parallel")
+	flag.IntVar(&verifySamples, "verify-samples", verifySamples, "points per ingested chunk to compare against grib_get (0 disables)")
+	flag.Float64Var(&verifyTolerance, "verify-tolerance", verifyTolerance, "maximum absolute difference accepted by ingest verification")
+	flag.BoolVar(&verifyAbort, "verify-abort", verifyAbort, "fail the ingest when verification finds mismatches")
+	flag.StringVar(&ibtracsPath, "ibtracs", ibtracsPath, "IBTrACS CSV, either the trimmed file or a full release")
+	flag.DurationVar(&ibtracsRefreshInterval, "ibtracs-refresh", ibtracsRefreshInterval, "how often to download the latest IBTrACS release, 0 to disable")
+	flag.StringVar(&ibtracsURL, "ibtracs-url", ibtracsURL, "where to download the IBTrACS CSV from when -ibtracs is missing (empty disables)")
+	flag.StringVar(&ibtracsAgency, "ibtracs-agency", ibtracsAgency, "agency whose position, wind and pressure columns are used when the CSV has them, e.g. CMA, TOKYO, USA")
+	authTokensPath := flag.String("auth-tokens", "", "file of \"role token\" lines enabling access control, roles: reader, ingester, admin (empty disables)")
+	flag.StringVar(&apiKeys, "api-keys", apiKeys, "comma separated \"role:token\" pairs added to -auth-tokens, best set as GRIBER_API_KEYS")
+	flag.StringVar(&swaggerUIBase, "swagger-ui", swaggerUIBase, "base URL of the swagger-ui-dist files /docs loads")
+	flag.StringVar(&shadowURL, "shadow-url", shadowURL, "base URL of a second instance to mirror GET requests to, e.g. http://canary:8080 (empty disables)")
+	flag.Float64Var(&shadowPercent, "shadow-percent", shadowPercent, "percentage of GET requests mirrored to -shadow-url")
+	flag.DurationVar(&shadowTimeout, "shadow-timeout", shadowTimeout, "timeout of mirrored requests")
+	flag.Float64Var(&shadowTolerance, "shadow-tolerance", shadowTolerance, "absolute difference between numbers ignored when diffing mirrored responses")
+	flag.BoolVar(&gcsNoAuth, "no-gcs-auth", gcsNoAuth, "read GRIB data with anonymous HTTP range requests instead of the authenticated GCS client")
+	flag.StringVar(&auditLogPath, "audit-log", auditLogPath, "file admin operations are appended to (empty disables)")
+	flag.StringVar(&costsPath, "costs", costsPath, "file per client cost totals are kept in (empty keeps them in memory)")
+	hooksFlag := flag.String("hooks", "", "comma separated post-ingest hooks to run: wind_alert, export")
+	flag.Float64Var(&hookAlertSpeed, "alert-speed", hookAlertSpeed, "wind speed in m/s the wind_alert hook logs at")
+	flag.StringVar(&hookExportDir, "export-dir", hookExportDir, "directory the export hook writes to")
+	datasetsPath := flag.String("datasets", "", "JSON file of virtual datasets selectable with dataset= (empty disables)")
+	spotsPath := flag.String("spots", "", "JSON file of saved surf and kite spots rated on /spots/{name}/conditions (empty disables)")
+	rulesPath := flag.String("rules", "", "JSON file of saved threshold rules with iCalendar feeds on /rules/{id}/calendar.ics (empty disables)")
+	signingKeyPath := flag.String("signing-key", "", "file of \"hmac-sha256 <key>\" or \"ed25519 <seed>\" (base64) to sign responses with (empty disables)")
+	fixturesDir := flag.String("fixtures", "", "read index files and GRIB data from this directory, laid out like the bucket, instead of GCS")
+	seed := flag.Int64("seed", 0, "seed of the server's random choices, for reproducible runs (0 picks one)")
+	featuresFlag := flag.String("features", "", "comma separated experimental features to enable, -name disables a default one")
+	packingFlag := flag.String("packing", packing, "how new grids are stored in tmp/: float (float32) or int16 (scaled, half the size)")
+	flag.IntVar(&responseCompressMin, "response-compress-min", responseCompressMin, "responses from this many bytes are gzip/deflate compressed for clients accepting it (-1 disables)")
+	compressFlag := flag.Int("compress-level", compressLevel, "zstd level 1-22 new grid files in tmp/ are compressed with (0 disables)")
+	gribDecoderFlag := flag.String("grib-decoder", gribDecoder, "how GRIB chunks are decoded: auto (every backend available, native first), or a comma separated fallback chain of native (pure Go) and grib_dump (needs eccodes)")
+	windUnitFlag := flag.String("wind-unit", windUnit, "unit of wind speeds in typhoon responses: m/s, kts or km/h")
+	if doctor {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+	if err := applyConfig(flag.CommandLine, *configPath); err != nil {
+		log.Fatalf("Invalid -config: %v", err)
+	}
+	manifest.path = filepath.Join(tmpDir, "manifest.json")
+	limit, err := parseByteSize(*memoryLimit)
+	if err != nil {
+		log.Fatalf("Invalid -memory-limit: %v", err)
+	}
+	if windUnit, err = parseWindUnit(*windUnitFlag); err != nil {
+		log.Fatalf("Invalid -wind-unit: %v", err)
+	}
+	if packing, err = parsePacking(*packingFlag); err != nil {
+		log.Fatalf("Invalid -packing: %v", err)
+	}
+	if compressLevel, err = parseCompressLevel(*compressFlag); err != nil {
+		log.Fatalf("Invalid -compress-level: %v", err)
+	}
+	if rateWindow <= 0 {
+		log.Fatalf("Invalid -rate-window: %v", rateWindow)
+	}
+	if gribDecoder, decodeChain, err = parseGribDecoder(*gribDecoderFlag); err != nil {
+		log.Fatalf("Invalid -grib-decoder: %v", err)
+	}
+	if enabledFeatures, err = parseFeatures(*featuresFlag); err != nil {
+		log.Fatalf("Invalid -features: %v", err)
+	}
+	if *fixturesDir != "" {
+		if err := useFixtures(*fixturesDir); err != nil {
+			log.Fatalf("Invalid -fixtures: %v", err)
+		}
+	}
+	if *seed != 0 {
+		rng = newLockedRand(*seed)
+	}
+	if authTokens, err = authTokenStore(*authTokensPath); err != nil {
+		log.Fatalf("Invalid -auth-tokens: %v", err)
+	}
+	if enabledHooks, err = parseHooks(*hooksFlag); err != nil {
+		log.Fatalf("Invalid -hooks: %v", err)
+	}
+	if *datasetsPath != "" {
+		if datasets, err = loadDatasets(*datasetsPath); err != nil {
+			log.Fatalf("Invalid -datasets: %v", err)
+		}
+	}
+	if *spotsPath != "" {
+		if spots, err = loadSpots(*spotsPath); err != nil {
+			log.Fatalf("Invalid -spots: %v", err)
+		}
+	}
+	if *rulesPath != "" {
+		if rules, err = loadRules(*rulesPath); err != nil {
+			log.Fatalf("Invalid -rules: %v", err)
+		}
+	}
+	if *signingKeyPath != "" {
+		if signer, err = loadSigningKey(*signingKeyPath); err != nil {
+			log.Fatalf("Invalid -signing-key: %v", err)
+		}
+	}
+	if doctor {
+		detectDecoders()
+		os.Exit(runDoctor(os.Stdout))
+	}
+	removePartialFiles()
+	detectDecoders()
+	startMemoryWatchdog(limit)
+	startCostFlusher()
+	startTyphonLoader()
+	startTyphonRefresher()
+
+	registerHandlers()
+	if grpcListen != "" {
+		if err := startGRPC(); err != nil {
+			log.Fatalf("Fail to serve gRPC on %s: %v", grpcListen, err)
+		}
+		fmt.Printf("Serving gRPC %s.%s on %s\n", grpcPackage, grpcServiceName, grpcListen)
+	}
+	host := listenAddr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	fmt.Printf("Listening on http://%s\n", host)
+	// experimental endpoints are listed only while their feature is on
 	fmt.Printf("  - Single point API: /api\n")
+	fmt.Printf("  - Batch points API: /batch (POST)\n")
 	fmt.Printf("  - Range coord API:  /range\n")
 	fmt.Printf("  - Date range API:   /daterange\n")
 	fmt.Printf("  - Typhoon API: /typhoon\n")
-	err := http.ListenAndServe(":8080", nil)
+	fmt.Printf("  - Typhoon seasons: /typhoon/seasons/{year}\n")
+	fmt.Printf("  - Typhoon tracks:  /typhoon/tracks/{sid} (json, csv, gpx, kml, kmz, shp)\n")
+	fmt.Printf("  - Typhoon resolve: /typhoon/resolve\n")
+	fmt.Printf("  - Typhoon refresh: /typhoon/refresh (admin)\n")
+	fmt.Printf("  - Regrid API:  /regrid\n")
+	if featureEnabled("tiles") {
+		fmt.Printf("  - Vector tiles: /vector-tile/{z}/{x}/{y}\n")
+	}
+	fmt.Printf("  - Wind render: /render\n")
+	fmt.Printf("  - Wind rose API: /windrose\n")
+	if featureEnabled("extremes") {
+		fmt.Printf("  - Extremes API:  /extremes\n")
+	}
+	fmt.Printf("  - Diurnal API:   /diurnal\n")
+	fmt.Printf("  - Correlate API: /correlate\n")
+	if featureEnabled("composite") {
+		fmt.Printf("  - Composite API: /composite\n")
+	}
+	fmt.Printf("  - Area stats:  /stats\n")
+	fmt.Printf("  - Transect:    /transect\n")
+	if featureEnabled("trajectory") {
+		fmt.Printf("  - Trajectory:  /trajectory\n")
+		fmt.Printf("  - Trajectory origin: /trajectory/origin\n")
+	}
+	fmt.Printf("  - Routing cost: /routing/cost\n")
+	fmt.Printf("  - Runway crosswind: /aviation/crosswind\n")
+	fmt.Printf("  - Drone windows: /drone/windows\n")
+	fmt.Printf("  - Fire weather: /fire-weather\n")
+	fmt.Printf("  - Dispersion export: /export/dispersion\n")
+	fmt.Printf("  - GeoTIFF export: /export/geotiff\n")
+	fmt.Printf("  - Capacity factor: /energy/capacity-factor (POST)\n")
+	fmt.Printf("  - Surf/kite spots: /spots, /spots/{name}/conditions\n")
+	fmt.Printf("  - Rule calendars: /rules/{id}/calendar.ics\n")
+	fmt.Printf("  - Exposure jobs: /jobs/exposure (POST), /jobs/{id}\n")
+	fmt.Printf("  - Debug neighborhood: /debug/neighborhood\n")
+	fmt.Printf("  - Feeds: /feeds/cycles, /feeds/storms (atom, rss)\n")
+	fmt.Printf("  - Latest batch: /latest\n")
+	fmt.Printf("  - Published steps: /steps\n")
+	fmt.Printf("  - Manifest: /manifest\n")
+	fmt.Printf("  - Liveness: /healthz\n")
+	fmt.Printf("  - Readiness: /readyz\n")
+	fmt.Printf("  - Capabilities: /capabilities\n")
+	fmt.Printf("  - Signing key: /signing-key\n")
+	fmt.Printf("  - OpenAPI spec: /openapi.json, Swagger UI: /docs\n")
+	fmt.Printf("  - gRPC schema: /griber.proto\n")
+	fmt.Printf("  - Examples: /examples (json, ipynb)\n")
+	fmt.Printf("  - Admin: /admin/cache, /admin/prefetch, /admin/cache/purge, /admin/reload, /admin/audit, /admin/export, /admin/costs\n")
+	err = serve(logRequests(negotiateLanguage(compressResponses(limitRate(retryAfter(signResponses(shadowRequests(timeTravel(bypassCache(http.DefaultServeMux))))))))))
 	if err != nil {
 		println(err)
 	}
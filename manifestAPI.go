@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /manifest is a read replica of the grid manifest for external tooling,
+// which polls it with since= to learn what data is stored. Entries come
+// oldest update first, so a poller keeps the updated_at of the last entry it
+// saw (or follows next_cursor) and never misses one.
+
+const (
+	defaultManifestLimit = 1000
+	maxManifestLimit     = 10000
+)
+
+type ManifestAPIParams struct {
+	Since  time.Time `json:"since"` // only entries updated after this
+	Date   string    `json:"date"`  // filters, empty matches all
+	Batch  string    `json:"batch"`
+	Param  string    `json:"param"`
+	Cursor string    `json:"cursor"` // next_cursor of the previous page
+	Limit  int       `json:"limit"`
+}
+
+type ManifestItem struct {
+	Date  string `json:"date"`
+	Batch string `json:"batch"`
+	Param string `json:"param"`
+	Step  string `json:"step"`
+	ManifestEntry
+}
+
+type ManifestResponse struct {
+	Entries    []ManifestItem `json:"entries"`
+	NextCursor string         `json:"next_cursor,omitempty"` // set while more entries follow
+	Status     int            `json:"status"`
+	Success    bool           `json:"success"`
+}
+
+var manifestFailResponse = ManifestResponse{
+	Entries: []ManifestItem{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendManifestJsonError(w http.ResponseWriter, statusCode int) {
+	response := manifestFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// manifestHandler serves /manifest?since=&date=&batch=&param=&cursor=&limit=&format=json|ndjson
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+	params := ManifestAPIParams{
+		Date:   httpQuery.Get("date"),
+		Batch:  httpQuery.Get("batch"),
+		Param:  httpQuery.Get("param"),
+		Cursor: httpQuery.Get("cursor"),
+		Limit:  defaultManifestLimit,
+	}
+	if sinceStr := httpQuery.Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339Nano, sinceStr)
+		if err != nil {
+			sendManifestJsonError(w, http.StatusBadRequest)
+			return
+		}
+		params.Since = since
+	}
+	if limitStr := httpQuery.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxManifestLimit {
+			sendManifestJsonError(w, http.StatusBadRequest)
+			return
+		}
+		params.Limit = limit
+	}
+	format := httpQuery.Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		format = "ndjson"
+	}
+	if format != "" && format != "json" && format != "ndjson" {
+		sendManifestJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	response, err := ManifestQuery(params)
+	if err != nil {
+		sendManifestJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+
+	if format == "ndjson" {
+		// no envelope to carry the cursor, it goes in a header instead
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if response.NextCursor != "" {
+			w.Header().Set("X-Next-Cursor", response.NextCursor)
+		}
+		encoder := json.NewEncoder(w)
+		for _, item := range response.Entries {
+			if err := encoder.Encode(item); err != nil {
+				log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+				return
+			}
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func ManifestQuery(params ManifestAPIParams) (ManifestResponse, error) {
+	after, afterKey, err := decodeManifestCursor(params.Cursor)
+	if err != nil {
+		return manifestFailResponse, err
+	}
+	if params.Since.After(after) {
+		after, afterKey = params.Since, ""
+	}
+
+	entries, err := manifest.snapshot()
+	if err != nil {
+		return manifestFailResponse, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	items := make([]ManifestItem, 0, len(entries))
+	for key, entry := range entries {
+		// oldest first, entries updated at the same instant by key
+		if !after.IsZero() && (entry.UpdatedAt.Before(after) || entry.UpdatedAt.Equal(after) && (afterKey == "" || key <= afterKey)) {
+			continue
+		}
+		parts := strings.Split(key, "|")
+		if len(parts) != 4 {
+			continue
+		}
+		item := ManifestItem{Date: parts[0], Batch: parts[1], Param: parts[2], Step: parts[3], ManifestEntry: entry}
+		if params.Date != "" && item.Date != params.Date ||
+			params.Batch != "" && item.Batch != params.Batch ||
+			params.Param != "" && item.Param != params.Param {
+			continue
+		}
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b ManifestItem) int {
+		return cmp.Or(a.UpdatedAt.Compare(b.UpdatedAt), cmp.Compare(a.key(), b.key()))
+	})
+
+	response := ManifestResponse{Entries: items, Status: http.StatusOK, Success: true}
+	if len(items) > params.Limit {
+		response.Entries = items[:params.Limit]
+		last := response.Entries[params.Limit-1]
+		response.NextCursor = encodeManifestCursor(last.UpdatedAt, last.key())
+	}
+	return response, nil
+}
+
+func (item ManifestItem) key() string {
+	return manifestKey(item.Date, item.Batch, item.Param, item.Step)
+}
+
+// The cursor is the updated_at and key of the last entry of a page.
+func encodeManifestCursor(t time.Time, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.Format(time.RFC3339Nano) + " " + key))
+}
+
+// decodeManifestCursor returns the zero time for an empty cursor. With a
+// plain since=, afterKey is empty and every entry of that instant is skipped.
+func decodeManifestCursor(cursor string) (time.Time, string, error) {
+	if cursor == "" {
+		return time.Time{}, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: cursor", ErrInvalidParams)
+	}
+	timeStr, key, ok := strings.Cut(string(raw), " ")
+	t, err := time.Parse(time.RFC3339Nano, timeStr)
+	if !ok || err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: cursor", ErrInvalidParams)
+	}
+	return t, key, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Memory guardrails: a watchdog samples the process RSS and, once it crosses
+// memoryHighWater of the configured ceiling, evicts the in-memory grid cache
+// and refuses new cold ingests (downloads and cache loads) with
+// ErrMemoryPressure until usage drops again. Warm cache hits keep being served.
+// A ceiling of 0 disables the watchdog.
+
+const (
+	memoryHighWater     = 0.9
+	memoryCheckInterval = 5 * time.Second
+)
+
+var (
+	memoryLimitBytes int64
+	memoryPressure   atomic.Bool
+)
+
+// startMemoryWatchdog runs the watchdog until the process exits.
+func startMemoryWatchdog(limitBytes int64) {
+	memoryLimitBytes = limitBytes
+	if limitBytes <= 0 {
+		return
+	}
+	log.Printf("Memory watchdog enabled, ceiling %d MiB", limitBytes>>20)
+	go func() {
+		ticker := time.NewTicker(memoryCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkMemory()
+		}
+	}()
+}
+
+func checkMemory() {
+	rss := processRSS()
+	high := int64(float64(memoryLimitBytes) * memoryHighWater)
+	if rss < high {
+		if memoryPressure.Swap(false) {
+			log.Printf("Memory pressure cleared: rss %d MiB", rss>>20)
+		}
+		return
+	}
+
+	entries, bytes := fileCacheStats()
+	log.Printf("Memory pressure: rss %d MiB of %d MiB, evicting %d cached grids (%d MiB)",
+		rss>>20, memoryLimitBytes>>20, entries, bytes>>20)
+	memoryPressure.Store(true)
+	ClearDateRangeCache()
+	debug.FreeOSMemory()
+}
+
+// checkColdIngest is called before work that grows memory, i.e. downloading or
+// loading a grid that is not cached yet.
+func checkColdIngest() error {
+	if memoryPressure.Load() {
+		return ErrMemoryPressure
+	}
+	return nil
+}
+
+// processRSS returns the resident set size, read from /proc on Linux and
+// estimated from the Go runtime elsewhere.
+func processRSS() int64 {
+	if statm, err := os.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(statm))
+		if len(fields) > 1 {
+			if pages, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.Sys - stats.HeapReleased)
+}
+
+// parseByteSize parses sizes such as "4096", "512MiB", "4GiB" or "4GB".
+func parseByteSize(str string) (int64, error) {
+	str = strings.TrimSpace(str)
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+		{"B", 1},
+	}
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(str, unit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			scale = unit.scale
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", str)
+	}
+	return int64(value * float64(scale)), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// A minimal Mapbox Vector Tile (v2.1) encoder for point layers, written
+// against vector_tile.proto by hand so no protobuf dependency is needed.
+
+const mvtExtent = 4096
+
+// mvtPoint is one point feature, at tile coordinates in [0, mvtExtent).
+type mvtPoint struct {
+	X, Y       int
+	Properties []float64 // in the order of the layer's keys
+}
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+	wire32     = 5
+)
+
+func appendTag(buf []byte, field, wire int) []byte {
+	return binary.AppendUvarint(buf, uint64(field<<3|wire))
+}
+
+func appendBytesField(buf []byte, field int, data []byte) []byte {
+	buf = appendTag(buf, field, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendVarintField(buf []byte, field int, v uint64) []byte {
+	buf = appendTag(buf, field, wireVarint)
+	return binary.AppendUvarint(buf, v)
+}
+
+func zigzag(v int) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// encodeMVTLayer encodes a tile holding one layer of points. Missing (NaN)
+// properties are left off the feature.
+func encodeMVTLayer(name string, keys []string, points []mvtPoint) []byte {
+	var layer []byte
+	layer = appendVarintField(layer, 15, 2) // version
+	layer = appendBytesField(layer, 1, []byte(name))
+
+	var feature, packed, value []byte
+	values := 0
+	for id, point := range points {
+		feature = appendVarintField(feature[:0], 1, uint64(id+1))
+
+		packed = packed[:0]
+		for k, property := range point.Properties {
+			if math.IsNaN(property) {
+				continue
+			}
+			packed = binary.AppendUvarint(packed, uint64(k))
+			packed = binary.AppendUvarint(packed, uint64(values))
+			values++
+		}
+		feature = appendBytesField(feature, 2, packed) // tags
+		feature = appendVarintField(feature, 3, 1)     // type POINT
+
+		packed = binary.AppendUvarint(packed[:0], 1<<3|1) // MoveTo, one point
+		packed = binary.AppendUvarint(packed, zigzag(point.X))
+		packed = binary.AppendUvarint(packed, zigzag(point.Y))
+		feature = appendBytesField(feature, 4, packed) // geometry
+
+		layer = appendBytesField(layer, 2, feature)
+	}
+	for _, key := range keys {
+		layer = appendBytesField(layer, 3, []byte(key))
+	}
+	// values in the order the tags above numbered them
+	for _, point := range points {
+		for _, property := range point.Properties {
+			if math.IsNaN(property) {
+				continue
+			}
+			value = appendTag(value[:0], 2, wire32) // float_value
+			value = binary.LittleEndian.AppendUint32(value, math.Float32bits(float32(property)))
+			layer = appendBytesField(layer, 4, value)
+		}
+	}
+	layer = appendVarintField(layer, 5, mvtExtent)
+
+	return appendBytesField(nil, 3, layer)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Source attribution: an ensemble of backward trajectories is released from
+// the receptor with perturbed start positions and winds, and the region
+// holding the most likely share of their end points is returned as the
+// probable origin of the air, e.g. of a smoke or pollution episode.
+
+const (
+	maxOriginHours   = 120
+	maxOriginMembers = 200
+	trajectoryStep   = time.Hour
+	originWindSpread = 0.1 // standard deviation of the wind scale of a member
+)
+
+type OriginAPIParams struct {
+	Lat         float64   `json:"lat"`
+	Lon         float64   `json:"lon"`
+	Time        time.Time `json:"time"`        // arrival at the receptor
+	Hours       int       `json:"hours"`       // how far back to follow the air
+	Members     int       `json:"members"`     // ensemble size
+	Spread      float64   `json:"spread"`      // km, standard deviation of the start positions
+	Probability float64   `json:"probability"` // share of the ensemble the region holds
+}
+
+type OriginEndpoint struct {
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Weight   float64 `json:"weight"`    // probability of the member
+	InRegion bool    `json:"in_region"` // whether the end point is inside the region
+}
+
+type OriginResponse struct {
+	Lat         float64          `json:"lat"`
+	Lon         float64          `json:"lon"`
+	Time        time.Time        `json:"time"`
+	Hours       int              `json:"hours"`
+	Members     int              `json:"members"`
+	Completed   int              `json:"completed"` // members followed for the full period
+	Probability float64          `json:"probability"`
+	Centroid    [2]float64       `json:"centroid"` // [lon, lat] of the weighted mean end point
+	Region      [][2]float64     `json:"region"`   // closed [lon, lat] ring, GeoJSON polygon order
+	Endpoints   []OriginEndpoint `json:"endpoints"`
+	Status      int              `json:"status"`
+	Success     bool             `json:"success"`
+}
+
+var originFailResponse = OriginResponse{
+	Region:    [][2]float64{},
+	Endpoints: []OriginEndpoint{},
+	Status:    http.StatusBadRequest,
+	Success:   false,
+}
+
+func sendOriginJsonError(w http.ResponseWriter, statusCode int) {
+	response := originFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// originHandler serves /trajectory/origin?lat=&lon=&time=&hours=&members=&spread=&probability=
+func originHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	lat, err := parseCoordinate(httpQuery.Get("lat"))
+	if err != nil {
+		sendOriginJsonError(w, http.StatusBadRequest)
+		return
+	}
+	lon, err := parseCoordinate(httpQuery.Get("lon"))
+	if err != nil {
+		sendOriginJsonError(w, http.StatusBadRequest)
+		return
+	}
+	at, err := parseAsOf(httpQuery.Get("time"))
+	if err != nil {
+		sendOriginJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := OriginAPIParams{
+		Lat:         lat,
+		Lon:         lon,
+		Time:        at,
+		Hours:       48,
+		Members:     50,
+		Spread:      10,
+		Probability: 0.9,
+	}
+	for name, dst := range map[string]*int{"hours": &params.Hours, "members": &params.Members} {
+		if s := httpQuery.Get(name); s != "" {
+			if *dst, err = strconv.Atoi(s); err != nil {
+				sendOriginJsonError(w, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	for name, dst := range map[string]*float64{"spread": &params.Spread, "probability": &params.Probability} {
+		if s := httpQuery.Get(name); s != "" {
+			if *dst, err = strconv.ParseFloat(s, 64); err != nil {
+				sendOriginJsonError(w, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	setLogField(r.Context(), "date", at.Format("2006010215"))
+	data, err2 := OriginQuery(r.Context(), params)
+	if err2 != nil {
+		sendOriginJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func OriginQuery(ctx context.Context, params OriginAPIParams) (OriginResponse, error) {
+	for _, value := range []float64{params.Lat, params.Lon, params.Spread, params.Probability} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return originFailResponse, fmt.Errorf("%w: lat, lon, spread and probability must be finite", ErrInvalidParams)
+		}
+	}
+	switch {
+	case params.Lat < -90 || params.Lat > 90:
+		return originFailResponse, fmt.Errorf("%w: lat %g", ErrOutOfGrid, params.Lat)
+	case params.Lon < -360 || params.Lon > 360:
+		return originFailResponse, fmt.Errorf("%w: lon %g", ErrOutOfGrid, params.Lon)
+	case params.Hours <= 0 || params.Hours > maxOriginHours:
+		return originFailResponse, fmt.Errorf("%w: hours must be between 1 and %d", ErrInvalidParams, maxOriginHours)
+	case params.Members <= 0 || params.Members > maxOriginMembers:
+		return originFailResponse, fmt.Errorf("%w: members must be between 1 and %d", ErrInvalidParams, maxOriginMembers)
+	case params.Spread < 0:
+		return originFailResponse, fmt.Errorf("%w: spread must not be negative", ErrInvalidParams)
+	case params.Probability <= 0 || params.Probability > 1:
+		return originFailResponse, fmt.Errorf("%w: probability must be in (0, 1]", ErrInvalidParams)
+	}
+
+	// the same request always draws the same ensemble
+	seed := fnv.New64a()
+	fmt.Fprintf(seed, "%g|%g|%d|%g", params.Lat, params.Lon, params.Time.Unix(), params.Spread)
+	random := rand.New(rand.NewPCG(seed.Sum64(), uint64(params.Members)))
+
+	field := newWindField(ctx)
+	duration := -time.Duration(params.Hours) * time.Hour
+	kmPerDegree := earthRadiusKm * math.Pi / 180
+	type member struct{ x, y float64 } // km east and north of the receptor
+	var ends []member
+	for i := range params.Members {
+		if err := ctx.Err(); err != nil {
+			return originFailResponse, err
+		}
+		lat, lon, scale := params.Lat, params.Lon, 1.0
+		if i > 0 { // member 0 is the unperturbed trajectory
+			lat += random.NormFloat64() * params.Spread / kmPerDegree
+			lon += random.NormFloat64() * params.Spread / (kmPerDegree * math.Max(math.Cos(params.Lat*math.Pi/180), 0.01))
+			scale += random.NormFloat64() * originWindSpread
+		}
+		path, err := integrateTrajectory(field, lat, lon, params.Time, duration, trajectoryStep, scale)
+		if err != nil {
+			return originFailResponse, err
+		}
+		end := path[len(path)-1]
+		if !end.Time.Equal(params.Time.Add(duration)) {
+			continue // ran into missing data, its origin is unknown
+		}
+		ends = append(ends, member{
+			x: (end.Lon - params.Lon) * kmPerDegree * math.Cos(params.Lat*math.Pi/180),
+			y: (end.Lat - params.Lat) * kmPerDegree,
+		})
+	}
+	setLogField(ctx, "members", params.Members)
+	if len(ends) == 0 {
+		return originFailResponse, fmt.Errorf("%w: no trajectory could be followed back %d hours", ErrDataNotPublished, params.Hours)
+	}
+
+	// every completed member is equally likely; rank them by Mahalanobis
+	// distance from the mean and keep the closest until they hold the
+	// requested probability
+	weight := 1 / float64(len(ends))
+	var mx, my float64
+	for _, e := range ends {
+		mx += e.x * weight
+		my += e.y * weight
+	}
+	var sxx, syy, sxy float64
+	for _, e := range ends {
+		dx, dy := e.x-mx, e.y-my
+		sxx += dx * dx * weight
+		syy += dy * dy * weight
+		sxy += dx * dy * weight
+	}
+	det := sxx*syy - sxy*sxy
+	distance := func(e member) float64 {
+		dx, dy := e.x-mx, e.y-my
+		if det <= 1e-9 {
+			return dx*dx + dy*dy
+		}
+		return (syy*dx*dx - 2*sxy*dx*dy + sxx*dy*dy) / det
+	}
+	order := make([]int, len(ends))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(distance(ends[a]), distance(ends[b])) })
+	// the region always holds at least the closest member
+	keep := min(len(ends), max(1, int(math.Ceil(params.Probability*float64(len(ends))-1e-9))))
+	inRegion := make([]bool, len(ends))
+	var region [][2]float64
+	for _, i := range order[:keep] {
+		inRegion[i] = true
+		region = append(region, [2]float64{ends[i].x, ends[i].y})
+	}
+
+	toLonLat := func(x, y float64) [2]float64 {
+		return [2]float64{
+			normalizeLon(params.Lon + x/(kmPerDegree*math.Cos(params.Lat*math.Pi/180))),
+			params.Lat + y/kmPerDegree,
+		}
+	}
+	ring := convexHull(region)
+	for i, p := range ring {
+		ring[i] = toLonLat(p[0], p[1])
+	}
+	endpoints := make([]OriginEndpoint, len(ends))
+	for i, e := range ends {
+		p := toLonLat(e.x, e.y)
+		endpoints[i] = OriginEndpoint{Lat: p[1], Lon: p[0], Weight: weight, InRegion: inRegion[i]}
+	}
+
+	return OriginResponse{
+		Lat:         params.Lat,
+		Lon:         params.Lon,
+		Time:        params.Time,
+		Hours:       params.Hours,
+		Members:     params.Members,
+		Completed:   len(ends),
+		Probability: float64(keep) * weight,
+		Centroid:    toLonLat(mx, my),
+		Region:      ring,
+		Endpoints:   endpoints,
+		Status:      http.StatusOK,
+		Success:     true,
+	}, nil
+}
+
+// convexHull returns the hull of points counter-clockwise as a closed ring
+// (Andrew's monotone chain). Fewer than three distinct points give the
+// degenerate ring through them.
+func convexHull(points [][2]float64) [][2]float64 {
+	points = slices.Clone(points)
+	slices.SortFunc(points, func(a, b [2]float64) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	points = slices.Compact(points)
+	if len(points) < 3 {
+		return append(points, points[0])
+	}
+	cross := func(o, a, b [2]float64) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+	hull := make([][2]float64, 0, 2*len(points))
+	for _, p := range points { // lower hull
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- { // upper hull
+		p := points[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull // ends on the first point again
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Grids can be written to tmp/ packed as int16 with a per-grid offset and
+// scale, like GRIB simple packing, which keeps long archives at a fraction of
+// the size. Unpacking is transparent to everything above readParamFile.
+
+const (
+	packingFloat = "float" // float32, within 1e-7 relative of the decoded values
+	packingInt16 = "int16" // scaled int16, error bounded by Packing.MaxError
+
+	packedMissing = math.MinInt16 // reserved for NaN
+	packedMax     = math.MaxInt16
+)
+
+// packing applies to grids written from now on, files keep the packing they
+// were written with.
+var packing = packingFloat
+
+func parsePacking(s string) (string, error) {
+	switch s {
+	case packingFloat, packingInt16:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown packing %q, want %s or %s", s, packingFloat, packingInt16)
+	}
+}
+
+// Packing describes how a param file's packed values decode:
+// value = Offset + Scale*q for every q other than the missing marker.
+type Packing struct {
+	Type     string  `json:"type"`
+	Offset   float64 `json:"offset"`
+	Scale    float64 `json:"scale"`
+	MaxError float64 `json:"max_error"` // largest absolute difference to the original values
+}
+
+// packInt16 quantizes values to little-endian int16s spread over their range.
+func packInt16(values []float64) (Packing, []byte) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	p := Packing{Type: packingInt16, Scale: 1}
+	if lo <= hi {
+		p.Offset = (lo + hi) / 2
+		if hi > lo {
+			p.Scale = (hi - lo) / (2 * packedMax)
+		}
+	}
+
+	packed := make([]byte, 2*len(values))
+	for i, v := range values {
+		q := int16(packedMissing)
+		if !math.IsNaN(v) {
+			q = int16(math.Round((v - p.Offset) / p.Scale))
+			p.MaxError = max(p.MaxError, math.Abs(p.Offset+p.Scale*float64(q)-v))
+		}
+		binary.LittleEndian.PutUint16(packed[2*i:], uint16(q))
+	}
+	return p, packed
+}
+
+func unpackInt16(p Packing, packed []byte) ([]float64, error) {
+	if len(packed)%2 != 0 {
+		return nil, fmt.Errorf("packed data has odd length %d", len(packed))
+	}
+	values := make([]float64, len(packed)/2)
+	for i := range values {
+		q := int16(binary.LittleEndian.Uint16(packed[2*i:]))
+		if q == packedMissing {
+			values[i] = math.NaN()
+			continue
+		}
+		values[i] = p.Offset + p.Scale*float64(q)
+	}
+	return values, nil
+}
+
+// newParamFile encodes values with the configured packing.
+func newParamFile(spec GridSpec, values []float64) paramFile {
+	if packing != packingInt16 {
+		return paramFile{Grid: &spec, Values: values}
+	}
+	p, packed := packInt16(values)
+	return paramFile{Grid: &spec, Packing: &p, Packed: packed}
+}
+
+// values decodes the file's values whatever their packing.
+func (f *paramFile) values() ([]float64, error) {
+	if f.Packing == nil {
+		return f.Values, nil
+	}
+	if f.Packing.Type != packingInt16 {
+		return nil, fmt.Errorf("unknown packing %q", f.Packing.Type)
+	}
+	return unpackInt16(*f.Packing, f.Packed)
+}
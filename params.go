@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Parameters the server can ingest, by the name clients use. What /api,
+// /range and /daterange return is selected with either
+//
+//	param=wind (default)  the 10u/10v pair, answered as u and v
+//	param=2t              one parameter, answered as value(s)
+//	params=10u,msl,2t     any parameters, answered as fields keyed by name
+//	dataset=surface_wind  the parameters of a virtual dataset, as params= (see datasets.go)
+
+type paramDef struct {
+	GribParam string // param in the .index files
+	Levtype   string // levtype in the .index files
+	Levelist  string // levelist in the .index files, pressure levels only
+	Unit      string
+}
+
+var paramRegistry = map[string]paramDef{
+	"10u":  {GribParam: "10u", Levtype: "sfc", Unit: "m/s"},  // 10 metre U wind
+	"10v":  {GribParam: "10v", Levtype: "sfc", Unit: "m/s"},  // 10 metre V wind
+	"2t":   {GribParam: "2t", Levtype: "sfc", Unit: "K"},     // 2 metre temperature
+	"msl":  {GribParam: "msl", Levtype: "sfc", Unit: "Pa"},   // mean sea level pressure
+	"10fg": {GribParam: "10fg", Levtype: "sfc", Unit: "m/s"}, // 10 metre wind gust since the previous step
+	"2d":   {GribParam: "2d", Levtype: "sfc", Unit: "K"},     // 2 metre dewpoint temperature
+	"tp":   {GribParam: "tp", Levtype: "sfc", Unit: "m"},     // total precipitation since the start of the run
+	"t850": {GribParam: "t", Levtype: "pl", Levelist: "850", Unit: "K"},
+	"t700": {GribParam: "t", Levtype: "pl", Levelist: "700", Unit: "K"},
+	"t500": {GribParam: "t", Levtype: "pl", Levelist: "500", Unit: "K"},
+	"r850": {GribParam: "r", Levtype: "pl", Levelist: "850", Unit: "%"}, // relative humidity
+	"r700": {GribParam: "r", Levtype: "pl", Levelist: "700", Unit: "%"},
+}
+
+const paramWind = "wind"
+
+// maxQueryParams bounds params=, each one is a grid to load.
+const maxQueryParams = 8
+
+func parseQueryParam(s string) (string, error) {
+	if s == "" || s == paramWind {
+		return paramWind, nil
+	}
+	if _, ok := paramRegistry[s]; !ok {
+		return "", fmt.Errorf("%w: unknown param %q", ErrInvalidParams, s)
+	}
+	return s, nil
+}
+
+// parseQueryParams parses a comma separated params= list, dropping
+// duplicates. An empty list returns nil.
+func parseQueryParams(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		if _, ok := paramRegistry[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown param %q", ErrInvalidParams, name)
+		}
+		names = append(names, name)
+	}
+	if len(names) > maxQueryParams {
+		return nil, fmt.Errorf("%w: at most %d params", ErrInvalidParams, maxQueryParams)
+	}
+	return names, nil
+}
+
+// selectedParams returns the parameters a query loads, from its param and
+// params arguments.
+func selectedParams(param string, params []string) []string {
+	switch {
+	case len(params) > 0:
+		return params
+	case param == "" || param == paramWind:
+		return windParams
+	default:
+		return []string{param}
+	}
+}
+
+// loadParamFields returns the grid of a batch's parameters at a forecast step
+// and their values in the order of names. All of them must be on the same
+// grid.
+func loadParamFields(ctx context.Context, filePath, date, batch string, step int, names []string) (Grid, [][]float64, error) {
+	grids, err := getOrLoadParams(ctx, filePath, date, batch, step, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := make([][]float64, len(grids))
+	for i, grid := range grids {
+		if !sameGrid(grid.Grid, grids[0].Grid) || len(grid.Values) != len(grids[0].Values) {
+			return nil, nil, fmt.Errorf("%s and %s of %s-%s are on different grids", names[0], names[i], date, batch)
+		}
+		fields[i] = grid.Values
+	}
+	return grids[0].Grid, fields, nil
+}
+
+// fieldsByName keys per parameter outputs by parameter name, for params=.
+func fieldsByName[T any](names []string, outputs []T) map[string]T {
+	fields := make(map[string]T, len(names))
+	for i, name := range names {
+		fields[name] = outputs[i]
+	}
+	return fields
+}
+
+func nullFloats(outputs [][]float64) []NullFloats {
+	converted := make([]NullFloats, len(outputs))
+	for i, output := range outputs {
+		converted[i] = output
+	}
+	return converted
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// validate=true turns any query into a dry run: parameters are validated and
+// the files the query needs are resolved against the caches and the bucket,
+// but nothing is downloaded, decoded or loaded.
+
+const (
+	cacheStateMemory = "memory" // decoded grid held in the in-memory cache
+	cacheStateDisk   = "disk"   // cache file in tmp/, needs to be parsed
+	cacheStateRemote = "remote" // needs download and decode
+)
+
+type PlannedFile struct {
+	Date      string `json:"date"`
+	Batch     string `json:"batch"`
+	Path      string `json:"path"`
+	Cache     string `json:"cache"`               // memory, disk or remote
+	Published *bool  `json:"published,omitempty"` // upstream availability, remote files only
+}
+
+type QueryPlan struct {
+	Files              []PlannedFile `json:"files"`
+	EstimatedLatencyMs int64         `json:"estimated_latency_ms"`
+	Error              string        `json:"error,omitempty"`
+	Status             int           `json:"status"`
+	Success            bool          `json:"success"`
+}
+
+// Load latencies per cache state, an exponential moving average of what
+// loadParams observed, seeded with typical values.
+var (
+	loadLatencyMutex sync.Mutex
+	loadLatency      = map[string]time.Duration{
+		cacheStateMemory: time.Millisecond,
+		cacheStateDisk:   2 * time.Second,
+		cacheStateRemote: 30 * time.Second,
+	}
+)
+
+func observeLoadLatency(state string, d time.Duration) {
+	loadLatencyMutex.Lock()
+	defer loadLatencyMutex.Unlock()
+	loadLatency[state] = (loadLatency[state]*4 + d) / 5
+}
+
+func estimatedLoadLatency(state string) time.Duration {
+	loadLatencyMutex.Lock()
+	defer loadLatencyMutex.Unlock()
+	return loadLatency[state]
+}
+
+func isValidateRequest(r *http.Request) bool {
+	return r.URL.Query().Get("validate") == "true"
+}
+
+// sendQueryPlan answers a validate=true request. validateErr is the result of
+// the query's own parameter validation, dates are the dates it would load.
+func sendQueryPlan(w http.ResponseWriter, r *http.Request, validateErr error, dates []string, batch string) {
+	plan := QueryPlan{Files: []PlannedFile{}, Status: http.StatusOK, Success: true}
+	if validateErr != nil {
+		plan.Error = validateErr.Error()
+		plan.Status = queryErrorStatus(validateErr)
+		plan.Success = false
+	} else {
+		plan = planQuery(r.Context(), dates, batch)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(plan.Status)
+	json.NewEncoder(w).Encode(plan)
+}
+
+func planQuery(ctx context.Context, dates []string, batch string) QueryPlan {
+	plan := QueryPlan{Files: make([]PlannedFile, 0, len(dates)), Status: http.StatusOK, Success: true}
+	stream, err := streamForBatch(batch)
+	if err != nil {
+		return QueryPlan{Files: []PlannedFile{}, Error: err.Error(), Status: queryErrorStatus(err)}
+	}
+
+	var latency time.Duration
+	for _, date := range dates {
+		filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+		file := PlannedFile{Date: date, Batch: batch, Path: filePath, Cache: cacheStateRemote}
+		if batchCached(date, batch) {
+			file.Cache = cacheStateMemory
+		} else if batchOnDisk(filePath, date, batch) {
+			file.Cache = cacheStateDisk
+		} else {
+			published := source.Published(ctx, date, batch, stream, 0)
+			file.Published = &published
+		}
+		latency += estimatedLoadLatency(file.Cache)
+		plan.Files = append(plan.Files, file)
+	}
+	plan.EstimatedLatencyMs = latency.Milliseconds()
+	return plan
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Point time series for the statistics endpoints (/windrose, ...). Unlike
+// /daterange they span several batches per day and skip missing data
+// instead of filling it with 0, which would bias any statistic.
+
+var allBatches = []string{"00z", "06z", "12z", "18z"}
+
+// seriesSample is the wind at the point for one date and batch.
+type seriesSample struct {
+	Date  string
+	Batch string
+	U, V  float64
+}
+
+// parseBatches parses a comma separated batch list, empty means all batches.
+// Duplicates are dropped so no sample is counted twice.
+func parseBatches(s string) ([]string, error) {
+	if s == "" {
+		return allBatches, nil
+	}
+	var batches []string
+	for _, batch := range strings.Split(s, ",") {
+		batch = strings.TrimSpace(batch)
+		if !isValidBatch(batch) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidBatch, batch)
+		}
+		if !slices.Contains(batches, batch) {
+			batches = append(batches, batch)
+		}
+	}
+	return batches, nil
+}
+
+// pointSeries reads the wind at (lat, lon) for every date and batch, in
+// time order. Files that cannot be loaded and missing cells are skipped and
+// counted in missing.
+func pointSeries(ctx context.Context, lat, lon float64, dates, batches []string) ([]seriesSample, int, error) {
+	series, missing, err := pointsSeries(ctx, [][2]float64{{lat, lon}}, dates, batches)
+	if err != nil {
+		return nil, missing[0], err
+	}
+	return series[0], missing[0], nil
+}
+
+// pointsSeries is pointSeries for several [lat, lon] points, reading each
+// file once for all of them.
+func pointsSeries(ctx context.Context, points [][2]float64, dates, batches []string) ([][]seriesSample, []int, error) {
+	series := make([][]seriesSample, len(points))
+	for i := range series {
+		series[i] = make([]seriesSample, 0, len(dates)*len(batches))
+	}
+	missing := make([]int, len(points))
+	for _, date := range dates {
+		for _, batch := range batches {
+			if err := ctx.Err(); err != nil {
+				return nil, missing, err
+			}
+			filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+			cache, err := getOrLoadFileCache(ctx, filePath, date, batch)
+			if errors.Is(err, ErrMemoryPressure) {
+				return nil, missing, err
+			}
+			if err != nil {
+				appendLogField(ctx, "missing_dates", date+"-"+batch)
+				setLogField(ctx, "load_error", err)
+				for i := range missing {
+					missing[i]++
+				}
+				continue
+			}
+
+			for i, point := range points {
+				index, err := cache.Grid.Index(point[0], point[1])
+				if err != nil {
+					return nil, missing, fmt.Errorf("failed to get index for coord: %w", err)
+				}
+				if index < 0 || index >= len(cache.U) || index >= len(cache.V) ||
+					math.IsNaN(cache.U[index]) || math.IsNaN(cache.V[index]) {
+					missing[i]++
+					continue
+				}
+				series[i] = append(series[i], seriesSample{Date: date, Batch: batch, U: cache.U[index], V: cache.V[index]})
+			}
+		}
+	}
+	return series, missing, nil
+}
@@ -1,13 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"math"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 )
 
@@ -19,20 +17,33 @@ type RangeAPIParams struct {
 	Step  float64 `json:"step"`  // Step size
 	Date  string  `json:"date"`  // Date
 	Batch string  `json:"batch"` // Batch
+	Lead  int     `json:"lead"`  // forecast step in hours, named lead here as step is the spacing
+
+	Param   string   `json:"param"`   // wind or a single parameter such as 2t
+	Params  []string `json:"params"`  // any parameters, overrides Param
+	Derived []string `json:"derived"` // speed and/or dir
+
+	Smooth       float64 `json:"smooth"`        // Smoothing scale in degrees, 0 disables
+	SmoothKernel string  `json:"smooth_kernel"` // gaussian or box
 }
 
 type RangeResponse struct {
-	U       []float64 `json:"u"`
-	V       []float64 `json:"v"`
-	Lats    []float64 `json:"lats"`
-	Lons    []float64 `json:"lons"`
-	Status  int       `json:"status"`
-	Success bool      `json:"success"`
+	U       NullFloats            `json:"u"`
+	V       NullFloats            `json:"v"`
+	Param   string                `json:"param,omitempty"`  // scalar param only
+	Values  NullFloats            `json:"values,omitempty"` // scalar param only
+	Fields  map[string]NullFloats `json:"fields,omitempty"` // params= only
+	Speed   NullFloats            `json:"speed,omitempty"`  // derived=speed only
+	Dir     NullFloats            `json:"dir,omitempty"`    // derived=dir only
+	Lats    []float64             `json:"lats"`
+	Lons    []float64             `json:"lons"`
+	Status  int                   `json:"status"`
+	Success bool                  `json:"success"`
 }
 
 var rangeFailResponse = RangeResponse{
-	U:       []float64{},
-	V:       []float64{},
+	U:       NullFloats{},
+	V:       NullFloats{},
 	Lats:    []float64{},
 	Lons:    []float64{},
 	Status:  http.StatusBadRequest,
@@ -40,9 +51,11 @@ var rangeFailResponse = RangeResponse{
 }
 
 func sendRangeJsonError(w http.ResponseWriter, statusCode int) {
+	response := rangeFailResponse
+	response.Status = statusCode
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(rangeFailResponse)
+	json.NewEncoder(w).Encode(response)
 }
 
 func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
@@ -54,7 +67,7 @@ func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
-	slat, err := strconv.ParseFloat(slatStr, 64)
+	slat, err := parseCoordinate(slatStr)
 	if err != nil {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
@@ -66,7 +79,7 @@ func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
-	slon, err := strconv.ParseFloat(slonStr, 64)
+	slon, err := parseCoordinate(slonStr)
 	if err != nil {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
@@ -78,7 +91,7 @@ func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
-	elat, err := strconv.ParseFloat(elatStr, 64)
+	elat, err := parseCoordinate(elatStr)
 	if err != nil {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
@@ -90,7 +103,7 @@ func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
-	elon, err := strconv.ParseFloat(elonStr, 64)
+	elon, err := parseCoordinate(elonStr)
 	if err != nil {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
@@ -103,7 +116,7 @@ func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	step, err := strconv.ParseFloat(stepStr, 64)
-	if err != nil || step <= 0 {
+	if err != nil || !(step > 0) || math.IsInf(step, 0) {
 		sendRangeJsonError(w, http.StatusBadRequest)
 		return
 	}
@@ -122,6 +135,53 @@ func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Parse optional smoothing
+	smooth := 0.0
+	if smoothStr := httpQuery.Get("smooth"); smoothStr != "" {
+		smooth, err = parseSmooth(smoothStr)
+		if err != nil {
+			sendRangeJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	smoothKernel := httpQuery.Get("smooth_kernel")
+	if smoothKernel == "" {
+		smoothKernel = SmoothGaussian
+	}
+	if smoothKernel != SmoothGaussian && smoothKernel != SmoothBox {
+		sendRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Parse optional forecast step
+	lead, err := parseStep(httpQuery.Get("lead"), batch)
+	if err != nil {
+		sendRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	param, err := parseQueryParam(httpQuery.Get("param"))
+	if err != nil {
+		sendRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+	paramList, err := parseQueryParams(httpQuery.Get("params"))
+	if err != nil || (paramList != nil && httpQuery.Has("param")) {
+		sendRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+	if datasetParams, err := queryDataset(r.Context(), httpQuery, date, batch, lead); err != nil {
+		sendRangeJsonError(w, queryErrorStatus(err))
+		return
+	} else if datasetParams != nil {
+		paramList = datasetParams
+	}
+	derived, err := parseDerived(httpQuery.Get("derived"))
+	if err != nil {
+		sendRangeJsonError(w, http.StatusBadRequest)
+		return
+	}
+
 	params := RangeAPIParams{
 		SLat:  slat,
 		SLon:  slon,
@@ -130,135 +190,127 @@ func rangeQueryHandler(w http.ResponseWriter, r *http.Request) {
 		Step:  step,
 		Date:  date,
 		Batch: batch,
+		Lead:  lead,
+
+		Param:   param,
+		Params:  paramList,
+		Derived: derived,
+
+		Smooth:       smooth,
+		SmoothKernel: smoothKernel,
 	}
 
-	// Query range
-	data, err2 := RangeQuery(params)
-	if err2 != nil {
+	format, err := parseFormat(r, formatGeoJSON, formatShape)
+	if err != nil {
 		sendRangeJsonError(w, http.StatusBadRequest)
-		log.Println(err2)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(data)
-	if err != nil {
-		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	// Query range
+	if isValidateRequest(r) {
+		sendQueryPlan(w, r, validateDateBatch(date, batch), []string{date}, batch)
+		return
 	}
-}
-
-func RangeQuery(params RangeAPIParams) (RangeResponse, error) {
-	date := params.Date
-	batch := params.Batch
-	filePath := filepath.Join("tmp", date+"-"+batch+".json")
 
-	// First try
-	response, err := readAndParseRangeFile(filePath, params)
-	if err == nil {
-		return response, nil
+	setLogField(r.Context(), "date", date)
+	setLogField(r.Context(), "batch", batch)
+
+	// Without smoothing, which needs every point at once, the response is
+	// streamed from the loaded fields instead of built up in memory
+	if params.Smooth == 0 && format != formatGeoJSON && format != formatShape {
+		lattice, err2 := loadRangeLattice(r.Context(), params)
+		if err2 != nil {
+			sendRangeJsonError(w, queryErrorStatus(err2))
+			setLogField(r.Context(), "error", err2)
+			return
+		}
+		streamRange(w, format, "range-"+date+batch, lattice)
+		return
 	}
 
-	// Try to download
-	if err := downloadAndSave(date, batch); err != nil {
-		return rangeFailResponse, fmt.Errorf("download failed: %w", err)
+	data, err2 := RangeQuery(r.Context(), params)
+	if err2 != nil {
+		sendRangeJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
 	}
 
-	// Second try
-	response, err = readAndParseRangeFile(filePath, params)
+	switch format {
+	case formatCSV, formatNDJSON:
+		writeTable(w, format, "range-"+date+batch, data.table())
+		return
+	case formatShape:
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="range-`+date+batch+`.zip"`)
+		w.WriteHeader(http.StatusOK)
+		if err := writeShapefileZip(w, tableShapes("range-"+date+batch, data.table())); err != nil {
+			log.Printf("Met Error when writing shapefile to ResponseWriter: %v", err)
+		}
+		return
+	case formatGeoJSON:
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(rangeGeoJSON(data)); err != nil {
+			log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
 	if err != nil {
-		log.Printf("Second read/parse failed after download: %v", err)
-		return rangeFailResponse, fmt.Errorf("read/parse failed after download: %w", err)
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
 	}
-
-	return response, nil
 }
 
-func readAndParseRangeFile(filePath string, params RangeAPIParams) (RangeResponse, error) {
-	content, err := os.ReadFile(filePath)
+func RangeQuery(ctx context.Context, params RangeAPIParams) (RangeResponse, error) {
+	lattice, err := loadRangeLattice(ctx, params)
 	if err != nil {
-		return RangeResponse{}, fmt.Errorf("failed to read file %s: %w", filePath, err)
-	}
-
-	var data struct {
-		U []float64 `json:"10u"`
-		V []float64 `json:"10v"`
-	}
-
-	if err := json.Unmarshal(content, &data); err != nil {
-		return RangeResponse{}, fmt.Errorf("failed to unmarshal json from %s: %w", filePath, err)
-	}
-
-	if len(data.U) == 0 {
-		return RangeResponse{}, fmt.Errorf("json data for '10u' is empty or missing")
-	}
-	if len(data.V) == 0 {
-		return RangeResponse{}, fmt.Errorf("json data for '10v' is empty or missing")
+		return rangeFailResponse, err
 	}
 
-	// Generate grid points
-	var uValues []float64
-	var vValues []float64
+	// one output per field (u and v, or the scalar)
+	outputs := make([][]float64, len(lattice.fields))
 	var lats []float64
 	var lons []float64
-
-	// Calculate number of steps
-	latSteps := int(math.Abs(params.ELat-params.SLat)/params.Step) + 1
-	lonSteps := int(math.Abs(params.ELon-params.SLon)/params.Step) + 1
-
-	// Iterate through the grid
-	for latIdx := 0; latIdx < latSteps; latIdx++ {
-		lat := params.SLat + float64(latIdx)*params.Step*getSign(params.ELat-params.SLat)
-		// Clamp latitude to valid range
-		if lat > 90 {
-			lat = 90
+	var cells []int // lattice cell of each point, for smoothing
+	lattice.each(func(lat, lon float64, index, cell int) {
+		for i, field := range lattice.fields {
+			outputs[i] = append(outputs[i], field[index])
 		}
-		if lat < -90 {
-			lat = -90
-		}
-
-		for lonIdx := 0; lonIdx < lonSteps; lonIdx++ {
-			lon := params.SLon + float64(lonIdx)*params.Step*getSign(params.ELon-params.SLon)
-			// Normalize longitude to -180 to 180
-			for lon > 180 {
-				lon -= 360
-			}
-			for lon < -180 {
-				lon += 360
-			}
-
-			// Get index for this coordinate
-			valueIndex, err := GetIndexForCoord(lat, lon)
-			if err != nil {
-				log.Printf("Warning: failed to get index for coord (%f, %f): %v", lat, lon, err)
-				continue
-			}
-
-			// Bounds check
-			if valueIndex < 0 || valueIndex >= len(data.U) || valueIndex >= len(data.V) {
-				log.Printf("Warning: index %d out of bounds for coord (%f, %f)", valueIndex, lat, lon)
-				continue
-			}
-
-			uValues = append(uValues, data.U[valueIndex])
-			vValues = append(vValues, data.V[valueIndex])
-			lats = append(lats, lat)
-			lons = append(lons, lon)
+		lats = append(lats, lat)
+		lons = append(lons, lon)
+		cells = append(cells, cell)
+	})
+
+	// Smooth on the output lattice, scale converted from degrees to cells
+	if params.Smooth > 0 {
+		for _, output := range outputs {
+			smoothLattice(output, cells, lattice.latSteps, lattice.lonSteps, params.Smooth/params.Step, params.SmoothKernel)
 		}
 	}
 
-	if len(uValues) == 0 {
-		return RangeResponse{}, fmt.Errorf("no valid data points found in range")
-	}
-
 	response := RangeResponse{
-		U:       uValues,
-		V:       vValues,
+		U:       NullFloats{},
+		V:       NullFloats{},
 		Lats:    lats,
 		Lons:    lons,
 		Status:  http.StatusOK,
 		Success: true,
 	}
+	names := lattice.names
+	switch {
+	case len(params.Params) > 0:
+		response.Fields = fieldsByName(names, nullFloats(outputs))
+	case len(names) == 2:
+		response.U, response.V = outputs[0], outputs[1]
+	default:
+		response.Param, response.Values = names[0], outputs[0]
+	}
+	if len(params.Derived) > 0 {
+		u, v, _ := windComponents(names)
+		response.Speed, response.Dir = deriveWind(params.Derived, outputs[u], outputs[v])
+	}
 
 	return response, nil
 }
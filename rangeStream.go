@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A global range query at 0.25° is a million points per field; built up as
+// slices and encoded in one go it takes hundreds of MB. Unsmoothed range
+// responses are instead written as they are read off the loaded fields, one
+// array (json) or row (csv, ndjson) at a time, through a fixed size buffer.
+// The bytes are the same as the buffered encoding.
+
+const (
+	rangeStreamBuffer = 64 << 10
+
+	// maxRangePoints bounds the output lattice like maxRegridPoints, before
+	// the fields are loaded, the lattice counted or a smoothing buffer sized.
+	maxRangePoints = 4 * TotalPoints
+)
+
+// rangeLattice is the output lattice of a range query over its loaded fields.
+type rangeLattice struct {
+	params   RangeAPIParams
+	names    []string
+	grid     Grid
+	fields   [][]float64
+	latSteps int
+	lonSteps int
+}
+
+func loadRangeLattice(ctx context.Context, params RangeAPIParams) (*rangeLattice, error) {
+	date := params.Date
+	batch := params.Batch
+	if err := validateDateBatch(date, batch); err != nil {
+		return nil, err
+	}
+	if !(params.Step > 0) || math.IsInf(params.Step, 0) {
+		return nil, fmt.Errorf("%w: step must be a positive number of degrees", ErrInvalidParams)
+	}
+	// counted in float64 so a tiny step cannot overflow; NaN corners fail too
+	latSteps := math.Floor(math.Abs(params.ELat-params.SLat)/params.Step) + 1
+	lonSteps := math.Floor(math.Abs(params.ELon-params.SLon)/params.Step) + 1
+	if !(latSteps*lonSteps <= float64(maxRangePoints)) {
+		return nil, fmt.Errorf("%w: range of %.0f points exceeds limit of %d", ErrInvalidParams, latSteps*lonSteps, maxRangePoints)
+	}
+	filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+
+	names := selectedParams(params.Param, params.Params)
+	if len(params.Derived) > 0 {
+		if _, _, err := windComponents(names); err != nil {
+			return nil, err
+		}
+	}
+	grid, fields, err := loadParamFields(ctx, filePath, date, batch, params.Lead, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+	lattice := &rangeLattice{
+		params:   params,
+		names:    names,
+		grid:     grid,
+		fields:   fields,
+		latSteps: int(latSteps),
+		lonSteps: int(lonSteps),
+	}
+
+	points := 0
+	lattice.each(func(float64, float64, int, int) { points++ })
+	if points == 0 {
+		return nil, fmt.Errorf("%w: no valid data points found in range", ErrOutOfGrid)
+	}
+	if skipped := lattice.latSteps*lattice.lonSteps - points; skipped > 0 {
+		addLogCount(ctx, "skipped_points", int64(skipped))
+	}
+	addLogCount(ctx, "points", int64(points))
+	return lattice, nil
+}
+
+// each calls yield for every lattice point with data, in order, with its
+// grid index and its cell on the lattice.
+func (l *rangeLattice) each(yield func(lat, lon float64, index, cell int)) {
+	params := l.params
+	for latIdx := 0; latIdx < l.latSteps; latIdx++ {
+		lat := params.SLat + float64(latIdx)*params.Step*getSign(params.ELat-params.SLat)
+		// Clamp latitude to valid range
+		lat = math.Max(-90, math.Min(90, lat))
+
+		for lonIdx := 0; lonIdx < l.lonSteps; lonIdx++ {
+			lon := params.SLon + float64(lonIdx)*params.Step*getSign(params.ELon-params.SLon)
+			// Normalize longitude to -180 to 180
+			for lon > 180 {
+				lon -= 360
+			}
+			for lon < -180 {
+				lon += 360
+			}
+
+			index, err := l.grid.Index(lat, lon)
+			if err != nil || index < 0 || slices.ContainsFunc(l.fields, func(field []float64) bool { return index >= len(field) }) {
+				continue
+			}
+			yield(lat, lon, index, latIdx*l.lonSteps+lonIdx)
+		}
+	}
+}
+
+// rangeSeries is one value column of a range response, by grid index.
+type rangeSeries struct {
+	name  string
+	value func(index int) float64
+}
+
+func fieldSeries(name string, field []float64) rangeSeries {
+	return rangeSeries{name, func(index int) float64 { return field[index] }}
+}
+
+// derived returns the requested derived series of the wind.
+func (l *rangeLattice) derived() []rangeSeries {
+	if len(l.params.Derived) == 0 {
+		return nil
+	}
+	ui, vi, _ := windComponents(l.names)
+	u, v := l.fields[ui], l.fields[vi]
+	var series []rangeSeries
+	if slices.Contains(l.params.Derived, derivedSpeed) {
+		series = append(series, rangeSeries{"speed", func(index int) float64 {
+			return math.Sqrt(u[index]*u[index] + v[index]*v[index])
+		}})
+	}
+	if slices.Contains(l.params.Derived, derivedDir) {
+		series = append(series, rangeSeries{"dir", func(index int) float64 {
+			return windDirection(u[index], v[index])
+		}})
+	}
+	return series
+}
+
+// fieldSeries returns the fields of a params= query, sorted by name like
+// the keys of an encoded map.
+func (l *rangeLattice) fieldSeries() []rangeSeries {
+	series := make([]rangeSeries, len(l.names))
+	for i, name := range l.names {
+		series[i] = fieldSeries(name, l.fields[i])
+	}
+	slices.SortFunc(series, func(a, b rangeSeries) int { return strings.Compare(a.name, b.name) })
+	return series
+}
+
+// streamRange writes the response of a range query in format, json or one
+// of the table formats.
+func streamRange(w http.ResponseWriter, format, name string, l *rangeLattice) {
+	if format == formatCSV || format == formatNDJSON {
+		streamRangeTable(w, format, name, l)
+		return
+	}
+
+	buf := bufio.NewWriterSize(w, rangeStreamBuffer)
+	var scratch []byte
+	array := func(value func(lat, lon float64, index int) float64) {
+		buf.WriteByte('[')
+		first := true
+		l.each(func(lat, lon float64, index, _ int) {
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			scratch = appendNullFloat(scratch[:0], value(lat, lon, index))
+			buf.Write(scratch)
+		})
+		buf.WriteByte(']')
+	}
+	series := func(s rangeSeries) {
+		buf.WriteString(strconv.Quote(s.name) + ":")
+		array(func(_, _ float64, index int) float64 { return s.value(index) })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	buf.WriteByte('{')
+	switch {
+	case len(l.params.Params) > 0:
+		buf.WriteString(`"u":[],"v":[],"fields":{`)
+		for i, s := range l.fieldSeries() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			series(s)
+		}
+		buf.WriteString("},")
+	case len(l.names) == 2:
+		series(fieldSeries("u", l.fields[0]))
+		buf.WriteByte(',')
+		series(fieldSeries("v", l.fields[1]))
+		buf.WriteByte(',')
+	default:
+		buf.WriteString(`"u":[],"v":[],"param":` + strconv.Quote(l.names[0]) + ",")
+		series(fieldSeries("values", l.fields[0]))
+		buf.WriteByte(',')
+	}
+	for _, s := range l.derived() {
+		series(s)
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"lats":`)
+	array(func(lat, _ float64, _ int) float64 { return lat })
+	buf.WriteString(`,"lons":`)
+	array(func(_, lon float64, _ int) float64 { return lon })
+	buf.WriteString(`,"status":200,"success":true}` + "\n")
+	if err := buf.Flush(); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// streamRangeTable writes one row per point, columns in the order of
+// RangeResponse.table.
+func streamRangeTable(w http.ResponseWriter, format, name string, l *rangeLattice) {
+	var series []rangeSeries
+	switch {
+	case len(l.params.Params) > 0:
+		series = l.fieldSeries()
+	case len(l.names) == 2:
+		series = []rangeSeries{fieldSeries("u", l.fields[0]), fieldSeries("v", l.fields[1])}
+	default:
+		series = []rangeSeries{fieldSeries(l.names[0], l.fields[0])}
+	}
+	series = append(series, l.derived()...)
+	columns := []string{"lat", "lon"}
+	for _, s := range series {
+		columns = append(columns, s.name)
+	}
+
+	buf := bufio.NewWriterSize(w, rangeStreamBuffer)
+	writer := newTableWriter(w, buf, format, name, columns)
+	row := make([]any, len(columns))
+	failed := false
+	l.each(func(lat, lon float64, index, _ int) {
+		if failed {
+			return
+		}
+		row[0], row[1] = lat, lon
+		for i, s := range series {
+			row[2+i] = s.value(index)
+		}
+		failed = writer.row(row) != nil
+	})
+	writer.flush()
+	if err := buf.Flush(); err != nil && !failed {
+		log.Printf("Met Error when writing %s to ResponseWriter: %v", format, err)
+	}
+}
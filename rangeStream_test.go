@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// Oversized and non-finite lattices are refused before any field is loaded
+// or a lattice point counted, so a tiny step fails at once.
+func TestLoadRangeLatticeRejectsUnboundedLattices(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	for _, tc := range []struct{ slat, slon, elat, elon, step float64 }{
+		{90, -180, -90, 180, 0.001},
+		{90, -180, -90, 180, 1e-300},
+		{90, nan, -90, 180, 1},
+		{90, -180, -90, inf, 1},
+		{90, -180, -90, 180, nan},
+		{90, -180, -90, 180, inf},
+		{90, -180, -90, 180, 0},
+	} {
+		params := RangeAPIParams{SLat: tc.slat, SLon: tc.slon, ELat: tc.elat, ELon: tc.elon, Step: tc.step, Date: "20250101", Batch: "00z"}
+		start := time.Now()
+		_, err := loadRangeLattice(context.Background(), params)
+		if !errors.Is(err, ErrInvalidParams) {
+			t.Errorf("loadRangeLattice(%v) = %v, want %v", tc, err, ErrInvalidParams)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("loadRangeLattice(%v) took %v", tc, elapsed)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate limiting: each client, identified by its valid token or else its IP,
+// has a token bucket holding up to rateLimit requests that refills at
+// rateLimit per rateWindow, so a client may burst its whole allowance and
+// then goes on at the steady rate. ipRateLimit adds a bucket per IP that every request
+// takes from whatever token it carries, so one address cannot multiply its
+// allowance by rotating keys. Every response carries, for the emptiest of
+// the buckets it took from,
+//
+//	X-RateLimit-Limit      requests the bucket holds
+//	X-RateLimit-Remaining  requests left in it
+//	X-RateLimit-Reset      unix time it is full again
+//
+// and requests finding a bucket empty get 429 with a Retry-After of when the
+// next request is allowed and the same numbers in the body. A limit of 0
+// disables its bucket.
+
+var (
+	rateLimit   = 0
+	ipRateLimit = 0
+	rateWindow  = time.Minute
+)
+
+type tokenBucket struct {
+	tokens  float64
+	limit   int
+	updated time.Time
+}
+
+// refill adds the tokens accrued since the last update.
+func (b *tokenBucket) refill(now time.Time) {
+	rate := float64(b.limit) / rateWindow.Seconds()
+	b.tokens = math.Min(float64(b.limit), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+}
+
+// full is when the bucket holds limit tokens again.
+func (b *tokenBucket) full() time.Time {
+	rate := float64(b.limit) / rateWindow.Seconds()
+	return b.updated.Add(time.Duration((float64(b.limit) - b.tokens) / rate * float64(time.Second)))
+}
+
+var (
+	rateMutex   sync.Mutex
+	rateBuckets = make(map[string]*tokenBucket)
+)
+
+type RateLimitResponse struct {
+	Error      string `json:"error"`
+	Limit      int    `json:"limit"`
+	Remaining  int    `json:"remaining"`
+	Reset      int64  `json:"reset"`       // unix time
+	RetryAfter int    `json:"retry_after"` // seconds
+	Status     int    `json:"status"`
+	Success    bool   `json:"success"`
+}
+
+// rateClient is the key requests are counted under: the token when it is a
+// known one, otherwise the IP, so inventing a fresh key per request neither
+// resets the allowance nor grows the bucket map. With access control off no
+// token is known.
+func rateClient(r *http.Request) string {
+	if authTokens != nil && requestRole(r) != "" {
+		return "token:" + tokenFingerprint(r)
+	}
+	return "ip:" + remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateBucket names a bucket and its limit.
+type rateBucket struct {
+	key   string
+	limit int
+}
+
+// rateState is what a request learns about the bucket limiting it.
+type rateState struct {
+	limit      int
+	remaining  int
+	reset      time.Time
+	retryAfter time.Duration // until a token is back, when ok is false
+	ok         bool
+}
+
+// takeRequest takes a token from every bucket, or from none when one of them
+// is empty, and returns the state of the emptiest.
+func takeRequest(now time.Time, wanted ...rateBucket) rateState {
+	rateMutex.Lock()
+	defer rateMutex.Unlock()
+	for _, want := range wanted {
+		if rateBuckets[want.key] == nil {
+			// drop the buckets that have refilled before adding one
+			for key, b := range rateBuckets {
+				if !now.Before(b.full()) {
+					delete(rateBuckets, key)
+				}
+			}
+			break
+		}
+	}
+	buckets := make([]*tokenBucket, len(wanted))
+	for i, want := range wanted {
+		bucket := rateBuckets[want.key]
+		if bucket == nil {
+			bucket = &tokenBucket{tokens: float64(want.limit), limit: want.limit, updated: now}
+			rateBuckets[want.key] = bucket
+		}
+		bucket.refill(now)
+		buckets[i] = bucket
+	}
+
+	state := rateState{ok: true, remaining: math.MaxInt}
+	for _, bucket := range buckets {
+		if bucket.tokens < 1 {
+			state.ok = false
+			rate := float64(bucket.limit) / rateWindow.Seconds()
+			state.retryAfter = max(state.retryAfter, time.Duration((1-bucket.tokens)/rate*float64(time.Second)))
+		}
+	}
+	for _, bucket := range buckets {
+		if state.ok {
+			bucket.tokens--
+		}
+		if remaining := int(bucket.tokens); remaining < state.remaining {
+			state.limit, state.remaining, state.reset = bucket.limit, max(remaining, 0), bucket.full()
+		}
+	}
+	return state
+}
+
+func limitRate(next http.Handler) http.Handler {
+	if rateLimit <= 0 && ipRateLimit <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" || r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var buckets []rateBucket
+		if rateLimit > 0 {
+			buckets = append(buckets, rateBucket{key: rateClient(r), limit: rateLimit})
+		}
+		if ipRateLimit > 0 {
+			buckets = append(buckets, rateBucket{key: "per-ip:" + remoteHost(r), limit: ipRateLimit})
+		}
+		now := time.Now()
+		state := takeRequest(now, buckets...)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(state.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(state.remaining))
+		reset := int64(math.Ceil(float64(state.reset.UnixNano()) / 1e9))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		if state.ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		setLogField(r.Context(), "rate_limited", true)
+		retryAfter := max(int(math.Ceil(state.retryAfter.Seconds())), 1)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(RateLimitResponse{
+			Error:      statusText(languageFrom(r.Context()), http.StatusTooManyRequests),
+			Limit:      state.limit,
+			Reset:      reset,
+			RetryAfter: retryAfter,
+			Status:     http.StatusTooManyRequests,
+		})
+	})
+}
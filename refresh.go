@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// refresh=true makes a request download and decode its batches again even
+// when they are cached, replacing the stored copies, for when a cached grid
+// is known to be bad. Being as expensive as a cold ingest it is limited to
+// ingesters and admins.
+
+type refreshKey struct{}
+
+func refreshRequested(ctx context.Context) bool {
+	refresh, _ := ctx.Value(refreshKey{}).(bool)
+	return refresh
+}
+
+func bypassCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("refresh") != "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if roleRank[requestRole(r)] < roleRank[roleIngester] {
+			sendAuthError(w, r, http.StatusForbidden)
+			return
+		}
+		setLogField(r.Context(), "refresh", true)
+		ctx := context.WithValue(r.Context(), refreshKey{}, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
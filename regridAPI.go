@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"grib_server/grid"
+)
+
+const (
+	RegridBilinear     = "bilinear"
+	RegridConservative = "conservative"
+
+	maxRegridPoints = 4 * TotalPoints
+)
+
+type RegridAPIParams struct {
+	SLat   float64 `json:"slat"`   // North edge of the target grid
+	SLon   float64 `json:"slon"`   // West edge of the target grid
+	ELat   float64 `json:"elat"`   // South edge of the target grid
+	ELon   float64 `json:"elon"`   // East edge of the target grid
+	Step   float64 `json:"step"`   // Target resolution in degrees
+	Method string  `json:"method"` // bilinear or conservative
+	Date   string  `json:"date"`
+	Batch  string  `json:"batch"`
+}
+
+type RegridResponse struct {
+	Grid    GridSpec   `json:"grid"`
+	U       NullFloats `json:"u"`
+	V       NullFloats `json:"v"`
+	Status  int        `json:"status"`
+	Success bool       `json:"success"`
+}
+
+var regridFailResponse = RegridResponse{
+	U:       NullFloats{},
+	V:       NullFloats{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendRegridJsonError(w http.ResponseWriter, statusCode int) {
+	response := regridFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+func regridHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	// bbox is optional and defaults to the whole globe
+	bbox := map[string]float64{"slat": 90, "slon": -180, "elat": -90, "elon": 180}
+	for _, name := range []string{"slat", "slon", "elat", "elon"} {
+		str := httpQuery.Get(name)
+		if str == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(str, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			sendRegridJsonError(w, http.StatusBadRequest)
+			return
+		}
+		bbox[name] = value
+	}
+
+	stepStr := httpQuery.Get("step")
+	if stepStr == "" {
+		sendRegridJsonError(w, http.StatusBadRequest)
+		return
+	}
+	step, err := strconv.ParseFloat(stepStr, 64)
+	if err != nil || !(step > 0) || math.IsInf(step, 0) {
+		sendRegridJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	method := httpQuery.Get("method")
+	if method == "" {
+		method = RegridBilinear
+	}
+	if method != RegridBilinear && method != RegridConservative {
+		sendRegridJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	date := httpQuery.Get("date")
+	if date == "" {
+		sendRegridJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	batch := httpQuery.Get("batch")
+	if batch == "" {
+		sendRegridJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := RegridAPIParams{
+		SLat:   bbox["slat"],
+		SLon:   bbox["slon"],
+		ELat:   bbox["elat"],
+		ELon:   bbox["elon"],
+		Step:   step,
+		Method: method,
+		Date:   date,
+		Batch:  batch,
+	}
+
+	if isValidateRequest(r) {
+		err := validateDateBatch(date, batch)
+		if err == nil {
+			_, err = regridTarget(params.SLat, params.SLon, params.ELat, params.ELon, params.Step)
+		}
+		sendQueryPlan(w, r, err, []string{date}, batch)
+		return
+	}
+
+	setLogField(r.Context(), "date", date)
+	setLogField(r.Context(), "batch", batch)
+	data, err2 := RegridQuery(r.Context(), params)
+	if err2 != nil {
+		sendRegridJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func RegridQuery(ctx context.Context, params RegridAPIParams) (RegridResponse, error) {
+	if err := validateDateBatch(params.Date, params.Batch); err != nil {
+		return regridFailResponse, err
+	}
+	target, err := regridTarget(params.SLat, params.SLon, params.ELat, params.ELon, params.Step)
+	if err != nil {
+		return regridFailResponse, err
+	}
+
+	filePath := filepath.Join(tmpDir, params.Date+"-"+params.Batch+".json")
+	cache, err := getOrLoadFileCache(ctx, filePath, params.Date, params.Batch)
+	if err != nil {
+		return regridFailResponse, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+
+	uValues, err := Regrid(cache.Grid, cache.U, target, params.Method)
+	if err != nil {
+		return regridFailResponse, fmt.Errorf("failed to regrid 10u: %w", err)
+	}
+	vValues, err := Regrid(cache.Grid, cache.V, target, params.Method)
+	if err != nil {
+		return regridFailResponse, fmt.Errorf("failed to regrid 10v: %w", err)
+	}
+
+	response := RegridResponse{
+		Grid: GridSpec{
+			Type:     "regular_ll",
+			Ni:       target.Ni,
+			Nj:       target.Nj,
+			LatFirst: target.LatFirst,
+			LonFirst: target.LonFirst,
+			LatStep:  target.LatStep,
+			LonStep:  target.LonStep,
+		},
+		U:       uValues,
+		V:       vValues,
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	return response, nil
+}
+
+// regridTarget builds the regular grid covering the bbox at the given step,
+// scanned north to south like the GRIB source grids. A full 360° span keeps
+// a single copy of the dateline column.
+func regridTarget(slat, slon, elat, elon, step float64) (RegularLatLonGrid, error) {
+	for _, value := range []float64{slat, slon, elat, elon, step} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return RegularLatLonGrid{}, fmt.Errorf("%w: bbox and step must be finite", ErrInvalidParams)
+		}
+	}
+	if step <= 0 {
+		return RegularLatLonGrid{}, fmt.Errorf("%w: step must be positive", ErrInvalidParams)
+	}
+	north := math.Max(slat, elat)
+	south := math.Min(slat, elat)
+	if north > 90 || south < -90 {
+		return RegularLatLonGrid{}, fmt.Errorf("%w: latitude out of range [-90, 90]", ErrOutOfGrid)
+	}
+	if slon < -360 || slon > 360 || elon < -360 || elon > 360 {
+		return RegularLatLonGrid{}, fmt.Errorf("%w: longitude out of range [-360, 360]", ErrOutOfGrid)
+	}
+	span := elon - slon
+	if span <= 0 {
+		span += 360
+	}
+	// count in float64 first, a tiny step overflows int
+	cols := math.Floor(span/step+1e-9) + 1
+	if (cols-1)*step >= 360-1e-9 {
+		cols--
+	}
+	rows := math.Floor((north-south)/step+1e-9) + 1
+	if cols*rows > float64(maxRegridPoints) {
+		return RegularLatLonGrid{}, fmt.Errorf("%w: target grid of %.0f points exceeds limit of %d", ErrInvalidParams, cols*rows, maxRegridPoints)
+	}
+	return RegularLatLonGrid{
+		Ni:       int(cols),
+		Nj:       int(rows),
+		LatFirst: north,
+		LonFirst: slon,
+		LatStep:  step,
+		LonStep:  step,
+	}, nil
+}
+
+// Regrid resamples values on the source grid onto a regular lat-lon target,
+// so grids of different resolutions or types can be compared point by point.
+//
+// bilinear interpolates the four source points around each target point.
+// conservative averages, weighted by cos(lat), every source point whose cell
+// centre falls inside the target cell, which preserves area means when
+// coarsening; target cells without any source point (refining) fall back to
+// bilinear. Missing (NaN) source values are skipped.
+func Regrid(src Grid, values []float64, dst RegularLatLonGrid, method string) ([]float64, error) {
+	if len(values) != src.Size() {
+		return nil, fmt.Errorf("grid has %d points but %d values", src.Size(), len(values))
+	}
+	switch method {
+	case RegridBilinear:
+		out := make([]float64, dst.Size())
+		for index := range out {
+			lat, lon := dst.Coord(index)
+			out[index] = interpolateBilinear(src, values, lat, lon)
+		}
+		return out, nil
+	case RegridConservative:
+		return regridConservative(src, values, dst), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown regrid method %q", ErrInvalidParams, method)
+	}
+}
+
+// interpolateBilinear returns the bilinear estimate at (lat, lon), renormalizing
+// the weights over the non-missing neighbours.
+func interpolateBilinear(src Grid, values []float64, lat, lon float64) float64 {
+	return grid.Interpolate(src, values, lat, lon)
+}
+
+func regridConservative(src Grid, values []float64, dst RegularLatLonGrid) []float64 {
+	sums := make([]float64, dst.Size())
+	weights := make([]float64, dst.Size())
+	for index, value := range values {
+		if math.IsNaN(value) {
+			continue
+		}
+		lat, lon := src.Coord(index)
+		// target cell is centred on its grid point
+		j := int(math.Round((dst.LatFirst - lat) / dst.LatStep))
+		if j < 0 || j >= dst.Nj {
+			continue
+		}
+		lonOffset := math.Mod(lon-dst.LonFirst, 360)
+		if lonOffset < 0 {
+			lonOffset += 360
+		}
+		if lonOffset >= 360-dst.LonStep/2 {
+			lonOffset -= 360 // western half of the first column
+		}
+		i := int(math.Round(lonOffset / dst.LonStep))
+		if dst.Global() {
+			i = (i%dst.Ni + dst.Ni) % dst.Ni
+		} else if i < 0 || i >= dst.Ni {
+			continue
+		}
+		w := math.Cos(lat * math.Pi / 180)
+		if w <= 0 {
+			w = 1e-6 // keep the poles, which have no area but are real values
+		}
+		sums[j*dst.Ni+i] += w * value
+		weights[j*dst.Ni+i] += w
+	}
+
+	out := make([]float64, dst.Size())
+	for index := range out {
+		if weights[index] > 0 {
+			out[index] = sums[index] / weights[index]
+			continue
+		}
+		lat, lon := dst.Coord(index)
+		out[index] = interpolateBilinear(src, values, lat, lon)
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// A global 0.5° target starting at -180 puts the 179.75° source column on
+// the edge of its first column, which used to index column -1.
+func TestRegridConservativeGlobalDateline(t *testing.T) {
+	src := RegularLatLonGrid{Ni: 1440, Nj: 9, LatFirst: 1, LonFirst: 0, LatStep: 0.25, LonStep: 0.25}
+	values := make([]float64, src.Size())
+	for index := range values {
+		values[index], _ = src.Coord(index)
+	}
+	dst, err := regridTarget(1, -180, -1, 180, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dst.Global() {
+		t.Fatalf("target %+v is not global", dst)
+	}
+	out, err := Regrid(src, values, dst, RegridConservative)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the field only varies with latitude, a row mixing in another row's
+	// points is not uniform
+	for j := range dst.Nj {
+		want := out[j*dst.Ni+1]
+		for i := range dst.Ni {
+			if got := out[j*dst.Ni+i]; math.IsNaN(got) || math.Abs(got-want) > 1e-9 {
+				t.Fatalf("row %d column %d = %g, want %g", j, i, got, want)
+			}
+		}
+	}
+}
+
+func TestRegridTargetRejectsUnboundedGrids(t *testing.T) {
+	nan, inf := math.NaN(), math.Inf(1)
+	for _, tc := range []struct{ slat, slon, elat, elon, step float64 }{
+		{90, -180, -90, 180, 1e-9},
+		{90, nan, -90, 180, 1},
+		{90, -180, -90, inf, 1},
+		{90, -180, nan, 180, 1},
+		{90, -180, -90, 1e308, 1},
+		{90, -180, -90, 2147483648, 1},
+		{90, -180, -90, 180, nan},
+		{90, -180, -90, 180, inf},
+		{90, -180, -90, 180, 0},
+	} {
+		if dst, err := regridTarget(tc.slat, tc.slon, tc.elat, tc.elon, tc.step); err == nil {
+			t.Errorf("regridTarget(%v) = %+v, want an error", tc, dst)
+		}
+	}
+}
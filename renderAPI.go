@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Server side rendering of the wind as a PNG, for dashboards that embed an
+// image instead of plotting: arrows or barbs every spacing pixels over an
+// optional speed colour ramp, on a plate carrée (equirectangular) map of the
+// bbox.
+
+const (
+	maxRenderPixels   = 4096 * 4096
+	renderStyleArrows = "arrows"
+	renderStyleBarbs  = "barbs"
+)
+
+// speedRamp maps wind speed in m/s to colour, linear between stops.
+var speedRamp = []struct {
+	Speed float64
+	Color color.RGBA
+}{
+	{0, color.RGBA{0x31, 0x36, 0x95, 0xff}},
+	{5, color.RGBA{0x45, 0x75, 0xb4, 0xff}},
+	{10, color.RGBA{0x74, 0xad, 0xd1, 0xff}},
+	{15, color.RGBA{0xab, 0xd9, 0xa0, 0xff}},
+	{20, color.RGBA{0xfe, 0xe0, 0x90, 0xff}},
+	{25, color.RGBA{0xf4, 0x6d, 0x43, 0xff}},
+	{33, color.RGBA{0xd7, 0x30, 0x27, 0xff}},
+	{45, color.RGBA{0xa5, 0x00, 0x26, 0xff}},
+}
+
+type RenderAPIParams struct {
+	Date    string
+	Batch   string
+	West    float64
+	South   float64
+	East    float64
+	North   float64
+	Width   int
+	Height  int
+	Style   string // arrows or barbs
+	Ramp    bool   // speed colour ramp behind the symbols
+	Spacing int    // pixels between symbols
+}
+
+// renderHandler serves /render?date=&batch=&bbox=west,south,east,north&width=&height=&style=&ramp=&spacing=
+func renderHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := RenderAPIParams{
+		Date:    httpQuery.Get("date"),
+		Batch:   httpQuery.Get("batch"),
+		Width:   800,
+		Height:  600,
+		Style:   renderStyleArrows,
+		Spacing: 32,
+	}
+	bbox := strings.Split(httpQuery.Get("bbox"), ",")
+	if len(bbox) != 4 {
+		sendTileJsonError(w, http.StatusBadRequest)
+		return
+	}
+	for i, dst := range []*float64{&params.West, &params.South, &params.East, &params.North} {
+		value, err := strconv.ParseFloat(strings.TrimSpace(bbox[i]), 64)
+		if err != nil {
+			sendTileJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	for name, dst := range map[string]*int{"width": &params.Width, "height": &params.Height, "spacing": &params.Spacing} {
+		if s := httpQuery.Get(name); s != "" {
+			value, err := strconv.Atoi(s)
+			if err != nil {
+				sendTileJsonError(w, http.StatusBadRequest)
+				return
+			}
+			*dst = value
+		}
+	}
+	if style := httpQuery.Get("style"); style != "" {
+		params.Style = style
+	}
+	params.Ramp = httpQuery.Get("ramp") == "true"
+
+	setLogField(r.Context(), "date", params.Date)
+	setLogField(r.Context(), "batch", params.Batch)
+	img, err2 := RenderQuery(r.Context(), params)
+	if err2 != nil {
+		sendTileJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		sendTileJsonError(w, http.StatusInternalServerError)
+		setLogField(r.Context(), "error", err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("Met Error when writing png to ResponseWriter: %v", err)
+	}
+}
+
+func RenderQuery(ctx context.Context, params RenderAPIParams) (*image.RGBA, error) {
+	switch {
+	case params.Width <= 0 || params.Height <= 0 || params.Width*params.Height > maxRenderPixels:
+		return nil, fmt.Errorf("%w: image must be at most %d pixels", ErrInvalidParams, maxRenderPixels)
+	case params.Spacing < 8:
+		return nil, fmt.Errorf("%w: spacing must be at least 8 pixels", ErrInvalidParams)
+	case params.Style != renderStyleArrows && params.Style != renderStyleBarbs:
+		return nil, fmt.Errorf("%w: style must be %s or %s", ErrInvalidParams, renderStyleArrows, renderStyleBarbs)
+	case params.North <= params.South || params.North > 90 || params.South < -90:
+		return nil, fmt.Errorf("%w: bbox latitudes", ErrOutOfGrid)
+	}
+	if err := validateDateBatch(params.Date, params.Batch); err != nil {
+		return nil, err
+	}
+	east := params.East
+	if east <= params.West {
+		east += 360 // across the antimeridian
+	}
+
+	filePath := filepath.Join(tmpDir, params.Date+"-"+params.Batch+".json")
+	cache, err := getOrLoadFileCache(ctx, filePath, params.Date, params.Batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+
+	pxPerLon := float64(params.Width) / (east - params.West)
+	pxPerLat := float64(params.Height) / (params.North - params.South)
+	coord := func(x, y float64) (float64, float64) {
+		return params.North - y/pxPerLat, params.West + x/pxPerLon
+	}
+	wind := func(x, y float64) (float64, float64, float64) {
+		lat, lon := coord(x, y)
+		u := interpolateBilinear(cache.Grid, cache.U, lat, lon)
+		v := interpolateBilinear(cache.Grid, cache.V, lat, lon)
+		return u, v, lat
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, params.Width, params.Height))
+	ink := color.RGBA{0, 0, 0, 0xff}
+	if params.Ramp {
+		for y := range params.Height {
+			if y%64 == 0 && ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			for x := range params.Width {
+				u, v, _ := wind(float64(x)+0.5, float64(y)+0.5)
+				if c, ok := rampColor(math.Hypot(u, v)); ok {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	} else {
+		for i := range img.Pix {
+			img.Pix[i] = 0xff // white
+		}
+	}
+
+	half := float64(params.Spacing) / 2
+	for cy := half; cy < float64(params.Height); cy += float64(params.Spacing) {
+		for cx := half; cx < float64(params.Width); cx += float64(params.Spacing) {
+			u, v, lat := wind(cx, cy)
+			if math.IsNaN(u) || math.IsNaN(v) {
+				continue
+			}
+			// screen direction the wind blows to, keeping its true bearing
+			// on the stretched plate carrée
+			dx := u / math.Max(math.Cos(lat*math.Pi/180), 0.01) * pxPerLon
+			dy := -v * pxPerLat
+			norm := math.Hypot(dx, dy)
+			if norm > 0 {
+				dx, dy = dx/norm, dy/norm
+			}
+			length := 0.8 * float64(params.Spacing)
+			if params.Style == renderStyleBarbs {
+				drawBarb(img, cx, cy, dx, dy, length, math.Hypot(u, v)*msToKnots, ink)
+			} else {
+				drawArrow(img, cx, cy, dx, dy, length*math.Min(1, 0.3+math.Hypot(u, v)/20), ink)
+			}
+		}
+	}
+	return img, nil
+}
+
+func rampColor(speed float64) (color.RGBA, bool) {
+	if math.IsNaN(speed) {
+		return color.RGBA{}, false
+	}
+	if speed >= speedRamp[len(speedRamp)-1].Speed {
+		return speedRamp[len(speedRamp)-1].Color, true
+	}
+	for i := 1; i < len(speedRamp); i++ {
+		if speed < speedRamp[i].Speed {
+			lo, hi := speedRamp[i-1], speedRamp[i]
+			w := (speed - lo.Speed) / (hi.Speed - lo.Speed)
+			mix := func(a, b uint8) uint8 { return uint8(float64(a) + w*(float64(b)-float64(a)) + 0.5) }
+			return color.RGBA{mix(lo.Color.R, hi.Color.R), mix(lo.Color.G, hi.Color.G), mix(lo.Color.B, hi.Color.B), 0xff}, true
+		}
+	}
+	return speedRamp[0].Color, true
+}
+
+// drawLine draws a one pixel line, clipped to the image.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.RGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		x, y := int(math.Round(x0+t*(x1-x0))), int(math.Round(y0+t*(y1-y0)))
+		if image.Pt(x, y).In(img.Rect) {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// drawArrow draws an arrow of the given length centred on (cx, cy) pointing
+// along the unit vector (dx, dy).
+func drawArrow(img *image.RGBA, cx, cy, dx, dy, length float64, c color.RGBA) {
+	x0, y0 := cx-dx*length/2, cy-dy*length/2
+	x1, y1 := cx+dx*length/2, cy+dy*length/2
+	drawLine(img, x0, y0, x1, y1, c)
+	head := math.Max(3, length/4)
+	for _, side := range []float64{-1, 1} {
+		// 30° either side of the shaft
+		hx := -dx*math.Cos(math.Pi/6) - side*dy*math.Sin(math.Pi/6)
+		hy := -dy*math.Cos(math.Pi/6) + side*dx*math.Sin(math.Pi/6)
+		drawLine(img, x1, y1, x1+hx*head, y1+hy*head, c)
+	}
+}
+
+// drawBarb draws a wind barb at (cx, cy) for a wind blowing along (dx, dy):
+// the staff points to where the wind comes from, with a pennant per 50 kt, a
+// full barb per 10 kt and a half barb for 5 kt at its far end. Calm (under
+// 2.5 kt) is a circle.
+func drawBarb(img *image.RGBA, cx, cy, dx, dy, length, knots float64, c color.RGBA) {
+	if knots < 2.5 {
+		for a := 0.0; a < 2*math.Pi; a += math.Pi / 8 {
+			drawLine(img, cx+3*math.Cos(a), cy+3*math.Sin(a), cx+3*math.Cos(a+math.Pi/8), cy+3*math.Sin(a+math.Pi/8), c)
+		}
+		return
+	}
+	sx, sy := -dx, -dy // along the staff, away from the station
+	tipX, tipY := cx+sx*length, cy+sy*length
+	drawLine(img, cx, cy, tipX, tipY, c)
+
+	// feathers on the clockwise side of the staff, slanted towards the tip
+	fx, fy := -sy, sx
+	feather := length * 0.4
+	gap := length * 0.12
+	rounded := int(math.Round(knots/5)) * 5
+	pos := 0.0
+	for ; rounded >= 50; rounded -= 50 {
+		bx, by := tipX-sx*pos, tipY-sy*pos
+		ex, ey := bx-sx*gap, by-sy*gap
+		px, py := bx+fx*feather, by+fy*feather
+		for t := 0.0; t <= 1; t += 0.1 { // fill the triangle with lines
+			drawLine(img, bx+(ex-bx)*t, by+(ey-by)*t, px, py, c)
+		}
+		pos += gap * 1.5
+	}
+	for ; rounded >= 10; rounded -= 10 {
+		bx, by := tipX-sx*pos, tipY-sy*pos
+		drawLine(img, bx, by, bx+(fx+sx*0.3)*feather, by+(fy+sy*0.3)*feather, c)
+		pos += gap
+	}
+	if rounded >= 5 {
+		if pos == 0 {
+			pos = gap // a lone half barb sits off the tip
+		}
+		bx, by := tipX-sx*pos, tipY-sy*pos
+		drawLine(img, bx, by, bx+(fx+sx*0.3)*feather/2, by+(fy+sy*0.3)*feather/2, c)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every request carries a set of log fields in its context. Handlers and the
+// layers below them (downloader, decoder, cache) attach what they did, e.g.
+// date, batch, param or bytes fetched, and logRequests prints them as one
+// line when the request completes. Without fields in the context (library
+// use, bench) the helpers are no-ops.
+
+type logFieldsKey struct{}
+
+type logFields struct {
+	mu     sync.Mutex
+	keys   []string
+	values map[string]string
+	counts map[string]int64
+}
+
+func withLogFields(ctx context.Context) (context.Context, *logFields) {
+	fields := &logFields{values: make(map[string]string), counts: make(map[string]int64)}
+	return context.WithValue(ctx, logFieldsKey{}, fields), fields
+}
+
+func fieldsFrom(ctx context.Context) *logFields {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(logFieldsKey{}).(*logFields)
+	return fields
+}
+
+func (f *logFields) key(key string) {
+	if _, ok := f.values[key]; ok {
+		return
+	}
+	if _, ok := f.counts[key]; ok {
+		return
+	}
+	f.keys = append(f.keys, key)
+}
+
+// setLogField sets key to value, replacing an earlier value.
+func setLogField(ctx context.Context, key string, value any) {
+	f := fieldsFrom(ctx)
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.key(key)
+	f.values[key] = fmt.Sprint(value)
+}
+
+// logField returns the value set under key, "" if none.
+func logField(ctx context.Context, key string) string {
+	f := fieldsFrom(ctx)
+	if f == nil {
+		return ""
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.values[key]
+}
+
+// appendLogField adds value to a comma separated list under key.
+func appendLogField(ctx context.Context, key string, value string) {
+	f := fieldsFrom(ctx)
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.key(key)
+	if f.values[key] == "" {
+		f.values[key] = value
+	} else {
+		f.values[key] += "," + value
+	}
+}
+
+// addLogCount adds n to the counter under key.
+func addLogCount(ctx context.Context, key string, n int64) {
+	f := fieldsFrom(ctx)
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.key(key)
+	f.counts[key] += n
+}
+
+// count returns the counter under key, 0 if nothing was counted.
+func (f *logFields) count(key string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.counts[key]
+}
+
+// String formats the fields as " key=value ..." in the order they were added.
+func (f *logFields) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var b strings.Builder
+	for _, key := range f.keys {
+		if count, ok := f.counts[key]; ok {
+			fmt.Fprintf(&b, " %s=%d", key, count)
+			continue
+		}
+		value := f.values[key]
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", key, value)
+	}
+	return b.String()
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// logRequests gives every request its log fields and prints the summary line.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, fields := withLogFields(r.Context())
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		recordCost(r, fields)
+		log.Printf("%s %s status=%d duration=%s resp_bytes=%d%s",
+			r.Method, r.URL.Path, recorder.status, time.Since(start).Round(time.Microsecond), recorder.bytes, fields)
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Response compression: a response with a million floats is tens of MB of
+// JSON, which gzip shrinks several times. Responses are compressed with
+// zstd, gzip or deflate as the client's Accept-Encoding asks, once they
+// reach responseCompressMin bytes; smaller ones are not worth the CPU and are
+// sent as they are. zstd uses the same package as the grid files
+// (compression.go). Bodies that are compressed already (images, archives) or
+// carry their own Content-Encoding pass through.
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	encodingZstd    = "zstd"
+)
+
+// encodingPreference orders the encodings on a q value tie.
+var encodingPreference = map[string]int{encodingZstd: 3, encodingGzip: 2, encodingDeflate: 1}
+
+// responseCompressMin is the size from which responses are compressed, a
+// negative value disables compression.
+var responseCompressMin = 1024
+
+// incompressibleTypes are content types already compressed.
+var incompressibleTypes = []string{"image/png", "image/jpeg", "application/zip", "application/gzip", "application/vnd.google-earth.kmz", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	flateWriters = sync.Pool{New: func() any { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+	// HTTP clients need not decode zstd windows over 8 MB (RFC 9659)
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(8<<20))
+		return w
+	}}
+)
+
+// acceptedEncoding picks zstd, gzip or deflate from an Accept-Encoding
+// header by q value, in that order on a tie, "" when none is acceptable.
+func acceptedEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			name = encodingGzip
+		}
+		if encodingPreference[name] == 0 || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && encodingPreference[name] > encodingPreference[best]) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressResponses compresses the responses of clients that accept it.
+func compressResponses(next http.Handler) http.Handler {
+	if responseCompressMin < 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writer := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer writer.close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// compressWriter holds back the start of a response until it is known to
+// reach responseCompressMin, then sends it compressed or, if it ends short
+// of that or cannot be compressed, as it is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	status      int
+	buffer      []byte
+	compressor  io.WriteCloser // once compressing
+	passthrough bool
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.status != 0 || c.passthrough {
+		return
+	}
+	c.status = status
+	// informational and bodiless responses go out at once
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		c.passthrough = true
+		c.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case c.passthrough:
+		return c.ResponseWriter.Write(p)
+	case c.compressor != nil:
+		return c.compressor.Write(p)
+	}
+	c.buffer = append(c.buffer, p...)
+	if len(c.buffer) >= responseCompressMin {
+		if err := c.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start sends the header, compressed if compress and the content allows,
+// and the buffered start of the body.
+func (c *compressWriter) start(compress bool) error {
+	header := c.Header()
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	for _, t := range incompressibleTypes {
+		if contentType == t {
+			compress = false
+		}
+	}
+	if header.Get("Content-Encoding") != "" {
+		compress = false
+	}
+	if !compress {
+		c.passthrough = true
+		c.ResponseWriter.WriteHeader(c.status)
+		_, err := c.ResponseWriter.Write(c.buffer)
+		c.buffer = nil
+		return err
+	}
+
+	header.Set("Content-Encoding", c.encoding)
+	header.Del("Content-Length")
+	c.ResponseWriter.WriteHeader(c.status)
+	switch c.encoding {
+	case encodingGzip:
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(c.ResponseWriter)
+		c.compressor = gz
+	case encodingZstd:
+		zs := zstdWriters.Get().(*zstd.Encoder)
+		zs.Reset(c.ResponseWriter)
+		c.compressor = zs
+	default:
+		fl := flateWriters.Get().(*flate.Writer)
+		fl.Reset(c.ResponseWriter)
+		c.compressor = fl
+	}
+	_, err := c.compressor.Write(c.buffer)
+	c.buffer = nil
+	return err
+}
+
+// Flush sends what was written so far, committing to compression for a
+// response streamed in parts.
+func (c *compressWriter) Flush() {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.passthrough && c.compressor == nil {
+		c.start(len(c.buffer) > 0)
+	}
+	if flusher, ok := c.compressor.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// close ends the response: a short one is sent as it is, a compressed one
+// gets its trailer and the compressor goes back to its pool.
+func (c *compressWriter) close() {
+	switch {
+	case c.passthrough:
+	case c.compressor == nil:
+		if c.status != 0 {
+			c.start(false)
+		}
+	default:
+		c.compressor.Close()
+		switch compressor := c.compressor.(type) {
+		case *gzip.Writer:
+			gzipWriters.Put(compressor)
+		case *flate.Writer:
+			flateWriters.Put(compressor)
+		case *zstd.Encoder:
+			zstdWriters.Put(compressor)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Query endpoints answer in the format= asked for: json (the default), csv
+// or ndjson, which turn a response into a table, one row per point or date,
+// for analysts to load straight into pandas or a spreadsheet. Errors are
+// always JSON.
+
+const (
+	formatJSON    = "json"
+	formatCSV     = "csv"
+	formatNDJSON  = "ndjson"
+	formatGeoJSON = "geojson" // /range, /typhoon and /trajectory
+	formatShape   = "shp"     // zipped shapefile, /range
+	formatXLSX    = "xlsx"    // workbook with a chart, /daterange
+)
+
+// parseFormat reads format=, accepting the table formats and any extra ones
+// the endpoint supports.
+func parseFormat(r *http.Request, extra ...string) (string, error) {
+	format := r.URL.Query().Get("format")
+	switch {
+	case format == "":
+		return formatJSON, nil
+	case format == formatJSON, format == formatCSV, format == formatNDJSON, slices.Contains(extra, format):
+		return format, nil
+	}
+	return "", fmt.Errorf("%w: format %q", ErrInvalidParams, format)
+}
+
+// queryTable is a response as columns of values, each a string or a float64
+// (NaN for missing).
+type queryTable struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// tableBuilder collects the value series of a response next to its key
+// columns.
+type tableBuilder struct {
+	table queryTable
+	n     int
+}
+
+func newTableBuilder(n int, keys ...string) *tableBuilder {
+	b := &tableBuilder{table: queryTable{Columns: keys, Rows: make([][]any, n)}, n: n}
+	for i := range b.table.Rows {
+		b.table.Rows[i] = make([]any, 0, len(keys)+4)
+	}
+	return b
+}
+
+func (b *tableBuilder) key(i int, value any) {
+	b.table.Rows[i] = append(b.table.Rows[i], value)
+}
+
+// series adds a column, skipped when the response does not carry it.
+func (b *tableBuilder) series(name string, values []float64) {
+	if len(values) != b.n {
+		return
+	}
+	b.table.Columns = append(b.table.Columns, name)
+	for i, v := range values {
+		b.table.Rows[i] = append(b.table.Rows[i], v)
+	}
+}
+
+// values adds the columns every query response shares.
+func (b *tableBuilder) values(u, v []float64, param string, values []float64, fields map[string]NullFloats, speed, dir []float64) queryTable {
+	b.series("u", u)
+	b.series("v", v)
+	if param != "" {
+		b.series(param, values)
+	}
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		b.series(name, fields[name])
+	}
+	b.series("speed", speed)
+	b.series("dir", dir)
+	return b.table
+}
+
+func (r SingleResponse) table() queryTable {
+	b := newTableBuilder(1)
+	one := func(f *NullFloat) []float64 {
+		if f == nil {
+			return nil
+		}
+		return []float64{float64(*f)}
+	}
+	var u, v []float64
+	if r.Param == "" && r.Fields == nil {
+		u, v = []float64{float64(r.U)}, []float64{float64(r.V)}
+	}
+	fields := make(map[string]NullFloats, len(r.Fields))
+	for name, value := range r.Fields {
+		fields[name] = NullFloats{float64(value)}
+	}
+	return b.values(u, v, r.Param, one(r.Value), fields, one(r.Speed), one(r.Dir))
+}
+
+func (r RangeResponse) table() queryTable {
+	b := newTableBuilder(len(r.Lats), "lat", "lon")
+	for i := range r.Lats {
+		b.key(i, r.Lats[i])
+		b.key(i, r.Lons[i])
+	}
+	return b.values(r.U, r.V, r.Param, r.Values, r.Fields, r.Speed, r.Dir)
+}
+
+func (r DateRangeResponse) table() queryTable {
+	b := newTableBuilder(len(r.Dates), "date")
+	for i, date := range r.Dates {
+		b.key(i, date)
+	}
+	return b.values(r.U, r.V, r.Param, r.Values, r.Fields, r.Speed, r.Dir)
+}
+
+// writeTable writes a table as csv or ndjson, name is used for the file name
+// of csv downloads.
+func writeTable(w http.ResponseWriter, format, name string, table queryTable) {
+	writer := newTableWriter(w, w, format, name, table.Columns)
+	for _, row := range table.Rows {
+		if err := writer.row(row); err != nil {
+			return
+		}
+	}
+	writer.flush()
+}
+
+// tableWriter writes the rows of a table as they come, for responses
+// streamed without building the whole table.
+type tableWriter struct {
+	w       io.Writer
+	columns []string
+	csv     *csv.Writer // nil for ndjson
+	record  []string
+	line    []byte
+}
+
+// newTableWriter sets the headers of a csv or ndjson response and, for csv,
+// writes the header row. The rows go to body, w itself or a buffer over it.
+func newTableWriter(w http.ResponseWriter, body io.Writer, format, name string, columns []string) *tableWriter {
+	writer := &tableWriter{w: body, columns: columns}
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+		writer.csv = csv.NewWriter(body)
+		writer.csv.Write(columns)
+		writer.record = make([]string, len(columns))
+		return writer
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	return writer
+}
+
+func (t *tableWriter) row(row []any) error {
+	if t.csv != nil {
+		for i, cell := range row {
+			t.record[i] = csvCell(cell)
+		}
+		if err := t.csv.Write(t.record); err != nil {
+			log.Printf("Met Error when writing csv to ResponseWriter: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	t.line = append(t.line[:0], '{')
+	for i, cell := range row {
+		if i > 0 {
+			t.line = append(t.line, ',')
+		}
+		t.line = strconv.AppendQuote(t.line, t.columns[i])
+		t.line = append(t.line, ':')
+		switch cell := cell.(type) {
+		case float64:
+			t.line = appendNullFloat(t.line, cell)
+		default:
+			encoded, _ := json.Marshal(cell)
+			t.line = append(t.line, encoded...)
+		}
+	}
+	t.line = append(t.line, '}', '\n')
+	if _, err := t.w.Write(t.line); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (t *tableWriter) flush() {
+	if t.csv != nil {
+		t.csv.Flush()
+	}
+}
+
+func csvCell(cell any) string {
+	switch cell := cell.(type) {
+	case float64:
+		if math.IsNaN(cell) || math.IsInf(cell, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(cell, 'g', -1, 64)
+	case string:
+		return cell
+	default:
+		return fmt.Sprint(cell)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Cost rasters for ship routing engines: for every batch time in a window,
+// every cell of a regular grid over a bbox and every heading, the hours the
+// vessel needs per nautical mile. Motoring vessels lose speed into the wind
+// (a simple headwind penalty), sailing vessels follow the polar POSTed with
+// the request. Only wind goes in for now; waves would be one more factor in
+// vesselSpeed once a wave parameter is ingested.
+
+const (
+	msToKnots          = 1.943844
+	maxRoutingCostSize = 4_000_000 // cells × headings × times
+	minSpeedShare      = 0.1       // motoring never drops below this share of the base speed
+)
+
+// Polar is a sailing vessel's boat speed table: Speed[i][j] in knots at true
+// wind angle TWA[i] (degrees off the bow, 0-180) and true wind speed TWS[j]
+// (knots). Both axes are ascending.
+type Polar struct {
+	TWA   []float64   `json:"twa"`
+	TWS   []float64   `json:"tws"`
+	Speed [][]float64 `json:"speed"`
+}
+
+func (p Polar) validate() error {
+	if len(p.TWA) < 2 || len(p.TWS) < 2 || len(p.Speed) != len(p.TWA) {
+		return fmt.Errorf("%w: polar needs at least 2 angles and 2 wind speeds and a speed row per angle", ErrInvalidParams)
+	}
+	if !slices.IsSorted(p.TWA) || !slices.IsSorted(p.TWS) {
+		return fmt.Errorf("%w: polar axes must be ascending", ErrInvalidParams)
+	}
+	for _, row := range p.Speed {
+		if len(row) != len(p.TWS) {
+			return fmt.Errorf("%w: polar speed rows must have one value per wind speed", ErrInvalidParams)
+		}
+	}
+	return nil
+}
+
+// at interpolates the boat speed bilinearly, clamping to the table's edges.
+func (p Polar) at(twa, tws float64) float64 {
+	i, wi := polarAxis(p.TWA, twa)
+	j, wj := polarAxis(p.TWS, tws)
+	return (1-wi)*((1-wj)*p.Speed[i][j]+wj*p.Speed[i][j+1]) +
+		wi*((1-wj)*p.Speed[i+1][j]+wj*p.Speed[i+1][j+1])
+}
+
+// polarAxis returns the interval of axis holding x and the weight of its
+// upper end.
+func polarAxis(axis []float64, x float64) (int, float64) {
+	x = math.Max(axis[0], math.Min(x, axis[len(axis)-1]))
+	i, _ := slices.BinarySearch(axis, x)
+	i = max(0, min(i-1, len(axis)-2))
+	return i, (x - axis[i]) / (axis[i+1] - axis[i])
+}
+
+type RoutingCostAPIParams struct {
+	SLat     float64   `json:"slat"`
+	SLon     float64   `json:"slon"`
+	ELat     float64   `json:"elat"`
+	ELon     float64   `json:"elon"`
+	Step     float64   `json:"step"` // grid step in degrees
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Headings int       `json:"headings"` // number of evenly spaced headings
+	Speed    float64   `json:"speed"`    // knots, motoring speed in calm, the engine of a sailing vessel
+	Penalty  float64   `json:"penalty"`  // knots lost per m/s of headwind, motoring only
+	Polar    *Polar    `json:"polar"`    // sailing when set
+}
+
+type RoutingCostResponse struct {
+	Grid     GridSpec       `json:"grid"`
+	Times    []time.Time    `json:"times"`
+	Headings []float64      `json:"headings"` // degrees true, direction of travel
+	Mode     string         `json:"mode"`     // motor or sail
+	Unit     string         `json:"unit"`     // h/nm
+	Cost     [][]NullFloats `json:"cost"`     // [time][heading][cell], null where the cell cannot be crossed
+	Status   int            `json:"status"`
+	Success  bool           `json:"success"`
+}
+
+var routingCostFailResponse = RoutingCostResponse{
+	Times:    []time.Time{},
+	Headings: []float64{},
+	Cost:     [][]NullFloats{},
+	Status:   http.StatusBadRequest,
+	Success:  false,
+}
+
+func sendRoutingCostJsonError(w http.ResponseWriter, statusCode int) {
+	response := routingCostFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// routingCostHandler serves /routing/cost?slat=&slon=&elat=&elon=&step=&start=&end=&headings=&speed=&penalty=,
+// POSTed with a Polar as the body for a sailing vessel.
+func routingCostHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := RoutingCostAPIParams{Headings: 8, Speed: 12, Penalty: 0.15}
+	for name, dst := range map[string]*float64{
+		"slat": &params.SLat, "slon": &params.SLon, "elat": &params.ELat, "elon": &params.ELon, "step": &params.Step,
+	} {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendRoutingCostJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	for name, dst := range map[string]*float64{"speed": &params.Speed, "penalty": &params.Penalty} {
+		if s := httpQuery.Get(name); s != "" {
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				sendRoutingCostJsonError(w, http.StatusBadRequest)
+				return
+			}
+			*dst = value
+		}
+	}
+	if s := httpQuery.Get("headings"); s != "" {
+		headings, err := strconv.Atoi(s)
+		if err != nil {
+			sendRoutingCostJsonError(w, http.StatusBadRequest)
+			return
+		}
+		params.Headings = headings
+	}
+
+	var err error
+	if params.Start, err = parseAsOf(httpQuery.Get("start")); err != nil {
+		sendRoutingCostJsonError(w, http.StatusBadRequest)
+		return
+	}
+	params.End = params.Start
+	if s := httpQuery.Get("end"); s != "" {
+		if params.End, err = parseAsOf(s); err != nil {
+			sendRoutingCostJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if r.Method == http.MethodPost {
+		var polar Polar
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&polar); err != nil {
+			sendRoutingCostJsonError(w, http.StatusBadRequest)
+			return
+		}
+		params.Polar = &polar
+		if httpQuery.Get("speed") == "" {
+			params.Speed = 0 // no engine unless asked for
+		}
+	}
+
+	setLogField(r.Context(), "date", params.Start.Format("2006010215")+"-"+params.End.Format("2006010215"))
+	data, err2 := RoutingCostQuery(r.Context(), params)
+	if err2 != nil {
+		sendRoutingCostJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func RoutingCostQuery(ctx context.Context, params RoutingCostAPIParams) (RoutingCostResponse, error) {
+	switch {
+	case params.Step <= 0:
+		return routingCostFailResponse, fmt.Errorf("%w: step must be positive", ErrInvalidParams)
+	case params.Headings < 1 || params.Headings > 360:
+		return routingCostFailResponse, fmt.Errorf("%w: headings must be between 1 and 360", ErrInvalidParams)
+	case params.End.Before(params.Start):
+		return routingCostFailResponse, fmt.Errorf("%w: end is before start", ErrInvalidDate)
+	case params.Speed < 0, params.Polar == nil && params.Speed == 0:
+		return routingCostFailResponse, fmt.Errorf("%w: speed must be positive", ErrInvalidParams)
+	case params.Penalty < 0:
+		return routingCostFailResponse, fmt.Errorf("%w: penalty must not be negative", ErrInvalidParams)
+	}
+	mode := "motor"
+	if params.Polar != nil {
+		if err := params.Polar.validate(); err != nil {
+			return routingCostFailResponse, err
+		}
+		mode = "sail"
+	}
+	target, err := regridTarget(params.SLat, params.SLon, params.ELat, params.ELon, params.Step)
+	if err != nil {
+		return routingCostFailResponse, err
+	}
+
+	// the batch times covering the window
+	var times []time.Time
+	for t := params.Start.UTC().Truncate(batchInterval); !t.After(params.End); t = t.Add(batchInterval) {
+		times = append(times, t)
+	}
+	if size := target.Size() * params.Headings * len(times); size > maxRoutingCostSize {
+		return routingCostFailResponse, fmt.Errorf("%w: %d cost values exceed limit of %d", ErrInvalidParams, size, maxRoutingCostSize)
+	}
+	headings := make([]float64, params.Headings)
+	for k := range headings {
+		headings[k] = float64(k) * 360 / float64(params.Headings)
+	}
+
+	field := newWindField(ctx)
+	cost := make([][]NullFloats, len(times))
+	for ti, t := range times {
+		cache, err := field.batch(t)
+		if err != nil {
+			return routingCostFailResponse, err
+		}
+		u, err := Regrid(cache.Grid, cache.U, target, RegridBilinear)
+		if err != nil {
+			return routingCostFailResponse, fmt.Errorf("failed to regrid 10u: %w", err)
+		}
+		v, err := Regrid(cache.Grid, cache.V, target, RegridBilinear)
+		if err != nil {
+			return routingCostFailResponse, fmt.Errorf("failed to regrid 10v: %w", err)
+		}
+
+		cost[ti] = make([]NullFloats, len(headings))
+		for k, heading := range headings {
+			values := make(NullFloats, target.Size())
+			for cell := range values {
+				speed := vesselSpeed(params, u[cell], v[cell], heading)
+				if math.IsNaN(speed) || speed <= 0 {
+					values[cell] = math.NaN()
+					continue
+				}
+				values[cell] = 1 / speed
+			}
+			cost[ti][k] = values
+		}
+	}
+
+	return RoutingCostResponse{
+		Grid:     specOf(target),
+		Times:    times,
+		Headings: headings,
+		Mode:     mode,
+		Unit:     "h/nm",
+		Cost:     cost,
+		Status:   http.StatusOK,
+		Success:  true,
+	}, nil
+}
+
+// vesselSpeed is the speed in knots through a wind (u, v) in m/s on heading
+// (degrees true). A sailing vessel motors at params.Speed when that is faster
+// than sailing.
+func vesselSpeed(params RoutingCostAPIParams, u, v, heading float64) float64 {
+	if math.IsNaN(u) || math.IsNaN(v) {
+		return math.NaN()
+	}
+	windSpeed := math.Hypot(u, v)
+	windFrom := math.Mod(math.Atan2(-u, -v)*180/math.Pi+360, 360)
+	twa := math.Abs(math.Mod(windFrom-heading+540, 360) - 180) // 0 is dead ahead
+	if params.Polar != nil {
+		return math.Max(params.Polar.at(twa, windSpeed*msToKnots), params.Speed)
+	}
+	headwind := windSpeed * math.Cos(twa*math.Pi/180)
+	return math.Max(params.Speed-params.Penalty*math.Max(headwind, 0), minSpeedShare*params.Speed)
+}
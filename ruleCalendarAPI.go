@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Saved threshold rules are named in the -rules file, each with a stable id
+// (its key) and a feed token:
+//
+//	{
+//	  "port-x-gales": {"name": "Gale windows at Port X", "lat": 22.3, "lon": 114.2,
+//	                   "measure": "wind", "above": 17.2, "min_hours": 3,
+//	                   "token": "3f9c0b6d1e2a4c58b7e1"}
+//	}
+//
+// GET /rules/{id}/calendar.ics?token= is an iCalendar feed of the windows
+// where the 10 m wind or gust of the rule's point goes above its threshold
+// over the next ruleCalendarDays, sampled like /drone/windows. Calendar
+// clients cannot send an Authorization header, so the feed is not behind
+// -auth-tokens, the rule's token in the URL is its credential. Each event's
+// UID is made of the rule id and the window's start, so a window keeps its
+// event across refreshes as long as its start holds.
+
+const (
+	ruleCalendarDays = 10
+	minRuleToken     = 16
+	measureWind      = "wind"
+	measureGust      = "gust"
+)
+
+type Rule struct {
+	Name     string  `json:"name"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Measure  string  `json:"measure"`   // wind or gust, at 10 m
+	Above    float64 `json:"above"`     // m/s
+	MinHours float64 `json:"min_hours"` // shortest window worth an event
+	Token    string  `json:"token"`
+}
+
+// rules is nil without a -rules file.
+var rules map[string]Rule
+
+func loadRules(path string) (map[string]Rule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defined map[string]Rule
+	if err := json.Unmarshal(content, &defined); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for id, rule := range defined {
+		if rule.Measure != measureWind && rule.Measure != measureGust {
+			return nil, fmt.Errorf("%s: rule %q: measure must be %s or %s", path, id, measureWind, measureGust)
+		}
+		if rule.Lat < -90 || rule.Lat > 90 || rule.Above <= 0 || rule.MinHours < 0 {
+			return nil, fmt.Errorf("%s: rule %q: lat, above or min_hours out of range", path, id)
+		}
+		if len(rule.Token) < minRuleToken {
+			return nil, fmt.Errorf("%s: rule %q: token must be at least %d characters", path, id, minRuleToken)
+		}
+	}
+	return defined, nil
+}
+
+func sendRuleCalendarJsonError(w http.ResponseWriter, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]any{"status": statusCode, "success": false})
+}
+
+// ruleCalendarHandler serves GET /rules/{id}/calendar.ics?token=
+func ruleCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	setLogField(r.Context(), "rule", id)
+	rule, ok := rules[id]
+	// a wrong token answers like an unknown rule, so ids cannot be probed
+	if !ok || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(rule.Token)) != 1 {
+		sendRuleCalendarJsonError(w, queryErrorStatus(ErrRuleNotFound))
+		setLogField(r.Context(), "error", ErrRuleNotFound)
+		return
+	}
+
+	calendar, err := RuleCalendar(r.Context(), id, rule)
+	if err != nil {
+		sendRuleCalendarJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", id+".ics"))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, calendar); err != nil {
+		log.Printf("Met Error when writing calendar to ResponseWriter: %v", err)
+	}
+}
+
+// RuleCalendar returns the iCalendar feed of a rule's exceedance windows.
+func RuleCalendar(ctx context.Context, id string, rule Rule) (string, error) {
+	now := clockFrom(ctx).Now().UTC()
+	start := now.Truncate(24 * time.Hour)
+	samples, _, err := shortLeadWind(ctx, rule.Lat, rule.Lon, start, start.Add(ruleCalendarDays*24*time.Hour))
+	if err != nil {
+		return "", err
+	}
+	if len(samples) == 0 {
+		return "", fmt.Errorf("%w: no forecast from %s", ErrDataNotPublished, start.Format("20060102"))
+	}
+	measure := func(sample DroneSample) float64 {
+		if rule.Measure == measureGust {
+			return float64(sample.Gust)
+		}
+		return float64(sample.Wind)
+	}
+	windows := droneWindows(samples, rule.MinHours, func(sample DroneSample) bool { return measure(sample) > rule.Above })
+
+	var b strings.Builder
+	line := func(name, value string) {
+		writeICalLine(&b, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//Griber//threshold rules//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("X-WR-CALNAME", icalText(rule.Name))
+	line("REFRESH-INTERVAL;VALUE=DURATION", fmt.Sprintf("PT%dH", batchInterval/time.Hour))
+	for _, window := range windows {
+		// each sample stands for the interval up to the next one
+		end := window.End.Add(droneMinLead)
+		var peak DroneSample
+		for _, sample := range samples {
+			if !sample.Time.Before(window.Start) && !sample.Time.After(window.End) && measure(sample) >= measure(peak) {
+				peak = sample
+			}
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", fmt.Sprintf("%s-%s@griber", id, window.Start.Format("20060102T150405Z")))
+		line("DTSTAMP", now.Format("20060102T150405Z"))
+		line("DTSTART", window.Start.Format("20060102T150405Z"))
+		line("DTEND", end.Format("20060102T150405Z"))
+		line("SUMMARY", icalText(fmt.Sprintf("%s: %s above %g m/s", rule.Name, rule.Measure, rule.Above)))
+		line("DESCRIPTION", icalText(fmt.Sprintf("Peak %s %.1f m/s at %s UTC, forecast run %s.",
+			rule.Measure, measure(peak), peak.Time.Format("2006-01-02 15:04"), peak.Run)))
+		line("GEO", fmt.Sprintf("%g;%g", rule.Lat, rule.Lon))
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return b.String(), nil
+}
+
+// icalText escapes a TEXT value (RFC 5545 3.3.11).
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// writeICalLine writes a content line folded at 75 octets, without
+// splitting a UTF-8 sequence.
+func writeICalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74 // the leading space counts
+	}
+	b.WriteString(line + "\r\n")
+}
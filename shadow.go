@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Shadowing mirrors a share of GET requests to a second instance and logs
+// where its responses differ from ours, to validate changes such as a new
+// decode pipeline under real traffic. The client only ever sees the primary
+// response; the mirrored request runs after it has been served.
+
+const (
+	maxShadowBody     = 8 << 20 // larger responses are not compared
+	maxShadowInFlight = 8       // mirrored requests beyond this are dropped
+	maxShadowDiffs    = 10      // differences listed per response
+)
+
+var (
+	shadowURL       = "" // base URL of the shadow instance, empty disables
+	shadowPercent   = 0.0
+	shadowTimeout   = 10 * time.Second
+	shadowTolerance = 1e-6 // absolute difference accepted between numbers
+
+	shadowSlots = make(chan struct{}, maxShadowInFlight)
+)
+
+// shadowRecorder keeps a copy of the primary response body.
+type shadowRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *shadowRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *shadowRecorder) Write(b []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(b) > maxShadowBody {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func shadowRequests(next http.Handler) http.Handler {
+	if shadowURL == "" || shadowPercent <= 0 {
+		return next
+	}
+	client := &http.Client{Timeout: shadowTimeout}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// refresh=true would make the shadow download the batch again
+		if r.Method != http.MethodGet || strings.HasPrefix(r.URL.Path, "/admin/") || r.URL.Query().Get("refresh") == "true" ||
+			rng.Float64()*100 >= shadowPercent {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &shadowRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.overflow {
+			return
+		}
+
+		select {
+		case shadowSlots <- struct{}{}:
+		default:
+			return
+		}
+		header := r.Header.Clone()
+		// the recorded body is the uncompressed one, let the transport
+		// negotiate and decode the shadow's compression
+		header.Del("Accept-Encoding")
+		target := strings.TrimSuffix(shadowURL, "/") + r.URL.RequestURI()
+		go func() {
+			defer func() { <-shadowSlots }()
+			mirrorRequest(client, target, header, recorder.status, recorder.body.Bytes())
+		}()
+	})
+}
+
+func mirrorRequest(client *http.Client, target string, header http.Header, status int, body []byte) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+	if err != nil {
+		log.Printf("Shadow %s: %v", target, err)
+		return
+	}
+	req.Header = header
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Shadow %s: %v", target, err)
+		return
+	}
+	defer resp.Body.Close()
+	shadowBody, err := io.ReadAll(io.LimitReader(resp.Body, maxShadowBody+1))
+	if err != nil || len(shadowBody) > maxShadowBody {
+		return
+	}
+
+	diffs := shadowDiff(status, body, resp.StatusCode, shadowBody)
+	if len(diffs) == 0 {
+		return
+	}
+	log.Printf("Shadow diff %s: %s", target, strings.Join(diffs, "; "))
+}
+
+// shadowDiff describes how the shadow response differs from the primary one.
+// JSON bodies are compared by value, others byte for byte.
+func shadowDiff(status int, body []byte, shadowStatus int, shadowBody []byte) []string {
+	var diffs []string
+	if status != shadowStatus {
+		diffs = append(diffs, fmt.Sprintf("status %d vs %d", status, shadowStatus))
+	}
+	var a, b any
+	if json.Unmarshal(body, &a) != nil || json.Unmarshal(shadowBody, &b) != nil {
+		if !bytes.Equal(body, shadowBody) {
+			diffs = append(diffs, fmt.Sprintf("body differs (%d vs %d bytes)", len(body), len(shadowBody)))
+		}
+		return diffs
+	}
+	total := 0
+	jsonDiff("$", a, b, &diffs, &total)
+	if total > maxShadowDiffs {
+		diffs = append(diffs, fmt.Sprintf("... %d differences in total", total))
+	}
+	return diffs
+}
+
+func jsonDiff(path string, a, b any, diffs *[]string, total *int) {
+	report := func(format string, args ...any) {
+		*total++
+		if *total <= maxShadowDiffs {
+			*diffs = append(*diffs, path+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		bMap, ok := b.(map[string]any)
+		if !ok {
+			report("object vs %T", b)
+			return
+		}
+		keys := make([]string, 0, len(a)+len(bMap))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range bMap {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			jsonDiff(path+"."+k, a[k], bMap[k], diffs, total)
+		}
+	case []any:
+		bSlice, ok := b.([]any)
+		if !ok {
+			report("array vs %T", b)
+			return
+		}
+		if len(a) != len(bSlice) {
+			report("length %d vs %d", len(a), len(bSlice))
+			return
+		}
+		for i := range a {
+			jsonDiff(fmt.Sprintf("%s[%d]", path, i), a[i], bSlice[i], diffs, total)
+		}
+	case float64:
+		bFloat, ok := b.(float64)
+		if !ok || math.Abs(a-bFloat) > shadowTolerance {
+			report("%v vs %v", a, b)
+		}
+	default:
+		if a != b {
+			report("%v vs %v", a, b)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// A minimal ESRI Shapefile writer, for the agencies whose GIS still wants
+// shapefiles: point or polyline layers with a dBase III attribute table,
+// each layer its .shp, .shx, .dbf, .prj (WGS 84) and .cpg in one zip. See
+// the ESRI Shapefile Technical Description (1998) for the layout.
+
+const (
+	shapePoint    = 1
+	shapePolyLine = 3
+
+	// dBase numeric columns, wide enough for any value the server returns
+	dbfNumberWidth    = 19
+	dbfNumberDecimals = 6
+	dbfMaxText        = 254
+)
+
+const wgs84PRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// shapeLayer is one shapefile: a shape of Points (lon, lat) per record and
+// its attributes, float64 or string per column.
+type shapeLayer struct {
+	Name      string
+	ShapeType int
+	Columns   []string
+	Shapes    [][][2]float64
+	Rows      [][]any
+}
+
+// writeShapefileZip writes the layers zipped.
+func writeShapefileZip(w io.Writer, layers ...shapeLayer) error {
+	archive := zip.NewWriter(w)
+	for _, layer := range layers {
+		shp, shx := encodeShapes(layer)
+		dbf, err := encodeDBF(layer)
+		if err != nil {
+			return fmt.Errorf("%s: %w", layer.Name, err)
+		}
+		files := []struct {
+			extension string
+			content   []byte
+		}{
+			{".shp", shp}, {".shx", shx}, {".dbf", dbf}, {".prj", []byte(wgs84PRJ)}, {".cpg", []byte("UTF-8")},
+		}
+		for _, file := range files {
+			f, err := archive.Create(layer.Name + file.extension)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(file.content); err != nil {
+				return err
+			}
+		}
+	}
+	return archive.Close()
+}
+
+// shapeHeader is the 100 byte header shared by .shp and .shx; lengths are in
+// 16 bit words.
+func shapeHeader(buf *bytes.Buffer, words int, shapeType int, box [4]float64) {
+	binary.Write(buf, binary.BigEndian, [7]int32{9994, 0, 0, 0, 0, 0, int32(words)})
+	binary.Write(buf, binary.LittleEndian, [2]int32{1000, int32(shapeType)})
+	binary.Write(buf, binary.LittleEndian, [8]float64{box[0], box[1], box[2], box[3], 0, 0, 0, 0})
+}
+
+func encodeShapes(layer shapeLayer) (shp, shx []byte) {
+	box := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	contents := make([][]byte, len(layer.Shapes))
+	for i, points := range layer.Shapes {
+		var content bytes.Buffer
+		shapeBox := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		for _, p := range points {
+			shapeBox = [4]float64{math.Min(shapeBox[0], p[0]), math.Min(shapeBox[1], p[1]), math.Max(shapeBox[2], p[0]), math.Max(shapeBox[3], p[1])}
+		}
+		box = [4]float64{math.Min(box[0], shapeBox[0]), math.Min(box[1], shapeBox[1]), math.Max(box[2], shapeBox[2]), math.Max(box[3], shapeBox[3])}
+		binary.Write(&content, binary.LittleEndian, int32(layer.ShapeType))
+		if layer.ShapeType == shapePolyLine {
+			binary.Write(&content, binary.LittleEndian, shapeBox)
+			binary.Write(&content, binary.LittleEndian, [3]int32{1, int32(len(points)), 0}) // one part from point 0
+		}
+		for _, p := range points {
+			binary.Write(&content, binary.LittleEndian, p)
+		}
+		contents[i] = content.Bytes()
+	}
+	if len(contents) == 0 {
+		box = [4]float64{}
+	}
+
+	length := 50 // header words
+	for _, content := range contents {
+		length += 4 + len(content)/2
+	}
+	var shpBuf, shxBuf bytes.Buffer
+	shapeHeader(&shpBuf, length, layer.ShapeType, box)
+	shapeHeader(&shxBuf, 50+4*len(contents), layer.ShapeType, box)
+	offset := 50
+	for i, content := range contents {
+		binary.Write(&shxBuf, binary.BigEndian, [2]int32{int32(offset), int32(len(content) / 2)})
+		binary.Write(&shpBuf, binary.BigEndian, [2]int32{int32(i + 1), int32(len(content) / 2)})
+		shpBuf.Write(content)
+		offset += 4 + len(content)/2
+	}
+	return shpBuf.Bytes(), shxBuf.Bytes()
+}
+
+// encodeDBF writes the attribute table. Columns holding only numbers are
+// numeric, NaN left blank (null); any other column is text.
+func encodeDBF(layer shapeLayer) ([]byte, error) {
+	type dbfField struct {
+		name    string
+		numeric bool
+		width   int
+	}
+	fields := make([]dbfField, len(layer.Columns))
+	for i, column := range layer.Columns {
+		if len(column) > 10 {
+			return nil, fmt.Errorf("column name %q longer than dBase's 10 characters", column)
+		}
+		field := dbfField{name: column, numeric: true, width: dbfNumberWidth}
+		for _, row := range layer.Rows {
+			if text, ok := row[i].(string); ok {
+				if field.numeric {
+					field.numeric, field.width = false, 1
+				}
+				field.width = min(max(field.width, len(text)), dbfMaxText)
+			}
+		}
+		fields[i] = field
+	}
+
+	recordLength := 1 // deletion flag
+	for _, field := range fields {
+		recordLength += field.width
+	}
+	headerLength := 32 + 32*len(fields) + 1
+	var buf bytes.Buffer
+	now := clock.Now()
+	buf.Write([]byte{0x03, byte(now.Year() - 1900), byte(now.Month()), byte(now.Day())})
+	binary.Write(&buf, binary.LittleEndian, uint32(len(layer.Rows)))
+	binary.Write(&buf, binary.LittleEndian, uint16(headerLength))
+	binary.Write(&buf, binary.LittleEndian, uint16(recordLength))
+	buf.Write(make([]byte, 20))
+	for _, field := range fields {
+		descriptor := make([]byte, 32)
+		copy(descriptor, field.name)
+		descriptor[11] = 'C'
+		if field.numeric {
+			descriptor[11] = 'N'
+			descriptor[17] = dbfNumberDecimals
+		}
+		descriptor[16] = byte(field.width)
+		buf.Write(descriptor)
+	}
+	buf.WriteByte(0x0D)
+
+	for _, row := range layer.Rows {
+		buf.WriteByte(' ')
+		for i, field := range fields {
+			var cell string
+			switch value := row[i].(type) {
+			case float64:
+				if !math.IsNaN(value) && !math.IsInf(value, 0) {
+					cell = strconv.FormatFloat(value, 'f', dbfNumberDecimals, 64)
+				}
+			case string:
+				cell = value
+			}
+			if len(cell) > field.width {
+				cell = cell[:field.width]
+			}
+			if field.numeric {
+				fmt.Fprintf(&buf, "%*s", field.width, cell) // numbers right aligned
+			} else {
+				fmt.Fprintf(&buf, "%-*s", field.width, cell)
+			}
+		}
+	}
+	buf.WriteByte(0x1A)
+	return buf.Bytes(), nil
+}
+
+// tableShapes is a point layer of a table with lat and lon columns.
+func tableShapes(name string, table queryTable) shapeLayer {
+	layer := shapeLayer{Name: name, ShapeType: shapePoint, Columns: table.Columns, Rows: table.Rows}
+	latColumn, lonColumn := -1, -1
+	for i, column := range table.Columns {
+		switch column {
+		case "lat":
+			latColumn = i
+		case "lon":
+			lonColumn = i
+		}
+	}
+	for _, row := range table.Rows {
+		lat, _ := row[latColumn].(float64)
+		lon, _ := row[lonColumn].(float64)
+		layer.Shapes = append(layer.Shapes, [][2]float64{{lon, lat}})
+	}
+	return layer
+}
+
+// trackShapes are the layers of a storm track: its records as points and
+// the track as a polyline.
+func trackShapes(records [][]string) []shapeLayer {
+	sid := records[0][colSID]
+	points := shapeLayer{
+		Name:      sid + "_points",
+		ShapeType: shapePoint,
+		Columns:   []string{"sid", "name", "iso_time", "nature", "lat", "lon", "wind_kts", "pres_mb", "cat"},
+	}
+	var line [][2]float64
+	for _, record := range records {
+		lat, lon, ok := recordLatLon(record)
+		if !ok {
+			continue
+		}
+		lon = normalizeLon(lon)
+		isoTime := record[colIsoTime]
+		if t, err := time.Parse("20060102150405", isoTime); err == nil {
+			isoTime = t.UTC().Format(time.RFC3339)
+		}
+		point := typhoonPointFor(record)
+		points.Shapes = append(points.Shapes, [][2]float64{{lon, lat}})
+		points.Rows = append(points.Rows, []any{sid, record[colName], isoTime, record[colNature], lat, lon, point.Wind, point.Pres, point.Cat})
+		line = append(line, [2]float64{lon, lat})
+	}
+	track := shapeLayer{
+		Name:      sid + "_track",
+		ShapeType: shapePolyLine,
+		Columns:   []string{"sid", "name", "season", "basin"},
+		Shapes:    [][][2]float64{line},
+		Rows:      [][]any{{sid, records[0][colName], records[0][colSeason], records[0][colBasin]}},
+	}
+	if len(line) < 2 {
+		return []shapeLayer{points}
+	}
+	return []shapeLayer{points, track}
+}
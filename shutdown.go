@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// On SIGTERM or SIGINT the server stops accepting connections and lets the
+// requests in flight finish for up to -shutdown-timeout, then cancels those
+// left. Downloads and jobs run detached from the requests that started them
+// (see singleflight.go and jobs.go), so they are cancelled with background
+// once the requests are done, and the server waits for them to clean up
+// before it exits.
+
+// shutdownTimeout is how long requests are drained, and then how long
+// cancelled downloads and jobs are waited for.
+var shutdownTimeout = 30 * time.Second
+
+// partialSuffix marks a file being written, renamed in place when complete.
+const partialSuffix = ".partial"
+
+var (
+	background, stopBackground = context.WithCancel(context.Background())
+	backgroundWork             sync.WaitGroup
+)
+
+// detach returns a context with the values of ctx that is cancelled on
+// shutdown instead of with ctx, for work outliving a request. Call the
+// returned function when the work is done.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	backgroundWork.Add(1)
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(background, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		backgroundWork.Done()
+	}
+}
+
+// serve serves handler on listenAddr until a signal asks it to stop.
+func serve(handler http.Handler) error {
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server := &http.Server{
+		Addr:        listenAddr,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return requests },
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	select {
+	case err := <-served:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %v, draining requests for up to %v", sig, shutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Requests still in flight after %v, cancelling them", shutdownTimeout)
+		cancelRequests()
+		server.Close()
+	}
+	stopGRPC(ctx)
+
+	stopBackground()
+	done := make(chan struct{})
+	go func() {
+		backgroundWork.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Printf("Downloads and jobs still running after %v, exiting anyway", shutdownTimeout)
+	}
+
+	if costsPath != "" {
+		if err := flushCosts(); err != nil {
+			log.Printf("Fail to write costs %s: %v", costsPath, err)
+		}
+	}
+	removePartialFiles()
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// removePartialFiles deletes the files in tmp/ whose writes never completed,
+// left by a write that was cut short or a process that was killed.
+func removePartialFiles() {
+	filepath.WalkDir(tmpDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, partialSuffix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Fail to remove partial file %s: %v", path, err)
+		}
+		return nil
+	})
+}
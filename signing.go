@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Response signing. With -signing-key every response body is signed and the
+// signature sent in X-Signature, base64 encoded, with the algorithm in
+// X-Signature-Algorithm, so pipelines consuming the output can tell it was
+// not altered on the way. The key file holds one line, either
+//
+//	hmac-sha256 <base64 secret>   shared with the consumers
+//	ed25519 <base64 32 byte seed> consumers verify with the public key from /signing-key
+//
+// Every HTTP response is signed, errors included; the gRPC service of
+// -grpc-listen is not. Responses up to maxSignedBuffer are held back and the
+// signature sent as a header. Longer ones, the streamed /range output among
+// them, are sent as they are written and the signature follows in an
+// X-Signature trailer, so signing does not hold a whole global field in
+// memory. Streamed ed25519 responses are signed with Ed25519ph (RFC 8032,
+// the SHA-512 digest of the body) and say so in X-Signature-Algorithm;
+// hmac-sha256 signatures are the same either way.
+
+const (
+	signHMAC      = "hmac-sha256"
+	signEd25519   = "ed25519"
+	signEd25519ph = "ed25519ph"
+
+	maxSignedBuffer = 1 << 20
+)
+
+type responseSigner struct {
+	algorithm  string
+	secret     []byte             // hmac-sha256
+	privateKey ed25519.PrivateKey // ed25519
+}
+
+// signer is nil when signing is off.
+var signer *responseSigner
+
+func loadSigningKey(path string) (*responseSigner, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%s: want \"%s <key>\" or \"%s <key>\"", path, signHMAC, signEd25519)
+	}
+	key, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%s: key is not base64: %w", path, err)
+	}
+	switch fields[0] {
+	case signHMAC:
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%s: hmac secret of %d bytes, want at least 32", path, len(key))
+		}
+		return &responseSigner{algorithm: signHMAC, secret: key}, nil
+	case signEd25519:
+		if len(key) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%s: ed25519 seed of %d bytes, want %d", path, len(key), ed25519.SeedSize)
+		}
+		return &responseSigner{algorithm: signEd25519, privateKey: ed25519.NewKeyFromSeed(key)}, nil
+	default:
+		return nil, fmt.Errorf("%s: unknown algorithm %q", path, fields[0])
+	}
+}
+
+func (s *responseSigner) sign(body []byte) string {
+	if s.algorithm == signEd25519 {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, body))
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// streamHash returns the hash a streamed response is written through.
+func (s *responseSigner) streamHash() hash.Hash {
+	if s.algorithm == signEd25519 {
+		return sha512.New()
+	}
+	return hmac.New(sha256.New, s.secret)
+}
+
+// signStream signs a response written through streamHash.
+func (s *responseSigner) signStream(h hash.Hash) string {
+	if s.algorithm == signEd25519 {
+		signature, err := s.privateKey.Sign(nil, h.Sum(nil), &ed25519.Options{Hash: crypto.SHA512})
+		if err != nil {
+			return ""
+		}
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (s *responseSigner) streamAlgorithm() string {
+	if s.algorithm == signEd25519 {
+		return signEd25519ph
+	}
+	return s.algorithm
+}
+
+// signResponses sends each response with its signature, in a header or, for
+// responses streamed past maxSignedBuffer, a trailer.
+func signResponses(next http.Handler) http.Handler {
+	if signer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := &signingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(writer, r)
+		writer.finish()
+	})
+}
+
+type signingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer // until streaming
+	hash   hash.Hash    // once streaming
+}
+
+func (s *signingWriter) WriteHeader(status int) {
+	if s.hash == nil {
+		s.status = status
+	}
+}
+
+func (s *signingWriter) Write(p []byte) (int, error) {
+	if s.hash != nil {
+		s.hash.Write(p)
+		return s.ResponseWriter.Write(p)
+	}
+	s.body.Write(p)
+	if s.body.Len() > maxSignedBuffer {
+		if err := s.stream(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush switches to streaming, a handler flushing wants its output sent.
+func (s *signingWriter) Flush() {
+	if s.hash == nil {
+		s.stream()
+	}
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *signingWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// stream sends the header, announcing the signature trailer, and what was
+// held back so far.
+func (s *signingWriter) stream() error {
+	header := s.Header()
+	header.Set("X-Signature-Algorithm", signer.streamAlgorithm())
+	header.Add("Trailer", "X-Signature")
+	header.Del("Content-Length") // trailers need a chunked body
+	s.hash = signer.streamHash()
+	s.hash.Write(s.body.Bytes())
+	s.ResponseWriter.WriteHeader(s.status)
+	_, err := s.ResponseWriter.Write(s.body.Bytes())
+	s.body = bytes.Buffer{}
+	return err
+}
+
+func (s *signingWriter) finish() {
+	if s.hash != nil {
+		s.Header().Set("X-Signature", signer.signStream(s.hash))
+		return
+	}
+	s.Header().Set("X-Signature-Algorithm", signer.algorithm)
+	s.Header().Set("X-Signature", signer.sign(s.body.Bytes()))
+	s.ResponseWriter.WriteHeader(s.status)
+	s.ResponseWriter.Write(s.body.Bytes())
+}
+
+type SigningKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // base64
+	Status    int    `json:"status"`
+	Success   bool   `json:"success"`
+}
+
+// signingKeyHandler publishes the ed25519 public key responses are signed
+// with. There is nothing to publish for hmac-sha256 or when signing is off.
+func signingKeyHandler(w http.ResponseWriter, r *http.Request) {
+	response := SigningKeyResponse{Status: http.StatusNotFound}
+	if signer != nil && signer.algorithm == signEd25519 {
+		response = SigningKeyResponse{
+			Algorithm: signEd25519,
+			PublicKey: base64.StdEncoding.EncodeToString(signer.privateKey.Public().(ed25519.PublicKey)),
+			Status:    http.StatusOK,
+			Success:   true,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.Status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// signedBody serves size bytes through signResponses in chunks, the way
+// streamRange writes, and returns the body with the signature headers and
+// trailers.
+func signedBody(t *testing.T, size int) ([]byte, http.Header, http.Header) {
+	t.Helper()
+	handler := signResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		chunk := bytes.Repeat([]byte("0123456789abcdef"), 4<<10)
+		for written := 0; written < size; written += len(chunk) {
+			w.Write(chunk[:min(len(chunk), size-written)])
+		}
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != size {
+		t.Fatalf("got %d bytes, want %d", len(body), size)
+	}
+	return body, resp.Header, resp.Trailer
+}
+
+func TestSignResponses(t *testing.T) {
+	defer func(previous *responseSigner) { signer = previous }(signer)
+	secret := bytes.Repeat([]byte{7}, 32)
+	privateKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{9}, ed25519.SeedSize))
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	hmacOf := func(body []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		return mac.Sum(nil)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		signer    *responseSigner
+		size      int
+		algorithm string
+		trailer   bool
+		verify    func(body, signature []byte) bool
+	}{
+		{"hmac buffered", &responseSigner{algorithm: signHMAC, secret: secret}, 1000, signHMAC, false,
+			func(body, signature []byte) bool { return hmac.Equal(hmacOf(body), signature) }},
+		{"hmac streamed", &responseSigner{algorithm: signHMAC, secret: secret}, 3 * maxSignedBuffer, signHMAC, true,
+			func(body, signature []byte) bool { return hmac.Equal(hmacOf(body), signature) }},
+		{"ed25519 buffered", &responseSigner{algorithm: signEd25519, privateKey: privateKey}, 1000, signEd25519, false,
+			func(body, signature []byte) bool { return ed25519.Verify(publicKey, body, signature) }},
+		{"ed25519 streamed", &responseSigner{algorithm: signEd25519, privateKey: privateKey}, 3 * maxSignedBuffer, signEd25519ph, true,
+			func(body, signature []byte) bool {
+				digest := sha512.Sum512(body)
+				return ed25519.VerifyWithOptions(publicKey, digest[:], signature, &ed25519.Options{Hash: crypto.SHA512}) == nil
+			}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			signer = tc.signer
+			body, header, trailer := signedBody(t, tc.size)
+			if got := header.Get("X-Signature-Algorithm"); got != tc.algorithm {
+				t.Errorf("algorithm %q, want %q", got, tc.algorithm)
+			}
+			encoded := header.Get("X-Signature")
+			if tc.trailer {
+				if encoded != "" {
+					t.Errorf("streamed response has a signature header")
+				}
+				encoded = trailer.Get("X-Signature")
+			}
+			signature, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil || len(signature) == 0 {
+				t.Fatalf("signature %q: %v", encoded, err)
+			}
+			if !tc.verify(body, signature) {
+				t.Errorf("signature does not verify")
+			}
+		})
+	}
+}
@@ -1,27 +1,41 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
-	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type SingleAPIParams struct {
-	Lat   float64 `json:"lat"`
-	Lon   float64 `json:"lon"`
-	Date  string  `json:"date"`
-	Batch string  `json:"batch"`
+	Lat     float64  `json:"lat"`
+	Lon     float64  `json:"lon"`
+	Date    string   `json:"date"`
+	Batch   string   `json:"batch"`
+	Step    int      `json:"step"`    // forecast step in hours, 0 is the analysis
+	Param   string   `json:"param"`   // wind or a single parameter such as 2t
+	Params  []string `json:"params"`  // any parameters, overrides Param
+	Derived []string `json:"derived"` // speed and/or dir
+	Time    string   `json:"time"`    // HHMM, interpolated between the batches around it
 }
 
 type SingleResponse struct {
-	U       float64 `json:"u"`
-	V       float64 `json:"v"`
-	Status  int     `json:"status"`
-	Success bool    `json:"success"`
+	U       NullFloat            `json:"u"`                // null for scalar params
+	V       NullFloat            `json:"v"`                // null for scalar params
+	Param   string               `json:"param,omitempty"`  // scalar param only
+	Value   *NullFloat           `json:"value,omitempty"`  // scalar param only
+	Fields  map[string]NullFloat `json:"fields,omitempty"` // params= only
+	Speed   *NullFloat           `json:"speed,omitempty"`  // derived=speed only
+	Dir     *NullFloat           `json:"dir,omitempty"`    // derived=dir only
+	Status  int                  `json:"status"`
+	Success bool                 `json:"success"`
 }
 
 var singleFailResponse = SingleResponse{
@@ -31,10 +45,22 @@ var singleFailResponse = SingleResponse{
 	Success: false,
 }
 
+// parseCoordinate parses a latitude or longitude, which has to be finite.
+func parseCoordinate(s string) (float64, error) {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidParams, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%w: coordinate %q is not finite", ErrInvalidParams, s)
+	}
+	return value, nil
+}
+
 func sendSingleJsonError(w http.ResponseWriter, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode) // 写入HTTP状态码 (例如 400, 500)
-	json.NewEncoder(w).Encode(singleFailResponse)
+	response := singleFailResponse
+	response.Status = statusCode
+	writeSingleResponse(w, statusCode, response)
 }
 
 func singleQueryHandler(w http.ResponseWriter, r *http.Request) {
@@ -46,7 +72,7 @@ func singleQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendSingleJsonError(w, http.StatusBadRequest)
 		return
 	}
-	lat, err := strconv.ParseFloat(latStr, 64)
+	lat, err := parseCoordinate(latStr)
 	if err != nil {
 		sendSingleJsonError(w, http.StatusBadRequest)
 		return
@@ -57,7 +83,7 @@ func singleQueryHandler(w http.ResponseWriter, r *http.Request) {
 		sendSingleJsonError(w, http.StatusBadRequest)
 		return
 	}
-	lon, err := strconv.ParseFloat(lonStr, 64)
+	lon, err := parseCoordinate(lonStr)
 	if err != nil {
 		sendSingleJsonError(w, http.StatusBadRequest)
 		return
@@ -69,96 +95,322 @@ func singleQueryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// either a batch or a time between batches
 	batch := httpQuery.Get("batch")
-	if batch == "" {
+	timeOfDay := httpQuery.Get("time")
+	if (batch == "") == (timeOfDay == "") || (timeOfDay != "" && httpQuery.Has("step")) {
+		sendSingleJsonError(w, http.StatusBadRequest)
+		return
+	}
+	if timeOfDay != "" {
+		at, err := parseTimeOfDay(date, timeOfDay)
+		if err != nil {
+			sendSingleJsonError(w, http.StatusBadRequest)
+			return
+		}
+		batch = fmt.Sprintf("%02dz", at.Truncate(batchInterval).Hour()) // the batch before
+	}
+
+	step, err := parseStep(httpQuery.Get("step"), batch)
+	if err != nil {
+		sendSingleJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	param, err := parseQueryParam(httpQuery.Get("param"))
+	if err != nil {
+		sendSingleJsonError(w, http.StatusBadRequest)
+		return
+	}
+	paramList, err := parseQueryParams(httpQuery.Get("params"))
+	if err != nil || (paramList != nil && httpQuery.Has("param")) {
+		sendSingleJsonError(w, http.StatusBadRequest)
+		return
+	}
+	if datasetParams, err := queryDataset(r.Context(), httpQuery, date, batch, step); err != nil {
+		sendSingleJsonError(w, queryErrorStatus(err))
+		return
+	} else if datasetParams != nil {
+		paramList = datasetParams
+	}
+	derived, err := parseDerived(httpQuery.Get("derived"))
+	if err != nil {
 		sendSingleJsonError(w, http.StatusBadRequest)
 		return
 	}
 
 	params := SingleAPIParams{
-		Lat:   lat,
-		Lon:   lon,
-		Date:  date,
-		Batch: batch,
+		Lat:     lat,
+		Lon:     lon,
+		Date:    date,
+		Batch:   batch,
+		Step:    step,
+		Param:   param,
+		Params:  paramList,
+		Derived: derived,
+		Time:    timeOfDay,
+	}
+
+	format, err := parseFormat(r)
+	if err != nil {
+		sendSingleJsonError(w, http.StatusBadRequest)
+		return
 	}
 
 	// final respons
-	data, err2 := SingleQuery(params)
+	if isValidateRequest(r) {
+		sendQueryPlan(w, r, validateDateBatch(date, batch), []string{date}, batch)
+		return
+	}
+
+	setLogField(r.Context(), "date", date)
+	setLogField(r.Context(), "batch", batch)
+	if timeOfDay != "" {
+		setLogField(r.Context(), "time", timeOfDay)
+	}
+	data, err2 := SingleQuery(r.Context(), params)
 	if err2 != nil {
-		sendSingleJsonError(w, http.StatusBadRequest)
-		log.Println(err2)
+		sendSingleJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(data)
+	if format != formatJSON {
+		name := "api-" + date + batch
+		if timeOfDay != "" {
+			name = "api-" + date + "T" + timeOfDay
+		}
+		writeTable(w, format, name, data.table())
+		return
+	}
+	err = writeSingleResponse(w, http.StatusOK, data)
 	if err != nil {
 		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
 	}
 }
 
-func SingleQuery(params SingleAPIParams) (SingleResponse, error) {
+func SingleQuery(ctx context.Context, params SingleAPIParams) (SingleResponse, error) {
+	if params.Time != "" {
+		return singleTimeQuery(ctx, params)
+	}
 	date := params.Date
 	batch := params.Batch
-	filePath := filepath.Join("tmp", date+"-"+batch+".json")
-
-	// First try
-	response, err := readAndParseFile(filePath, params)
-	if err == nil {
-		return response, nil
+	if err := validateDateBatch(date, batch); err != nil {
+		return singleFailResponse, err
 	}
+	filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
 
-	// Try to download
-	if err := downloadAndSave(date, batch); err != nil {
-		return singleFailResponse, fmt.Errorf("download failed: %w", err)
+	// Served from the in-memory file cache, the file is only read
+	// (or downloaded) on a miss
+	names := selectedParams(params.Param, params.Params)
+	grid, fields, err := loadParamFields(ctx, filePath, date, batch, params.Step, names)
+	if err != nil {
+		return singleFailResponse, fmt.Errorf("failed to load %s: %w", filePath, err)
 	}
+	return singlePoint(ctx, params, names, grid, fields, filePath)
+}
 
-	// Second try
-	response, err = readAndParseFile(filePath, params)
+// singlePoint picks the point of params from the loaded fields of names.
+func singlePoint(ctx context.Context, params SingleAPIParams, names []string, grid Grid, fields [][]float64, filePath string) (SingleResponse, error) {
+	valueIndex, err := grid.Index(params.Lat, params.Lon)
 	if err != nil {
-		log.Printf("Second read/parse failed after download: %v", err)
-		return singleFailResponse, fmt.Errorf("read/parse failed after download: %w", err)
+		return singleFailResponse, fmt.Errorf("failed to get index for coord: %w", err)
+	}
+	if len(params.Derived) > 0 {
+		if _, _, err := windComponents(names); err != nil {
+			return singleFailResponse, err
+		}
+	}
+	values := make([]NullFloat, len(fields))
+	for i, field := range fields {
+		if valueIndex >= len(field) {
+			return singleFailResponse, fmt.Errorf("%w: index %d out of bounds for %s", ErrOutOfGrid, valueIndex, filePath)
+		}
+		values[i] = NullFloat(field[valueIndex])
 	}
 
-	// finally
+	response := SingleResponse{
+		U:       NullFloat(math.NaN()),
+		V:       NullFloat(math.NaN()),
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	switch {
+	case len(params.Params) > 0:
+		response.Fields = fieldsByName(names, values)
+	case len(names) == 2:
+		response.U, response.V = values[0], values[1]
+	default:
+		response.Param, response.Value = names[0], &values[0]
+	}
+	if len(params.Derived) > 0 {
+		u, v, _ := windComponents(names)
+		response.derive(params.Derived, values[u], values[v])
+	}
+	addLogCount(ctx, "points", 1)
 	return response, nil
 }
 
-func readAndParseFile(filePath string, params SingleAPIParams) (SingleResponse, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return SingleResponse{}, fmt.Errorf("failed to read file %s: %w", filePath, err)
+func (r *SingleResponse) derive(derived []string, u, v NullFloat) {
+	speed, dir := deriveWind(derived, []float64{float64(u)}, []float64{float64(v)})
+	if speed != nil {
+		r.Speed = (*NullFloat)(&speed[0])
 	}
-
-	var data struct {
-		U []float64 `json:"10u"`
-		V []float64 `json:"10v"`
+	if dir != nil {
+		r.Dir = (*NullFloat)(&dir[0])
 	}
+}
 
-	if err := json.Unmarshal(content, &data); err != nil {
-		return SingleResponse{}, fmt.Errorf("failed to unmarshal json from %s: %w", filePath, err)
+// parseTimeOfDay reads time=HHMM on date.
+func parseTimeOfDay(date, hhmm string) (time.Time, error) {
+	at, err := time.Parse("200601021504", date+hhmm)
+	if err != nil || len(hhmm) != 4 {
+		return time.Time{}, fmt.Errorf("%w: time %q, want HHMM", ErrInvalidParams, hhmm)
 	}
+	return at, nil
+}
 
-	if len(data.U) == 0 {
-		return SingleResponse{}, fmt.Errorf("json data for '10u' is empty or missing")
+// singleTimeQuery answers a time between batches by interpolating the
+// analyses of the batches before and after it linearly in time. After 18z
+// the batch after is 00z of the next day. Speed and direction are derived
+// from the interpolated components, interpolating a direction would turn
+// through the wrong side of the circle.
+func singleTimeQuery(ctx context.Context, params SingleAPIParams) (SingleResponse, error) {
+	at, err := parseTimeOfDay(params.Date, params.Time)
+	if err != nil {
+		return singleFailResponse, err
 	}
-	if len(data.V) == 0 {
-		return SingleResponse{}, fmt.Errorf("json data for '10v' is empty or missing")
+	names := selectedParams(params.Param, params.Params)
+	if len(params.Derived) > 0 {
+		if _, _, err := windComponents(names); err != nil {
+			return singleFailResponse, err
+		}
 	}
+	before := at.Truncate(batchInterval)
+	weight := float64(at.Sub(before)) / float64(batchInterval)
 
-	lat := params.Lat
-	lon := params.Lon
-	valueIndex, err := GetIndexForCoord(lat, lon)
+	query := func(t time.Time) (SingleResponse, error) {
+		batchParams := params
+		batchParams.Date, batchParams.Batch = t.Format("20060102"), fmt.Sprintf("%02dz", t.Hour())
+		batchParams.Step, batchParams.Time, batchParams.Derived = 0, "", nil
+		return SingleQuery(ctx, batchParams)
+	}
+	response, err := query(before)
 	if err != nil {
-		return SingleResponse{}, fmt.Errorf("failed to get index for coord: %w", err)
+		return singleFailResponse, err
 	}
-	response := SingleResponse{
-		U:       data.U[valueIndex],
-		V:       data.V[valueIndex],
-		Status:  http.StatusOK,
-		Success: true,
+	if weight > 0 {
+		after, err := query(before.Add(batchInterval))
+		if err != nil {
+			return singleFailResponse, err
+		}
+		lerp := func(a, b NullFloat) NullFloat {
+			return NullFloat((1-weight)*float64(a) + weight*float64(b))
+		}
+		response.U, response.V = lerp(response.U, after.U), lerp(response.V, after.V)
+		if response.Value != nil {
+			value := lerp(*response.Value, *after.Value)
+			response.Value = &value
+		}
+		if response.Fields != nil {
+			fields := make(map[string]NullFloat, len(response.Fields))
+			for name, value := range response.Fields {
+				fields[name] = lerp(value, after.Fields[name])
+			}
+			response.Fields = fields
+		}
 	}
 
+	if len(params.Derived) > 0 {
+		u, v := response.U, response.V
+		if response.Fields != nil {
+			ui, vi, _ := windComponents(names)
+			u, v = response.Fields[names[ui]], response.Fields[names[vi]]
+		}
+		response.derive(params.Derived, u, v)
+	}
 	return response, nil
 }
+
+// singleBufferPool holds encode buffers for writeSingleResponse.
+var singleBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 128)
+		return &buf
+	},
+}
+
+// writeSingleResponse encodes response into a pooled buffer. The encoding
+// allocates nothing, setting the Content-Type header allocates its value; the
+// lookup before it (singlePoint, derive) allocates as well.
+func writeSingleResponse(w http.ResponseWriter, statusCode int, response SingleResponse) error {
+	bufPtr := singleBufferPool.Get().(*[]byte)
+	buf := response.appendJSON((*bufPtr)[:0])
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, err := w.Write(buf)
+	*bufPtr = buf
+	singleBufferPool.Put(bufPtr)
+	return err
+}
+
+// appendJSON encodes the response exactly like json.Encoder would, without
+// reflection or allocation. Keep it in sync with the SingleResponse tags.
+func (r SingleResponse) appendJSON(buf []byte) []byte {
+	buf = append(buf, `{"u":`...)
+	buf = appendNullFloat(buf, float64(r.U))
+	buf = append(buf, `,"v":`...)
+	buf = appendNullFloat(buf, float64(r.V))
+	if r.Param != "" {
+		buf = append(buf, `,"param":`...)
+		buf = appendJSONString(buf, r.Param)
+	}
+	if r.Value != nil {
+		buf = append(buf, `,"value":`...)
+		buf = appendNullFloat(buf, float64(*r.Value))
+	}
+	if len(r.Fields) > 0 {
+		buf = append(buf, `,"fields":{`...)
+		names := make([]string, 0, 16) // on the stack for up to 16 fields
+		for name := range r.Fields {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for i, name := range names {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, name)
+			buf = append(buf, ':')
+			buf = appendNullFloat(buf, float64(r.Fields[name]))
+		}
+		buf = append(buf, '}')
+	}
+	if r.Speed != nil {
+		buf = append(buf, `,"speed":`...)
+		buf = appendNullFloat(buf, float64(*r.Speed))
+	}
+	if r.Dir != nil {
+		buf = append(buf, `,"dir":`...)
+		buf = appendNullFloat(buf, float64(*r.Dir))
+	}
+	buf = append(buf, `,"status":`...)
+	buf = strconv.AppendInt(buf, int64(r.Status), 10)
+	buf = append(buf, `,"success":`...)
+	buf = strconv.AppendBool(buf, r.Success)
+	return append(buf, '}', '\n')
+}
+
+// appendJSONString quotes s like encoding/json. Parameter names are plain
+// ASCII and are appended as they are, anything else goes through json.Marshal.
+func appendJSONString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			quoted, _ := json.Marshal(s)
+			return append(buf, quoted...)
+		}
+	}
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
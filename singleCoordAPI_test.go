@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func singleResponses() map[string]SingleResponse {
+	value, speed, dir, missing := NullFloat(251.5), NullFloat(6.08), NullFloat(189.46), NullFloat(math.NaN())
+	return map[string]SingleResponse{
+		"wind":    {U: 1, V: 6, Status: http.StatusOK, Success: true},
+		"derived": {U: 1.5, V: -6, Speed: &speed, Dir: &dir, Status: http.StatusOK, Success: true},
+		"scalar":  {U: NullFloat(math.NaN()), V: NullFloat(math.NaN()), Param: "2t", Value: &value, Status: http.StatusOK, Success: true},
+		"missing": {U: NullFloat(math.NaN()), V: NullFloat(math.Inf(1)), Param: "2t", Value: &missing, Status: http.StatusOK, Success: true},
+		"fields": {U: NullFloat(math.NaN()), V: NullFloat(math.NaN()), Status: http.StatusOK, Success: true,
+			Fields: map[string]NullFloat{"2t": 250, "10v": NullFloat(math.NaN()), "10u": -1e-7, "msl": 101325}},
+		"escaped": {Param: `<a&b> "é"`, Value: &value, Status: http.StatusOK, Success: true},
+		"failed":  singleFailResponse,
+	}
+}
+
+func TestSingleResponseAppendJSON(t *testing.T) {
+	for name, response := range singleResponses() {
+		want, err := json.Marshal(response)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want = append(want, '\n')
+		if got := response.appendJSON(nil); !bytes.Equal(got, want) {
+			t.Errorf("%s:\n got %s\nwant %s", name, got, want)
+		}
+	}
+}
+
+// discardWriter is a ResponseWriter that allocates nothing.
+type discardWriter struct{ header http.Header }
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w discardWriter) WriteHeader(int)             {}
+
+func TestWriteSingleResponseAllocs(t *testing.T) {
+	w := discardWriter{header: make(http.Header)}
+	buf := make([]byte, 0, 512)
+	for name, response := range singleResponses() {
+		if name == "escaped" {
+			continue // falls back to json.Marshal
+		}
+		if allocs := testing.AllocsPerRun(100, func() { response.appendJSON(buf[:0]) }); allocs != 0 {
+			t.Errorf("%s: appendJSON makes %v allocations, want 0", name, allocs)
+		}
+		writeSingleResponse(w, http.StatusOK, response) // warm the pool
+		// the one left is the Content-Type header value
+		if allocs := testing.AllocsPerRun(100, func() { writeSingleResponse(w, http.StatusOK, response) }); allocs != 1 {
+			t.Errorf("%s: %v allocations per response, want 1", name, allocs)
+		}
+	}
+}
+
+func BenchmarkWriteSingleResponse(b *testing.B) {
+	w := discardWriter{header: make(http.Header)}
+	response := singleResponses()["derived"]
+	b.Run("appendJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			writeSingleResponse(w, http.StatusOK, response)
+		}
+	})
+	b.Run("json.Encoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(response)
+		}
+	})
+}
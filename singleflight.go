@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// downloads coalesces concurrent downloads of the same parameters of a batch
+// step: the first caller starts it, later ones wait for its result instead
+// of downloading again and racing on the same files.
+var downloads = &flightGroup{}
+
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// Do runs fn once for all concurrent callers with the same key and reports
+// whether the caller shared another's run. fn runs with a context that is not
+// cancelled with the caller's, as other callers may still be waiting on it,
+// only on shutdown; a cancelled caller stops waiting and gets its context's
+// error.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) error) (bool, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f, shared := g.flights[key]
+	if !shared {
+		f = &flight{done: make(chan struct{})}
+		g.flights[key] = f
+		detached, done := detach(ctx)
+		go func() {
+			defer done()
+			f.err = fn(detached)
+			g.mu.Lock()
+			delete(g.flights, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return shared, f.err
+	case <-ctx.Done():
+		return shared, ctx.Err()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	SmoothGaussian = "gaussian"
+	SmoothBox      = "box"
+
+	maxSmooth = 5.0 // degrees
+)
+
+// parseSmooth parses a smoothing scale such as "1deg", "0.5deg" or "1" (degrees).
+func parseSmooth(str string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(str), "deg"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid smooth %q: %w", str, err)
+	}
+	if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid smooth %q", str)
+	}
+	if value > maxSmooth {
+		return 0, fmt.Errorf("smooth %q exceeds limit of %gdeg", str, maxSmooth)
+	}
+	return value, nil
+}
+
+// smoothKernel returns the 1D weights of a kernel with the given scale in
+// lattice cells: the half-width of a box, or the standard deviation of a
+// gaussian truncated at 3 sigma.
+func smoothKernel(kernel string, scale float64) []float64 {
+	var weights []float64
+	switch kernel {
+	case SmoothBox:
+		half := int(math.Round(scale))
+		for k := -half; k <= half; k++ {
+			weights = append(weights, 1)
+		}
+	default:
+		half := int(math.Ceil(3 * scale))
+		for k := -half; k <= half; k++ {
+			weights = append(weights, math.Exp(-float64(k*k)/(2*scale*scale)))
+		}
+	}
+	return weights
+}
+
+// smoothLattice smooths values in place. values[n] sits on cell cells[n] of a
+// row-major rows×cols lattice, lattice cells without a value and NaN values
+// are excluded from the kernel rather than counted as zero. scale is in
+// lattice cells.
+func smoothLattice(values []float64, cells []int, rows, cols int, scale float64, kernel string) {
+	weights := smoothKernel(kernel, scale)
+	half := len(weights) / 2
+	if half == 0 {
+		return
+	}
+	// taps past the lattice edge never land on a cell
+	if reach := max(rows, cols) - 1; half > reach {
+		weights = weights[half-reach : half+reach+1]
+		half = reach
+	}
+
+	sum := make([]float64, rows*cols)
+	mask := make([]float64, rows*cols)
+	for n, cell := range cells {
+		if !math.IsNaN(values[n]) {
+			sum[cell] = values[n]
+			mask[cell] = 1
+		}
+	}
+
+	// normalized convolution: convolve value*mask and mask, then divide
+	convolve := func(field []float64, alongRows bool) []float64 {
+		out := make([]float64, len(field))
+		for r := 0; r < rows; r++ {
+			for c := 0; c < cols; c++ {
+				acc := 0.0
+				for k, w := range weights {
+					rr, cc := r, c+k-half
+					if !alongRows {
+						rr, cc = r+k-half, c
+					}
+					if rr < 0 || rr >= rows || cc < 0 || cc >= cols {
+						continue
+					}
+					acc += w * field[rr*cols+cc]
+				}
+				out[r*cols+c] = acc
+			}
+		}
+		return out
+	}
+	sum = convolve(convolve(sum, true), false)
+	mask = convolve(convolve(mask, true), false)
+
+	for n, cell := range cells {
+		if !math.IsNaN(values[n]) && mask[cell] > 0 {
+			values[n] = sum[cell] / mask[cell]
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// dataSource is where the downloader reads index files and GRIB chunks from:
+// the public ECMWF bucket, or a fixture directory for hermetic integration
+// tests (-fixtures). Both report unpublished data as ErrDataNotPublished.
+type dataSource interface {
+	// Index returns the .index file of a batch's forecast step.
+	Index(ctx context.Context, date, batch, stream string, step int) (string, error)
+	// Published reports whether the step's index exists, without reading it.
+	Published(ctx context.Context, date, batch, stream string, step int) bool
+	// OpenObject opens a GRIB file for range reads.
+	OpenObject(ctx context.Context, bucket, object string) (objectReader, error)
+}
+
+type objectReader interface {
+	NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+	Close() error
+}
+
+var source dataSource = gcsSource{}
+
+// gcsSource reads from Google Cloud Storage.
+type gcsSource struct{}
+
+func (gcsSource) Index(ctx context.Context, date, batch, stream string, step int) (string, error) {
+	return queryIndex(ctx, indexURL(date, batch, stream, step))
+}
+
+func (gcsSource) Published(ctx context.Context, date, batch, stream string, step int) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, indexURL(date, batch, stream, step), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// gcsNoAuth skips the GCS client and reads objects with anonymous HTTP range
+// requests, which the public bucket allows. -no-gcs-auth sets it, and it is
+// used anyway when the client cannot be created, e.g. without credentials.
+var (
+	gcsNoAuth        = false
+	warnGCSAnonymous sync.Once
+)
+
+func (gcsSource) OpenObject(ctx context.Context, bucket, object string) (objectReader, error) {
+	if gcsNoAuth {
+		return httpObject{url: makeUrl("storage.googleapis.com", "/"+bucket+"/"+object)}, nil
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		warnGCSAnonymous.Do(func() {
+			log.Printf("Fail to init GCS client, reading anonymously over HTTP instead: %v", err)
+		})
+		return httpObject{url: makeUrl("storage.googleapis.com", "/"+bucket+"/"+object)}, nil
+	}
+	return &gcsObject{client: client, handle: client.Bucket(bucket).Object(object)}, nil
+}
+
+type gcsObject struct {
+	client *storage.Client
+	handle *storage.ObjectHandle
+}
+
+func (o *gcsObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	reader, err := o.handle.NewRangeReader(ctx, offset, length)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrDataNotPublished, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+	}
+	return reader, nil
+}
+
+func (o *gcsObject) Close() error {
+	return o.client.Close()
+}
+
+// httpObject reads a public object with HTTP range requests.
+type httpObject struct {
+	url string
+}
+
+func (o httpObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrDataNotPublished, o.url)
+	default:
+		// a 200 would be the whole object, the range was not honoured
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: range request to %s answered %s", ErrUpstreamUnavailable, o.url, resp.Status)
+	}
+}
+
+func (o httpObject) Close() error { return nil }
+
+// fixtureSource reads files laid out like the bucket below dir, e.g.
+// dir/20250101/00z/ifs/0p25/oper/20250101000000-0h-oper-fc.index and the
+// .grib2 next to it. The bucket name is not part of the path.
+type fixtureSource struct {
+	dir string
+}
+
+func (s fixtureSource) path(date, batch string, step int, suffix, stream string) string {
+	return filepath.Join(s.dir, makeRelative(date, batch, step, suffix, stream))
+}
+
+func (s fixtureSource) Index(ctx context.Context, date, batch, stream string, step int) (string, error) {
+	path := s.path(date, batch, step, ".index", stream)
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("index %s: %w", path, ErrDataNotPublished)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func (s fixtureSource) Published(ctx context.Context, date, batch, stream string, step int) bool {
+	_, err := os.Stat(s.path(date, batch, step, ".index", stream))
+	return err == nil
+}
+
+func (s fixtureSource) OpenObject(ctx context.Context, bucket, object string) (objectReader, error) {
+	file, err := os.Open(filepath.Join(s.dir, object))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrDataNotPublished, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fixtureObject{file}, nil
+}
+
+type fixtureObject struct {
+	file *os.File
+}
+
+func (o fixtureObject) NewRangeReader(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(o.file, offset, length)), nil
+}
+
+func (o fixtureObject) Close() error {
+	return o.file.Close()
+}
+
+// useFixtures switches the downloader to the fixture directory.
+func useFixtures(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	source = fixtureSource{dir: dir}
+	log.Printf("Reading GRIB data from fixtures in %s", dir)
+	return nil
+}
+
+// rng drives every random choice of the server (verification samples,
+// shadowed requests), so -seed makes runs reproducible.
+var rng = newLockedRand(time.Now().UnixNano())
+
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
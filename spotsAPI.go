@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Saved spots are named in the -spots file and rated for surf or kiting on
+// /spots/{name}/conditions from the wind alone:
+//
+//	{
+//	  "bondi":    {"lat": -33.89, "lon": 151.28, "facing": 110, "activity": "surf"},
+//	  "tarifa":   {"lat": 36.01, "lon": -5.61, "facing": 200, "activity": "kite"}
+//	}
+//
+// facing is the direction the beach looks out to sea, in degrees. The wind
+// direction relative to it says whether the wind is onshore, cross-shore or
+// offshore, which with its speed band gives a rating from 0 (no go) to 5.
+// Surf ratings only judge how the wind grooms or spoils the waves, swell is
+// not ingested.
+
+const (
+	maxSpotDays  = 31
+	activitySurf = "surf"
+	activityKite = "kite"
+)
+
+var spotRatingLabels = []string{"no go", "poor", "fair", "good", "very good", "epic"}
+
+type Spot struct {
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Facing   float64 `json:"facing"` // degrees the beach faces
+	Activity string  `json:"activity"`
+}
+
+// spots is nil without a -spots file.
+var spots map[string]Spot
+
+func loadSpots(path string) (map[string]Spot, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defined map[string]Spot
+	if err := json.Unmarshal(content, &defined); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, spot := range defined {
+		if spot.Activity != activitySurf && spot.Activity != activityKite {
+			return nil, fmt.Errorf("%s: spot %q: activity must be %s or %s", path, name, activitySurf, activityKite)
+		}
+		if spot.Lat < -90 || spot.Lat > 90 || spot.Facing < 0 || spot.Facing >= 360 {
+			return nil, fmt.Errorf("%s: spot %q: lat or facing out of range", path, name)
+		}
+	}
+	return defined, nil
+}
+
+type SpotSample struct {
+	Time      time.Time `json:"time"`
+	Speed     float64   `json:"speed"`     // m/s
+	Direction float64   `json:"direction"` // degrees the wind blows from
+	Relative  string    `json:"relative"`  // onshore, cross-onshore, cross-shore, cross-offshore or offshore
+	Rating    int       `json:"rating"`    // 0-5
+	Label     string    `json:"label"`
+}
+
+type SpotDay struct {
+	Date     string    `json:"date"`
+	Mean     float64   `json:"mean"` // mean rating
+	Best     int       `json:"best"`
+	BestTime time.Time `json:"best_time"`
+}
+
+type SpotConditionsResponse struct {
+	Spot     string       `json:"spot"`
+	Lat      float64      `json:"lat"`
+	Lon      float64      `json:"lon"`
+	Facing   float64      `json:"facing"`
+	Activity string       `json:"activity"`
+	Samples  []SpotSample `json:"samples"`
+	Days     []SpotDay    `json:"days"`
+	Missing  int          `json:"missing"` // date/batch pairs without data
+	Status   int          `json:"status"`
+	Success  bool         `json:"success"`
+}
+
+var spotConditionsFailResponse = SpotConditionsResponse{
+	Samples: []SpotSample{},
+	Days:    []SpotDay{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendSpotConditionsJsonError(w http.ResponseWriter, statusCode int) {
+	response := spotConditionsFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// spotsHandler serves GET /spots, the saved spots.
+func spotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"spots":   spots,
+		"status":  http.StatusOK,
+		"success": true,
+	})
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// spotConditionsHandler serves GET /spots/{name}/conditions?start=&end=&batch=
+func spotConditionsHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+	start, end := httpQuery.Get("start"), httpQuery.Get("end")
+	if end == "" {
+		end = start
+	}
+	batches, err := parseBatches(httpQuery.Get("batch"))
+	if err != nil {
+		sendSpotConditionsJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", start+"-"+end)
+	setLogField(r.Context(), "spot", r.PathValue("name"))
+	data, err2 := SpotConditionsQuery(r.Context(), r.PathValue("name"), start, end, batches)
+	if err2 != nil {
+		sendSpotConditionsJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func SpotConditionsQuery(ctx context.Context, name, startDate, endDate string, batches []string) (SpotConditionsResponse, error) {
+	spot, ok := spots[name]
+	if !ok {
+		return spotConditionsFailResponse, fmt.Errorf("%w: %q", ErrSpotNotFound, name)
+	}
+	dates, err := generateDateRange(startDate, endDate)
+	if err != nil {
+		return spotConditionsFailResponse, err
+	}
+	if len(dates) > maxSpotDays {
+		return spotConditionsFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxSpotDays)
+	}
+	series, missing, err := pointSeries(ctx, spot.Lat, spot.Lon, dates, batches)
+	if err != nil {
+		return spotConditionsFailResponse, err
+	}
+	if len(series) == 0 {
+		return spotConditionsFailResponse, fmt.Errorf("%w: no data between %s and %s", ErrDataNotPublished, startDate, endDate)
+	}
+
+	samples := make([]SpotSample, len(series))
+	for i, s := range series {
+		t, _ := time.Parse("2006010215", s.Date+s.Batch[:2])
+		speed, dir := math.Hypot(s.U, s.V), windDirection(s.U, s.V)
+		relative := relativeWind(dir, spot.Facing)
+		rating := rateSpot(spot.Activity, speed, relative)
+		samples[i] = SpotSample{
+			Time:      t,
+			Speed:     speed,
+			Direction: dir,
+			Relative:  relative,
+			Rating:    rating,
+			Label:     spotRatingLabels[rating],
+		}
+	}
+
+	// pointSeries returns date order, so each day is one run of samples
+	days := []SpotDay{}
+	total, count := 0, 0
+	for _, sample := range samples {
+		date := sample.Time.Format("20060102")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, SpotDay{Date: date, Best: -1})
+			total, count = 0, 0
+		}
+		day := &days[len(days)-1]
+		total += sample.Rating
+		count++
+		day.Mean = float64(total) / float64(count)
+		if sample.Rating > day.Best {
+			day.Best, day.BestTime = sample.Rating, sample.Time
+		}
+	}
+
+	return SpotConditionsResponse{
+		Spot:     name,
+		Lat:      spot.Lat,
+		Lon:      spot.Lon,
+		Facing:   spot.Facing,
+		Activity: spot.Activity,
+		Samples:  samples,
+		Days:     days,
+		Missing:  missing,
+		Status:   http.StatusOK,
+		Success:  true,
+	}, nil
+}
+
+// relativeWind classifies a wind blowing from dir against a beach facing
+// facing: onshore blows from the sea straight in, offshore from the land out.
+func relativeWind(dir, facing float64) string {
+	angle := math.Abs(math.Mod(dir-facing+540, 360) - 180) // 0 is straight onshore
+	switch {
+	case angle <= 45:
+		return "onshore"
+	case angle < 80:
+		return "cross-onshore"
+	case angle <= 100:
+		return "cross-shore"
+	case angle < 135:
+		return "cross-offshore"
+	default:
+		return "offshore"
+	}
+}
+
+// rateSpot rates a wind of speed m/s for an activity, 0 to 5.
+func rateSpot(activity string, speed float64, relative string) int {
+	if activity == activityKite {
+		// offshore wind carries a kiter out to sea whatever its speed
+		if relative == "offshore" || relative == "cross-offshore" {
+			return 0
+		}
+		var rating int
+		switch {
+		case speed < 5, speed >= 20:
+			return 0
+		case speed < 7:
+			rating = 2
+		case speed < 12:
+			rating = 4
+		case speed < 16:
+			rating = 3
+		default:
+			rating = 1
+		}
+		switch relative {
+		case "cross-shore", "cross-onshore":
+			rating++
+		case "onshore":
+			rating--
+		}
+		return max(0, min(rating, 5))
+	}
+
+	// surf: light or offshore wind grooms the waves, onshore wind chops them up
+	switch relative {
+	case "offshore", "cross-offshore":
+		switch {
+		case speed < 3:
+			return 5
+		case speed < 8:
+			return 4
+		case speed < 12:
+			return 3
+		default:
+			return 2
+		}
+	case "cross-shore":
+		switch {
+		case speed < 3:
+			return 4
+		case speed < 6:
+			return 3
+		case speed < 10:
+			return 2
+		default:
+			return 1
+		}
+	default:
+		switch {
+		case speed < 3:
+			return 4
+		case speed < 5:
+			return 2
+		case speed < 8:
+			return 1
+		default:
+			return 0
+		}
+	}
+}
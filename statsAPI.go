@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// Statistics of a field over a bbox, from the grid points inside it. Points
+// of a lat-lon grid crowd together towards the poles, so every statistic is
+// weighted by the area a point stands for, cos(lat).
+
+var areaPercentiles = []float64{10, 25, 50, 75, 90, 95, 99}
+
+type StatsAPIParams struct {
+	SLat  float64 `json:"slat"`
+	SLon  float64 `json:"slon"`
+	ELat  float64 `json:"elat"`
+	ELon  float64 `json:"elon"`
+	Date  string  `json:"date"`
+	Batch string  `json:"batch"`
+	Step  int     `json:"step"`
+	Param string  `json:"param"` // wind (its speed) or a scalar param
+}
+
+type AreaLocation struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Value float64 `json:"value"`
+}
+
+type StatsResponse struct {
+	Param       string             `json:"param"`
+	Unit        string             `json:"unit"`
+	Min         AreaLocation       `json:"min"`
+	Max         AreaLocation       `json:"max"`
+	Mean        float64            `json:"mean"`
+	Std         float64            `json:"std"`
+	Percentiles map[string]float64 `json:"percentiles"` // keyed p10, p50, ...
+	Points      int                `json:"points"`      // grid points in the bbox with data
+	Missing     int                `json:"missing"`     // grid points in the bbox without
+	Status      int                `json:"status"`
+	Success     bool               `json:"success"`
+}
+
+var statsFailResponse = StatsResponse{
+	Percentiles: map[string]float64{},
+	Status:      http.StatusBadRequest,
+	Success:     false,
+}
+
+func sendStatsJsonError(w http.ResponseWriter, statusCode int) {
+	response := statsFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// statsHandler serves /stats?slat=&slon=&elat=&elon=&date=&batch=&step=&param=
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := StatsAPIParams{
+		Date:  httpQuery.Get("date"),
+		Batch: httpQuery.Get("batch"),
+	}
+	for name, dst := range map[string]*float64{"slat": &params.SLat, "slon": &params.SLon, "elat": &params.ELat, "elon": &params.ELon} {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendStatsJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	var err error
+	if params.Step, err = parseStep(httpQuery.Get("step"), params.Batch); err != nil {
+		sendStatsJsonError(w, http.StatusBadRequest)
+		return
+	}
+	if params.Param, err = parseQueryParam(httpQuery.Get("param")); err != nil {
+		sendStatsJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", params.Date)
+	setLogField(r.Context(), "batch", params.Batch)
+	data, err2 := StatsQuery(r.Context(), params)
+	if err2 != nil {
+		sendStatsJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func StatsQuery(ctx context.Context, params StatsAPIParams) (StatsResponse, error) {
+	north, south := math.Max(params.SLat, params.ELat), math.Min(params.SLat, params.ELat)
+	if north > 90 || south < -90 {
+		return statsFailResponse, fmt.Errorf("%w: latitude out of range [-90, 90]", ErrOutOfGrid)
+	}
+	span := params.ELon - params.SLon
+	if span <= 0 {
+		span += 360 // across the antimeridian
+	}
+	if err := validateDateBatch(params.Date, params.Batch); err != nil {
+		return statsFailResponse, err
+	}
+	filePath := filepath.Join(tmpDir, params.Date+"-"+params.Batch+".json")
+	names := selectedParams(params.Param, nil)
+	grid, fields, err := loadParamFields(ctx, filePath, params.Date, params.Batch, params.Step, names)
+	if err != nil {
+		return statsFailResponse, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+
+	type point struct {
+		index         int
+		value, weight float64
+	}
+	var points []point
+	missing := 0
+	for index := range grid.Size() {
+		lat, lon := grid.Coord(index)
+		if lat < south || lat > north || math.Mod(lon-params.SLon+720, 360) > span {
+			continue
+		}
+		value := fields[0][index]
+		if len(fields) == 2 {
+			value = math.Hypot(fields[0][index], fields[1][index])
+		}
+		if math.IsNaN(value) {
+			missing++
+			continue
+		}
+		points = append(points, point{index, value, math.Max(math.Cos(lat*math.Pi/180), 1e-6)})
+	}
+	if len(points) == 0 {
+		return statsFailResponse, fmt.Errorf("%w: no grid point with data in the bbox", ErrOutOfGrid)
+	}
+	addLogCount(ctx, "points", int64(len(points)))
+
+	slices.SortFunc(points, func(a, b point) int { return cmp.Compare(a.value, b.value) })
+	var sum, weights float64
+	for _, p := range points {
+		sum += p.weight * p.value
+		weights += p.weight
+	}
+	mean := sum / weights
+	var sq float64
+	for _, p := range points {
+		sq += p.weight * (p.value - mean) * (p.value - mean)
+	}
+
+	// weighted percentiles: the value where the cumulative weight, counted
+	// to the middle of each point, reaches p
+	percentiles := make(map[string]float64, len(areaPercentiles))
+	cumulative := make([]float64, len(points))
+	running := 0.0
+	for i, p := range points {
+		cumulative[i] = (running + p.weight/2) / weights
+		running += p.weight
+	}
+	for _, p := range areaPercentiles {
+		target := p / 100
+		i, _ := slices.BinarySearch(cumulative, target)
+		var value float64
+		switch {
+		case i == 0:
+			value = points[0].value
+		case i == len(points):
+			value = points[len(points)-1].value
+		default:
+			f := (target - cumulative[i-1]) / (cumulative[i] - cumulative[i-1])
+			value = points[i-1].value + f*(points[i].value-points[i-1].value)
+		}
+		percentiles["p"+strconv.FormatFloat(p, 'f', -1, 64)] = value
+	}
+
+	location := func(p point) AreaLocation {
+		lat, lon := grid.Coord(p.index)
+		return AreaLocation{Lat: lat, Lon: lon, Value: p.value}
+	}
+	unit := "m/s"
+	if params.Param != paramWind {
+		unit = paramRegistry[params.Param].Unit
+	}
+	return StatsResponse{
+		Param:       params.Param,
+		Unit:        unit,
+		Min:         location(points[0]),
+		Max:         location(points[len(points)-1]),
+		Mean:        mean,
+		Std:         math.Sqrt(sq / weights),
+		Percentiles: percentiles,
+		Points:      len(points),
+		Missing:     missing,
+		Status:      http.StatusOK,
+		Success:     true,
+	}, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// /steps lists, for one date/batch, the forecast steps published upstream
+// and the parameters (as named in paramRegistry) each step's .index file
+// holds, so clients can fill step pickers with what exists instead of
+// guessing from maxStep. Every step's index is read once: a batch whose
+// steps are all published never changes and is cached for good, one still
+// being published is read again after unpublishedTTL.
+
+const (
+	stepIndexWorkers = 8
+	maxStepsCached   = 64
+)
+
+type StepParams struct {
+	Step   int      `json:"step"`
+	Params []string `json:"params"`
+}
+
+type StepsResponse struct {
+	Date     string       `json:"date"`
+	Batch    string       `json:"batch"`
+	Stream   string       `json:"stream"`
+	Steps    []StepParams `json:"steps"`    // published steps in order
+	Params   []string     `json:"params"`   // every parameter of any step
+	Missing  []int        `json:"missing"`  // steps not published (yet)
+	Complete bool         `json:"complete"` // every step is published
+	Status   int          `json:"status"`
+	Success  bool         `json:"success"`
+}
+
+var stepsFailResponse = StepsResponse{
+	Steps:   []StepParams{},
+	Params:  []string{},
+	Missing: []int{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendStepsJsonError(w http.ResponseWriter, statusCode int) {
+	response := stepsFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// stepsHandler serves /steps?date=&batch=
+func stepsHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+	date, batch := httpQuery.Get("date"), httpQuery.Get("batch")
+
+	setLogField(r.Context(), "date", date+"-"+batch)
+	data, err := StepsQuery(r.Context(), date, batch)
+	if err != nil {
+		sendStepsJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+type stepsCacheEntry struct {
+	response StepsResponse
+	expires  time.Time // zero for complete batches
+	added    time.Time
+}
+
+var (
+	stepsMutex     sync.Mutex
+	stepsCache     = make(map[string]stepsCacheEntry)
+	stepsDiscovery = &flightGroup{}
+)
+
+func StepsQuery(ctx context.Context, date, batch string) (StepsResponse, error) {
+	if err := validateDateBatch(date, batch); err != nil {
+		return stepsFailResponse, err
+	}
+	key := date + "|" + batch
+	if response, ok := cachedSteps(key); ok {
+		return response, nil
+	}
+	_, err := stepsDiscovery.Do(ctx, key, func(ctx context.Context) error {
+		response, err := discoverSteps(ctx, date, batch)
+		if err != nil {
+			return err
+		}
+		entry := stepsCacheEntry{response: response, added: clock.Now()}
+		if !response.Complete {
+			entry.expires = entry.added.Add(unpublishedTTL)
+		}
+		stepsMutex.Lock()
+		defer stepsMutex.Unlock()
+		if len(stepsCache) >= maxStepsCached {
+			oldest := ""
+			for k, e := range stepsCache {
+				if oldest == "" || e.added.Before(stepsCache[oldest].added) {
+					oldest = k
+				}
+			}
+			delete(stepsCache, oldest)
+		}
+		stepsCache[key] = entry
+		return nil
+	})
+	if err != nil {
+		return stepsFailResponse, err
+	}
+	if response, ok := cachedSteps(key); ok {
+		return response, nil
+	}
+	return stepsFailResponse, fmt.Errorf("steps of %s-%s were not cached", date, batch)
+}
+
+func cachedSteps(key string) (StepsResponse, bool) {
+	stepsMutex.Lock()
+	defer stepsMutex.Unlock()
+	entry, ok := stepsCache[key]
+	if !ok || !entry.expires.IsZero() && clock.Now().After(entry.expires) {
+		return StepsResponse{}, false
+	}
+	return entry.response, true
+}
+
+// discoverSteps reads the index of every step of the batch with a pool of
+// stepIndexWorkers.
+func discoverSteps(ctx context.Context, date, batch string) (StepsResponse, error) {
+	stream, err := streamForBatch(batch)
+	if err != nil {
+		return stepsFailResponse, err
+	}
+	var steps []int
+	for step := 0; step <= maxStep(batch); step += stepInterval {
+		steps = append(steps, step)
+	}
+
+	found := make([][]string, len(steps)) // nil for unpublished steps
+	errs := make([]error, len(steps))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range stepIndexWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				index, err := source.Index(ctx, date, batch, stream, steps[i])
+				if errors.Is(err, ErrDataNotPublished) {
+					continue
+				}
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				chunks, err := parseIndexResponse(index, slices.Collect(maps.Keys(paramRegistry)))
+				if err != nil {
+					errs[i] = fmt.Errorf("step %s: %w", stepName(steps[i]), err)
+					continue
+				}
+				params := []string{}
+				for _, chunk := range chunks {
+					if !slices.Contains(params, chunk.ParamName) {
+						params = append(params, chunk.ParamName)
+					}
+				}
+				slices.Sort(params)
+				found[i] = params
+			}
+		}()
+	}
+	for i := range steps {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if err := errors.Join(append(errs, ctx.Err())...); err != nil {
+		return stepsFailResponse, err
+	}
+
+	response := StepsResponse{
+		Date:    date,
+		Batch:   batch,
+		Stream:  stream,
+		Steps:   []StepParams{},
+		Params:  []string{},
+		Missing: []int{},
+		Status:  http.StatusOK,
+		Success: true,
+	}
+	for i, params := range found {
+		if params == nil {
+			response.Missing = append(response.Missing, steps[i])
+			continue
+		}
+		response.Steps = append(response.Steps, StepParams{Step: steps[i], Params: params})
+		for _, param := range params {
+			if !slices.Contains(response.Params, param) {
+				response.Params = append(response.Params, param)
+			}
+		}
+	}
+	if len(response.Steps) == 0 {
+		return stepsFailResponse, fmt.Errorf("%w: no step of %s-%s", ErrDataNotPublished, date, batch)
+	}
+	slices.Sort(response.Params)
+	response.Complete = len(response.Missing) == 0
+	return response, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const earthRadiusKm = 6371.0
+
+// haversineKm is the great-circle distance between two points in km.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := phi2 - phi1
+	dLambda := normalizeLon(lon2-lon1) * math.Pi / 180
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// greatCirclePoint returns the point a fraction f of the way along the great
+// circle from (lat1, lon1) to (lat2, lon2).
+func greatCirclePoint(lat1, lon1, lat2, lon2, f float64) (float64, float64) {
+	phi1, lambda1 := lat1*math.Pi/180, lon1*math.Pi/180
+	phi2, lambda2 := lat2*math.Pi/180, lon2*math.Pi/180
+	delta := haversineKm(lat1, lon1, lat2, lon2) / earthRadiusKm
+	if delta == 0 {
+		return lat1, lon1
+	}
+	a := math.Sin((1-f)*delta) / math.Sin(delta)
+	b := math.Sin(f*delta) / math.Sin(delta)
+	x := a*math.Cos(phi1)*math.Cos(lambda1) + b*math.Cos(phi2)*math.Cos(lambda2)
+	y := a*math.Cos(phi1)*math.Sin(lambda1) + b*math.Cos(phi2)*math.Sin(lambda2)
+	z := a*math.Sin(phi1) + b*math.Sin(phi2)
+	return math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi, math.Atan2(y, x) * 180 / math.Pi
+}
+
+// initialBearing is the direction in degrees true to head from (lat1, lon1)
+// along the great circle to (lat2, lon2).
+func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// destinationPoint is the point km away from (lat, lon) heading bearing
+// degrees true along a great circle.
+func destinationPoint(lat, lon, bearing, km float64) (float64, float64) {
+	phi1, lambda1 := lat*math.Pi/180, lon*math.Pi/180
+	theta, delta := bearing*math.Pi/180, km/earthRadiusKm
+	phi2 := math.Asin(math.Sin(phi1)*math.Cos(delta) + math.Cos(phi1)*math.Sin(delta)*math.Cos(theta))
+	lambda2 := lambda1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(phi1), math.Cos(delta)-math.Sin(phi1)*math.Sin(phi2))
+	return phi2 * 180 / math.Pi, normalizeLon(lambda2 * 180 / math.Pi)
+}
+
+// recordLatLon parses the position of an IBTrACS record.
+func recordLatLon(record []string) (float64, float64, bool) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(record[colLat]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(record[colLon]), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// simplifyTrack reduces a track with Douglas-Peucker: points closer than
+// toleranceKm to the line through the points kept around them are dropped.
+// The first and last points are always kept, as are records without a valid
+// position since nothing can be said about them.
+func simplifyTrack(records [][]string, toleranceKm float64) [][]string {
+	if toleranceKm <= 0 || len(records) < 3 {
+		return records
+	}
+
+	lats := make([]float64, 0, len(records))
+	lons := make([]float64, 0, len(records))
+	positioned := make([]int, 0, len(records)) // indices into records
+	keep := make([]bool, len(records))
+	for i, record := range records {
+		lat, lon, ok := recordLatLon(record)
+		if !ok {
+			keep[i] = true
+			continue
+		}
+		lats = append(lats, lat)
+		lons = append(lons, lon)
+		positioned = append(positioned, i)
+	}
+	if len(positioned) > 0 {
+		for _, i := range douglasPeucker(lats, lons, toleranceKm) {
+			keep[positioned[i]] = true
+		}
+	}
+
+	simplified := make([][]string, 0, len(records))
+	for i, record := range records {
+		if keep[i] {
+			simplified = append(simplified, record)
+		}
+	}
+	return simplified
+}
+
+// douglasPeucker returns the indices of the points kept, in order. It uses an
+// explicit stack so very long tracks cannot exhaust the goroutine stack.
+func douglasPeucker(lats, lons []float64, toleranceKm float64) []int {
+	n := len(lats)
+	if n < 3 {
+		indices := make([]int, n)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices
+	}
+
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	stack := [][2]int{{0, n - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		maxDist, maxIndex := 0.0, -1
+		for i := first + 1; i < last; i++ {
+			d := crossTrackKm(lats[i], lons[i], lats[first], lons[first], lats[last], lons[last])
+			if d > maxDist {
+				maxDist, maxIndex = d, i
+			}
+		}
+		if maxIndex >= 0 && maxDist > toleranceKm {
+			keep[maxIndex] = true
+			stack = append(stack, [2]int{first, maxIndex}, [2]int{maxIndex, last})
+		}
+	}
+
+	indices := make([]int, 0, n)
+	for i, k := range keep {
+		if k {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// crossTrackKm is the distance in km from P to the segment AB, measured in an
+// equirectangular projection centred on A. Track points are a few hundred km
+// apart at most, where the projection error is far below useful tolerances.
+func crossTrackKm(latP, lonP, latA, lonA, latB, lonB float64) float64 {
+	scale := math.Cos(latA*math.Pi/180) * math.Pi / 180 * earthRadiusKm
+	project := func(lat, lon float64) (float64, float64) {
+		return normalizeLon(lon-lonA) * scale, (lat - latA) * math.Pi / 180 * earthRadiusKm
+	}
+	px, py := project(latP, lonP)
+	bx, by := project(latB, lonB)
+
+	lengthSq := bx*bx + by*by
+	if lengthSq == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/lengthSq))
+	return math.Hypot(px-t*bx, py-t*by)
+}
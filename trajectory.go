@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+// Trajectories are integrated through the 10 m wind of successive batch
+// analyses: the wind is interpolated bilinearly in space on each analysis
+// and linearly in time between the two that bracket the current instant.
+
+const batchInterval = 6 * time.Hour
+
+// windField samples the wind at any point and instant, loading the batch
+// analyses it needs on first use and keeping them for the life of the query.
+type windField struct {
+	ctx     context.Context
+	batches map[time.Time]*FileCache
+}
+
+func newWindField(ctx context.Context) *windField {
+	return &windField{ctx: ctx, batches: make(map[time.Time]*FileCache)}
+}
+
+// batch returns the analysis valid at t, which must fall on a batch time.
+func (f *windField) batch(t time.Time) (*FileCache, error) {
+	if cache, ok := f.batches[t]; ok {
+		return cache, nil
+	}
+	date, batch := t.Format("20060102"), fmt.Sprintf("%02dz", t.Hour())
+	filePath := filepath.Join(tmpDir, date+"-"+batch+".json")
+	cache, err := getOrLoadFileCache(f.ctx, filePath, date, batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s %s: %w", date, batch, err)
+	}
+	f.batches[t] = cache
+	return cache, nil
+}
+
+// at returns u and v in m/s at (lat, lon) and t, NaN where the data is missing.
+func (f *windField) at(t time.Time, lat, lon float64) (float64, float64, error) {
+	t = t.UTC()
+	t0 := t.Truncate(batchInterval)
+	before, err := f.batch(t0)
+	if err != nil {
+		return 0, 0, err
+	}
+	u := interpolateBilinear(before.Grid, before.U, lat, lon)
+	v := interpolateBilinear(before.Grid, before.V, lat, lon)
+	w := float64(t.Sub(t0)) / float64(batchInterval)
+	if w == 0 {
+		return u, v, nil
+	}
+
+	after, err := f.batch(t0.Add(batchInterval))
+	if err != nil {
+		return 0, 0, err
+	}
+	u1 := interpolateBilinear(after.Grid, after.U, lat, lon)
+	v1 := interpolateBilinear(after.Grid, after.V, lat, lon)
+	return (1-w)*u + w*u1, (1-w)*v + w*v1, nil
+}
+
+// displace moves (lat, lon) by the wind (u, v) in m/s blowing for dt seconds,
+// negative dt to go back in time. lon is not normalized so paths stay
+// continuous across the antimeridian.
+func displace(lat, lon, u, v, dt float64) (float64, float64) {
+	const metresPerDegree = earthRadiusKm * 1000 * math.Pi / 180
+	lat2 := lat + v*dt/metresPerDegree
+	lon2 := lon + u*dt/(metresPerDegree*math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	// crossing a pole comes back down on the other side
+	if lat2 > 90 {
+		lat2, lon2 = 180-lat2, lon2+180
+	} else if lat2 < -90 {
+		lat2, lon2 = -180-lat2, lon2+180
+	}
+	return lat2, lon2
+}
+
+// TrajectoryPoint is one position of a trajectory.
+type TrajectoryPoint struct {
+	Time time.Time `json:"time"`
+	Lat  float64   `json:"lat"`
+	Lon  float64   `json:"lon"`
+}
+
+// integrateTrajectory follows the air parcel at (lat, lon) from start for
+// duration, backwards when duration is negative, with the classic fourth
+// order Runge-Kutta method and a fixed step. scale multiplies the wind, for
+// perturbed ensemble members. The path stops early where the wind is missing.
+func integrateTrajectory(field *windField, lat, lon float64, start time.Time, duration, step time.Duration, scale float64) ([]TrajectoryPoint, error) {
+	direction := time.Duration(1)
+	if duration < 0 {
+		direction, duration = -1, -duration
+	}
+	steps := int(math.Ceil(float64(duration) / float64(step)))
+	path := make([]TrajectoryPoint, 0, steps+1)
+	path = append(path, TrajectoryPoint{Time: start, Lat: lat, Lon: lon})
+
+	t, elapsed := start, time.Duration(0)
+	for range steps {
+		dt := min(step, duration-elapsed)
+		elapsed += dt
+		next := t.Add(direction * dt)
+		mid := t.Add(direction * dt / 2)
+		seconds := next.Sub(t).Seconds()
+
+		// the slopes at the start, twice at the midpoint and at the end
+		var us, vs [4]float64
+		for k, stage := range []struct {
+			t        time.Time
+			fraction float64 // of the step the stage is displaced by, from the previous slope
+		}{{t, 0}, {mid, 0.5}, {mid, 0.5}, {next, 1}} {
+			slat, slon := lat, lon
+			if k > 0 {
+				slat, slon = displace(lat, lon, scale*us[k-1], scale*vs[k-1], seconds*stage.fraction)
+			}
+			u, v, err := field.at(stage.t, slat, slon)
+			if err != nil {
+				return path, err
+			}
+			if math.IsNaN(u) || math.IsNaN(v) {
+				return path, nil
+			}
+			us[k], vs[k] = u, v
+		}
+		u := (us[0] + 2*us[1] + 2*us[2] + us[3]) / 6
+		v := (vs[0] + 2*vs[1] + 2*vs[2] + vs[3]) / 6
+		lat, lon = displace(lat, lon, scale*u, scale*v, seconds)
+		t = next
+		path = append(path, TrajectoryPoint{Time: t, Lat: lat, Lon: lon})
+	}
+	return path, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A single trajectory through the wind, forward or backward, for drift and
+// plume estimates. See trajectory.go for the integration.
+
+const (
+	maxTrajectoryHours = 240
+	minTrajectoryStep  = 5 * time.Minute
+)
+
+type TrajectoryAPIParams struct {
+	Lat   float64       `json:"lat"`
+	Lon   float64       `json:"lon"`
+	Start time.Time     `json:"start"`
+	Hours float64       `json:"hours"` // negative for a backward trajectory
+	Step  time.Duration `json:"step"`
+}
+
+type TrajectoryResponse struct {
+	Lat       float64           `json:"lat"`
+	Lon       float64           `json:"lon"`
+	Start     time.Time         `json:"start"`
+	Hours     float64           `json:"hours"`
+	Step      int               `json:"step"` // minutes
+	Points    []TrajectoryPoint `json:"points"`
+	Completed bool              `json:"completed"` // false when the path ran out of wind data
+	Distance  float64           `json:"distance"`  // km along the path
+	Status    int               `json:"status"`
+	Success   bool              `json:"success"`
+}
+
+var trajectoryFailResponse = TrajectoryResponse{
+	Points:  []TrajectoryPoint{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendTrajectoryJsonError(w http.ResponseWriter, statusCode int) {
+	response := trajectoryFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// trajectoryHandler serves /trajectory?lat=&lon=&start=&hours=&step=&format=json|geojson,
+// step in minutes.
+func trajectoryHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := TrajectoryAPIParams{Step: trajectoryStep}
+	for name, dst := range map[string]*float64{"lat": &params.Lat, "lon": &params.Lon, "hours": &params.Hours} {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendTrajectoryJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	var err error
+	if params.Start, err = parseAsOf(httpQuery.Get("start")); err != nil {
+		sendTrajectoryJsonError(w, http.StatusBadRequest)
+		return
+	}
+	if s := httpQuery.Get("step"); s != "" {
+		minutes, err := strconv.Atoi(s)
+		if err != nil {
+			sendTrajectoryJsonError(w, http.StatusBadRequest)
+			return
+		}
+		params.Step = time.Duration(minutes) * time.Minute
+	}
+	format, err := parseFormat(r, formatGeoJSON)
+	if err != nil || format == formatCSV || format == formatNDJSON {
+		sendTrajectoryJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	setLogField(r.Context(), "date", params.Start.Format("2006010215"))
+	data, err2 := TrajectoryQuery(r.Context(), params)
+	if err2 != nil {
+		sendTrajectoryJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	if format == formatGeoJSON {
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(trajectoryGeoJSON(data)); err != nil {
+			log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func TrajectoryQuery(ctx context.Context, params TrajectoryAPIParams) (TrajectoryResponse, error) {
+	for _, value := range []float64{params.Lat, params.Lon, params.Hours} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return trajectoryFailResponse, fmt.Errorf("%w: lat, lon and hours must be finite", ErrInvalidParams)
+		}
+	}
+	switch {
+	case params.Lat < -90 || params.Lat > 90:
+		return trajectoryFailResponse, fmt.Errorf("%w: latitude out of range [-90, 90]", ErrOutOfGrid)
+	case params.Hours == 0 || params.Hours > maxTrajectoryHours || params.Hours < -maxTrajectoryHours:
+		return trajectoryFailResponse, fmt.Errorf("%w: hours must be between -%d and %d and not 0", ErrInvalidParams, maxTrajectoryHours, maxTrajectoryHours)
+	case params.Step < minTrajectoryStep || params.Step > batchInterval:
+		return trajectoryFailResponse, fmt.Errorf("%w: step must be between %v and %v", ErrInvalidParams, minTrajectoryStep, batchInterval)
+	}
+
+	duration := time.Duration(params.Hours * float64(time.Hour))
+	path, err := integrateTrajectory(newWindField(ctx), params.Lat, params.Lon, params.Start, duration, params.Step, 1)
+	if err != nil {
+		return trajectoryFailResponse, err
+	}
+	distance := 0.0
+	for i := 1; i < len(path); i++ {
+		distance += haversineKm(path[i-1].Lat, path[i-1].Lon, path[i].Lat, path[i].Lon)
+	}
+	for i := range path {
+		path[i].Lon = normalizeLon(path[i].Lon)
+	}
+	addLogCount(ctx, "points", int64(len(path)))
+
+	return TrajectoryResponse{
+		Lat:       params.Lat,
+		Lon:       params.Lon,
+		Start:     params.Start,
+		Hours:     params.Hours,
+		Step:      int(params.Step / time.Minute),
+		Points:    path,
+		Completed: path[len(path)-1].Time.Equal(params.Start.Add(duration)),
+		Distance:  distance,
+		Status:    http.StatusOK,
+		Success:   true,
+	}, nil
+}
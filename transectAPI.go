@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+// The wind along the great circle between two points, for route planning:
+// n evenly spaced samples from start to end, interpolated bilinearly, with
+// the components along the course (tailwind positive) and across it (from
+// the left positive, pushing the vessel to the right).
+
+const maxTransectPoints = 2000
+
+type TransectAPIParams struct {
+	SLat  float64 `json:"slat"`
+	SLon  float64 `json:"slon"`
+	ELat  float64 `json:"elat"`
+	ELon  float64 `json:"elon"`
+	N     int     `json:"n"`
+	Date  string  `json:"date"`
+	Batch string  `json:"batch"`
+	Step  int     `json:"step"`
+}
+
+type TransectResponse struct {
+	Lats      []float64  `json:"lats"`
+	Lons      []float64  `json:"lons"`
+	Distances []float64  `json:"distances"` // km from the start
+	Bearings  []float64  `json:"bearings"`  // course in degrees true at each point
+	U         NullFloats `json:"u"`
+	V         NullFloats `json:"v"`
+	Speed     NullFloats `json:"speed"`
+	Along     NullFloats `json:"along"` // m/s, tailwind positive
+	Cross     NullFloats `json:"cross"` // m/s, wind from the left positive
+	Distance  float64    `json:"distance"`
+	Status    int        `json:"status"`
+	Success   bool       `json:"success"`
+}
+
+var transectFailResponse = TransectResponse{
+	Lats:      []float64{},
+	Lons:      []float64{},
+	Distances: []float64{},
+	Bearings:  []float64{},
+	U:         NullFloats{},
+	V:         NullFloats{},
+	Speed:     NullFloats{},
+	Along:     NullFloats{},
+	Cross:     NullFloats{},
+	Status:    http.StatusBadRequest,
+	Success:   false,
+}
+
+func sendTransectJsonError(w http.ResponseWriter, statusCode int) {
+	response := transectFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// transectHandler serves /transect?slat=&slon=&elat=&elon=&n=&date=&batch=&step=
+func transectHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	params := TransectAPIParams{
+		N:     200,
+		Date:  httpQuery.Get("date"),
+		Batch: httpQuery.Get("batch"),
+	}
+	for name, dst := range map[string]*float64{"slat": &params.SLat, "slon": &params.SLon, "elat": &params.ELat, "elon": &params.ELon} {
+		value, err := strconv.ParseFloat(httpQuery.Get(name), 64)
+		if err != nil {
+			sendTransectJsonError(w, http.StatusBadRequest)
+			return
+		}
+		*dst = value
+	}
+	if s := httpQuery.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			sendTransectJsonError(w, http.StatusBadRequest)
+			return
+		}
+		params.N = n
+	}
+	step, err := parseStep(httpQuery.Get("step"), params.Batch)
+	if err != nil {
+		sendTransectJsonError(w, http.StatusBadRequest)
+		return
+	}
+	params.Step = step
+
+	setLogField(r.Context(), "date", params.Date)
+	setLogField(r.Context(), "batch", params.Batch)
+	data, err2 := TransectQuery(r.Context(), params)
+	if err2 != nil {
+		sendTransectJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func TransectQuery(ctx context.Context, params TransectAPIParams) (TransectResponse, error) {
+	switch {
+	case params.N < 2 || params.N > maxTransectPoints:
+		return transectFailResponse, fmt.Errorf("%w: n must be between 2 and %d", ErrInvalidParams, maxTransectPoints)
+	case math.Abs(params.SLat) > 90 || math.Abs(params.ELat) > 90:
+		return transectFailResponse, fmt.Errorf("%w: latitude out of range [-90, 90]", ErrOutOfGrid)
+	}
+	total := haversineKm(params.SLat, params.SLon, params.ELat, params.ELon)
+	if total > math.Pi*earthRadiusKm-1 {
+		return transectFailResponse, fmt.Errorf("%w: antipodal points have no single great circle", ErrInvalidParams)
+	}
+	if err := validateDateBatch(params.Date, params.Batch); err != nil {
+		return transectFailResponse, err
+	}
+	filePath := filepath.Join(tmpDir, params.Date+"-"+params.Batch+".json")
+	grid, fields, err := loadParamFields(ctx, filePath, params.Date, params.Batch, params.Step, windParams)
+	if err != nil {
+		return transectFailResponse, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+
+	response := TransectResponse{
+		Lats:      make([]float64, params.N),
+		Lons:      make([]float64, params.N),
+		Distances: make([]float64, params.N),
+		Bearings:  make([]float64, params.N),
+		U:         make(NullFloats, params.N),
+		V:         make(NullFloats, params.N),
+		Speed:     make(NullFloats, params.N),
+		Along:     make(NullFloats, params.N),
+		Cross:     make(NullFloats, params.N),
+		Distance:  total,
+		Status:    http.StatusOK,
+		Success:   true,
+	}
+	for k := range params.N {
+		f := float64(k) / float64(params.N-1)
+		lat, lon := greatCirclePoint(params.SLat, params.SLon, params.ELat, params.ELon, f)
+		// the course here is the bearing on to the end, or back from it at the end
+		bearing := initialBearing(lat, lon, params.ELat, params.ELon)
+		if k == params.N-1 {
+			bearing = math.Mod(initialBearing(params.ELat, params.ELon, params.SLat, params.SLon)+180, 360)
+		}
+		u := interpolateBilinear(grid, fields[0], lat, lon)
+		v := interpolateBilinear(grid, fields[1], lat, lon)
+		theta := bearing * math.Pi / 180
+		response.Lats[k], response.Lons[k] = lat, lon
+		response.Distances[k] = f * total
+		response.Bearings[k] = bearing
+		response.U[k], response.V[k] = u, v
+		response.Speed[k] = math.Hypot(u, v)
+		response.Along[k] = u*math.Sin(theta) + v*math.Cos(theta)
+		response.Cross[k] = u*math.Cos(theta) - v*math.Sin(theta)
+	}
+	addLogCount(ctx, "points", int64(params.N))
+	return response, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// getTyphon only touches the storms of the requested day, but still builds a
+// map per record and point per track, simplifies every track when asked to
+// and marshals each point for the string trace. The same few cycles are asked
+// for over and over, so computed responses are kept in a small LRU. Entries
+// expire after typhoonCacheTTL and are dropped as soon as the dataset is
+// reloaded, which bumps typhonDataGeneration.
+
+const (
+	typhoonCacheSize = 256
+	typhoonCacheTTL  = time.Hour
+)
+
+// typhonDataGeneration increments every time the IBTrACS data is replaced.
+var typhonDataGeneration atomic.Uint64
+
+var typhoonCache = newTyphoonLRU(typhoonCacheSize, typhoonCacheTTL)
+
+type typhoonCacheEntry struct {
+	key        string
+	response   TyphonAPIResponse
+	generation uint64
+	expires    time.Time
+}
+
+type typhoonLRU struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List // front is most recently used
+	items    map[string]*list.Element
+}
+
+func newTyphoonLRU(capacity int, ttl time.Duration) *typhoonLRU {
+	return &typhoonLRU{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *typhoonLRU) get(key string) (TyphonAPIResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return TyphonAPIResponse{}, false
+	}
+	entry := element.Value.(*typhoonCacheEntry)
+	if entry.generation != typhonDataGeneration.Load() || clock.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return TyphonAPIResponse{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.response, true
+}
+
+// put caches response, computed from the data of generation.
+func (c *typhoonLRU) put(key string, response TyphonAPIResponse, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &typhoonCacheEntry{
+		key:        key,
+		response:   response,
+		generation: generation,
+		expires:    clock.Now().Add(c.ttl),
+	}
+	if element, ok := c.items[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*typhoonCacheEntry).key)
+	}
+}
+
+func (c *typhoonLRU) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.items)
+}
+
+// cacheKey identifies the response of a query, every field that changes the
+// result must be part of it.
+func (p TyphonAPIParams) cacheKey() string {
+	return p.date + "|" + p.batch + "|" + strconv.FormatFloat(p.simplifyKm, 'g', -1, 64) + "|" + p.lang + "|" + strconv.FormatBool(p.legacy)
+}
+
+// getTyphonCached answers from the LRU when possible. Cached responses are
+// shared between requests and must not be modified.
+func getTyphonCached(params TyphonAPIParams) (TyphonAPIResponse, error) {
+	key := params.cacheKey()
+	if response, ok := typhoonCache.get(key); ok {
+		return response, nil
+	}
+	// read before the query: the data is replaced before the generation is
+	// bumped, so a reload racing the query leaves the entry already stale
+	generation := typhonDataGeneration.Load()
+	response, err := getTyphon(params)
+	if err != nil {
+		return response, err
+	}
+	typhoonCache.put(key, response, generation)
+	return response, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Storm names are reused across seasons and basins, and a storm keeps its
+// SID but may change basin along its track. The canonical storm ID
+// combines season, genesis basin, name and SID so that it is unique and
+// still readable: 2022-SP-TIFFANY-2022008S13148.
+
+func buildStormIDs(records [][]string) map[string]string {
+	ids := make(map[string]string)
+	for _, record := range records {
+		if len(record) < numColumns || record[colSID] == "" {
+			continue
+		}
+		// records of a storm are in time order, the first one is its genesis
+		if _, ok := ids[record[colSID]]; !ok {
+			ids[record[colSID]] = canonicalStormID(record)
+		}
+	}
+	return ids
+}
+
+func canonicalStormID(record []string) string {
+	name := strings.ReplaceAll(strings.TrimSpace(record[colName]), " ", "_")
+	return record[colSeason] + "-" + strings.TrimSpace(record[colBasin]) + "-" + name + "-" + record[colSID]
+}
+
+// stormIDFor returns the canonical storm ID of the storm a record belongs to.
+func stormIDFor(record []string) string {
+	if id, ok := currentTyphonData().stormIDs[record[colSID]]; ok {
+		return id
+	}
+	return canonicalStormID(record)
+}
+
+type ResolveAPIParams struct {
+	Name string `json:"name"`
+	Year int    `json:"year"` // 0 matches every season
+}
+
+type ResolveResponse struct {
+	Name       string         `json:"name"`
+	Year       int            `json:"year,omitempty"`
+	Candidates []StormSummary `json:"candidates"`
+	Ambiguous  bool           `json:"ambiguous"`
+	WindUnit   string         `json:"wind_unit,omitempty"`
+	Status     int            `json:"status"`
+	Success    bool           `json:"success"`
+}
+
+var resolveFailResponse = ResolveResponse{
+	Candidates: []StormSummary{},
+	Status:     http.StatusBadRequest,
+	Success:    false,
+}
+
+func sendResolveJsonError(w http.ResponseWriter, statusCode int) {
+	response := resolveFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// typhoonResolveHandler serves /typhoon/resolve?name=&year=
+func typhoonResolveHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	name := strings.TrimSpace(httpQuery.Get("name"))
+	if name == "" {
+		sendResolveJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	year := 0
+	if yearStr := httpQuery.Get("year"); yearStr != "" {
+		var err error
+		year, err = strconv.Atoi(yearStr)
+		if err != nil {
+			sendResolveJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	params := ResolveAPIParams{
+		Name: strings.ToUpper(name),
+		Year: year,
+	}
+
+	data, err := ResolveQuery(params)
+	if err != nil {
+		sendResolveJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+// ResolveQuery lists the storms named params.Name, newest first.
+func ResolveQuery(params ResolveAPIParams) (ResolveResponse, error) {
+	typhonData, err := typhonRecords()
+	if err != nil {
+		return resolveFailResponse, err
+	}
+
+	season := strconv.Itoa(params.Year)
+	candidates := summarizeStorms(typhonData,
+		func(record []string) bool {
+			return strings.EqualFold(strings.TrimSpace(record[colName]), params.Name) &&
+				(params.Year == 0 || record[colSeason] == season)
+		},
+		func(record []string) bool { return true })
+	if len(candidates) == 0 {
+		return resolveFailResponse, fmt.Errorf("%w: %s", ErrStormNotFound, params.Name)
+	}
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].Start > candidates[b].Start })
+
+	response := ResolveResponse{
+		Name:       params.Name,
+		Year:       params.Year,
+		Candidates: candidates,
+		Ambiguous:  len(candidates) > 1,
+		WindUnit:   windUnit,
+		Status:     http.StatusOK,
+		Success:    true,
+	}
+	return response, nil
+}
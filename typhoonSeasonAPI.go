@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSeasonPageSize = 50
+	maxSeasonPageSize     = 500
+)
+
+type SeasonAPIParams struct {
+	Year     int    `json:"year"`
+	Basin    string `json:"basin"` // optional, e.g. WP, EP, NA
+	Page     int    `json:"page"`  // 1-based
+	PageSize int    `json:"page_size"`
+}
+
+// StormSummary describes one storm of a season.
+type StormSummary struct {
+	SID     string   `json:"sid"`
+	StormID string   `json:"storm_id"`
+	Name    string   `json:"name"`
+	Season  int      `json:"season"`
+	Number  int      `json:"number"`
+	Basins  []string `json:"basins"` // every basin the track passes through
+	Start   string   `json:"start"`  // ISO_TIME of the first track point
+	End     string   `json:"end"`    // ISO_TIME of the last track point
+	Points  int      `json:"points"`
+	MaxWind *float64 `json:"max_wind"` // windUnit, null when never reported
+	MinPres *float64 `json:"min_pres"` // hPa, null when never reported
+	MaxCat  *int     `json:"max_cat"`
+	// BBox is [min_lon, min_lat, max_lon, max_lat] of the track. It is not
+	// split at the dateline, so tracks crossing it span the whole globe.
+	BBox [4]float64 `json:"bbox"`
+}
+
+type SeasonResponse struct {
+	Season   int            `json:"season"`
+	Basin    string         `json:"basin,omitempty"`
+	Storms   []StormSummary `json:"storms"`
+	WindUnit string         `json:"wind_unit,omitempty"`
+	Total    int            `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Status   int            `json:"status"`
+	Success  bool           `json:"success"`
+}
+
+var seasonFailResponse = SeasonResponse{
+	Storms:  []StormSummary{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendSeasonJsonError(w http.ResponseWriter, statusCode int) {
+	response := seasonFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// typhoonSeasonHandler serves /typhoon/seasons/{year}?basin=&page=&page_size=
+func typhoonSeasonHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	year, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		sendSeasonJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	page := 1
+	if pageStr := httpQuery.Get("page"); pageStr != "" {
+		page, err = strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			sendSeasonJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	pageSize := defaultSeasonPageSize
+	if sizeStr := httpQuery.Get("page_size"); sizeStr != "" {
+		pageSize, err = strconv.Atoi(sizeStr)
+		if err != nil || pageSize < 1 || pageSize > maxSeasonPageSize {
+			sendSeasonJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	params := SeasonAPIParams{
+		Year:     year,
+		Basin:    strings.ToUpper(httpQuery.Get("basin")),
+		Page:     page,
+		PageSize: pageSize,
+	}
+
+	data, err2 := SeasonQuery(params)
+	if err2 != nil {
+		sendSeasonJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func SeasonQuery(params SeasonAPIParams) (SeasonResponse, error) {
+	typhonData, err := typhonRecords()
+	if err != nil {
+		return seasonFailResponse, err
+	}
+
+	storms := seasonStorms(typhonData, params.Year, params.Basin)
+	sort.Slice(storms, func(a, b int) bool {
+		if storms[a].Start != storms[b].Start {
+			return storms[a].Start < storms[b].Start
+		}
+		return storms[a].SID < storms[b].SID
+	})
+
+	start := min((params.Page-1)*params.PageSize, len(storms))
+	end := min(start+params.PageSize, len(storms))
+	response := SeasonResponse{
+		Season:   params.Year,
+		Basin:    params.Basin,
+		Storms:   storms[start:end],
+		WindUnit: windUnit,
+		Total:    len(storms),
+		Page:     params.Page,
+		PageSize: params.PageSize,
+		Status:   http.StatusOK,
+		Success:  true,
+	}
+	return response, nil
+}
+
+// seasonStorms summarizes every storm of the season, keeping only storms that
+// enter basin when it is not empty.
+func seasonStorms(records [][]string, year int, basin string) []StormSummary {
+	season := strconv.Itoa(year)
+	return summarizeStorms(records,
+		func(record []string) bool { return record[colSeason] == season },
+		func(record []string) bool { return basin == "" || record[colBasin] == basin })
+}
+
+// summarizeStorms summarizes the storms of the records selected by include.
+// A storm is returned if at least one of its selected records satisfies keep.
+func summarizeStorms(records [][]string, include, keep func(record []string) bool) []StormSummary {
+	summaries := make(map[string]*StormSummary)
+	var order []string
+	kept := make(map[string]bool)
+
+	for _, record := range records {
+		if len(record) < numColumns || !include(record) {
+			continue
+		}
+		sid := record[colSID]
+		summary, ok := summaries[sid]
+		if !ok {
+			number, _ := strconv.Atoi(record[colNumber])
+			season, _ := strconv.Atoi(record[colSeason])
+			summary = &StormSummary{
+				SID:     sid,
+				StormID: stormIDFor(record),
+				Name:    record[colName],
+				Season:  season,
+				Number:  number,
+				Basins:  []string{},
+				Start:   record[colIsoTime],
+				End:     record[colIsoTime],
+				BBox:    [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+			}
+			summaries[sid] = summary
+			order = append(order, sid)
+		}
+
+		summary.Points++
+		if record[colIsoTime] < summary.Start {
+			summary.Start = record[colIsoTime]
+		}
+		if record[colIsoTime] > summary.End {
+			summary.End = record[colIsoTime]
+		}
+		if b := strings.TrimSpace(record[colBasin]); b != "" && !slices.Contains(summary.Basins, b) {
+			summary.Basins = append(summary.Basins, b)
+		}
+		if keep(record) {
+			kept[sid] = true
+		}
+
+		point := typhoonPointFor(record)
+		if !math.IsNaN(point.Lat) {
+			summary.BBox[1] = math.Min(summary.BBox[1], point.Lat)
+			summary.BBox[3] = math.Max(summary.BBox[3], point.Lat)
+		}
+		if !math.IsNaN(point.Lon) {
+			lon := normalizeLon(point.Lon)
+			summary.BBox[0] = math.Min(summary.BBox[0], lon)
+			summary.BBox[2] = math.Max(summary.BBox[2], lon)
+		}
+		if wind := point.Wind; !math.IsNaN(wind) {
+			if summary.MaxWind == nil || wind > *summary.MaxWind {
+				summary.MaxWind = &wind
+			}
+		}
+		if pres := point.Pres; !math.IsNaN(pres) {
+			if summary.MinPres == nil || pres < *summary.MinPres {
+				summary.MinPres = &pres
+			}
+		}
+		if !math.IsNaN(point.Cat) {
+			if cat := int(point.Cat); summary.MaxCat == nil || cat > *summary.MaxCat {
+				summary.MaxCat = &cat
+			}
+		}
+	}
+
+	storms := make([]StormSummary, 0, len(order))
+	for _, sid := range order {
+		if !kept[sid] {
+			continue
+		}
+		summary := summaries[sid]
+		if math.IsInf(summary.BBox[0], 0) || math.IsInf(summary.BBox[1], 0) {
+			summary.BBox = [4]float64{}
+		}
+		if summary.MaxWind != nil {
+			wind := convertWind(*summary.MaxWind)
+			summary.MaxWind = &wind
+		}
+		storms = append(storms, *summary)
+	}
+	return storms
+}
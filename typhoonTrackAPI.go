@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	trackFormatJSON = "json"
+	trackFormatCSV  = "csv"
+	trackFormatGPX  = "gpx"
+	trackFormatKML  = "kml"
+	trackFormatKMZ  = "kmz"
+	trackFormatSHP  = "shp"
+)
+
+// ibtracsHeader is the header row written by CSV exports, in column order.
+var ibtracsHeader = []string{"SID", "SEASON", "NUMBER", "BASIN", "SUBBASIN", "NAME", "ISO_TIME", "NATURE", "LAT", "LON", "CAT", "WIND", "PRES"}
+
+type TrackAPIParams struct {
+	SID        string  `json:"sid"`
+	Format     string  `json:"format"`      // json, csv, gpx, kml, kmz or shp
+	SimplifyKm float64 `json:"simplify_km"` // Douglas-Peucker tolerance, 0 keeps every point
+}
+
+type TrackResponse struct {
+	SID      string           `json:"sid"`
+	StormID  string           `json:"storm_id"`
+	Name     string           `json:"name"`
+	Season   string           `json:"season"`
+	Points   []map[string]any `json:"points"`
+	WindUnit string           `json:"wind_unit,omitempty"`
+	Status   int              `json:"status"`
+	Success  bool             `json:"success"`
+}
+
+var trackFailResponse = TrackResponse{
+	Points:  []map[string]any{},
+	Status:  http.StatusBadRequest,
+	Success: false,
+}
+
+func sendTrackJsonError(w http.ResponseWriter, statusCode int) {
+	response := trackFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// typhoonTrackHandler serves /typhoon/tracks/{sid}?format=json|csv|gpx|kml|kmz|shp&simplify=
+func typhoonTrackHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	sid := r.PathValue("sid")
+	if sid == "" {
+		sendTrackJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(httpQuery.Get("format"))
+	if format == "" {
+		format = trackFormatJSON
+	}
+	if !slices.Contains([]string{trackFormatJSON, trackFormatCSV, trackFormatGPX, trackFormatKML, trackFormatKMZ, trackFormatSHP}, format) {
+		sendTrackJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	var simplifyKm float64
+	if simplifyStr := httpQuery.Get("simplify"); simplifyStr != "" {
+		var err error
+		simplifyKm, err = strconv.ParseFloat(simplifyStr, 64)
+		if err != nil || simplifyKm < 0 || math.IsInf(simplifyKm, 0) || math.IsNaN(simplifyKm) {
+			sendTrackJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	params := TrackAPIParams{
+		SID:        sid,
+		Format:     format,
+		SimplifyKm: simplifyKm,
+	}
+
+	records, err := TrackQuery(params)
+	if err != nil {
+		sendTrackJsonError(w, queryErrorStatus(err))
+		setLogField(r.Context(), "error", err)
+		return
+	}
+
+	switch params.Format {
+	case trackFormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+sid+`.csv"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeTrackCSV(w, records)
+	case trackFormatGPX:
+		w.Header().Set("Content-Type", "application/gpx+xml")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+sid+`.gpx"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeTrackGPX(w, records)
+	case trackFormatKML:
+		w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+sid+`.kml"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeTrackKML(w, records)
+	case trackFormatKMZ:
+		w.Header().Set("Content-Type", "application/vnd.google-earth.kmz")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+sid+`.kmz"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeTrackKMZ(w, records)
+	case trackFormatSHP:
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+sid+`.zip"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeShapefileZip(w, trackShapes(records)...)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(trackResponse(records, languageFrom(r.Context())))
+	}
+	if err != nil {
+		log.Printf("Met Error when writing track to ResponseWriter: %v", err)
+	}
+}
+
+// TrackQuery returns the IBTrACS records of one storm in time order.
+func TrackQuery(params TrackAPIParams) ([][]string, error) {
+	dataset, err := loadedTyphonData()
+	if err != nil {
+		return nil, err
+	}
+
+	records := dataset.tracks[params.SID]
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrStormNotFound, params.SID)
+	}
+	return simplifyTrack(records, params.SimplifyKm), nil
+}
+
+func trackResponse(records [][]string, lang string) TrackResponse {
+	dataset := currentTyphonData()
+	points := make([]map[string]any, 0, len(records))
+	for _, record := range records {
+		points = append(points, typhoonRecordMap(record, lang, dataset))
+	}
+	return TrackResponse{
+		SID:      records[0][colSID],
+		StormID:  stormIDFor(records[0]),
+		Name:     records[0][colName],
+		Season:   records[0][colSeason],
+		Points:   points,
+		WindUnit: windUnit,
+		Status:   http.StatusOK,
+		Success:  true,
+	}
+}
+
+func writeTrackCSV(w io.Writer, records [][]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ibtracsHeader); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := writer.Write(record[:numColumns]); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// GPX 1.1, https://www.topografix.com/GPX/1/1/
+type gpxDocument struct {
+	XMLName xml.Name `xml:"gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Desc    string     `xml:"desc,omitempty"`
+	Segment gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time,omitempty"`
+	Desc string  `xml:"desc,omitempty"`
+}
+
+// writeTrackGPX writes the track as a single GPX segment. Points without a
+// valid position cannot be represented in GPX and are left out; wind,
+// pressure and category go into each point's description.
+func writeTrackGPX(w io.Writer, records [][]string) error {
+	first := records[0]
+	document := gpxDocument{
+		Version: "1.1",
+		Creator: "Griber",
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Track: gpxTrack{
+			Name: strings.TrimSpace(first[colName] + " " + first[colSID]),
+			Desc: "IBTrACS " + first[colSeason] + " " + first[colBasin],
+		},
+	}
+	for _, record := range records {
+		lat, lon, ok := recordLatLon(record)
+		if !ok {
+			continue
+		}
+		point := gpxPoint{
+			Lat:  lat,
+			Lon:  normalizeLon(lon),
+			Desc: fmt.Sprintf("wind=%s kts pres=%s mb cat=%s nature=%s", record[colWind], record[colPres], record[colCat], record[colNature]),
+		}
+		if t, err := time.Parse("20060102150405", record[colIsoTime]); err == nil {
+			point.Time = t.UTC().Format(time.RFC3339)
+		}
+		document.Track.Segment.Points = append(document.Track.Segment.Points, point)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
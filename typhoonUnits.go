@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// IBTrACS reports wind in knots and pressure in mb (= hPa). Responses carry
+// numbers instead of the raw CSV strings, with wind converted to windUnit
+// and missing values as null.
+
+const (
+	windUnitMS  = "m/s"
+	windUnitKts = "kts"
+	windUnitKmh = "km/h"
+)
+
+// windUnit is the unit of every wind speed in typhoon responses.
+var windUnit = windUnitMS
+
+func parseWindUnit(unit string) (string, error) {
+	switch strings.ToLower(unit) {
+	case "m/s", "ms", "mps":
+		return windUnitMS, nil
+	case "kts", "kt", "knots":
+		return windUnitKts, nil
+	case "km/h", "kmh", "kph":
+		return windUnitKmh, nil
+	}
+	return "", fmt.Errorf("unknown wind unit %q (want m/s, kts or km/h)", unit)
+}
+
+// convertWind converts a speed in knots to windUnit.
+func convertWind(kts float64) float64 {
+	switch windUnit {
+	case windUnitKts:
+		return kts
+	case windUnitKmh:
+		return kts * 1.852
+	default:
+		return kts * 0.514444
+	}
+}
+
+// speedIn converts a speed in m/s to unit.
+func speedIn(ms float64, unit string) float64 {
+	switch unit {
+	case windUnitKts:
+		return ms / 0.514444
+	case windUnitKmh:
+		return ms * 3.6
+	default:
+		return ms
+	}
+}
+
+// typhoonPoint holds the numeric fields of one IBTrACS record, NaN when the
+// CSV leaves them blank. Wind is in knots.
+type typhoonPoint struct {
+	Lat, Lon, Wind, Pres, Cat float64
+}
+
+func typhoonPointKey(record []string) string {
+	return record[colSID] + "|" + record[colIsoTime]
+}
+
+func parseTyphoonPoints(records [][]string) map[string]typhoonPoint {
+	points := make(map[string]typhoonPoint, len(records))
+	for _, record := range records {
+		if len(record) < numColumns {
+			continue
+		}
+		points[typhoonPointKey(record)] = parseTyphoonPoint(record)
+	}
+	return points
+}
+
+func parseTyphoonPoint(record []string) typhoonPoint {
+	return typhoonPoint{
+		Lat:  parseCSVFloat(record[colLat]),
+		Lon:  parseCSVFloat(record[colLon]),
+		Wind: parseCSVFloat(record[colWind]),
+		Pres: parseCSVFloat(record[colPres]),
+		Cat:  parseCSVFloat(record[colCat]),
+	}
+}
+
+func parseCSVFloat(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return v
+}
+
+// typhoonPointFor returns the parsed fields of a record.
+func typhoonPointFor(record []string) typhoonPoint {
+	if point, ok := currentTyphonData().points[typhoonPointKey(record)]; ok {
+		return point
+	}
+	return parseTyphoonPoint(record)
+}
@@ -1,25 +1,23 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"net/url"
 	"os"
 	"path/filepath"
 )
 
-func makeRelative(date string, batch string, suffix string, prot string) string {
-	fileName := date + batch[:2] + "0000-0h-" + prot + "-fc" + suffix
+func makeRelative(date string, batch string, step int, suffix string, prot string) string {
+	fileName := date + batch[:2] + "0000-" + stepName(step) + "-" + prot + "-fc" + suffix
 	relative := filepath.Join(date, batch, "ifs/0p25", prot, fileName)
 	return relative
 }
 
-func makeAbs(bucketName string, date string, batch string, suffix string, prot string) string {
+func makeAbs(bucketName string, date string, batch string, step int, suffix string, prot string) string {
 	basePath := "/" + bucketName
-	relative := makeRelative(date, batch, suffix, prot)
+	relative := makeRelative(date, batch, step, suffix, prot)
 	path := filepath.Join(basePath, relative)
 	return path
 }
@@ -44,26 +42,105 @@ func writeFile(path string, data []byte) error {
 	return nil
 }
 
-func unwarpGribRawJsonValue(raw string) ([]float64, error) {
+func unwarpGribRawJsonValue(raw string) ([]float64, GridSpec, error) {
 	type NormalJson map[string]interface{}
 	jsonHolder := NormalJson{}
 	if err := json.Unmarshal([]byte(raw), &jsonHolder); err != nil {
-		return nil, fmt.Errorf("fail to parse Json: %w", err)
+		return nil, GridSpec{}, fmt.Errorf("fail to parse Json: %w", err)
 	}
 
 	messages := jsonHolder["messages"].([]interface{})[0].([]interface{})
 	var values []float64
+	bitmapPresent := false
+	missingValue := defaultMissingValue
+	gridKeys := make(map[string]interface{})
 	for _, message := range messages {
-		if message.(map[string]interface{})["key"] == "values" {
+		key, _ := message.(map[string]interface{})["key"].(string)
+		switch key {
+		case "gridType", "Ni", "Nj", "N", "pl",
+			"latitudeOfFirstGridPointInDegrees", "longitudeOfFirstGridPointInDegrees",
+			"iDirectionIncrementInDegrees", "jDirectionIncrementInDegrees":
+			gridKeys[key] = message.(map[string]interface{})["value"]
+		case "bitmapPresent":
+			if flag, ok := message.(map[string]interface{})["value"].(float64); ok {
+				bitmapPresent = flag != 0
+			}
+		case "missingValue":
+			if mv, ok := message.(map[string]interface{})["value"].(float64); ok {
+				missingValue = mv
+			}
+		case "values":
 			// JSON 解析后，数字数组是 []interface{}，需要逐个转换
 			valueInterface := message.(map[string]interface{})["value"].([]interface{})
 			values = make([]float64, len(valueInterface))
 			for i, v := range valueInterface {
-				values[i] = v.(float64)
+				if f, ok := v.(float64); ok {
+					values[i] = f
+				} else {
+					values[i] = math.NaN() // grib_dump may print null for masked cells
+				}
 			}
 		}
 	}
-	return values, nil
+	if bitmapPresent {
+		maskMissingValues(values, missingValue)
+	}
+
+	spec := gridSpecFromKeys(gridKeys)
+	grid, err := spec.Grid()
+	if err != nil {
+		return nil, GridSpec{}, err
+	}
+	if grid.Size() != len(values) {
+		return nil, GridSpec{}, fmt.Errorf("%s grid has %d points but message has %d values", spec.Type, grid.Size(), len(values))
+	}
+	return values, spec, nil
+}
+
+// gridSpecFromKeys builds a GridSpec from the geometry keys of a grib_dump
+// message. Messages without a gridType are assumed to be on the default grid.
+func gridSpecFromKeys(keys map[string]interface{}) GridSpec {
+	gridType, _ := keys["gridType"].(string)
+	if gridType == "" {
+		return defaultGridSpec
+	}
+	number := func(key string) float64 {
+		v, _ := keys[key].(float64)
+		return v
+	}
+	spec := GridSpec{Type: gridType}
+	switch gridType {
+	case "regular_ll":
+		spec.Ni = int(number("Ni"))
+		spec.Nj = int(number("Nj"))
+		spec.LatFirst = number("latitudeOfFirstGridPointInDegrees")
+		spec.LonFirst = number("longitudeOfFirstGridPointInDegrees")
+		spec.LatStep = number("jDirectionIncrementInDegrees")
+		spec.LonStep = number("iDirectionIncrementInDegrees")
+	case "reduced_gg":
+		spec.N = int(number("N"))
+		pl, _ := keys["pl"].([]interface{})
+		spec.PL = make([]int, len(pl))
+		for i, points := range pl {
+			p, _ := points.(float64)
+			spec.PL[i] = int(p)
+		}
+	}
+	return spec
+}
+
+// defaultMissingValue is the eccodes default fill value, used when grib_dump
+// does not print a missingValue key.
+const defaultMissingValue = 9999.0
+
+// maskMissingValues replaces cells holding the GRIB fill value with NaN, so a
+// bitmap hole is stored as missing instead of being read as a 9999 m/s wind.
+func maskMissingValues(values []float64, missingValue float64) {
+	for i, v := range values {
+		if v == missingValue {
+			values[i] = math.NaN()
+		}
+	}
 }
 
 const (
@@ -78,66 +155,7 @@ const (
 
 // GetIndexForCoord targetLat: (-90 to 90)
 // targetLon: (-180 to 180)
+// It indexes the default 0.25° grid, use Grid.Index for data on other grids.
 func GetIndexForCoord(targetLat, targetLon float64) (int, error) {
-	// Normalize lon to 0 to 360
-	normalizedLon := math.Mod(targetLon, 360)
-	if normalizedLon < 0 {
-		normalizedLon += 360
-	}
-
-	// Calculate offset from LonFirst (180)
-	// Data array starts at 180 and wraps: 180, 180.25, ..., 359.75, 0, 0.25, ..., 179.75
-	lonOffset := normalizedLon - LonFirst
-	if lonOffset < 0 {
-		lonOffset += 360 // Handle wrap-around
-	}
-
-	// calc nearest lon index
-	iFloat := lonOffset / LonStep
-	i := int(math.Round(iFloat)) % Ni
-
-	// GRIB scan from 90 (North) to -90 (South)
-	// j = (LatFirst - targetLat) / LatStep
-	jFloat := (LatFirst - targetLat) / LatStep
-	j := int(math.Round(jFloat))
-
-	// no looping but constraint
-	if j < 0 {
-		j = 0 // targetLat > 90
-	}
-	if j >= Nj {
-		j = Nj - 1 // targetLat < -90
-	}
-
-	// calc slice index
-	index := (j * Ni) + i
-
-	// safe check
-	if index < 0 || index >= TotalPoints {
-		return -1, fmt.Errorf("index %d out of range [0, %d)", index, TotalPoints)
-	}
-
-	return index, nil
-}
-
-func readCSV(path string) ([][]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			fmt.Println(err)
-		}
-	}(file)
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Fatalf("Fail to read csv: %v", err)
-	}
-
-	records = records[1 : len(records)-1]
-	return records, nil
+	return defaultGrid.Index(targetLat, targetLon)
 }
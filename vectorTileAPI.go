@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Wind as Mapbox vector tiles: /vector-tile/{z}/{x}/{y} holds one "wind"
+// point layer with u, v, speed and dir. Points are the grid points inside
+// the tile, thinned at low zooms to at most vectorTilePoints per side so a
+// tile stays small however much of the globe it covers.
+
+const (
+	vectorTilePoints = 32
+	maxTileZoom      = 22
+	mercatorMaxLat   = 85.0511287798066
+)
+
+var vectorTileKeys = []string{"u", "v", "speed", "dir"}
+
+type VectorTileAPIParams struct {
+	Z, X, Y int
+	Date    string
+	Batch   string
+}
+
+type TileErrorResponse struct {
+	Status  int  `json:"status"`
+	Success bool `json:"success"`
+}
+
+func sendTileJsonError(w http.ResponseWriter, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(TileErrorResponse{Status: statusCode, Success: false})
+}
+
+// parseTile reads the z/x/y path values; y may carry a .mvt or .pbf suffix.
+func parseTile(r *http.Request) (int, int, int, error) {
+	z, err := strconv.Atoi(r.PathValue("z"))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: z %q", ErrInvalidParams, r.PathValue("z"))
+	}
+	x, err := strconv.Atoi(r.PathValue("x"))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: x %q", ErrInvalidParams, r.PathValue("x"))
+	}
+	yStr := strings.TrimSuffix(strings.TrimSuffix(r.PathValue("y"), ".mvt"), ".pbf")
+	y, err := strconv.Atoi(yStr)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: y %q", ErrInvalidParams, r.PathValue("y"))
+	}
+	if z < 0 || z > maxTileZoom || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return 0, 0, 0, fmt.Errorf("%w: no tile %d/%d/%d", ErrInvalidParams, z, x, y)
+	}
+	return z, x, y, nil
+}
+
+// tileLatLon returns the coordinate of a point of tile (z, x, y) at tile
+// coordinates (px, py) in [0, 1].
+func tileLatLon(z, x, y int, px, py float64) (float64, float64) {
+	n := float64(int(1) << z)
+	lon := (float64(x)+px)/n*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*(float64(y)+py)/n))) * 180 / math.Pi
+	return lat, lon
+}
+
+// tilePixel is the inverse of tileLatLon.
+func tilePixel(z, x, y int, lat, lon float64) (float64, float64) {
+	n := float64(int(1) << z)
+	lat = math.Max(-mercatorMaxLat, math.Min(lat, mercatorMaxLat)) * math.Pi / 180
+	px := (lon+180)/360*n - float64(x)
+	py := (1-math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi)/2*n - float64(y)
+	return px, py
+}
+
+// vectorTileHandler serves GET /vector-tile/{z}/{x}/{y}?date=&batch=
+func vectorTileHandler(w http.ResponseWriter, r *http.Request) {
+	z, x, y, err := parseTile(r)
+	if err != nil {
+		sendTileJsonError(w, http.StatusBadRequest)
+		return
+	}
+	date, batch := r.URL.Query().Get("date"), r.URL.Query().Get("batch")
+
+	setLogField(r.Context(), "date", date)
+	setLogField(r.Context(), "batch", batch)
+	tile, err2 := VectorTileQuery(r.Context(), VectorTileAPIParams{Z: z, X: x, Y: y, Date: date, Batch: batch})
+	if err2 != nil {
+		sendTileJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(tile); err != nil {
+		log.Printf("Met Error when writing tile to ResponseWriter: %v", err)
+	}
+}
+
+func VectorTileQuery(ctx context.Context, params VectorTileAPIParams) ([]byte, error) {
+	if err := validateDateBatch(params.Date, params.Batch); err != nil {
+		return nil, err
+	}
+	filePath := filepath.Join(tmpDir, params.Date+"-"+params.Batch+".json")
+	cache, err := getOrLoadFileCache(ctx, filePath, params.Date, params.Batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+
+	// sample the tile on a lattice and snap to the nearest grid point; at
+	// high zooms several lattice points share a grid point, which is kept once
+	seen := make(map[int]bool)
+	var points []mvtPoint
+	for row := range vectorTilePoints {
+		for col := range vectorTilePoints {
+			lat, lon := tileLatLon(params.Z, params.X, params.Y,
+				(float64(col)+0.5)/vectorTilePoints, (float64(row)+0.5)/vectorTilePoints)
+			index, err := cache.Grid.Index(lat, lon)
+			if err != nil || seen[index] || index >= len(cache.U) || index >= len(cache.V) {
+				continue
+			}
+			seen[index] = true
+
+			gridLat, gridLon := cache.Grid.Coord(index)
+			// keep the point's longitude on this tile's side of the antimeridian
+			gridLon = lon + normalizeLon(gridLon-lon)
+			px, py := tilePixel(params.Z, params.X, params.Y, gridLat, gridLon)
+			if px < 0 || px >= 1 || py < 0 || py >= 1 {
+				continue
+			}
+			u, v := cache.U[index], cache.V[index]
+			points = append(points, mvtPoint{
+				X:          int(px * mvtExtent),
+				Y:          int(py * mvtExtent),
+				Properties: []float64{u, v, math.Hypot(u, v), windDirection(u, v)},
+			})
+		}
+	}
+	addLogCount(ctx, "points", int64(len(points)))
+	return encodeMVTLayer("wind", vectorTileKeys, points), nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Speed class edges in m/s, the WRPLOT defaults. Speeds below the first edge
+// count as calm, the last class is open ended.
+var windRoseSpeedEdges = []float64{0.5, 2.1, 3.6, 5.7, 8.8, 11.1}
+
+const maxWindRoseDays = 366
+
+type WindRoseAPIParams struct {
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+	StartDate string   `json:"start"` // yyyymmdd format
+	EndDate   string   `json:"end"`   // yyyymmdd format
+	Batches   []string `json:"batches"`
+	Sectors   int      `json:"sectors"` // 4, 8, 16 or 36
+}
+
+type SpeedClass struct {
+	Min float64  `json:"min"`
+	Max *float64 `json:"max"` // null for the open ended class
+}
+
+type WindRoseSector struct {
+	Direction   float64   `json:"direction"`   // sector centre, degrees the wind blows from
+	Frequencies []float64 `json:"frequencies"` // percent of all samples per speed class
+	Total       float64   `json:"total"`       // percent of all samples in the sector
+}
+
+type WindRoseResponse struct {
+	Lat          float64          `json:"lat"`
+	Lon          float64          `json:"lon"`
+	Start        string           `json:"start"`
+	End          string           `json:"end"`
+	Batches      []string         `json:"batches"`
+	SpeedClasses []SpeedClass     `json:"speed_classes"` // m/s
+	Sectors      []WindRoseSector `json:"sectors"`
+	Calm         float64          `json:"calm"` // percent of samples below the first class
+	Samples      int              `json:"samples"`
+	Missing      int              `json:"missing"` // date/batch pairs without data
+	Status       int              `json:"status"`
+	Success      bool             `json:"success"`
+}
+
+var windRoseFailResponse = WindRoseResponse{
+	Batches:      []string{},
+	SpeedClasses: []SpeedClass{},
+	Sectors:      []WindRoseSector{},
+	Status:       http.StatusBadRequest,
+	Success:      false,
+}
+
+func sendWindRoseJsonError(w http.ResponseWriter, statusCode int) {
+	response := windRoseFailResponse
+	response.Status = statusCode
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// windRoseHandler serves /windrose?lat=&lon=&start=&end=&batch=&sectors=
+func windRoseHandler(w http.ResponseWriter, r *http.Request) {
+	httpQuery := r.URL.Query()
+
+	lat, err := parseCoordinate(httpQuery.Get("lat"))
+	if err != nil {
+		sendWindRoseJsonError(w, http.StatusBadRequest)
+		return
+	}
+	lon, err := parseCoordinate(httpQuery.Get("lon"))
+	if err != nil {
+		sendWindRoseJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	start := httpQuery.Get("start")
+	end := httpQuery.Get("end")
+	if start == "" || end == "" {
+		sendWindRoseJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	batches, err := parseBatches(httpQuery.Get("batch"))
+	if err != nil {
+		sendWindRoseJsonError(w, http.StatusBadRequest)
+		return
+	}
+
+	sectors := 16
+	if sectorsStr := httpQuery.Get("sectors"); sectorsStr != "" {
+		sectors, err = strconv.Atoi(sectorsStr)
+		if err != nil {
+			sendWindRoseJsonError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	params := WindRoseAPIParams{
+		Lat:       lat,
+		Lon:       lon,
+		StartDate: start,
+		EndDate:   end,
+		Batches:   batches,
+		Sectors:   sectors,
+	}
+
+	setLogField(r.Context(), "date", start+"-"+end)
+	data, err2 := WindRoseQuery(r.Context(), params)
+	if err2 != nil {
+		sendWindRoseJsonError(w, queryErrorStatus(err2))
+		setLogField(r.Context(), "error", err2)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		log.Printf("Met Error when writing json to ResponseWriter: %v", err)
+	}
+}
+
+func WindRoseQuery(ctx context.Context, params WindRoseAPIParams) (WindRoseResponse, error) {
+	switch params.Sectors {
+	case 4, 8, 16, 36:
+	default:
+		return windRoseFailResponse, fmt.Errorf("%w: sectors must be 4, 8, 16 or 36", ErrInvalidParams)
+	}
+	dates, err := generateDateRange(params.StartDate, params.EndDate)
+	if err != nil {
+		return windRoseFailResponse, err
+	}
+	if len(dates) > maxWindRoseDays {
+		return windRoseFailResponse, fmt.Errorf("%w: %d days exceeds limit of %d", ErrInvalidParams, len(dates), maxWindRoseDays)
+	}
+
+	samples, missing, err := pointSeries(ctx, params.Lat, params.Lon, dates, params.Batches)
+	if err != nil {
+		return windRoseFailResponse, err
+	}
+	if len(samples) == 0 {
+		return windRoseFailResponse, fmt.Errorf("%w: no data between %s and %s", ErrDataNotPublished, params.StartDate, params.EndDate)
+	}
+
+	classes := len(windRoseSpeedEdges)
+	counts := make([][]int, params.Sectors)
+	for i := range counts {
+		counts[i] = make([]int, classes)
+	}
+	calm := 0
+	width := 360 / float64(params.Sectors)
+	for _, sample := range samples {
+		speed := math.Hypot(sample.U, sample.V)
+		if speed < windRoseSpeedEdges[0] {
+			calm++
+			continue
+		}
+		class := classes - 1
+		for c := 1; c < classes; c++ {
+			if speed < windRoseSpeedEdges[c] {
+				class = c - 1
+				break
+			}
+		}
+		sector := int(math.Floor((windDirection(sample.U, sample.V)+width/2)/width)) % params.Sectors
+		counts[sector][class]++
+	}
+
+	percent := func(n int) float64 {
+		return float64(n) * 100 / float64(len(samples))
+	}
+	rose := make([]WindRoseSector, params.Sectors)
+	for i := range rose {
+		rose[i] = WindRoseSector{Direction: float64(i) * width, Frequencies: make([]float64, classes)}
+		total := 0
+		for c, n := range counts[i] {
+			rose[i].Frequencies[c] = percent(n)
+			total += n
+		}
+		rose[i].Total = percent(total)
+	}
+
+	speedClasses := make([]SpeedClass, classes)
+	for c := range speedClasses {
+		speedClasses[c].Min = windRoseSpeedEdges[c]
+		if c+1 < classes {
+			speedClasses[c].Max = &windRoseSpeedEdges[c+1]
+		}
+	}
+
+	response := WindRoseResponse{
+		Lat:          params.Lat,
+		Lon:          params.Lon,
+		Start:        params.StartDate,
+		End:          params.EndDate,
+		Batches:      params.Batches,
+		SpeedClasses: speedClasses,
+		Sectors:      rose,
+		Calm:         percent(calm),
+		Samples:      len(samples),
+		Missing:      missing,
+		Status:       http.StatusOK,
+		Success:      true,
+	}
+	return response, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A minimal Office Open XML (XLSX) writer for series tables: a "data" sheet
+// with the table, its first column the category (the dates), and a line
+// chart of the numeric series next to it, for the end consumers who only
+// open Excel. Written by hand against ECMA-376, no dependency needed.
+
+const (
+	xlsxChartRows  = 20 // rows the chart spans
+	xmlDeclaration = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+const (
+	nsSpreadsheet   = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	nsRelationships = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsPackageRels   = "http://schemas.openxmlformats.org/package/2006/relationships"
+	nsDrawing       = "http://schemas.openxmlformats.org/drawingml/2006/main"
+	nsChart         = "http://schemas.openxmlformats.org/drawingml/2006/chart"
+	nsSheetDrawing  = "http://schemas.openxmlformats.org/drawingml/2006/spreadsheetDrawing"
+)
+
+// xlsxColumn is the column letters of a 0-based column index.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// writeXLSX writes table as a workbook titled title. Series named in
+// unplotted (e.g. directions in degrees) stay off the chart.
+func writeXLSX(w io.Writer, title string, table queryTable, unplotted ...string) error {
+	var sheet strings.Builder
+	sheet.WriteString(xmlDeclaration + `<worksheet xmlns="` + nsSpreadsheet + `" xmlns:r="` + nsRelationships + `"><sheetData>`)
+	writeRow := func(r int, cells []any) {
+		fmt.Fprintf(&sheet, `<row r="%d">`, r)
+		for c, cell := range cells {
+			ref := xlsxColumn(c) + strconv.Itoa(r)
+			switch cell := cell.(type) {
+			case float64:
+				if !math.IsNaN(cell) && !math.IsInf(cell, 0) {
+					fmt.Fprintf(&sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(cell, 'g', -1, 64))
+				}
+			default:
+				fmt.Fprintf(&sheet, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, html.EscapeString(fmt.Sprint(cell)))
+			}
+		}
+		sheet.WriteString(`</row>`)
+	}
+	header := make([]any, len(table.Columns))
+	for i, column := range table.Columns {
+		header[i] = column
+	}
+	writeRow(1, header)
+	for i, row := range table.Rows {
+		writeRow(i+2, row)
+	}
+	sheet.WriteString(`</sheetData><drawing r:id="rId1"/></worksheet>`)
+
+	// the chart plots every numeric series against the first column
+	last := len(table.Rows) + 1
+	var series strings.Builder
+	plotted := 0
+	for c := 1; c < len(table.Columns); c++ {
+		numeric := len(table.Rows) > 0
+		for _, row := range table.Rows {
+			if _, ok := row[c].(float64); !ok {
+				numeric = false
+			}
+		}
+		if !numeric || slices.Contains(unplotted, table.Columns[c]) {
+			continue
+		}
+		column := xlsxColumn(c)
+		fmt.Fprintf(&series, `<c:ser><c:idx val="%d"/><c:order val="%d"/><c:tx><c:strRef><c:f>data!$%s$1</c:f></c:strRef></c:tx>`+
+			`<c:marker><c:symbol val="none"/></c:marker>`+
+			`<c:cat><c:strRef><c:f>data!$A$2:$A$%d</c:f></c:strRef></c:cat>`+
+			`<c:val><c:numRef><c:f>data!$%s$2:$%s$%d</c:f></c:numRef></c:val><c:smooth val="0"/></c:ser>`,
+			plotted, plotted, column, last, column, column, last)
+		plotted++
+	}
+	chart := xmlDeclaration + `<c:chartSpace xmlns:c="` + nsChart + `" xmlns:a="` + nsDrawing + `" xmlns:r="` + nsRelationships + `"><c:chart>` +
+		`<c:title><c:tx><c:rich><a:bodyPr/><a:p><a:r><a:t>` + html.EscapeString(title) + `</a:t></a:r></a:p></c:rich></c:tx><c:overlay val="0"/></c:title>` +
+		`<c:autoTitleDeleted val="0"/><c:plotArea><c:layout/>` +
+		`<c:lineChart><c:grouping val="standard"/><c:varyColors val="0"/>` + series.String() +
+		`<c:marker val="1"/><c:axId val="1"/><c:axId val="2"/></c:lineChart>` +
+		`<c:catAx><c:axId val="1"/><c:scaling><c:orientation val="minMax"/></c:scaling><c:delete val="0"/><c:axPos val="b"/><c:crossAx val="2"/></c:catAx>` +
+		`<c:valAx><c:axId val="2"/><c:scaling><c:orientation val="minMax"/></c:scaling><c:delete val="0"/><c:axPos val="l"/><c:majorGridlines/>` +
+		`<c:numFmt formatCode="General" sourceLinked="1"/><c:crossAx val="1"/></c:valAx></c:plotArea>` +
+		`<c:legend><c:legendPos val="b"/><c:overlay val="0"/></c:legend><c:plotVisOnly val="1"/><c:dispBlanksAs val="gap"/></c:chart></c:chartSpace>`
+
+	// anchored right of the data
+	from := len(table.Columns) + 1
+	drawing := xmlDeclaration + `<xdr:wsDr xmlns:xdr="` + nsSheetDrawing + `" xmlns:a="` + nsDrawing + `"><xdr:twoCellAnchor>` +
+		fmt.Sprintf(`<xdr:from><xdr:col>%d</xdr:col><xdr:colOff>0</xdr:colOff><xdr:row>1</xdr:row><xdr:rowOff>0</xdr:rowOff></xdr:from>`, from) +
+		fmt.Sprintf(`<xdr:to><xdr:col>%d</xdr:col><xdr:colOff>0</xdr:colOff><xdr:row>%d</xdr:row><xdr:rowOff>0</xdr:rowOff></xdr:to>`, from+10, 1+xlsxChartRows) +
+		`<xdr:graphicFrame macro=""><xdr:nvGraphicFramePr><xdr:cNvPr id="2" name="Chart 1"/><xdr:cNvGraphicFramePr/></xdr:nvGraphicFramePr>` +
+		`<xdr:xfrm><a:off x="0" y="0"/><a:ext cx="0" cy="0"/></xdr:xfrm>` +
+		`<a:graphic><a:graphicData uri="` + nsChart + `"><c:chart xmlns:c="` + nsChart + `" xmlns:r="` + nsRelationships + `" r:id="rId1"/></a:graphicData></a:graphic>` +
+		`</xdr:graphicFrame><xdr:clientData/></xdr:twoCellAnchor></xdr:wsDr>`
+
+	files := []struct{ name, content string }{
+		{"[Content_Types].xml", xmlDeclaration + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			`<Override PartName="/xl/drawings/drawing1.xml" ContentType="application/vnd.openxmlformats-officedocument.drawing+xml"/>` +
+			`<Override PartName="/xl/charts/chart1.xml" ContentType="application/vnd.openxmlformats-officedocument.drawingml.chart+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xmlDeclaration + `<Relationships xmlns="` + nsPackageRels + `">` +
+			`<Relationship Id="rId1" Type="` + nsRelationships + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", xmlDeclaration + `<workbook xmlns="` + nsSpreadsheet + `" xmlns:r="` + nsRelationships + `">` +
+			`<sheets><sheet name="data" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xmlDeclaration + `<Relationships xmlns="` + nsPackageRels + `">` +
+			`<Relationship Id="rId1" Type="` + nsRelationships + `/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="` + nsRelationships + `/styles" Target="styles.xml"/></Relationships>`},
+		{"xl/styles.xml", xmlDeclaration + `<styleSheet xmlns="` + nsSpreadsheet + `">` +
+			`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/></cellXfs></styleSheet>`},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+		{"xl/worksheets/_rels/sheet1.xml.rels", xmlDeclaration + `<Relationships xmlns="` + nsPackageRels + `">` +
+			`<Relationship Id="rId1" Type="` + nsRelationships + `/drawing" Target="../drawings/drawing1.xml"/></Relationships>`},
+		{"xl/drawings/drawing1.xml", drawing},
+		{"xl/drawings/_rels/drawing1.xml.rels", xmlDeclaration + `<Relationships xmlns="` + nsPackageRels + `">` +
+			`<Relationship Id="rId1" Type="` + nsRelationships + `/chart" Target="../charts/chart1.xml"/></Relationships>`},
+		{"xl/charts/chart1.xml", chart},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}